@@ -12,6 +12,14 @@ import (
 )
 
 func main() {
+	if err := run(); err != nil {
+		log.Print(err)
+		os.Exit(1) //nolint:osexit // run has returned, so no deferred calls are skipped
+	}
+}
+
+// run reads a long URL from stdin, shortens it and prints the response.
+func run() error {
 	endpoint := "http://localhost:8080/"
 	// data container for the request
 	data := url.Values{}
@@ -22,18 +30,18 @@ func main() {
 
 	long, err := reader.ReadString('\n')
 	if err != nil {
-		log.Fatalf("Ошибка чтения URL: %v", err)
+		return fmt.Errorf("Ошибка чтения URL: %v", err)
 	}
 	long = strings.TrimSuffix(long, "\n")
 
 	// URL validation
 	if long == "" {
-		log.Fatalf("URL не может быть пустым")
+		return fmt.Errorf("URL не может быть пустым")
 	}
 
 	_, err = url.ParseRequestURI(long)
 	if err != nil {
-		log.Fatalf("Некорректный URL: %v", err)
+		return fmt.Errorf("Некорректный URL: %v", err)
 	}
 
 	data.Set("url", long)
@@ -42,14 +50,14 @@ func main() {
 
 	request, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(data.Encode()))
 	if err != nil {
-		log.Fatalf("Ошибка создания запроса: %v", err)
+		return fmt.Errorf("Ошибка создания запроса: %v", err)
 	}
 
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
 	response, err := client.Do(request)
 	if err != nil {
-		log.Fatalf("Ошибка отправки запроса: %v", err)
+		return fmt.Errorf("Ошибка отправки запроса: %v", err)
 	}
 
 	fmt.Println("Статус-код ", response.Status)
@@ -57,8 +65,9 @@ func main() {
 
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Fatalf("Ошибка чтения тела ответа: %v", err)
+		return fmt.Errorf("Ошибка чтения тела ответа: %v", err)
 	}
 
 	fmt.Println(string(body))
+	return nil
 }
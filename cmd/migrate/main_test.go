@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+)
+
+// recordingStorage is an in-memory target recording the batches added to it.
+type recordingStorage struct {
+	*storage.URLStorage
+	batches []map[string]string
+}
+
+func (s *recordingStorage) AddURLs(urls map[string]string, userID string) error {
+	s.batches = append(s.batches, urls)
+	return s.URLStorage.AddURLs(urls, userID)
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.json")
+	var lines []string
+	for i := 1; i <= 5; i++ {
+		lines = append(lines, fmt.Sprintf(`{"uuid":"%d","short_url":"short%d","original_url":"https://example.com/%d","user_id":"system"}`, i, i, i))
+	}
+	lines = append(lines, `{"uuid":"6","short_url":"short6","original_url":"https://example.com/6","user_id":"user1"}`)
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write storage file: %v", err)
+	}
+	records, err := storage.LoadURLRecords(path)
+	if err != nil {
+		t.Fatalf("LoadURLRecords() returned error: %v", err)
+	}
+
+	target := &recordingStorage{URLStorage: storage.NewURLStorage()}
+	// Already migrated by an earlier run
+	target.URLStorage.AddURL("short4", "https://example.com/4", "system")
+
+	result, err := migrate(records, target, 2)
+	if err != nil {
+		t.Fatalf("migrate() returned error: %v", err)
+	}
+	if result.Inserted != 5 || result.Skipped != 1 {
+		t.Errorf("Expected 5 inserted and 1 skipped, got %+v", result)
+	}
+	// system: [short1 short2], [short3 short4] (conflict, retried one by one), [short5]; user1: [short6]
+	if len(target.batches) != 4 {
+		t.Errorf("Expected 4 batches, got %d: %v", len(target.batches), target.batches)
+	}
+
+	for _, record := range records {
+		info, exists, _ := target.GetURLInfo(record.ShortURL)
+		if !exists || info.OriginalURL != record.OriginalURL || info.UserID != record.UserID {
+			t.Errorf("Expected %+v to be migrated, got %+v", record, info)
+		}
+	}
+
+	// Migrating again skips everything
+	result, err = migrate(records, target, 2)
+	if err != nil {
+		t.Fatalf("migrate() returned error: %v", err)
+	}
+	if result.Inserted != 0 || result.Skipped != 6 {
+		t.Errorf("Expected all 6 records to be skipped, got %+v", result)
+	}
+}
+
+func TestMigrate_StopsOnStorageError(t *testing.T) {
+	records := []storage.URLMapping{
+		{ShortURL: "short1", OriginalURL: "https://example.com/1", UserID: "system"},
+		{ShortURL: "short2", OriginalURL: "https://example.com/2", UserID: "system"},
+	}
+	target := storage.NewURLStorageWithOptions(storage.URLStorageOptions{MaxURLs: 1})
+
+	if _, err := migrate(records, target, 10); err == nil {
+		t.Error("Expected an error when the target is full")
+	}
+}
+
+func TestRun_RequiresFileAndDatabase(t *testing.T) {
+	t.Setenv("FILE_STORAGE_PATH", "")
+	t.Setenv("DATABASE_DSN", "")
+
+	var out strings.Builder
+	if err := run([]string{"-f", "urls.json"}, &out); err == nil {
+		t.Error("Expected an error without a database")
+	}
+	if err := run([]string{"-f", "urls.json", "-d", "postgres://localhost", "-batch-size", "0"}, &out); err == nil {
+		t.Error("Expected an error for a non-positive batch size")
+	}
+}
@@ -19,12 +19,21 @@ type ShortenRequest struct {
 }
 
 func main() {
-	if len(os.Args) < 3 {
-		log.Fatal("Usage: go run cmd/profiler/main.go <server_url> <profile_name>")
+	if err := run(os.Args[1:]); err != nil {
+		log.Print(err)
+		os.Exit(1) //nolint:osexit // run has returned, so no deferred calls are skipped
+	}
+}
+
+// run generates load on the server given in args and saves its memory profile
+// under the profile name given in args.
+func run(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: go run cmd/profiler/main.go <server_url> <profile_name>")
 	}
 
-	serverURL := os.Args[1]
-	profileName := os.Args[2]
+	serverURL := args[0]
+	profileName := args[1]
 
 	log.Printf("Starting load generation for %s", serverURL)
 	log.Printf("Profile will be saved as profiles/%s.pprof", profileName)
@@ -41,14 +50,14 @@ func main() {
 
 	log.Println("Collecting memory profile...")
 	err := collectMemoryProfile(serverURL, profileName)
-	if err != nil {
-		log.Fatalf("Failed to collect memory profile: %v", err)
-	}
-
 	close(stopChan)
 	wg.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to collect memory profile: %v", err)
+	}
 
 	log.Printf("Profile saved to profiles/%s.pprof", profileName)
+	return nil
 }
 
 func generateLoad(serverURL string, wg *sync.WaitGroup, stopChan chan bool, workerID int) {
@@ -12,8 +12,10 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/achufistov/shortygopher.git/internal/app/cleanup"
 	"github.com/achufistov/shortygopher.git/internal/app/config"
 	"github.com/achufistov/shortygopher.git/internal/app/handlers"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
 	"github.com/achufistov/shortygopher.git/internal/app/middleware"
 	"github.com/achufistov/shortygopher.git/internal/app/storage"
 
@@ -27,6 +29,9 @@ var (
 	buildCommit  string
 )
 
+// readinessInterval is how often the storage is pinged until the service is ready.
+const readinessInterval = time.Second
+
 func printBuildInfo() {
 	buildInfo := map[string]string{
 		"Build version": buildVersion,
@@ -42,8 +47,19 @@ func printBuildInfo() {
 	}
 }
 
-func initLogger() (*zap.Logger, error) {
-	logger, err := zap.NewProduction()
+// initLogger creates the application logger logging entries of at least level.
+// The console format writes human-readable entries; all other formats write JSON.
+func initLogger(format, level string) (*zap.Logger, error) {
+	zapConfig := zap.NewProductionConfig()
+	if format == config.LogFormatConsole {
+		zapConfig.Encoding = "console"
+	}
+	atomicLevel, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	zapConfig.Level = atomicLevel
+	logger, err := zapConfig.Build()
 	if err != nil {
 		return nil, err
 	}
@@ -51,6 +67,16 @@ func initLogger() (*zap.Logger, error) {
 }
 
 func main() {
+	if err := run(); err != nil {
+		fmt.Fprintf(os.Stderr, "shortener: %v\n", err)
+		os.Exit(1) //nolint:osexit // run has returned, so no deferred calls are skipped
+	}
+}
+
+// run starts the service and serves requests until a shutdown signal or a
+// server error. Deferred cleanup, such as syncing the logger and closing the
+// storage, runs before it returns, also when it fails to start.
+func run() error {
 	printBuildInfo()
 
 	// Parse command line flags
@@ -58,12 +84,12 @@ func main() {
 
 	cfg, err := config.LoadConfig()
 	if err != nil {
-		log.Fatalf("Error loading config: %v", err)
+		return fmt.Errorf("error loading config: %w", err)
 	}
 
-	logger, err := initLogger()
+	logger, err := initLogger(cfg.LogFormat, cfg.LogLevel)
 	if err != nil {
-		log.Fatalf("Error initializing logger: %v", err)
+		return fmt.Errorf("error initializing logger: %w", err)
 	}
 	defer func() {
 		if syncErr := logger.Sync(); syncErr != nil {
@@ -72,71 +98,158 @@ func main() {
 	}()
 
 	var storageInstance storage.Storage
-	if cfg.DatabaseDSN != "" {
-		dbStorage, dbErr := storage.NewDBStorage(cfg.DatabaseDSN)
+	switch cfg.StorageBackend {
+	case config.StorageBackendSQLite:
+		sqlitePath, _ := storage.SQLitePath(cfg.DatabaseDSN)
+		sqliteStorage, sqliteErr := storage.NewSQLiteStorage(sqlitePath, storage.SQLiteOptions{
+			NormalizeURLs: cfg.NormalizeURLs,
+		})
+		if sqliteErr != nil {
+			return fmt.Errorf("failed to initialize SQLite storage: %w", sqliteErr)
+		}
+		storageInstance = sqliteStorage
+	case config.StorageBackendPostgres:
+		dbStorage, dbErr := storage.NewDBStorageWithOptions(cfg.DatabaseDSN, storage.DBOptions{
+			ReplicaDSN:       cfg.DatabaseReplicaDSN,
+			NormalizeURLs:    cfg.NormalizeURLs,
+			MaxOpenConns:     cfg.DBMaxOpenConns,
+			MaxIdleConns:     cfg.DBMaxIdleConns,
+			ConnMaxLifetime:  cfg.DBConnMaxLifetime.Duration,
+			StatsCacheTTL:    cfg.StatsCacheTTL.Duration,
+			ApproximateStats: cfg.ApproximateStats,
+			PartialBatches:   cfg.PartialBatches,
+			WarmPool:         cfg.DBWarmPool,
+		})
 		if dbErr != nil {
-			log.Printf("Error initializing database storage: %v", dbErr)
 			if dbStorage != nil {
 				if closeErr := dbStorage.Close(); closeErr != nil {
-					log.Printf("Error closing database storage: %v", closeErr)
+					logger.Error("Error closing database storage", zap.Error(closeErr))
 				}
 			}
-			log.Fatalf("Failed to initialize database storage: %v", dbErr)
+			return fmt.Errorf("failed to initialize database storage: %w", dbErr)
 		}
 		defer func() {
 			if closeErr := dbStorage.Close(); closeErr != nil {
-				log.Printf("Error closing database storage: %v", closeErr)
+				logger.Error("Error closing database storage", zap.Error(closeErr))
 			}
 		}()
 		storageInstance = dbStorage
-	} else {
-		log.Println("Database DSN is empty, using in-memory storage")
-		storageInstance = storage.NewURLStorage()
+		if cfg.CircuitBreakerThreshold > 0 {
+			storageInstance = storage.NewCircuitBreaker(dbStorage, storage.CircuitBreakerOptions{
+				Threshold:    cfg.CircuitBreakerThreshold,
+				ResetTimeout: cfg.CircuitBreakerTimeout.Duration,
+				OnStateChange: func(state storage.CircuitState) {
+					logger.Warn("Database circuit breaker changed state", zap.Stringer("state", state))
+					metrics.CircuitBreakerState.Set(float64(state))
+				},
+			})
+		}
+		if cfg.EnableWriteFallback {
+			fallback, fallbackErr := storage.NewWriteFallback(storageInstance, storage.WriteFallbackOptions{
+				Path: cfg.WriteFallbackFile,
+			})
+			if fallbackErr != nil {
+				return fmt.Errorf("failed to initialize write fallback: %w", fallbackErr)
+			}
+			storageInstance = fallback
+		}
+	default:
+		logger.Info("Using in-memory storage", zap.String("backend", cfg.StorageBackend))
+		storageInstance = storage.NewURLStorageWithOptions(storage.URLStorageOptions{
+			NormalizeURLs: cfg.NormalizeURLs,
+			MaxURLs:       cfg.MaxTotalURLs,
+		})
 	}
 
-	urlMappings, err := storage.LoadURLMappings(cfg.FileStorage)
-	if err != nil {
-		log.Printf("Error loading URL mappings: %v", err)
-	} else {
-		for shortURL, originalURL := range urlMappings {
-			if addErr := storageInstance.AddURL(shortURL, originalURL, "system"); addErr != nil {
-				log.Printf("Error adding URL mapping (short: %s, original: %s): %v", shortURL, originalURL, addErr)
+	if cfg.FileStorage != "" {
+		saver := storage.GetBatchSaver(cfg.FileStorage)
+		saver.SetMaxPending(cfg.FileMaxPending)
+		saver.SetSaveInterval(cfg.FileSaveInterval.Duration)
+		records, loadErr := storage.LoadURLRecords(cfg.FileStorage)
+		if loadErr != nil {
+			logger.Error("Error loading URL mappings", zap.Error(loadErr))
+		} else {
+			// Import the records one by one, keeping their deletion and protection
+			// flags, so that a conflicting record doesn't prevent loading the others
+			for _, record := range records {
+				if addErr := storageInstance.Import([]storage.URLMapping{record}); addErr != nil {
+					logger.Error("Error adding URL mapping",
+						zap.String("short", record.ShortURL), zap.String("original", record.OriginalURL), zap.Error(addErr))
+				}
 			}
 		}
 	}
 
 	handlers.InitStorage(storageInstance)
+	handlers.InitLogger(logger)
+	handlers.InitSignedLinks(cfg.SecretKey)
+	handlers.InitCodePool(cfg.CodePoolSize)
+	handlers.InitCodeNamespacing(cfg.NamespaceCodes)
+	handlers.InitHTMLRedirects(cfg.HTMLRedirects)
+	if err := handlers.InitNotFound(cfg.NotFoundTemplate, cfg.NotFoundRedirect); err != nil {
+		return fmt.Errorf("failed to initialize not-found page: %w", err)
+	}
+	if cfg.FetchMetadata {
+		handlers.InitMetadataWorker(cfg.MetadataWorkers, cfg.MetadataQueueSize, cfg.MetadataTimeout.Duration)
+	}
+	metrics.SetDeleteWorkerHealthFunc(func() bool {
+		return handlers.DeleteWorkerHealthy(cfg.DeleteWorkerTimeout.Duration)
+	})
+	metrics.SetStorageSizeFunc(storageInstance.Count)
+
+	buildInfo := handlers.BuildInfo{
+		Version: buildVersion,
+		Date:    buildDate,
+		Commit:  buildCommit,
+	}
 
 	r := chi.NewRouter()
 
-	r.Use(middleware.LoggingMiddleware(logger))
-	r.Use(middleware.GzipMiddleware)
+	r.Use(middleware.TrailingSlashMiddleware(cfg))
+	r.Use(middleware.MetricsMiddlewareWithTiers(cfg))
+	switch cfg.LogFormat {
+	case config.LogFormatCLF:
+		r.Use(middleware.CommonLogMiddleware(os.Stdout))
+	case config.LogFormatCombined:
+		r.Use(middleware.CombinedLogMiddleware(os.Stdout))
+	default:
+		r.Use(middleware.LoggingMiddleware(logger))
+	}
+	r.Use(middleware.OptionsMiddleware(cfg, r))
+	r.Use(middleware.ResponseBufferingMiddleware(cfg))
+	r.Use(middleware.GzipMiddleware(cfg))
 	r.Use(middleware.AuthMiddleware(cfg))
+	var cleaner *cleanup.Cleaner
+	if cfg.AnonCleanupInterval.Duration > 0 {
+		tracker := cleanup.NewTracker()
+		r.Use(middleware.ActivityMiddleware(tracker))
+		cleaner = cleanup.NewCleaner(storageInstance, tracker, cleanup.Options{
+			TTL:         cfg.TokenTTL.Duration,
+			Interval:    cfg.AnonCleanupInterval.Duration,
+			FileStorage: cfg.FileStorage,
+		}, logger)
+	}
+	var sweeper *cleanup.TombstoneSweeper
+	if cfg.DeletedRetention.Duration > 0 {
+		sweeper = cleanup.NewTombstoneSweeper(storageInstance, cfg.DeletedRetention.Duration, cfg.FileStorage, logger)
+	}
+	r.Use(middleware.CSRFMiddleware(cfg))
+	r.Use(middleware.RateLimitMiddleware(cfg))
 
-	// Add pprof routes for profiling
+	// Add pprof routes for profiling; they stay at the root, as does /metrics
 	r.Mount("/debug/pprof", http.DefaultServeMux)
 
-	r.Post("/", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandlePost(cfg, w, r)
-	})
-	r.Get("/{id}", handlers.HandleGet)
-	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleShortenPost(cfg, w, r)
-	})
-	r.Post("/api/shorten/batch", func(w http.ResponseWriter, r *http.Request) {
-		handlers.HandleBatchShortenPost(cfg, w, r)
-	})
-	r.Get("/ping", handlers.HandlePing(storageInstance))
-	r.Get("/api/user/urls", handlers.HandleGetUserURLs(cfg))
-	r.Delete("/api/user/urls", handlers.HandleDeleteUserURLs(cfg))
+	r.Handle("/metrics", metrics.Handler())
+
+	registerRoutes(r, cfg, storageInstance, buildInfo)
 
 	// Create server with timeouts
 	srv := &http.Server{
 		Addr:         cfg.Address,
 		Handler:      r,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
 	}
 
 	// Create context that listens for interrupt signals
@@ -148,23 +261,27 @@ func main() {
 
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Server is running on %s", cfg.Address)
+		logger.Info("Server is running", zap.String("address", cfg.Address))
 		if cfg.EnableHTTPS {
-			log.Printf("HTTPS enabled, using certificate: %s and key: %s", cfg.CertFile, cfg.KeyFile)
+			logger.Info("HTTPS enabled", zap.String("cert", cfg.CertFile), zap.String("key", cfg.KeyFile))
 			serverErrors <- srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
 		} else {
 			serverErrors <- srv.ListenAndServe()
 		}
 	}()
 
+	// Serve shortening requests once the storage answers
+	go handlers.WaitForStorage(ctx, storageInstance, readinessInterval)
+
 	// Blocking select waiting for either a signal or an error
 	select {
 	case err := <-serverErrors:
 		if err != nil && err != http.ErrServerClosed {
-			log.Printf("Server error: %v", err)
+			logger.Error("Server error", zap.Error(err))
+			return err
 		}
 	case <-ctx.Done():
-		log.Printf("Start shutdown. Signal: %v", ctx.Err())
+		logger.Info("Start shutdown", zap.NamedError("signal", ctx.Err()))
 
 		// Give outstanding requests a deadline for completion
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -173,35 +290,107 @@ func main() {
 		// Trigger graceful shutdown
 		err := srv.Shutdown(shutdownCtx)
 		if err != nil {
-			log.Printf("Error during server shutdown: %v", err)
+			logger.Error("Error during server shutdown", zap.Error(err))
 
 			// If shutdown times out, force close
 			err = srv.Close()
 			if err != nil {
-				log.Printf("Error closing server: %v", err)
+				logger.Error("Error closing server", zap.Error(err))
 			}
 		}
 
+		// No more requests are served, finish accepted deletions and stop
+		// pre-generating short codes
+		if err := handlers.WaitForDeletes(shutdownCtx); err != nil {
+			logger.Error("Error waiting for pending deletes", zap.Error(err))
+		}
+		handlers.StopCodePool()
+		handlers.StopMetadataWorker()
+		if cleaner != nil {
+			cleaner.Close()
+		}
+		if sweeper != nil {
+			sweeper.Close()
+		}
+
 		// If using file storage, ensure all data is saved
 		if cfg.FileStorage != "" {
-			// Get all URLs from storage
-			urlMap := storageInstance.GetAllURLs()
-
-			// Save to file
-			if err := storage.SaveURLMappings(cfg.FileStorage, urlMap); err != nil {
-				log.Printf("Error saving URL mappings during shutdown: %v", err)
+			// Stop the periodic saver, then rewrite the file with complete records
+			// of all stored URLs, dropping the ones removed from storage
+			if err := storage.GetBatchSaver(cfg.FileStorage).Close(); err != nil {
+				logger.Error("Error saving URL mappings during shutdown", zap.Error(err))
+			}
+			if count, err := storage.SaveAllURLs(cfg.FileStorage, storageInstance); err != nil {
+				logger.Error("Error saving URL mappings during shutdown", zap.Error(err))
 			} else {
-				log.Printf("Successfully saved %d URL mappings to file", len(urlMap))
+				logger.Info("Saved URL mappings to file", zap.Int("count", count))
 			}
 		}
 
-		// Ensure database connection is properly closed
-		if dbStorage, ok := storageInstance.(*storage.DBStorage); ok {
-			if err := dbStorage.Close(); err != nil {
-				log.Printf("Error closing database connection: %v", err)
+		// Ensure database connection is properly closed; the storage may be
+		// wrapped in a circuit breaker or write fallback, which close the
+		// underlying storage
+		if cfg.StorageBackend == config.StorageBackendPostgres || cfg.StorageBackend == config.StorageBackendSQLite {
+			if err := storageInstance.Close(); err != nil {
+				logger.Error("Error closing database connection", zap.Error(err))
 			}
 		}
 
-		log.Println("Server shutdown completed")
+		logger.Info("Server shutdown completed")
 	}
+	return nil
+}
+
+// registerRoutes registers the service routes on r, under cfg.PathPrefix if set.
+func registerRoutes(r chi.Router, cfg *config.Config, storageInstance storage.Storage, buildInfo handlers.BuildInfo) {
+	if cfg.PathPrefix != "" {
+		r.Route(cfg.PathPrefix, func(r chi.Router) {
+			registerServiceRoutes(r, cfg, storageInstance, buildInfo)
+		})
+		return
+	}
+	registerServiceRoutes(r, cfg, storageInstance, buildInfo)
+}
+
+// registerServiceRoutes registers the handlers of the service on r.
+func registerServiceRoutes(r chi.Router, cfg *config.Config, storageInstance storage.Storage, buildInfo handlers.BuildInfo) {
+	// Shortening needs the storage, so it waits for the service to be ready
+	shorten := r.With(handlers.RequireReady)
+	shorten.Post("/", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandlePost(cfg, w, r)
+	})
+	r.Get("/{id}", handlers.HandleGet)
+	r.Head("/{id}", handlers.HandleGet)
+	r.Get("/{id}/qr", handlers.HandleGetQR(cfg))
+	r.Get("/api/expand/{id}", handlers.HandleExpand)
+	shorten.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenPost(cfg, w, r)
+	})
+	shorten.Post("/api/v2/shorten", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenV2Post(cfg, w, r)
+	})
+	shorten.Post("/api/shorten/batch", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleBatchShortenPost(cfg, w, r)
+	})
+	shorten.Post("/api/shorten/csv", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenCSVPost(cfg, w, r)
+	})
+	r.Get("/ping", handlers.HandlePing(storageInstance))
+	r.Get("/health", handlers.HandleHealth(buildInfo))
+	r.Get("/ready", handlers.HandleReady)
+	r.Get("/api/version", handlers.HandleVersion(buildInfo))
+	r.Get("/openapi.json", handlers.HandleOpenAPI)
+	r.Get("/api/user/urls", handlers.HandleGetUserURLs(cfg))
+	r.Delete("/api/user/urls", handlers.HandleDeleteUserURLs(cfg))
+	r.Post("/api/user/urls/restore", handlers.HandleRestoreUserURLs(cfg))
+	r.Delete("/api/user", handlers.HandlePurgeUser(cfg))
+
+	r.Route("/api/internal", func(r chi.Router) {
+		r.Use(middleware.TrustedSubnetMiddleware(cfg))
+		r.Use(middleware.SignedRequestMiddleware(cfg))
+		r.Get("/stats", handlers.HandleGetStats)
+		r.Get("/urls/{id}", handlers.HandleGetURLDetails)
+		r.Get("/export", handlers.HandleExport)
+		r.Post("/flush", handlers.HandleFlush(cfg))
+	})
 }
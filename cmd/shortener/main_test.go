@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/handlers"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestRegisterRoutes_PathPrefix(t *testing.T) {
+	cfg := &config.Config{BaseURL: "http://localhost:8080", PathPrefix: "/s"}
+	storageInstance := storage.NewURLStorage()
+	handlers.InitStorage(storageInstance)
+	handlers.SetReady(true)
+	t.Cleanup(func() { handlers.SetReady(false) })
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), middleware.UserIDKey, "test_user")
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	})
+	registerRoutes(r, cfg, storageInstance, handlers.BuildInfo{})
+
+	req := httptest.NewRequest(http.MethodPost, "/s/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+
+	var resp handlers.ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(resp.ShortURL, "http://localhost:8080/s/") {
+		t.Fatalf("Expected the short URL to contain the prefix, got %s", resp.ShortURL)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, strings.TrimPrefix(resp.ShortURL, "http://localhost:8080"), nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected status 307, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); location != "https://example.com" {
+		t.Errorf("Expected redirect to https://example.com, got %s", location)
+	}
+
+	// Routes are not served at the root anymore
+	req = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.org"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 outside the prefix, got %d", w.Code)
+	}
+}
+
+func TestInitLogger_Levels(t *testing.T) {
+	levels := []zapcore.Level{zapcore.DebugLevel, zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel}
+	for _, level := range []string{config.LogLevelDebug, config.LogLevelInfo, config.LogLevelWarn, config.LogLevelError} {
+		t.Run(level, func(t *testing.T) {
+			logger, err := initLogger(config.LogFormatJSON, level)
+			if err != nil {
+				t.Fatalf("initLogger() returned error: %v", err)
+			}
+			want, _ := zapcore.ParseLevel(level)
+			for _, l := range levels {
+				if enabled := logger.Core().Enabled(l); enabled != (l >= want) {
+					t.Errorf("Expected %s entries enabled=%v at level %s, got %v", l, l >= want, level, enabled)
+				}
+			}
+		})
+	}
+
+	if _, err := initLogger(config.LogFormatJSON, "verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
@@ -17,7 +17,8 @@ Multichecker объединяет несколько анализаторов д
   - shadow: проверяет затенение переменных
 
 Собственный анализатор:
-  - osexit: запрещает прямые вызовы os.Exit в функции main пакета main
+  - osexit: запрещает прямые вызовы os.Exit и log.Fatal, log.Fatalf, log.Fatalln в функции main пакета main;
+    с флагом -osexit.allow разрешает вызовы в строках с комментарием //nolint:osexit
 
 Использование:
 
@@ -36,12 +37,13 @@ Multichecker объединяет несколько анализаторов д
 	# Анализ конкретного пакета
 	go run cmd/staticlint/*.go ./internal/app/handlers
 
-	# Анализ всех пакетов проекта
-	go run cmd/staticlint/*.go ./...
+	# Анализ всех пакетов проекта; команды проекта завершаются с ошибкой
+	# единственным помеченным вызовом os.Exit в main после возврата из run
+	go run cmd/staticlint/*.go -osexit.allow ./...
 
 	# Сборка и запуск через бинарный файл
 	go build -o bin/staticlint ./cmd/staticlint
-	./bin/staticlint ./...
+	./bin/staticlint -osexit.allow ./...
 
 	# Показать справку
 	go run cmd/staticlint/*.go -help
@@ -3,21 +3,52 @@ package main
 import (
 	"go/ast"
 	"go/types"
+	"strings"
 
 	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/analysis/passes/inspect"
 	"golang.org/x/tools/go/ast/inspector"
 )
 
-// OSExitAnalyzer - analyzer for checking os.Exit usage in main function of main package
+// osExitAllowMarker - comment marker suppressing the diagnostic on its line when -osexit.allow is set
+const osExitAllowMarker = "//nolint:osexit"
+
+// exitFuncs - functions exiting the program without running deferred calls, by package path
+var exitFuncs = map[string]map[string]bool{
+	"os":  {"Exit": true},
+	"log": {"Fatal": true, "Fatalf": true, "Fatalln": true},
+}
+
+// OSExitAnalyzer - analyzer for checking os.Exit and log.Fatal* usage in main function of main package
 var OSExitAnalyzer = &analysis.Analyzer{
 	Name:     "osexit",
-	Doc:      "check for os.Exit usage in main function of main package",
+	Doc:      "check for os.Exit and log.Fatal, log.Fatalf, log.Fatalln usage in main function of main package",
 	Run:      runOSExitAnalyzer,
 	Requires: []*analysis.Analyzer{inspect.Analyzer},
 }
 
-// runOSExitAnalyzer - runs the analyzer for checking os.Exit usage in main function of main package
+// osExitAllow - allows exit calls marked with osExitAllowMarker (flag -osexit.allow)
+var osExitAllow bool
+
+func init() {
+	OSExitAnalyzer.Flags.BoolVar(&osExitAllow, "allow", false,
+		"allow os.Exit and log.Fatal calls on lines marked with "+osExitAllowMarker)
+}
+
+// allowedLines - returns the lines of file carrying osExitAllowMarker
+func allowedLines(pass *analysis.Pass, file *ast.File) map[int]bool {
+	lines := make(map[int]bool)
+	for _, group := range file.Comments {
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, osExitAllowMarker) {
+				lines[pass.Fset.Position(comment.Slash).Line] = true
+			}
+		}
+	}
+	return lines
+}
+
+// runOSExitAnalyzer - runs the analyzer for checking os.Exit and log.Fatal* usage in main function of main package
 func runOSExitAnalyzer(pass *analysis.Pass) (interface{}, error) {
 	// Check if this is the main package
 	if pass.Pkg.Name() != "main" {
@@ -26,12 +57,23 @@ func runOSExitAnalyzer(pass *analysis.Pass) (interface{}, error) {
 
 	inspect := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
 
-	// Filter to examine only function declarations
+	// Filter to examine only files and function declarations
 	nodeFilter := []ast.Node{
+		(*ast.File)(nil),
 		(*ast.FuncDecl)(nil),
 	}
 
+	// Lines of the current file where exit calls are allowed
+	var allowed map[int]bool
+
 	inspect.Preorder(nodeFilter, func(n ast.Node) {
+		if file, ok := n.(*ast.File); ok {
+			allowed = nil
+			if osExitAllow {
+				allowed = allowedLines(pass, file)
+			}
+			return
+		}
 		funcDecl := n.(*ast.FuncDecl)
 
 		// Check if this is the main function
@@ -44,7 +86,7 @@ func runOSExitAnalyzer(pass *analysis.Pass) (interface{}, error) {
 			return
 		}
 
-		// Inspect the main function body for os.Exit calls
+		// Inspect the main function body for os.Exit and log.Fatal* calls
 		ast.Inspect(funcDecl, func(node ast.Node) bool {
 			callExpr, ok := node.(*ast.CallExpr)
 			if !ok {
@@ -57,17 +99,21 @@ func runOSExitAnalyzer(pass *analysis.Pass) (interface{}, error) {
 				return true
 			}
 
-			// Check if the function is "Exit"
-			if selExpr.Sel.Name != "Exit" {
-				return true
-			}
-
-			// Check if the package is "os"
+			// Check if the package is "os" or "log" and the function exits
 			if ident, ok := selExpr.X.(*ast.Ident); ok {
 				// Check the object type
 				obj := pass.TypesInfo.ObjectOf(ident)
-				if pkg, ok := obj.(*types.PkgName); ok && pkg.Imported().Path() == "os" {
+				pkg, ok := obj.(*types.PkgName)
+				if !ok || !exitFuncs[pkg.Imported().Path()][selExpr.Sel.Name] {
+					return true
+				}
+				if allowed[pass.Fset.Position(callExpr.Pos()).Line] {
+					return true
+				}
+				if pkg.Imported().Path() == "os" {
 					pass.Reportf(callExpr.Pos(), "direct call to os.Exit is not allowed in main function of main package")
+				} else {
+					pass.Reportf(callExpr.Pos(), "call to log.%s is not allowed in main function of main package: it exits without running deferred calls", selExpr.Sel.Name)
 				}
 			}
 
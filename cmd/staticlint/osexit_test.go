@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestOSExitAnalyzer(t *testing.T) {
+	// Without -osexit.allow the marker is ignored
+	analysistest.Run(t, analysistest.TestData(), OSExitAnalyzer, "reported")
+}
+
+func TestOSExitAnalyzer_LogFatal(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), OSExitAnalyzer, "logfatal")
+}
+
+func TestOSExitAnalyzer_Allow(t *testing.T) {
+	if err := OSExitAnalyzer.Flags.Set("allow", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer OSExitAnalyzer.Flags.Set("allow", "false")
+
+	analysistest.Run(t, analysistest.TestData(), OSExitAnalyzer, "allowed")
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"log"
+	stdlog "log"
+)
+
+type logger struct{}
+
+func (logger) Fatal(v ...interface{}) {}
+
+func main() {
+	log.Fatal("fatal")                 // want "call to log.Fatal is not allowed in main function of main package: it exits without running deferred calls"
+	log.Fatalf("fatal: %v", "error")   // want "call to log.Fatalf is not allowed in main function of main package: it exits without running deferred calls"
+	log.Fatalln("fatal")               // want "call to log.Fatalln is not allowed in main function of main package: it exits without running deferred calls"
+	stdlog.Fatal("fatal via an alias") // want "call to log.Fatal is not allowed in main function of main package: it exits without running deferred calls"
+	log.Println("not fatal")
+	logger{}.Fatal("not the log package")
+
+	var l logger
+	l.Fatal("not the log package")
+}
+
+func helper() {
+	log.Fatal("only main is checked")
+}
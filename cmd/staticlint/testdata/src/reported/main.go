@@ -0,0 +1,8 @@
+package main
+
+import "os"
+
+func main() {
+	os.Exit(1) //nolint:osexit // want "direct call to os.Exit is not allowed in main function of main package"
+	os.Exit(2) // want "direct call to os.Exit is not allowed in main function of main package"
+}
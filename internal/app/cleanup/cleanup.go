@@ -0,0 +1,170 @@
+// Package cleanup purges data of anonymous users who stopped using the service.
+//
+// Users are created implicitly by the auth middleware, which issues a token with
+// a fresh UUID to every client without one. Once such a token expires, its user
+// can never authenticate again and the user's URLs can no longer be listed or
+// deleted. The Cleaner removes them after the user has been inactive for longer
+// than the token lifetime.
+//
+// Deleted URLs are kept as tombstones, so that stale links keep answering 410
+// Gone. The TombstoneSweeper removes them for good once they are older than a
+// retention period.
+package cleanup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// Tracker records the last activity of users. It is safe for concurrent use.
+type Tracker struct {
+	mu       sync.Mutex
+	started  time.Time
+	lastSeen map[string]time.Time
+	now      func() time.Time
+}
+
+// NewTracker creates an empty activity tracker.
+func NewTracker() *Tracker {
+	return &Tracker{
+		started:  time.Now(),
+		lastSeen: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Touch records activity of the specified user.
+func (t *Tracker) Touch(userID string) {
+	t.mu.Lock()
+	t.lastSeen[userID] = t.now()
+	t.mu.Unlock()
+}
+
+// LastSeen returns the last recorded activity of the specified user. Activity is
+// only tracked in memory, so users not seen since the tracker was created are
+// reported as last seen at its creation; a restart never makes users look idle.
+func (t *Tracker) LastSeen(userID string) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if seen, ok := t.lastSeen[userID]; ok {
+		return seen
+	}
+	return t.started
+}
+
+// forget drops activity recorded before cutoff, keeping the tracker bounded.
+func (t *Tracker) forget(cutoff time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for userID, seen := range t.lastSeen {
+		if seen.Before(cutoff) {
+			delete(t.lastSeen, userID)
+		}
+	}
+}
+
+// Options configures a Cleaner.
+type Options struct {
+	// TTL is the inactivity after which the URLs of anonymous users are purged,
+	// normally the lifetime of their auth tokens.
+	TTL time.Duration
+
+	// Interval is how often the cleaner sweeps the storage.
+	Interval time.Duration
+
+	// FileStorage is the file rewritten after purging URLs, so that they aren't
+	// loaded again. Empty if URLs aren't saved to a file.
+	FileStorage string
+}
+
+// Cleaner periodically purges URLs of anonymous users inactive for longer than
+// the configured TTL.
+type Cleaner struct {
+	store   storage.Storage
+	tracker *Tracker
+	opts    Options
+	logger  *zap.Logger
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewCleaner creates a cleaner and starts sweeping store every opts.Interval.
+// Sweep results are logged to logger.
+func NewCleaner(store storage.Storage, tracker *Tracker, opts Options, logger *zap.Logger) *Cleaner {
+	c := &Cleaner{
+		store:   store,
+		tracker: tracker,
+		opts:    opts,
+		logger:  logger,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go c.loop()
+	return c
+}
+
+// Sweep purges the URLs of anonymous users inactive for longer than the TTL and
+// returns the number of purged URLs. Only users with UUID identifiers, as issued
+// by the auth middleware, are considered anonymous; other owners, such as the
+// "system" user of file-loaded mappings, are never purged.
+func (c *Cleaner) Sweep() (int, error) {
+	cutoff := c.tracker.now().Add(-c.opts.TTL)
+
+	userIDs, err := c.store.GetUserIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	var inactive []string
+	for _, userID := range userIDs {
+		if _, err := uuid.Parse(userID); err != nil {
+			continue
+		}
+		if c.tracker.LastSeen(userID).Before(cutoff) {
+			inactive = append(inactive, userID)
+		}
+	}
+
+	c.tracker.forget(cutoff)
+	if len(inactive) == 0 {
+		return 0, nil
+	}
+	purged, err := c.store.PurgeUsers(inactive)
+	if err != nil || purged == 0 || c.opts.FileStorage == "" {
+		return purged, err
+	}
+	_, err = storage.SaveAllURLs(c.opts.FileStorage, c.store)
+	return purged, err
+}
+
+// Close stops the cleaner and waits for a running sweep to finish.
+func (c *Cleaner) Close() {
+	c.once.Do(func() { close(c.stop) })
+	<-c.done
+}
+
+// loop sweeps the storage every interval until the cleaner is closed.
+func (c *Cleaner) loop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			purged, err := c.Sweep()
+			if err != nil {
+				c.logger.Error("Failed to purge inactive anonymous users", zap.Error(err))
+			} else if purged > 0 {
+				c.logger.Info("Purged URLs of inactive anonymous users", zap.Int("count", purged))
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package cleanup
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+func TestCleaner_PurgesInactiveAnonymousUsers(t *testing.T) {
+	store := storage.NewURLStorage()
+	tracker := NewTracker()
+
+	staleUser := uuid.NewString()
+	activeUser := uuid.NewString()
+	store.AddURL("stale1", "https://stale.example.com/1", staleUser)
+	store.AddURL("stale2", "https://stale.example.com/2", staleUser)
+	store.AddURL("active1", "https://active.example.com", activeUser)
+	store.AddURL("system1", "https://system.example.com", "system")
+
+	// The stale user was last seen two days ago, the active one just now
+	now := time.Now()
+	tracker.now = func() time.Time { return now.Add(-48 * time.Hour) }
+	tracker.Touch(staleUser)
+	tracker.now = func() time.Time { return now }
+	tracker.Touch(activeUser)
+	tracker.started = now.Add(-72 * time.Hour)
+
+	fileStorage := filepath.Join(t.TempDir(), "urls.json")
+	if _, err := storage.SaveAllURLs(fileStorage, store); err != nil {
+		t.Fatalf("SaveAllURLs() returned error: %v", err)
+	}
+
+	cleaner := &Cleaner{store: store, tracker: tracker, opts: Options{TTL: 24 * time.Hour, FileStorage: fileStorage}}
+	purged, err := cleaner.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep() returned error: %v", err)
+	}
+	if purged != 2 {
+		t.Errorf("Expected 2 purged URLs, got %d", purged)
+	}
+
+	for _, shortURL := range []string{"stale1", "stale2"} {
+		if _, exists, _ := store.GetURL(shortURL); exists {
+			t.Errorf("Expected %s of the inactive user to be purged", shortURL)
+		}
+	}
+	if _, found := store.GetShortURLByOriginalURL("https://stale.example.com/1"); found {
+		t.Error("Expected purged URL to be forgotten by original URL lookups")
+	}
+	for _, shortURL := range []string{"active1", "system1"} {
+		if _, exists, _ := store.GetURL(shortURL); !exists {
+			t.Errorf("Expected %s to be kept", shortURL)
+		}
+	}
+	if _, tracked := tracker.lastSeen[staleUser]; tracked {
+		t.Error("Expected the tracker to forget the inactive user")
+	}
+	// The purged URLs aren't loaded from the file again
+	saved, err := storage.LoadURLMappings(fileStorage)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	if len(saved) != 2 || saved["active1"] == "" || saved["system1"] == "" {
+		t.Errorf("Expected only the kept URLs left in the file, got %v", saved)
+	}
+}
+
+func TestCleaner_KeepsUsersUnseenSinceStart(t *testing.T) {
+	store := storage.NewURLStorage()
+	store.AddURL("short1", "https://example.com", uuid.NewString())
+
+	cleaner := NewCleaner(store, NewTracker(), Options{TTL: time.Hour, Interval: time.Hour}, zap.NewNop())
+	defer cleaner.Close()
+
+	purged, err := cleaner.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep() returned error: %v", err)
+	}
+	if purged != 0 {
+		t.Errorf("Expected users unseen since start to be kept, got %d purged URLs", purged)
+	}
+}
+
+func TestTombstoneSweeper_RemovesExpiredTombstones(t *testing.T) {
+	store := storage.NewURLStorage()
+	store.AddURL("deleted", "https://deleted.example.com", "user1")
+	store.AddURL("live", "https://live.example.com", "user1")
+	store.DeleteURLs([]string{"deleted"}, "user1")
+
+	fileStorage := filepath.Join(t.TempDir(), "urls.json")
+	if _, err := storage.SaveAllURLs(fileStorage, store); err != nil {
+		t.Fatalf("SaveAllURLs() returned error: %v", err)
+	}
+
+	sweeper := &TombstoneSweeper{store: store, retention: 24 * time.Hour, fileStorage: fileStorage, now: time.Now}
+	if removed, err := sweeper.Sweep(); err != nil || removed != 0 {
+		t.Fatalf("Expected a recent tombstone to be kept, removed %d (err: %v)", removed, err)
+	}
+
+	// A day and a bit later the tombstone has expired
+	sweeper.now = func() time.Time { return time.Now().Add(25 * time.Hour) }
+	removed, err := sweeper.Sweep()
+	if err != nil {
+		t.Fatalf("Sweep() returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 removed URL, got %d", removed)
+	}
+	if _, exists, _ := store.GetURL("deleted"); exists {
+		t.Error("Expected the expired tombstone to be removed")
+	}
+	if _, exists, _ := store.GetURL("live"); !exists {
+		t.Error("Expected the live URL to be kept")
+	}
+	// The removed tombstone isn't loaded from the file again
+	saved, err := storage.LoadURLMappings(fileStorage)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	if len(saved) != 1 || saved["live"] == "" {
+		t.Errorf("Expected only the live URL left in the file, got %v", saved)
+	}
+}
+
+func TestTombstoneSweeper_Close(t *testing.T) {
+	sweeper := NewTombstoneSweeper(storage.NewURLStorage(), time.Hour, "", zap.NewNop())
+	sweeper.Close()
+	sweeper.Close()
+}
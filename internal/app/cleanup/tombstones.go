@@ -0,0 +1,83 @@
+package cleanup
+
+import (
+	"sync"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// maxTombstoneSweepInterval bounds how often tombstones are swept, so that
+// long retention periods don't leave expired tombstones around for as long.
+const maxTombstoneSweepInterval = time.Hour
+
+// TombstoneSweeper periodically hard-deletes URLs deleted longer than the
+// retention period ago.
+type TombstoneSweeper struct {
+	store     storage.Storage
+	retention time.Duration
+	// fileStorage is the file rewritten without the removed URLs, if any
+	fileStorage string
+	logger      *zap.Logger
+	now         func() time.Time
+	stop        chan struct{}
+	done        chan struct{}
+	once        sync.Once
+}
+
+// NewTombstoneSweeper creates a sweeper and starts sweeping store every
+// retention period, but at least hourly. Unless fileStorage is empty, the file
+// is rewritten after each sweep removing URLs, so that they aren't loaded again.
+// Sweep results are logged to logger.
+func NewTombstoneSweeper(store storage.Storage, retention time.Duration, fileStorage string, logger *zap.Logger) *TombstoneSweeper {
+	s := &TombstoneSweeper{
+		store:       store,
+		retention:   retention,
+		fileStorage: fileStorage,
+		logger:      logger,
+		now:         time.Now,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go s.loop(min(retention, maxTombstoneSweepInterval))
+	return s
+}
+
+// Sweep hard-deletes URLs deleted longer than the retention period ago and
+// returns the number of removed URLs.
+func (s *TombstoneSweeper) Sweep() (int, error) {
+	removed, err := s.store.HardDeleteExpired(s.now().Add(-s.retention))
+	if err != nil || removed == 0 || s.fileStorage == "" {
+		return removed, err
+	}
+	_, err = storage.SaveAllURLs(s.fileStorage, s.store)
+	return removed, err
+}
+
+// Close stops the sweeper and waits for a running sweep to finish.
+func (s *TombstoneSweeper) Close() {
+	s.once.Do(func() { close(s.stop) })
+	<-s.done
+}
+
+// loop sweeps the storage every interval until the sweeper is closed.
+func (s *TombstoneSweeper) loop(interval time.Duration) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			removed, err := s.Sweep()
+			if err != nil {
+				s.logger.Error("Failed to remove expired deleted URLs", zap.Error(err))
+			} else if removed > 0 {
+				s.logger.Info("Removed expired deleted URLs", zap.Int("count", removed))
+			}
+		}
+	}
+}
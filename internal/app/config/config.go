@@ -2,32 +2,190 @@
 package config
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"math"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	addressFlag     = flag.String("a", "localhost:8080", "HTTP server address")
 	baseURLFlag     = flag.String("b", "http://localhost:8080", "Base URL for shortened links")
 	fileStoragePath = flag.String("f", "urls.json", "File for storing urls")
-	databaseDSNFlag = flag.String("d", "", "Database connection string")
+	databaseDSNFlag = flag.String("d", "", "Database connection string (sqlite://path.db for SQLite)")
+	dbMaxOpenConns  = flag.Int("db-max-open-conns", 0, "Maximum open database connections (default 25)")
+	dbMaxIdleConns  = flag.Int("db-max-idle-conns", 0, "Maximum idle database connections (default 10)")
+	dbConnLifetime  = flag.Duration("db-conn-max-lifetime", 0, "Maximum time a database connection is reused (default 30m)")
+	replicaDSNFlag  = flag.String("dr", "", "Read replica database connection string")
 	jwtSecretFile   = flag.String("jwt-secret-file", "secret.key", "Path to JWT secret file")
-	configFile      = flag.String("c", "", "Path to JSON configuration file (can also use -config)")
+	configFile      = flag.String("c", "", "Path to JSON or YAML configuration file (can also use -config)")
 	enableHTTPS     = flag.Bool("s", false, "Enable HTTPS server")
 	certFile        = flag.String("cert", "cert.pem", "Path to TLS certificate file")
 	keyFile         = flag.String("key", "key.pem", "Path to TLS private key file")
+	csrfProtection  = flag.Bool("csrf", false, "Enable double-submit CSRF protection on write endpoints")
+	trailingSlash   = flag.String("trailing-slash", "", "Trailing slash handling in routes: strip, redirect or empty to disable")
+	trustedSubnet   = flag.String("t", "", "Trusted subnet in CIDR notation for internal endpoints")
+	proxyCIDRs      = flag.String("proxy-cidrs", "", "Comma-separated proxy CIDRs skipped when resolving client IPs from X-Forwarded-For")
+	codePoolSize    = flag.Int("code-pool", 0, "Number of short codes to pre-generate (0 disables the pool)")
+	internalAPIKey  = flag.String("internal-key", "", "API key granting access to internal endpoints")
+	signingKey      = flag.String("internal-signing-key", "", "Shared key requiring HMAC-signed requests on internal endpoints")
+	bufferResponses = flag.Bool("buffer-responses", false, "Buffer responses to send Content-Length")
+	normalizeURLs   = flag.Bool("normalize-urls", false, "Detect duplicate URLs by their normalized form")
+	cbThreshold     = flag.Int("cb-threshold", 0, "Consecutive database failures that open the circuit breaker (0 disables it)")
+	cbTimeout       = flag.Duration("cb-timeout", 0, "Time the circuit breaker stays open before probing the database (default 30s)")
+	rateLimitRPS    = flag.Float64("rate-limit", 0, "Requests per second allowed per client (0 disables rate limiting)")
+	rateLimitBurst  = flag.Int("rate-burst", 0, "Request burst allowed per client (defaults to the rate limit)")
+	dedupBatch      = flag.Bool("dedup-batch", true, "Share one short URL between repeated original URLs in a batch")
+	requireHTTPS    = flag.Bool("require-https-targets", false, "Only allow shortening https:// URLs")
+	writeFallback   = flag.Bool("write-fallback", false, "Queue failed database writes to a local log and replay them later")
+	fallbackFile    = flag.String("write-fallback-file", "urls.wal", "Write-ahead log file for queued database writes")
+	readTimeout     = flag.Duration("read-timeout", 0, "Maximum duration for reading a request (default 5s)")
+	writeTimeout    = flag.Duration("write-timeout", 0, "Maximum duration before timing out writes of a response (default 10s)")
+	idleTimeout     = flag.Duration("idle-timeout", 0, "Maximum time to wait for the next request on keep-alive connections (default 2m)")
+	maxTotalURLs    = flag.Int("max-urls", 0, "Maximum number of URLs kept in memory (0 means unlimited)")
+	decompressReqs  = flag.Bool("decompress-requests", true, "Decompress gzip, deflate and brotli request bodies")
+	logFormat       = flag.String("log-format", LogFormatJSON, "Log format: json, console, clf or combined")
+	verboseJSON     = flag.Bool("verbose-json", false, "Include empty optional fields in JSON responses")
+	recordCType     = flag.Bool("record-content-type", false, "Record how URLs were submitted and list it with the user's URLs")
+	namespaceCodes  = flag.Bool("namespace-codes", false, "Prefix generated short codes with a token derived from the user ID")
+	htmlRedirects   = flag.Bool("html-redirects", false, "Redirect clients accepting HTML with a meta refresh page instead of 307")
+	tokenTTL        = flag.Duration("token-ttl", 0, "Lifetime of auth tokens issued to anonymous users (default 24h)")
+	answerOptions   = flag.Bool("answer-options", true, "Answer OPTIONS requests on every route with the allowed methods")
+	idempotencyTTL  = flag.Duration("idempotency-ttl", 0, "Time results of requests with an Idempotency-Key are replayed (default 24h)")
+	statsCacheTTL   = flag.Duration("stats-cache-ttl", 0, "Time database statistics are cached (0 disables caching)")
+	approxStats     = flag.Bool("approximate-stats", false, "Estimate the total number of URLs from PostgreSQL planner statistics")
+	postRedirect    = flag.Bool("post-redirect-get", false, "Answer browser form submissions to / with 303 See Other to the short URL")
+	maxGzipWriters  = flag.Int("max-gzip-writers", 0, "Maximum number of concurrently compressed responses; others are served uncompressed (0 means unlimited)")
+	deprecationWarn = flag.Bool("deprecation-warnings", false, "Send a Warning header on responses of deprecated endpoints")
+	partialBatches  = flag.Bool("partial-batches", false, "Store the valid URLs of a batch even if the database rejects others")
+	metricsTiers    = flag.String("metrics-tiers", "", "Comma-separated user tiers labeling request metrics; other tiers are labeled \"other\"")
+	pathPrefix      = flag.String("path-prefix", "", "Path prefix all routes are served under, e.g. /s")
+	recordCreatorIP = flag.Bool("record-creator-ip", false, "Record a salted hash of the IP address each URL was created from")
+	maxDeleteBatch  = flag.Int("max-delete-batch", 0, "Maximum number of short URLs per delete request (0 means unlimited)")
+	dbWarmPool      = flag.Bool("db-warm-pool", false, "Open the idle database connections at startup instead of on the first requests")
+	notFoundTmpl    = flag.String("not-found-template", "", "Path to an HTML template served to browsers for unknown short URLs")
+	notFoundURL     = flag.String("not-found-redirect", "", "URL browsers are redirected to for unknown short URLs")
+	deleteTimeout   = flag.Duration("delete-worker-timeout", 0, "Time the delete queue may stall before the worker is reported unhealthy (default 1m)")
+	fetchMetadata   = flag.Bool("fetch-metadata", false, "Fetch and store the title and favicon of target pages when shortening")
+	metadataTimeout = flag.Duration("metadata-timeout", 0, "Maximum time fetching the metadata of a target page may take (default 3s)")
+	logLevel        = flag.String("log-level", "", "Minimum level of logged entries: debug, info, warn or error (default info)")
+	metadataWorkers = flag.Int("metadata-workers", 0, "Number of concurrent page metadata fetches (default 2)")
+	metadataQueue   = flag.Int("metadata-queue-size", 0, "Number of URLs waiting for their page metadata to be fetched (default 100)")
+	maxURLsPerUser  = flag.Int("max-urls-per-user", 0, "Maximum number of URLs a user may hold (0 means unlimited)")
+	retainDeleted   = flag.Duration("deleted-retention", 0, "How long deleted URLs are kept before they are removed for good (0 keeps them forever)")
+	storageBackend  = flag.String("storage-backend", "", "Storage backend: memory, file, postgres or sqlite (default detected from -d and -f)")
+	gzipLevel       = flag.Int("gzip-level", 0, "Gzip compression level of responses: 1 (best speed) to 9 (best compression), -2 for Huffman-only (default -1)")
+	fileMaxPending  = flag.Int("file-max-pending", 0, "Number of URLs queued for the file storage that triggers an early save (0 disables it)")
+	fileSaveEvery   = flag.Duration("file-save-interval", 0, "Interval of saving queued URLs to the file storage (default 5s)")
+	signedLinkTTL   = flag.Duration("signed-link-ttl", 0, "Lifetime of signed links to protected URLs (default 24h)")
+	reservedAliases = flag.String("reserved-aliases", "", "Comma-separated aliases that can't be claimed, e.g. first path segments of routes (default api,debug,health,metrics,ping,ready)")
+	anonCleanup     = flag.Duration("anon-cleanup-interval", 0, "Interval of purging URLs of anonymous users inactive past the token lifetime (0 disables it)")
+)
+
+// DefaultCircuitBreakerTimeout is used when no circuit breaker reset timeout is configured.
+const DefaultCircuitBreakerTimeout = 30 * time.Second
+
+// Default database connection pool settings, used when none are configured.
+const (
+	DefaultDBMaxOpenConns    = 25
+	DefaultDBMaxIdleConns    = 10
+	DefaultDBConnMaxLifetime = 30 * time.Minute
+)
+
+// Default HTTP server timeouts, used when none are configured.
+const (
+	DefaultReadTimeout  = 5 * time.Second
+	DefaultWriteTimeout = 10 * time.Second
+	DefaultIdleTimeout  = 120 * time.Second
+)
+
+// DefaultTokenTTL is used when no auth token lifetime is configured.
+const DefaultTokenTTL = 24 * time.Hour
+
+// DefaultIdempotencyTTL is used when no idempotency key lifetime is configured.
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// DefaultDeleteWorkerTimeout is used when no delete worker timeout is configured.
+const DefaultDeleteWorkerTimeout = time.Minute
+
+// DefaultMetadataTimeout is used when no page metadata fetch timeout is configured.
+const DefaultMetadataTimeout = 3 * time.Second
+
+// DefaultFileSaveInterval is used when no file storage save interval is configured.
+const DefaultFileSaveInterval = 5 * time.Second
+
+// DefaultSignedLinkTTL is used when no signed link lifetime is configured.
+const DefaultSignedLinkTTL = 24 * time.Hour
+
+// DefaultReservedAliases are used when no reserved aliases are configured.
+// They cover the first path segments of the service's own routes.
+var DefaultReservedAliases = []string{"api", "debug", "health", "metrics", "ping", "ready"}
+
+// Defaults of the page metadata worker, used when none are configured.
+const (
+	DefaultMetadataWorkers   = 2
+	DefaultMetadataQueueSize = 100
+)
+
+// Supported values of Config.TrailingSlash.
+const (
+	TrailingSlashStrip    = "strip"
+	TrailingSlashRedirect = "redirect"
+)
+
+// Supported values of Config.LogFormat.
+const (
+	// LogFormatJSON writes all logs as zap JSON entries
+	LogFormatJSON = "json"
+	// LogFormatConsole writes all logs as human-readable zap console entries
+	LogFormatConsole = "console"
+	// LogFormatCLF writes access logs in the Common Log Format and other logs as JSON
+	LogFormatCLF = "clf"
+	// LogFormatCombined writes access logs in the Combined Log Format, extended with
+	// the request duration, and other logs as JSON
+	LogFormatCombined = "combined"
+)
+
+// Supported values of Config.LogLevel.
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelWarn  = "warn"
+	LogLevelError = "error"
 )
 
+// Supported values of Config.StorageBackend.
+const (
+	// StorageBackendMemory keeps URLs in memory only, without file persistence
+	StorageBackendMemory = "memory"
+	// StorageBackendFile keeps URLs in memory, persisted to Config.FileStorage
+	StorageBackendFile = "file"
+	// StorageBackendPostgres stores URLs in the PostgreSQL database of Config.DatabaseDSN
+	StorageBackendPostgres = "postgres"
+	// StorageBackendSQLite stores URLs in the SQLite database of a sqlite://path.db Config.DatabaseDSN
+	StorageBackendSQLite = "sqlite"
+)
+
+// sqliteDSNPrefix marks DSNs of SQLite databases, see storage.SQLitePath.
+const sqliteDSNPrefix = "sqlite://"
+
 // Config contains all configuration parameters for the URL shortening service.
-// Configuration can be set via environment variables, command line flags, or JSON config file.
+// Configuration can be set via environment variables, command line flags, or a JSON or YAML config file.
 //
 // Setting priority (from highest to lowest):
 //  1. Environment variables
 //  2. Command line flags
-//  3. JSON config file
+//  3. JSON or YAML config file
 //  4. Default values
 //
 // Example usage:
@@ -39,67 +197,498 @@ var (
 //	fmt.Printf("Server started on %s\n", cfg.Address)
 type Config struct {
 	// Address defines the address and port for the HTTP server (e.g., "localhost:8080")
-	Address string `json:"server_address"`
+	Address string `json:"server_address" yaml:"server_address"`
 
 	// BaseURL defines the base URL for generating shortened links
-	BaseURL string `json:"base_url"`
+	BaseURL string `json:"base_url" yaml:"base_url"`
+
+	// FileStorage defines the path to the file for persistent URL storage
+	// (empty disables file persistence)
+	FileStorage string `json:"file_storage_path" yaml:"file_storage_path"`
+
+	// DatabaseDSN contains the database connection string (can be empty).
+	// A sqlite://path.db DSN selects the embedded SQLite storage instead of PostgreSQL
+	DatabaseDSN string `json:"database_dsn" yaml:"database_dsn"`
 
-	// FileStorage defines the path to the file for persistent URL storage (can be empty)
-	FileStorage string `json:"file_storage_path"`
+	// DBMaxOpenConns is the maximum number of open connections per database pool
+	DBMaxOpenConns int `json:"db_max_open_conns" yaml:"db_max_open_conns"`
 
-	// DatabaseDSN contains the database connection string (can be empty)
-	DatabaseDSN string `json:"database_dsn"`
+	// DBMaxIdleConns is the maximum number of idle connections kept per database pool
+	DBMaxIdleConns int `json:"db_max_idle_conns" yaml:"db_max_idle_conns"`
+
+	// DBConnMaxLifetime is the maximum time a database connection is reused
+	DBConnMaxLifetime Duration `json:"db_conn_max_lifetime" yaml:"db_conn_max_lifetime"`
+
+	// DatabaseReplicaDSN contains the read replica connection string (can be empty)
+	DatabaseReplicaDSN string `json:"database_dsn_replica" yaml:"database_dsn_replica"`
 
 	// SecretKey contains the secret key for JWT token signing
-	SecretKey string `json:"-"`
+	SecretKey string `json:"-" yaml:"-"`
 
 	// EnableHTTPS indicates whether to enable HTTPS server
-	EnableHTTPS bool `json:"enable_https"`
+	EnableHTTPS bool `json:"enable_https" yaml:"enable_https"`
 
 	// CertFile is the path to the TLS certificate file
-	CertFile string `json:"cert_file"`
+	CertFile string `json:"cert_file" yaml:"cert_file"`
 
 	// KeyFile is the path to the TLS private key file
-	KeyFile string `json:"key_file"`
+	KeyFile string `json:"key_file" yaml:"key_file"`
+
+	// CSRFProtection enables double-submit CSRF token checks on state-changing requests
+	CSRFProtection bool `json:"csrf_protection" yaml:"csrf_protection"`
+
+	// TrailingSlash defines how routes with a trailing slash are handled:
+	// "strip" serves them as the route without the slash, "redirect" redirects
+	// to it, and an empty value keeps them as distinct routes
+	TrailingSlash string `json:"trailing_slash" yaml:"trailing_slash"`
+
+	// TrustedSubnet is the IPv4 or IPv6 CIDR allowed to access internal endpoints,
+	// e.g. "192.168.1.0/24" or "2001:db8::/64" (empty denies all)
+	TrustedSubnet string `json:"trusted_subnet" yaml:"trusted_subnet"`
+
+	// InternalAPIKey grants access to internal endpoints via the X-Internal-Key header
+	// as an alternative to the trusted subnet (empty disables key access)
+	InternalAPIKey string `json:"internal_api_key" yaml:"internal_api_key"`
+
+	// InternalSigningKey is the key shared between instances to sign internal requests.
+	// When set, requests to internal endpoints must carry a valid, fresh
+	// X-Internal-Signature header (empty disables signing)
+	InternalSigningKey string `json:"internal_signing_key" yaml:"internal_signing_key"`
+
+	// ProxyCIDRs lists the networks of proxies in front of the service. When set, client
+	// IPs for rate limiting and trusted subnet checks are resolved from X-Forwarded-For
+	// by skipping addresses in these networks from the right
+	ProxyCIDRs []string `json:"proxy_cidrs" yaml:"proxy_cidrs"`
+
+	// CodePoolSize is the number of short codes generated ahead of time (0 disables the pool)
+	CodePoolSize int `json:"code_pool_size" yaml:"code_pool_size"`
+
+	// BufferResponses buffers response bodies to send an accurate Content-Length header
+	BufferResponses bool `json:"buffer_responses" yaml:"buffer_responses"`
+
+	// NormalizeURLs detects duplicate URLs by their normalized form (lowercased scheme
+	// and host, no default port or trailing slash) while redirecting to the URL as submitted
+	NormalizeURLs bool `json:"normalize_urls" yaml:"normalize_urls"`
+
+	// CircuitBreakerThreshold is the number of consecutive database failures after which
+	// requests fail fast with 503 (0 disables the circuit breaker)
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold" yaml:"circuit_breaker_threshold"`
+
+	// CircuitBreakerTimeout is how long the circuit breaker stays open before probing the database
+	CircuitBreakerTimeout Duration `json:"circuit_breaker_timeout" yaml:"circuit_breaker_timeout"`
+
+	// RateLimitRPS is the number of requests per second allowed per client (0 disables rate limiting)
+	RateLimitRPS float64 `json:"rate_limit_rps" yaml:"rate_limit_rps"`
+
+	// RateLimitBurst is the number of requests a client may make at once
+	RateLimitBurst int `json:"rate_limit_burst" yaml:"rate_limit_burst"`
+
+	// DedupWithinBatch makes repeated original URLs within one batch request share a short URL.
+	// When disabled, such batches are rejected, since original URLs are unique in storage
+	DedupWithinBatch bool `json:"dedup_within_batch" yaml:"dedup_within_batch"`
+
+	// RequireHTTPSTargets rejects shortening of URLs that don't use https://
+	RequireHTTPSTargets bool `json:"require_https_targets" yaml:"require_https_targets"`
+
+	// EnableWriteFallback queues database writes that fail to a local write-ahead log
+	// and replays them once the database recovers, instead of failing the request
+	EnableWriteFallback bool `json:"enable_write_fallback" yaml:"enable_write_fallback"`
+
+	// WriteFallbackFile is the write-ahead log file used by the write fallback
+	WriteFallbackFile string `json:"write_fallback_file" yaml:"write_fallback_file"`
+
+	// ReadTimeout is the maximum duration for reading an entire request, including the body
+	ReadTimeout Duration `json:"read_timeout" yaml:"read_timeout"`
+
+	// WriteTimeout is the maximum duration before timing out writes of a response
+	WriteTimeout Duration `json:"write_timeout" yaml:"write_timeout"`
+
+	// IdleTimeout is the maximum time to wait for the next request on keep-alive connections
+	IdleTimeout Duration `json:"idle_timeout" yaml:"idle_timeout"`
+
+	// MaxTotalURLs caps the number of URLs held by in-memory storage; once reached,
+	// shortening requests fail with 507 Insufficient Storage (0 means unlimited)
+	MaxTotalURLs int `json:"max_total_urls" yaml:"max_total_urls"`
+
+	// DecompressRequests enables decompression of compressed request bodies. When disabled,
+	// compressed requests are rejected with 415 to rule out decompression bombs
+	DecompressRequests bool `json:"decompress_requests" yaml:"decompress_requests"`
+
+	// LogFormat is the log output format: "json", "console", "clf" or "combined"
+	LogFormat string `json:"log_format" yaml:"log_format"`
+
+	// VerboseJSON includes empty optional fields in JSON responses instead of omitting them
+	VerboseJSON bool `json:"verbose_json" yaml:"verbose_json"`
+
+	// RecordContentType records the content type each URL was submitted with
+	// (text, json or form) and includes it in the user URLs listing
+	RecordContentType bool `json:"record_content_type" yaml:"record_content_type"`
+
+	// NamespaceCodes prefixes generated short codes with a token derived from the
+	// user ID, so that codes of different tenants never collide
+	NamespaceCodes bool `json:"namespace_codes" yaml:"namespace_codes"`
+
+	// HTMLRedirects answers clients accepting text/html with a 200 page redirecting
+	// through a meta refresh tag, for clients that strip 3xx redirects
+	HTMLRedirects bool `json:"html_redirects" yaml:"html_redirects"`
+
+	// TokenTTL is the lifetime of auth tokens issued to anonymous users
+	TokenTTL Duration `json:"token_ttl" yaml:"token_ttl"`
+
+	// AnonCleanupInterval is how often URLs of anonymous users inactive for
+	// longer than TokenTTL are purged (0 disables the cleanup)
+	AnonCleanupInterval Duration `json:"anon_cleanup_interval" yaml:"anon_cleanup_interval"`
+
+	// IdempotencyTTL is how long the result of a shortening request sent with an
+	// Idempotency-Key header is replayed to retries with the same key
+	IdempotencyTTL Duration `json:"idempotency_ttl" yaml:"idempotency_ttl"`
+
+	// StatsCacheTTL is how long database statistics are reused before counting
+	// again, so frequent scrapes of the stats endpoint don't scan the table (0 disables caching)
+	StatsCacheTTL Duration `json:"stats_cache_ttl" yaml:"stats_cache_ttl"`
+
+	// ApproximateStats estimates the total number of URLs from PostgreSQL planner
+	// statistics instead of counting all rows
+	ApproximateStats bool `json:"approximate_stats" yaml:"approximate_stats"`
+
+	// PostRedirectGet answers HTML form submissions to POST / from browsers with
+	// 303 See Other to the short URL instead of 201 with the URL in the body
+	PostRedirectGet bool `json:"post_redirect_get" yaml:"post_redirect_get"`
+
+	// AnswerOptions answers OPTIONS requests on every route with 204 and an Allow
+	// header listing the methods the route supports
+	AnswerOptions bool `json:"answer_options" yaml:"answer_options"`
+
+	// MaxGzipWriters is a soft cap on the number of responses compressed at once;
+	// responses beyond it are served uncompressed to bound CPU (0 means unlimited)
+	MaxGzipWriters int `json:"max_gzip_writers" yaml:"max_gzip_writers"`
+
+	// DeprecationWarnings sends a Warning header on responses of deprecated endpoints,
+	// such as POST /api/shorten, pointing clients to their replacement
+	DeprecationWarnings bool `json:"deprecation_warnings" yaml:"deprecation_warnings"`
+
+	// PartialBatches stores the valid URLs of a batch in PostgreSQL even if others
+	// fail to be stored; the failed ones are reported per correlation ID
+	PartialBatches bool `json:"partial_batches" yaml:"partial_batches"`
+
+	// MetricsTiers lists the user tiers request metrics are labeled with. When set,
+	// requests are also counted by tier; tiers not listed are counted as "other"
+	// to bound the number of series
+	MetricsTiers []string `json:"metrics_tiers" yaml:"metrics_tiers"`
+
+	// PathPrefix is the path all routes are served under, e.g. "/s" behind a shared
+	// gateway. It is also part of the generated short URLs (empty means the root)
+	PathPrefix string `json:"path_prefix" yaml:"path_prefix"`
+
+	// RecordCreatorIP records a hash of the IP address each URL was created from,
+	// taken from X-Real-IP, for detecting abuse without storing raw addresses.
+	// The hash is salted with the JWT secret
+	RecordCreatorIP bool `json:"record_creator_ip" yaml:"record_creator_ip"`
+
+	// MaxDeleteBatch is the maximum number of short URLs a delete request may
+	// list; larger requests are rejected before any work is queued (0 means unlimited)
+	MaxDeleteBatch int `json:"max_delete_batch" yaml:"max_delete_batch"`
+
+	// DBWarmPool opens DBMaxIdleConns connections per database pool at startup,
+	// so the first requests don't pay the connection establishment latency
+	DBWarmPool bool `json:"db_warm_pool" yaml:"db_warm_pool"`
+
+	// NotFoundTemplate is the path to an HTML template served with 404 to browsers
+	// requesting unknown short URLs (empty keeps the plain response)
+	NotFoundTemplate string `json:"not_found_template" yaml:"not_found_template"`
+
+	// NotFoundRedirect is an http(s) URL browsers requesting unknown short URLs are
+	// redirected to, e.g. a landing page. It takes precedence over NotFoundTemplate
+	NotFoundRedirect string `json:"not_found_redirect" yaml:"not_found_redirect"`
+
+	// DeleteWorkerTimeout is how long accepted deletions may wait without any being
+	// applied before the delete worker is reported unhealthy in metrics
+	DeleteWorkerTimeout Duration `json:"delete_worker_timeout" yaml:"delete_worker_timeout"`
+
+	// FetchMetadata fetches the title and favicon URL of target pages in the
+	// background after URLs are shortened, listed in GET /api/user/urls
+	FetchMetadata bool `json:"fetch_metadata" yaml:"fetch_metadata"`
+
+	// MetadataWorkers is the number of page metadata fetches running concurrently
+	MetadataWorkers int `json:"metadata_workers" yaml:"metadata_workers"`
+
+	// MetadataQueueSize is the number of URLs that may wait for their page metadata;
+	// metadata of URLs shortened while the queue is full is not fetched
+	MetadataQueueSize int `json:"metadata_queue_size" yaml:"metadata_queue_size"`
+
+	// MetadataTimeout bounds fetching the metadata of a target page
+	MetadataTimeout Duration `json:"metadata_timeout" yaml:"metadata_timeout"`
+
+	// LogLevel is the minimum level of logged entries: "debug", "info", "warn" or "error"
+	LogLevel string `json:"log_level" yaml:"log_level"`
+
+	// MaxURLsPerUser is the maximum number of URLs that aren't deleted a user may
+	// hold; shortening more is rejected with 429 (0 means unlimited)
+	MaxURLsPerUser int `json:"max_urls_per_user" yaml:"max_urls_per_user"`
+
+	// DeletedRetention is how long deleted URLs keep answering 410 Gone before
+	// they are removed from storage for good (0 keeps them forever)
+	DeletedRetention Duration `json:"deleted_retention" yaml:"deleted_retention"`
+
+	// StorageBackend is the storage URLs are kept in: "memory", "file", "postgres"
+	// or "sqlite". LoadConfig checks that the backend's DSN or file path is set.
+	// Left empty, it is detected as before: SQLite for a sqlite:// DatabaseDSN,
+	// PostgreSQL for another DSN, otherwise memory persisted to FileStorage if set
+	StorageBackend string `json:"storage_backend" yaml:"storage_backend"`
+
+	// GzipLevel is the compress/gzip level responses are compressed with, from
+	// gzip.HuffmanOnly (-2) to gzip.BestCompression (9); 0 selects
+	// gzip.DefaultCompression, as disabling compression is done by clients
+	GzipLevel int `json:"gzip_level" yaml:"gzip_level"`
+
+	// FileMaxPending is how many URLs may be queued for the periodic save to
+	// FileStorage; more trigger an immediate save (0 disables the limit)
+	FileMaxPending int `json:"file_max_pending" yaml:"file_max_pending"`
+
+	// FileSaveInterval is how often URLs queued for FileStorage are saved
+	FileSaveInterval Duration `json:"file_save_interval" yaml:"file_save_interval"`
+
+	// SignedLinkTTL is how long signed links handed out for protected URLs
+	// resolve; the token of each link carries its own expiry
+	SignedLinkTTL Duration `json:"signed_link_ttl" yaml:"signed_link_ttl"`
+
+	// ReservedAliases can't be claimed as custom aliases, compared case-insensitively,
+	// so that short URLs never shadow routes. An empty list in the configuration
+	// file reserves nothing; left unset, DefaultReservedAliases are reserved
+	ReservedAliases []string `json:"reserved_aliases" yaml:"reserved_aliases"`
 }
 
-// LoadConfig loads configuration from environment variables, command line flags, and JSON config file.
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// unmarshalConfigFile parses a YAML config file if its extension is .yml or .yaml,
+// and a JSON config file otherwise.
+func unmarshalConfigFile(path string, data []byte, config *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return yaml.Unmarshal(data, config)
+	}
+	return json.Unmarshal(data, config)
+}
+
+// LoadConfig loads configuration from environment variables, command line flags, and a JSON or YAML config file.
 // Returns a pointer to Config struct or an error if configuration loading fails.
 //
 // Supported environment variables:
 //   - SERVER_ADDRESS: server address
 //   - BASE_URL: base URL
 //   - FILE_STORAGE_PATH: storage file path
-//   - DATABASE_DSN: database connection string
+//   - DATABASE_DSN: database connection string (sqlite://path.db for SQLite)
+//   - DATABASE_DSN_REPLICA: read replica connection string
+//   - DB_MAX_OPEN_CONNS: maximum open database connections
+//   - DB_MAX_IDLE_CONNS: maximum idle database connections
+//   - DB_CONN_MAX_LIFETIME: maximum database connection lifetime (e.g. "30m")
 //   - JWT_SECRET_FILE: path to JWT secret file
 //   - ENABLE_HTTPS: enable HTTPS server (true/false)
 //   - TLS_CERT_FILE: path to TLS certificate file
 //   - TLS_KEY_FILE: path to TLS private key file
-//   - CONFIG: path to JSON configuration file
+//   - CSRF_PROTECTION: enable CSRF protection (true/false)
+//   - TRAILING_SLASH: trailing slash handling (strip/redirect)
+//   - TRUSTED_SUBNET: trusted IPv4 or IPv6 subnet in CIDR notation
+//   - INTERNAL_API_KEY: API key for internal endpoints
+//   - INTERNAL_SIGNING_KEY: shared key for HMAC-signed internal requests
+//   - PROXY_CIDRS: comma-separated proxy CIDRs skipped in X-Forwarded-For
+//   - CODE_POOL_SIZE: number of pre-generated short codes
+//   - BUFFER_RESPONSES: buffer responses to send Content-Length (true/false)
+//   - NORMALIZE_URLS: detect duplicate URLs by their normalized form (true/false)
+//   - CIRCUIT_BREAKER_THRESHOLD: database failures that open the circuit breaker
+//   - CIRCUIT_BREAKER_TIMEOUT: circuit breaker reset timeout (e.g. "30s")
+//   - RATE_LIMIT_RPS: requests per second allowed per client
+//   - RATE_LIMIT_BURST: request burst allowed per client
+//   - DEDUP_WITHIN_BATCH: share short URLs between repeated URLs in a batch (true/false)
+//   - REQUIRE_HTTPS_TARGETS: only allow shortening https:// URLs (true/false)
+//   - ENABLE_WRITE_FALLBACK: queue failed database writes for replay (true/false)
+//   - WRITE_FALLBACK_FILE: write-ahead log file for queued database writes
+//   - READ_TIMEOUT: HTTP server read timeout (e.g. "5s")
+//   - WRITE_TIMEOUT: HTTP server write timeout (e.g. "10s")
+//   - IDLE_TIMEOUT: HTTP server idle timeout (e.g. "2m")
+//   - MAX_TOTAL_URLS: maximum number of URLs kept in memory
+//   - DECOMPRESS_REQUESTS: decompress compressed request bodies (true/false)
+//   - LOG_FORMAT: log format (json/console/clf/combined)
+//   - VERBOSE_JSON: include empty optional fields in JSON responses (true/false)
+//   - RECORD_CONTENT_TYPE: record and list the content type URLs were submitted with (true/false)
+//   - NAMESPACE_CODES: prefix generated short codes with a per-user token (true/false)
+//   - HTML_REDIRECTS: redirect clients accepting HTML with a meta refresh page (true/false)
+//   - TOKEN_TTL: lifetime of anonymous users' auth tokens (e.g. "24h")
+//   - ANON_CLEANUP_INTERVAL: interval of purging inactive anonymous users' URLs (e.g. "1h")
+//   - ANSWER_OPTIONS: answer OPTIONS requests with the allowed methods (true/false)
+//   - IDEMPOTENCY_TTL: time results of requests with an Idempotency-Key are replayed (e.g. "24h")
+//   - STATS_CACHE_TTL: time database statistics are cached (e.g. "1m")
+//   - APPROXIMATE_STATS: estimate the total number of URLs in PostgreSQL (true/false)
+//   - POST_REDIRECT_GET: answer browser form submissions with 303 See Other (true/false)
+//   - MAX_GZIP_WRITERS: maximum number of concurrently compressed responses
+//   - DEPRECATION_WARNINGS: send a Warning header on deprecated endpoints (true/false)
+//   - PARTIAL_BATCHES: store the valid URLs of a batch even if others fail (true/false)
+//   - METRICS_TIERS: comma-separated user tiers labeling request metrics
+//   - PATH_PREFIX: path prefix all routes are served under (e.g. "/s")
+//   - RECORD_CREATOR_IP: record a salted hash of the creator's IP address per URL (true/false)
+//   - MAX_DELETE_BATCH: maximum number of short URLs per delete request
+//   - DB_WARM_POOL: open the idle database connections at startup (true/false)
+//   - NOT_FOUND_TEMPLATE: path to an HTML template served to browsers for unknown short URLs
+//   - NOT_FOUND_REDIRECT: URL browsers are redirected to for unknown short URLs
+//   - DELETE_WORKER_TIMEOUT: time the delete queue may stall before the worker is reported unhealthy (e.g. "1m")
+//   - FETCH_METADATA: fetch the title and favicon of target pages when shortening (true/false)
+//   - METADATA_TIMEOUT: maximum time fetching the metadata of a target page may take (e.g. "3s")
+//   - METADATA_WORKERS: number of concurrent page metadata fetches
+//   - METADATA_QUEUE_SIZE: number of URLs waiting for their page metadata to be fetched
+//   - LOG_LEVEL: minimum level of logged entries (debug/info/warn/error)
+//   - MAX_URLS_PER_USER: maximum number of URLs a user may hold
+//   - DELETED_RETENTION: time deleted URLs are kept before they are removed for good (e.g. "720h")
+//   - STORAGE_BACKEND: storage backend (memory/file/postgres/sqlite)
+//   - GZIP_LEVEL: gzip compression level of responses (1 for best speed to 9 for best compression)
+//   - FILE_MAX_PENDING: number of URLs queued for the file storage that triggers an early save
+//   - FILE_SAVE_INTERVAL: interval of saving queued URLs to the file storage (e.g. "1s")
+//   - SIGNED_LINK_TTL: lifetime of signed links to protected URLs (e.g. "1h")
+//   - RESERVED_ALIASES: comma-separated aliases that can't be claimed
+//   - CONFIG: path to JSON or YAML (.yml/.yaml) configuration file
 //
 // Supported flags:
 //   - -a: server address
 //   - -b: base URL
 //   - -f: storage file path
-//   - -d: database connection string
+//   - -d: database connection string (sqlite://path.db for SQLite)
+//   - -dr: read replica connection string
+//   - -db-max-open-conns: maximum open database connections
+//   - -db-max-idle-conns: maximum idle database connections
+//   - -db-conn-max-lifetime: maximum database connection lifetime
 //   - -jwt-secret-file: path to JWT secret file
 //   - -s: enable HTTPS server
 //   - -cert: path to TLS certificate file
 //   - -key: path to TLS private key file
-//   - -c, -config: path to JSON configuration file
+//   - -csrf: enable CSRF protection
+//   - -trailing-slash: trailing slash handling (strip/redirect)
+//   - -t: trusted IPv4 or IPv6 subnet in CIDR notation
+//   - -internal-key: API key for internal endpoints
+//   - -internal-signing-key: shared key for HMAC-signed internal requests
+//   - -proxy-cidrs: comma-separated proxy CIDRs skipped in X-Forwarded-For
+//   - -code-pool: number of pre-generated short codes
+//   - -buffer-responses: buffer responses to send Content-Length
+//   - -normalize-urls: detect duplicate URLs by their normalized form
+//   - -cb-threshold: database failures that open the circuit breaker
+//   - -cb-timeout: circuit breaker reset timeout
+//   - -rate-limit: requests per second allowed per client
+//   - -rate-burst: request burst allowed per client
+//   - -dedup-batch: share short URLs between repeated URLs in a batch
+//   - -require-https-targets: only allow shortening https:// URLs
+//   - -write-fallback: queue failed database writes for replay
+//   - -write-fallback-file: write-ahead log file for queued database writes
+//   - -read-timeout: HTTP server read timeout
+//   - -write-timeout: HTTP server write timeout
+//   - -idle-timeout: HTTP server idle timeout
+//   - -max-urls: maximum number of URLs kept in memory
+//   - -decompress-requests: decompress compressed request bodies
+//   - -log-format: log format (json/console/clf)
+//   - -verbose-json: include empty optional fields in JSON responses
+//   - -record-content-type: record and list the content type URLs were submitted with
+//   - -namespace-codes: prefix generated short codes with a per-user token
+//   - -html-redirects: redirect clients accepting HTML with a meta refresh page
+//   - -token-ttl: lifetime of anonymous users' auth tokens
+//   - -anon-cleanup-interval: interval of purging inactive anonymous users' URLs
+//   - -answer-options: answer OPTIONS requests with the allowed methods
+//   - -idempotency-ttl: time results of requests with an Idempotency-Key are replayed
+//   - -stats-cache-ttl: time database statistics are cached
+//   - -approximate-stats: estimate the total number of URLs in PostgreSQL
+//   - -post-redirect-get: answer browser form submissions with 303 See Other
+//   - -max-gzip-writers: maximum number of concurrently compressed responses
+//   - -deprecation-warnings: send a Warning header on deprecated endpoints
+//   - -partial-batches: store the valid URLs of a batch even if others fail
+//   - -metrics-tiers: comma-separated user tiers labeling request metrics
+//   - -path-prefix: path prefix all routes are served under
+//   - -record-creator-ip: record a salted hash of the creator's IP address per URL
+//   - -max-delete-batch: maximum number of short URLs per delete request
+//   - -db-warm-pool: open the idle database connections at startup
+//   - -not-found-template: path to an HTML template served to browsers for unknown short URLs
+//   - -not-found-redirect: URL browsers are redirected to for unknown short URLs
+//   - -delete-worker-timeout: time the delete queue may stall before the worker is reported unhealthy
+//   - -fetch-metadata: fetch the title and favicon of target pages when shortening
+//   - -metadata-timeout: maximum time fetching the metadata of a target page may take
+//   - -metadata-workers: number of concurrent page metadata fetches
+//   - -metadata-queue-size: number of URLs waiting for their page metadata to be fetched
+//   - -log-level: minimum level of logged entries
+//   - -max-urls-per-user: maximum number of URLs a user may hold
+//   - -deleted-retention: time deleted URLs are kept before they are removed for good
+//   - -storage-backend: storage backend (memory, file, postgres or sqlite)
+//   - -gzip-level: gzip compression level of responses
+//   - -file-max-pending: number of URLs queued for the file storage that triggers an early save
+//   - -file-save-interval: interval of saving queued URLs to the file storage
+//   - -signed-link-ttl: lifetime of signed links to protected URLs
+//   - -reserved-aliases: comma-separated aliases that can't be claimed
+//   - -c, -config: path to JSON or YAML (.yml/.yaml) configuration file
 func LoadConfig() (*Config, error) {
 	// Initialize config with default values
 	config := &Config{
-		Address:     *addressFlag,
-		BaseURL:     *baseURLFlag,
-		FileStorage: *fileStoragePath,
-		DatabaseDSN: *databaseDSNFlag,
-		CertFile:    *certFile,
-		KeyFile:     *keyFile,
-		EnableHTTPS: *enableHTTPS,
+		Address:                 *addressFlag,
+		BaseURL:                 *baseURLFlag,
+		FileStorage:             *fileStoragePath,
+		DatabaseDSN:             *databaseDSNFlag,
+		DatabaseReplicaDSN:      *replicaDSNFlag,
+		DBMaxOpenConns:          *dbMaxOpenConns,
+		DBMaxIdleConns:          *dbMaxIdleConns,
+		DBConnMaxLifetime:       Duration{*dbConnLifetime},
+		CertFile:                *certFile,
+		KeyFile:                 *keyFile,
+		EnableHTTPS:             *enableHTTPS,
+		CSRFProtection:          *csrfProtection,
+		TrailingSlash:           *trailingSlash,
+		TrustedSubnet:           *trustedSubnet,
+		InternalAPIKey:          *internalAPIKey,
+		InternalSigningKey:      *signingKey,
+		CodePoolSize:            *codePoolSize,
+		BufferResponses:         *bufferResponses,
+		NormalizeURLs:           *normalizeURLs,
+		CircuitBreakerThreshold: *cbThreshold,
+		CircuitBreakerTimeout:   Duration{*cbTimeout},
+		RateLimitRPS:            *rateLimitRPS,
+		RateLimitBurst:          *rateLimitBurst,
+		DedupWithinBatch:        *dedupBatch,
+		RequireHTTPSTargets:     *requireHTTPS,
+		EnableWriteFallback:     *writeFallback,
+		WriteFallbackFile:       *fallbackFile,
+		ReadTimeout:             Duration{*readTimeout},
+		WriteTimeout:            Duration{*writeTimeout},
+		IdleTimeout:             Duration{*idleTimeout},
+		MaxTotalURLs:            *maxTotalURLs,
+		DecompressRequests:      *decompressReqs,
+		LogFormat:               *logFormat,
+		VerboseJSON:             *verboseJSON,
+		RecordContentType:       *recordCType,
+		NamespaceCodes:          *namespaceCodes,
+		HTMLRedirects:           *htmlRedirects,
+		TokenTTL:                Duration{*tokenTTL},
+		AnonCleanupInterval:     Duration{*anonCleanup},
+		AnswerOptions:           *answerOptions,
+		IdempotencyTTL:          Duration{*idempotencyTTL},
+		DeleteWorkerTimeout:     Duration{*deleteTimeout},
+		FetchMetadata:           *fetchMetadata,
+		MetadataTimeout:         Duration{*metadataTimeout},
+		StatsCacheTTL:           Duration{*statsCacheTTL},
+		ApproximateStats:        *approxStats,
+		PostRedirectGet:         *postRedirect,
+		MaxGzipWriters:          *maxGzipWriters,
+		DeprecationWarnings:     *deprecationWarn,
+		PartialBatches:          *partialBatches,
+		RecordCreatorIP:         *recordCreatorIP,
+		MaxDeleteBatch:          *maxDeleteBatch,
+		DBWarmPool:              *dbWarmPool,
+		MetadataWorkers:         *metadataWorkers,
+		MetadataQueueSize:       *metadataQueue,
+		MaxURLsPerUser:          *maxURLsPerUser,
 	}
 
-	// Load from JSON config file if specified
+	// Load from JSON or YAML config file if specified
 	configPath := os.Getenv("CONFIG")
 	if configPath == "" {
 		if *configFile != "" {
@@ -113,7 +702,7 @@ func LoadConfig() (*Config, error) {
 			return nil, fmt.Errorf("failed to read config file: %w", err)
 		}
 
-		if err := json.Unmarshal(data, config); err != nil {
+		if err := unmarshalConfigFile(configPath, data, config); err != nil {
 			return nil, fmt.Errorf("failed to parse config file: %w", err)
 		}
 	}
@@ -124,6 +713,189 @@ func LoadConfig() (*Config, error) {
 		config.CertFile = *certFile
 		config.KeyFile = *keyFile
 	}
+	if *dbMaxOpenConns != 0 {
+		config.DBMaxOpenConns = *dbMaxOpenConns
+	}
+	if *dbMaxIdleConns != 0 {
+		config.DBMaxIdleConns = *dbMaxIdleConns
+	}
+	if *dbConnLifetime != 0 {
+		config.DBConnMaxLifetime = Duration{*dbConnLifetime}
+	}
+	if *csrfProtection {
+		config.CSRFProtection = true
+	}
+	if *trailingSlash != "" {
+		config.TrailingSlash = *trailingSlash
+	}
+	if *trustedSubnet != "" {
+		config.TrustedSubnet = *trustedSubnet
+	}
+	if *internalAPIKey != "" {
+		config.InternalAPIKey = *internalAPIKey
+	}
+	if *signingKey != "" {
+		config.InternalSigningKey = *signingKey
+	}
+	if *proxyCIDRs != "" {
+		config.ProxyCIDRs = splitList(*proxyCIDRs)
+	}
+	if *codePoolSize != 0 {
+		config.CodePoolSize = *codePoolSize
+	}
+	if *bufferResponses {
+		config.BufferResponses = true
+	}
+	if *normalizeURLs {
+		config.NormalizeURLs = true
+	}
+	if *cbThreshold != 0 {
+		config.CircuitBreakerThreshold = *cbThreshold
+	}
+	if *cbTimeout != 0 {
+		config.CircuitBreakerTimeout = Duration{*cbTimeout}
+	}
+	if *rateLimitRPS != 0 {
+		config.RateLimitRPS = *rateLimitRPS
+	}
+	if *rateLimitBurst != 0 {
+		config.RateLimitBurst = *rateLimitBurst
+	}
+	if !*dedupBatch {
+		config.DedupWithinBatch = false
+	}
+	if *requireHTTPS {
+		config.RequireHTTPSTargets = true
+	}
+	if *writeFallback {
+		config.EnableWriteFallback = true
+	}
+	if *fallbackFile != "urls.wal" {
+		config.WriteFallbackFile = *fallbackFile
+	}
+	if *readTimeout != 0 {
+		config.ReadTimeout = Duration{*readTimeout}
+	}
+	if *writeTimeout != 0 {
+		config.WriteTimeout = Duration{*writeTimeout}
+	}
+	if *idleTimeout != 0 {
+		config.IdleTimeout = Duration{*idleTimeout}
+	}
+	if *maxTotalURLs != 0 {
+		config.MaxTotalURLs = *maxTotalURLs
+	}
+	if !*decompressReqs {
+		config.DecompressRequests = false
+	}
+	if *logFormat != LogFormatJSON {
+		config.LogFormat = *logFormat
+	}
+	if *verboseJSON {
+		config.VerboseJSON = true
+	}
+	if *recordCType {
+		config.RecordContentType = true
+	}
+	if *namespaceCodes {
+		config.NamespaceCodes = true
+	}
+	if *htmlRedirects {
+		config.HTMLRedirects = true
+	}
+	if *tokenTTL != 0 {
+		config.TokenTTL = Duration{*tokenTTL}
+	}
+	if *anonCleanup != 0 {
+		config.AnonCleanupInterval = Duration{*anonCleanup}
+	}
+	if !*answerOptions {
+		config.AnswerOptions = false
+	}
+	if *idempotencyTTL != 0 {
+		config.IdempotencyTTL = Duration{*idempotencyTTL}
+	}
+	if *deleteTimeout != 0 {
+		config.DeleteWorkerTimeout = Duration{*deleteTimeout}
+	}
+	if *fetchMetadata {
+		config.FetchMetadata = true
+	}
+	if *metadataTimeout != 0 {
+		config.MetadataTimeout = Duration{*metadataTimeout}
+	}
+	if *logLevel != "" {
+		config.LogLevel = *logLevel
+	}
+	if *metadataWorkers != 0 {
+		config.MetadataWorkers = *metadataWorkers
+	}
+	if *metadataQueue != 0 {
+		config.MetadataQueueSize = *metadataQueue
+	}
+	if *maxURLsPerUser != 0 {
+		config.MaxURLsPerUser = *maxURLsPerUser
+	}
+	if *retainDeleted != 0 {
+		config.DeletedRetention = Duration{*retainDeleted}
+	}
+	if *storageBackend != "" {
+		config.StorageBackend = *storageBackend
+	}
+	if *gzipLevel != 0 {
+		config.GzipLevel = *gzipLevel
+	}
+	if *fileMaxPending != 0 {
+		config.FileMaxPending = *fileMaxPending
+	}
+	if *fileSaveEvery != 0 {
+		config.FileSaveInterval = Duration{*fileSaveEvery}
+	}
+	if *signedLinkTTL != 0 {
+		config.SignedLinkTTL = Duration{*signedLinkTTL}
+	}
+	if *statsCacheTTL != 0 {
+		config.StatsCacheTTL = Duration{*statsCacheTTL}
+	}
+	if *approxStats {
+		config.ApproximateStats = true
+	}
+	if *postRedirect {
+		config.PostRedirectGet = true
+	}
+	if *maxGzipWriters != 0 {
+		config.MaxGzipWriters = *maxGzipWriters
+	}
+	if *deprecationWarn {
+		config.DeprecationWarnings = true
+	}
+	if *partialBatches {
+		config.PartialBatches = true
+	}
+	if *metricsTiers != "" {
+		config.MetricsTiers = splitList(*metricsTiers)
+	}
+	if *reservedAliases != "" {
+		config.ReservedAliases = splitList(*reservedAliases)
+	}
+	if *pathPrefix != "" {
+		config.PathPrefix = *pathPrefix
+	}
+	if *recordCreatorIP {
+		config.RecordCreatorIP = true
+	}
+	if *maxDeleteBatch != 0 {
+		config.MaxDeleteBatch = *maxDeleteBatch
+	}
+	if *dbWarmPool {
+		config.DBWarmPool = true
+	}
+	if *notFoundTmpl != "" {
+		config.NotFoundTemplate = *notFoundTmpl
+	}
+	if *notFoundURL != "" {
+		config.NotFoundRedirect = *notFoundURL
+	}
 
 	// Override with environment variables
 	if envAddr := os.Getenv("SERVER_ADDRESS"); envAddr != "" {
@@ -138,6 +910,30 @@ func LoadConfig() (*Config, error) {
 	if envDSN := os.Getenv("DATABASE_DSN"); envDSN != "" {
 		config.DatabaseDSN = envDSN
 	}
+	if envReplicaDSN := os.Getenv("DATABASE_DSN_REPLICA"); envReplicaDSN != "" {
+		config.DatabaseReplicaDSN = envReplicaDSN
+	}
+	if envMaxOpen := os.Getenv("DB_MAX_OPEN_CONNS"); envMaxOpen != "" {
+		maxOpen, err := strconv.Atoi(envMaxOpen)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_MAX_OPEN_CONNS: %w", err)
+		}
+		config.DBMaxOpenConns = maxOpen
+	}
+	if envMaxIdle := os.Getenv("DB_MAX_IDLE_CONNS"); envMaxIdle != "" {
+		maxIdle, err := strconv.Atoi(envMaxIdle)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_MAX_IDLE_CONNS: %w", err)
+		}
+		config.DBMaxIdleConns = maxIdle
+	}
+	if envLifetime := os.Getenv("DB_CONN_MAX_LIFETIME"); envLifetime != "" {
+		lifetime, err := time.ParseDuration(envLifetime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DB_CONN_MAX_LIFETIME: %w", err)
+		}
+		config.DBConnMaxLifetime = Duration{lifetime}
+	}
 	if os.Getenv("ENABLE_HTTPS") == "true" {
 		config.EnableHTTPS = true
 	}
@@ -147,6 +943,222 @@ func LoadConfig() (*Config, error) {
 	if envKeyFile := os.Getenv("TLS_KEY_FILE"); envKeyFile != "" {
 		config.KeyFile = envKeyFile
 	}
+	if os.Getenv("CSRF_PROTECTION") == "true" {
+		config.CSRFProtection = true
+	}
+	if envTrailingSlash := os.Getenv("TRAILING_SLASH"); envTrailingSlash != "" {
+		config.TrailingSlash = envTrailingSlash
+	}
+	if envTrustedSubnet := os.Getenv("TRUSTED_SUBNET"); envTrustedSubnet != "" {
+		config.TrustedSubnet = envTrustedSubnet
+	}
+	if envAPIKey := os.Getenv("INTERNAL_API_KEY"); envAPIKey != "" {
+		config.InternalAPIKey = envAPIKey
+	}
+	if envSigningKey := os.Getenv("INTERNAL_SIGNING_KEY"); envSigningKey != "" {
+		config.InternalSigningKey = envSigningKey
+	}
+	if envProxyCIDRs := os.Getenv("PROXY_CIDRS"); envProxyCIDRs != "" {
+		config.ProxyCIDRs = splitList(envProxyCIDRs)
+	}
+	if envPoolSize := os.Getenv("CODE_POOL_SIZE"); envPoolSize != "" {
+		poolSize, err := strconv.Atoi(envPoolSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CODE_POOL_SIZE: %w", err)
+		}
+		config.CodePoolSize = poolSize
+	}
+
+	if os.Getenv("BUFFER_RESPONSES") == "true" {
+		config.BufferResponses = true
+	}
+	if os.Getenv("NORMALIZE_URLS") == "true" {
+		config.NormalizeURLs = true
+	}
+	if envThreshold := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); envThreshold != "" {
+		threshold, err := strconv.Atoi(envThreshold)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_THRESHOLD: %w", err)
+		}
+		config.CircuitBreakerThreshold = threshold
+	}
+	if envTimeout := os.Getenv("CIRCUIT_BREAKER_TIMEOUT"); envTimeout != "" {
+		timeout, err := time.ParseDuration(envTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIRCUIT_BREAKER_TIMEOUT: %w", err)
+		}
+		config.CircuitBreakerTimeout = Duration{timeout}
+	}
+	if envRPS := os.Getenv("RATE_LIMIT_RPS"); envRPS != "" {
+		rps, err := strconv.ParseFloat(envRPS, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_RPS: %w", err)
+		}
+		config.RateLimitRPS = rps
+	}
+	if envBurst := os.Getenv("RATE_LIMIT_BURST"); envBurst != "" {
+		burst, err := strconv.Atoi(envBurst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RATE_LIMIT_BURST: %w", err)
+		}
+		config.RateLimitBurst = burst
+	}
+	if envDedup := os.Getenv("DEDUP_WITHIN_BATCH"); envDedup != "" {
+		config.DedupWithinBatch = envDedup == "true"
+	}
+	if os.Getenv("REQUIRE_HTTPS_TARGETS") == "true" {
+		config.RequireHTTPSTargets = true
+	}
+	if os.Getenv("ENABLE_WRITE_FALLBACK") == "true" {
+		config.EnableWriteFallback = true
+	}
+	if envFallbackFile := os.Getenv("WRITE_FALLBACK_FILE"); envFallbackFile != "" {
+		config.WriteFallbackFile = envFallbackFile
+	}
+	for _, timeout := range []struct {
+		env   string
+		value *Duration
+	}{
+		{"READ_TIMEOUT", &config.ReadTimeout},
+		{"WRITE_TIMEOUT", &config.WriteTimeout},
+		{"IDLE_TIMEOUT", &config.IdleTimeout},
+		{"TOKEN_TTL", &config.TokenTTL},
+		{"ANON_CLEANUP_INTERVAL", &config.AnonCleanupInterval},
+		{"IDEMPOTENCY_TTL", &config.IdempotencyTTL},
+		{"DELETE_WORKER_TIMEOUT", &config.DeleteWorkerTimeout},
+		{"METADATA_TIMEOUT", &config.MetadataTimeout},
+		{"DELETED_RETENTION", &config.DeletedRetention},
+		{"FILE_SAVE_INTERVAL", &config.FileSaveInterval},
+		{"SIGNED_LINK_TTL", &config.SignedLinkTTL},
+		{"STATS_CACHE_TTL", &config.StatsCacheTTL},
+	} {
+		if envTimeout := os.Getenv(timeout.env); envTimeout != "" {
+			parsed, err := time.ParseDuration(envTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", timeout.env, err)
+			}
+			*timeout.value = Duration{parsed}
+		}
+	}
+	if envMaxURLs := os.Getenv("MAX_TOTAL_URLS"); envMaxURLs != "" {
+		maxURLs, err := strconv.Atoi(envMaxURLs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_TOTAL_URLS: %w", err)
+		}
+		config.MaxTotalURLs = maxURLs
+	}
+	if envDecompress := os.Getenv("DECOMPRESS_REQUESTS"); envDecompress != "" {
+		config.DecompressRequests = envDecompress == "true"
+	}
+	if envLogFormat := os.Getenv("LOG_FORMAT"); envLogFormat != "" {
+		config.LogFormat = envLogFormat
+	}
+	if os.Getenv("VERBOSE_JSON") == "true" {
+		config.VerboseJSON = true
+	}
+	if os.Getenv("RECORD_CONTENT_TYPE") == "true" {
+		config.RecordContentType = true
+	}
+	if os.Getenv("NAMESPACE_CODES") == "true" {
+		config.NamespaceCodes = true
+	}
+	if os.Getenv("HTML_REDIRECTS") == "true" {
+		config.HTMLRedirects = true
+	}
+	if os.Getenv("APPROXIMATE_STATS") == "true" {
+		config.ApproximateStats = true
+	}
+	if os.Getenv("POST_REDIRECT_GET") == "true" {
+		config.PostRedirectGet = true
+	}
+	if os.Getenv("DEPRECATION_WARNINGS") == "true" {
+		config.DeprecationWarnings = true
+	}
+	if os.Getenv("PARTIAL_BATCHES") == "true" {
+		config.PartialBatches = true
+	}
+	if envMetricsTiers := os.Getenv("METRICS_TIERS"); envMetricsTiers != "" {
+		config.MetricsTiers = splitList(envMetricsTiers)
+	}
+	if envReservedAliases := os.Getenv("RESERVED_ALIASES"); envReservedAliases != "" {
+		config.ReservedAliases = splitList(envReservedAliases)
+	}
+	if envPathPrefix := os.Getenv("PATH_PREFIX"); envPathPrefix != "" {
+		config.PathPrefix = envPathPrefix
+	}
+	if os.Getenv("RECORD_CREATOR_IP") == "true" {
+		config.RecordCreatorIP = true
+	}
+	if os.Getenv("DB_WARM_POOL") == "true" {
+		config.DBWarmPool = true
+	}
+	if os.Getenv("FETCH_METADATA") == "true" {
+		config.FetchMetadata = true
+	}
+	if envLogLevel := os.Getenv("LOG_LEVEL"); envLogLevel != "" {
+		config.LogLevel = envLogLevel
+	}
+	if envBackend := os.Getenv("STORAGE_BACKEND"); envBackend != "" {
+		config.StorageBackend = envBackend
+	}
+	if envNotFoundTmpl := os.Getenv("NOT_FOUND_TEMPLATE"); envNotFoundTmpl != "" {
+		config.NotFoundTemplate = envNotFoundTmpl
+	}
+	if envNotFoundURL := os.Getenv("NOT_FOUND_REDIRECT"); envNotFoundURL != "" {
+		config.NotFoundRedirect = envNotFoundURL
+	}
+	if envMaxGzip := os.Getenv("MAX_GZIP_WRITERS"); envMaxGzip != "" {
+		maxGzip, err := strconv.Atoi(envMaxGzip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_GZIP_WRITERS: %w", err)
+		}
+		config.MaxGzipWriters = maxGzip
+	}
+	if envGzipLevel := os.Getenv("GZIP_LEVEL"); envGzipLevel != "" {
+		level, err := strconv.Atoi(envGzipLevel)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GZIP_LEVEL: %w", err)
+		}
+		config.GzipLevel = level
+	}
+	if envMaxDelete := os.Getenv("MAX_DELETE_BATCH"); envMaxDelete != "" {
+		maxDelete, err := strconv.Atoi(envMaxDelete)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_DELETE_BATCH: %w", err)
+		}
+		config.MaxDeleteBatch = maxDelete
+	}
+	if envWorkers := os.Getenv("METADATA_WORKERS"); envWorkers != "" {
+		workers, err := strconv.Atoi(envWorkers)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METADATA_WORKERS: %w", err)
+		}
+		config.MetadataWorkers = workers
+	}
+	if envQueueSize := os.Getenv("METADATA_QUEUE_SIZE"); envQueueSize != "" {
+		queueSize, err := strconv.Atoi(envQueueSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid METADATA_QUEUE_SIZE: %w", err)
+		}
+		config.MetadataQueueSize = queueSize
+	}
+	if envMaxURLs := os.Getenv("MAX_URLS_PER_USER"); envMaxURLs != "" {
+		maxURLs, err := strconv.Atoi(envMaxURLs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MAX_URLS_PER_USER: %w", err)
+		}
+		config.MaxURLsPerUser = maxURLs
+	}
+	if envMaxPending := os.Getenv("FILE_MAX_PENDING"); envMaxPending != "" {
+		maxPending, err := strconv.Atoi(envMaxPending)
+		if err != nil {
+			return nil, fmt.Errorf("invalid FILE_MAX_PENDING: %w", err)
+		}
+		config.FileMaxPending = maxPending
+	}
+	if envAnswerOptions := os.Getenv("ANSWER_OPTIONS"); envAnswerOptions != "" {
+		config.AnswerOptions = envAnswerOptions == "true"
+	}
 
 	// Load JWT secret
 	secretFile := os.Getenv("JWT_SECRET_FILE")
@@ -161,9 +1173,238 @@ func LoadConfig() (*Config, error) {
 	config.SecretKey = strings.TrimSpace(string(secretKeyBytes))
 
 	// Validate required fields
-	if config.Address == "" || config.BaseURL == "" || config.FileStorage == "" {
-		return nil, fmt.Errorf("address, base URL, file storage path must be provided")
+	if config.Address == "" || config.BaseURL == "" {
+		return nil, fmt.Errorf("address and base URL must be provided")
+	}
+
+	if config.TrustedSubnet != "" {
+		if _, _, err := net.ParseCIDR(config.TrustedSubnet); err != nil {
+			return nil, fmt.Errorf("invalid trusted subnet: %w", err)
+		}
+	}
+
+	for _, cidr := range config.ProxyCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, fmt.Errorf("invalid proxy CIDR: %w", err)
+		}
+	}
+
+	if config.DBMaxOpenConns < 0 || config.DBMaxIdleConns < 0 || config.DBConnMaxLifetime.Duration < 0 {
+		return nil, fmt.Errorf("database pool settings must not be negative")
+	}
+	if config.DBMaxOpenConns == 0 {
+		config.DBMaxOpenConns = DefaultDBMaxOpenConns
+	}
+	if config.DBMaxIdleConns == 0 {
+		config.DBMaxIdleConns = DefaultDBMaxIdleConns
+	}
+	if config.DBConnMaxLifetime.Duration == 0 {
+		config.DBConnMaxLifetime = Duration{DefaultDBConnMaxLifetime}
+	}
+
+	if config.CircuitBreakerThreshold < 0 {
+		return nil, fmt.Errorf("circuit breaker threshold must not be negative")
+	}
+	if config.CircuitBreakerTimeout.Duration == 0 {
+		config.CircuitBreakerTimeout = Duration{DefaultCircuitBreakerTimeout}
+	}
+
+	if config.RateLimitRPS < 0 || config.RateLimitBurst < 0 {
+		return nil, fmt.Errorf("rate limit and burst must not be negative")
+	}
+	if config.RateLimitRPS > 0 && config.RateLimitBurst == 0 {
+		config.RateLimitBurst = int(math.Ceil(config.RateLimitRPS))
+	}
+
+	if config.EnableWriteFallback && config.WriteFallbackFile == "" {
+		return nil, fmt.Errorf("write fallback file must be provided when write fallback is enabled")
+	}
+
+	if config.ReadTimeout.Duration < 0 || config.WriteTimeout.Duration < 0 || config.IdleTimeout.Duration < 0 {
+		return nil, fmt.Errorf("server timeouts must not be negative")
+	}
+	if config.ReadTimeout.Duration == 0 {
+		config.ReadTimeout = Duration{DefaultReadTimeout}
+	}
+	if config.WriteTimeout.Duration == 0 {
+		config.WriteTimeout = Duration{DefaultWriteTimeout}
+	}
+	if config.IdleTimeout.Duration == 0 {
+		config.IdleTimeout = Duration{DefaultIdleTimeout}
+	}
+
+	if config.TokenTTL.Duration < 0 || config.AnonCleanupInterval.Duration < 0 {
+		return nil, fmt.Errorf("token lifetime and anonymous cleanup interval must not be negative")
+	}
+	if config.TokenTTL.Duration == 0 {
+		config.TokenTTL = Duration{DefaultTokenTTL}
+	}
+
+	if config.StatsCacheTTL.Duration < 0 {
+		return nil, fmt.Errorf("stats cache TTL must not be negative")
+	}
+
+	if config.IdempotencyTTL.Duration < 0 {
+		return nil, fmt.Errorf("idempotency key lifetime must not be negative")
+	}
+	if config.IdempotencyTTL.Duration == 0 {
+		config.IdempotencyTTL = Duration{DefaultIdempotencyTTL}
+	}
+
+	if config.DeleteWorkerTimeout.Duration < 0 {
+		return nil, fmt.Errorf("delete worker timeout must not be negative")
+	}
+	if config.DeleteWorkerTimeout.Duration == 0 {
+		config.DeleteWorkerTimeout = Duration{DefaultDeleteWorkerTimeout}
+	}
+
+	if config.MetadataTimeout.Duration < 0 {
+		return nil, fmt.Errorf("metadata timeout must not be negative")
+	}
+	if config.MetadataTimeout.Duration == 0 {
+		config.MetadataTimeout = Duration{DefaultMetadataTimeout}
+	}
+	if config.MetadataWorkers < 0 || config.MetadataQueueSize < 0 {
+		return nil, fmt.Errorf("metadata workers and queue size must not be negative")
+	}
+	if config.MetadataWorkers == 0 {
+		config.MetadataWorkers = DefaultMetadataWorkers
+	}
+	if config.MetadataQueueSize == 0 {
+		config.MetadataQueueSize = DefaultMetadataQueueSize
+	}
+
+	if config.MaxTotalURLs < 0 {
+		return nil, fmt.Errorf("max total URLs must not be negative")
+	}
+
+	if config.MaxGzipWriters < 0 {
+		return nil, fmt.Errorf("max gzip writers must not be negative")
+	}
+
+	if config.GzipLevel < gzip.HuffmanOnly || config.GzipLevel > gzip.BestCompression {
+		return nil, fmt.Errorf("invalid gzip level %d: must be between %d and %d",
+			config.GzipLevel, gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if config.GzipLevel == 0 {
+		config.GzipLevel = gzip.DefaultCompression
+	}
+
+	if config.MaxDeleteBatch < 0 {
+		return nil, fmt.Errorf("max delete batch must not be negative")
+	}
+
+	if config.MaxURLsPerUser < 0 {
+		return nil, fmt.Errorf("max URLs per user must not be negative")
+	}
+
+	if config.FileMaxPending < 0 {
+		return nil, fmt.Errorf("file max pending must not be negative")
+	}
+	if config.FileSaveInterval.Duration < 0 {
+		return nil, fmt.Errorf("file save interval must not be negative")
+	}
+	if config.FileSaveInterval.Duration == 0 {
+		config.FileSaveInterval = Duration{DefaultFileSaveInterval}
+	}
+
+	if config.SignedLinkTTL.Duration < 0 {
+		return nil, fmt.Errorf("signed link TTL must not be negative")
+	}
+	if config.SignedLinkTTL.Duration == 0 {
+		config.SignedLinkTTL = Duration{DefaultSignedLinkTTL}
+	}
+
+	if config.ReservedAliases == nil {
+		config.ReservedAliases = append([]string(nil), DefaultReservedAliases...)
+	}
+
+	if config.DeletedRetention.Duration < 0 {
+		return nil, fmt.Errorf("deleted retention must not be negative")
+	}
+
+	if config.NotFoundRedirect != "" {
+		u, err := url.Parse(config.NotFoundRedirect)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid not-found redirect %q: must be an http(s) URL", config.NotFoundRedirect)
+		}
+	}
+
+	if config.CodePoolSize < 0 {
+		return nil, fmt.Errorf("code pool size must not be negative")
+	}
+
+	// The prefix is kept as "/s": with a leading slash and without a trailing one
+	if config.PathPrefix = strings.Trim(config.PathPrefix, "/"); config.PathPrefix != "" {
+		config.PathPrefix = "/" + config.PathPrefix
+	}
+
+	switch config.TrailingSlash {
+	case "", TrailingSlashStrip, TrailingSlashRedirect:
+	default:
+		return nil, fmt.Errorf("invalid trailing slash mode %q: must be %q or %q",
+			config.TrailingSlash, TrailingSlashStrip, TrailingSlashRedirect)
+	}
+
+	switch config.LogFormat {
+	case "":
+		config.LogFormat = LogFormatJSON
+	case LogFormatJSON, LogFormatConsole, LogFormatCLF, LogFormatCombined:
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be %q, %q, %q or %q",
+			config.LogFormat, LogFormatJSON, LogFormatConsole, LogFormatCLF, LogFormatCombined)
+	}
+
+	switch config.LogLevel {
+	case "":
+		config.LogLevel = LogLevelInfo
+	case LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError:
+	default:
+		return nil, fmt.Errorf("invalid log level %q: must be %q, %q, %q or %q",
+			config.LogLevel, LogLevelDebug, LogLevelInfo, LogLevelWarn, LogLevelError)
+	}
+
+	if err := resolveStorageBackend(config); err != nil {
+		return nil, err
 	}
 
 	return config, nil
 }
+
+// resolveStorageBackend checks that the settings the configured storage backend
+// needs are present, or detects the backend from them if none is configured.
+// The memory backend disables file persistence.
+func resolveStorageBackend(config *Config) error {
+	sqlite := strings.HasPrefix(config.DatabaseDSN, sqliteDSNPrefix)
+	switch config.StorageBackend {
+	case "":
+		switch {
+		case sqlite:
+			config.StorageBackend = StorageBackendSQLite
+		case config.DatabaseDSN != "":
+			config.StorageBackend = StorageBackendPostgres
+		case config.FileStorage != "":
+			config.StorageBackend = StorageBackendFile
+		default:
+			config.StorageBackend = StorageBackendMemory
+		}
+	case StorageBackendMemory:
+		config.FileStorage = ""
+	case StorageBackendFile:
+		if config.FileStorage == "" {
+			return fmt.Errorf("storage backend %q requires a file storage path", config.StorageBackend)
+		}
+	case StorageBackendPostgres:
+		if config.DatabaseDSN == "" || sqlite {
+			return fmt.Errorf("storage backend %q requires a PostgreSQL database DSN", config.StorageBackend)
+		}
+	case StorageBackendSQLite:
+		if !sqlite || config.DatabaseDSN == sqliteDSNPrefix {
+			return fmt.Errorf("storage backend %q requires a %spath.db database DSN", config.StorageBackend, sqliteDSNPrefix)
+		}
+	default:
+		return fmt.Errorf("invalid storage backend %q: must be %q, %q, %q or %q", config.StorageBackend,
+			StorageBackendMemory, StorageBackendFile, StorageBackendPostgres, StorageBackendSQLite)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// codeRetryInterval is how long the generator waits after failing to generate a code.
+const codeRetryInterval = 100 * time.Millisecond
+
+// codeWaitTimeout is how long get waits for the pool before generating a code itself.
+const codeWaitTimeout = 50 * time.Millisecond
+
+// codePool pre-generates short codes in the background so that shortening
+// requests don't pay for generation and collision checks on the hot path.
+//
+// Codes are reserved from the moment they are generated until the caller
+// releases them after storing: the generator never hands out the same code
+// twice and skips codes that are already stored. With code namespacing enabled,
+// the stored code depends on the user, so it is checked when the code is taken.
+type codePool struct {
+	codes chan string
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu       sync.Mutex
+	reserved map[string]struct{}
+}
+
+// pool is the active code pool, nil when pre-generation is disabled.
+var pool *codePool
+
+// InitCodePool starts pre-generating up to size short codes.
+// A size of zero disables the pool and codes are generated on demand.
+// Any previously started pool is stopped. Must be called after InitStorage.
+func InitCodePool(size int) {
+	StopCodePool()
+	if size <= 0 {
+		return
+	}
+	pool = newCodePool(size)
+}
+
+// StopCodePool stops the background generator of the active code pool.
+func StopCodePool() {
+	if pool != nil {
+		pool.close()
+		pool = nil
+	}
+}
+
+// newCodePool creates a pool holding up to size codes and starts filling it.
+func newCodePool(size int) *codePool {
+	p := &codePool{
+		codes:    make(chan string, size),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		reserved: make(map[string]struct{}, size),
+	}
+	go p.fill()
+	return p
+}
+
+// fill keeps the pool full until the pool is closed.
+// Sends block while the pool is full, so the pool is refilled as it drains.
+func (p *codePool) fill() {
+	defer close(p.done)
+	for {
+		code, err := p.reserve()
+		if err != nil {
+			logger.Error("Failed to pre-generate short code", zap.Error(err))
+			select {
+			case <-time.After(codeRetryInterval):
+				continue
+			case <-p.stop:
+				return
+			}
+		}
+		select {
+		case p.codes <- code:
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// reserve generates a code that is neither waiting in the pool nor stored,
+// unless namespaced; see get.
+func (p *codePool) reserve() (string, error) {
+	for {
+		code, err := generateShortURL()
+		if err != nil {
+			return "", err
+		}
+
+		p.mu.Lock()
+		_, taken := p.reserved[code]
+		if !taken {
+			p.reserved[code] = struct{}{}
+		}
+		p.mu.Unlock()
+
+		if taken {
+			continue
+		}
+		if codeNamespacing {
+			return code, nil
+		}
+		if _, exists, _ := storageInstance.GetURL(code); exists {
+			p.release(code)
+			continue
+		}
+		return code, nil
+	}
+}
+
+// release removes code from the reserved set.
+func (p *codePool) release(code string) {
+	p.mu.Lock()
+	delete(p.reserved, code)
+	p.mu.Unlock()
+}
+
+// get takes a code for userID from the pool. If the pool stays empty for
+// codeWaitTimeout or is closed, as when the generator stalls, the code is
+// generated and reserved inline instead. With code namespacing enabled, codes
+// whose namespaced form is already stored are skipped. The code stays reserved
+// until it is released.
+func (p *codePool) get(userID string) (string, error) {
+	timeout := time.NewTimer(codeWaitTimeout)
+	defer timeout.Stop()
+
+	inline := false
+	for {
+		var code string
+		if !inline {
+			select {
+			case code = <-p.codes:
+			case <-timeout.C:
+				inline = true
+			case <-p.stop:
+				inline = true
+			}
+		}
+		if inline {
+			var err error
+			if code, err = p.reserve(); err != nil {
+				return "", err
+			}
+		}
+
+		if !codeNamespacing {
+			return code, nil
+		}
+		if _, exists, _ := storageInstance.GetURL(namespacedCode(userID, code)); !exists {
+			return code, nil
+		}
+		p.release(code)
+	}
+}
+
+// close stops the generator and waits for it to exit.
+func (p *codePool) close() {
+	close(p.stop)
+	<-p.done
+}
+
+// nextShortURL returns a short code for userID from the pool if one is
+// configured, otherwise generates a new one. The code is not namespaced yet.
+// Callers must pass the code to releaseShortURL once it is stored or discarded.
+func nextShortURL(userID string) (string, error) {
+	if pool != nil {
+		return pool.get(userID)
+	}
+	return generateShortURL()
+}
+
+// releaseShortURL drops the pool reservation of a code returned by nextShortURL.
+func releaseShortURL(code string) {
+	if pool != nil {
+		pool.release(code)
+	}
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+)
+
+func TestCodePool_UniqueUnderConcurrency(t *testing.T) {
+	InitStorage(storage.NewURLStorage())
+	InitCodePool(16)
+	defer StopCodePool()
+
+	const workers, perWorker = 8, 50
+
+	var mu sync.Mutex
+	seen := make(map[string]struct{}, workers*perWorker)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWorker; j++ {
+				code, err := nextShortURL("user1")
+				if err != nil {
+					t.Errorf("nextShortURL() returned error: %v", err)
+					return
+				}
+				mu.Lock()
+				if _, dup := seen[code]; dup {
+					t.Errorf("Code %s handed out twice", code)
+				}
+				seen[code] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// 400 codes were drawn from a pool of 16, so it must have been refilled
+	if len(seen) != workers*perWorker {
+		t.Errorf("Expected %d unique codes, got %d", workers*perWorker, len(seen))
+	}
+}
+
+func TestCodePool_Refills(t *testing.T) {
+	InitStorage(storage.NewURLStorage())
+	InitCodePool(4)
+	defer StopCodePool()
+
+	waitFull := func() {
+		deadline := time.Now().Add(time.Second)
+		for len(pool.codes) < cap(pool.codes) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Pool not refilled: %d of %d codes", len(pool.codes), cap(pool.codes))
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitFull()
+	for i := 0; i < 4; i++ {
+		code, _ := nextShortURL("user1")
+		releaseShortURL(code)
+	}
+	waitFull()
+}
+
+func TestCodePool_SkipsStoredCodes(t *testing.T) {
+	s := storage.NewURLStorage()
+	InitStorage(s)
+	InitCodePool(8)
+	defer StopCodePool()
+
+	for i := 0; i < 100; i++ {
+		code, _ := nextShortURL("user1")
+		if _, exists, _ := s.GetURL(code); exists {
+			t.Fatalf("Pool handed out stored code %s", code)
+		}
+		if err := s.AddURL(code, "https://example.com/"+code, "user1"); err != nil {
+			t.Fatalf("AddURL() returned error: %v", err)
+		}
+		releaseShortURL(code)
+	}
+}
+
+func TestCodePool_SkipsStoredNamespacedCodes(t *testing.T) {
+	// Only three distinct codes can be generated: AAAAAA, AQEBAQ and AgICAg
+	var mu sync.Mutex
+	var n byte
+	randRead = func(b []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range b {
+			b[i] = n
+		}
+		n = (n + 1) % 3
+		return len(b), nil
+	}
+	t.Cleanup(func() { randRead = rand.Read })
+
+	s := storage.NewURLStorage()
+	InitStorage(s)
+	InitCodeNamespacing(true)
+	defer InitCodeNamespacing(false)
+	// The namespaced forms of two codes are taken; their bare forms don't matter
+	s.AddURL(namespacedCode("user1", "AAAAAA"), "https://example.com/1", "user1")
+	s.AddURL(namespacedCode("user1", "AQEBAQ"), "https://example.com/2", "user1")
+	s.AddURL("AgICAg", "https://example.com/3", "user2")
+	InitCodePool(1)
+	defer StopCodePool()
+
+	for i := 0; i < 10; i++ {
+		code, _ := nextShortURL("user1")
+		if code != "AgICAg" {
+			t.Fatalf("Pool handed out code %s, whose namespaced form is stored", code)
+		}
+		releaseShortURL(code)
+	}
+}
+
+func TestCodePool_GetFallsBackToInlineCodes(t *testing.T) {
+	InitStorage(storage.NewURLStorage())
+	// A pool without a generator never receives codes, like one whose generator stalls
+	stalled := &codePool{codes: make(chan string), stop: make(chan struct{}), reserved: make(map[string]struct{})}
+	stopped := &codePool{codes: make(chan string), stop: make(chan struct{}), reserved: make(map[string]struct{})}
+	close(stopped.stop)
+
+	for name, p := range map[string]*codePool{"stalled": stalled, "stopped": stopped} {
+		start := time.Now()
+		code, err := p.get("user1")
+		if err != nil || len(code) != 6 {
+			t.Errorf("%s: expected generated code of length 6, got %q (err: %v)", name, code, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: get() took %v", name, elapsed)
+		}
+		if _, reserved := p.reserved[code]; !reserved {
+			t.Errorf("%s: expected inline code %s to be reserved", name, code)
+		}
+	}
+}
+
+func TestInitCodePool_Disabled(t *testing.T) {
+	InitCodePool(0)
+
+	if pool != nil {
+		t.Error("Expected no pool for size 0")
+	}
+	if code, err := nextShortURL("user1"); err != nil || len(code) != 6 {
+		t.Errorf("Expected generated code of length 6, got %q (err: %v)", code, err)
+	}
+}
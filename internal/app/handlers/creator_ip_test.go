@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestRecordCreatorIP(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.RecordCreatorIP = true
+	// httptest requests come from 192.0.2.1, trusted to set X-Real-IP
+	cfg.ProxyCIDRs = []string{"192.0.2.0/24"}
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	shorten := func(originalURL, realIP string) string {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"`+originalURL+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Real-IP", realIP)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		HandleShortenPost(cfg, w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+
+		var resp ShortenResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp.ShortURL[strings.LastIndex(resp.ShortURL, "/")+1:]
+	}
+	details := func(id string) URLDetailsResponse {
+		r := chi.NewRouter()
+		r.Get("/api/internal/urls/{id}", HandleGetURLDetails)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/internal/urls/"+id, nil))
+
+		var resp URLDetailsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+
+	first := details(shorten("https://example.com/1", "203.0.113.7")).CreatorIPHash
+	if first == "" {
+		t.Fatal("Expected the creator IP hash in the URL details")
+	}
+	if strings.Contains(first, "203.0.113.7") {
+		t.Errorf("Expected the raw IP not to be stored, got %s", first)
+	}
+	if second := details(shorten("https://example.com/2", "203.0.113.7")).CreatorIPHash; second != first {
+		t.Errorf("Expected the same hash for the same IP, got %s and %s", first, second)
+	}
+	if other := details(shorten("https://example.com/3", "198.51.100.1")).CreatorIPHash; other == first {
+		t.Error("Expected another IP to have another hash")
+	}
+
+	if hash := details(shorten("https://example.com/5", "203.0.113.7:4321")).CreatorIPHash; hash != first {
+		t.Errorf("Expected the port to be ignored, got %s and %s", first, hash)
+	}
+
+	// Without trusted proxies, X-Real-IP is ignored in favor of the remote address
+	cfg.ProxyCIDRs = nil
+	if hash := details(shorten("https://example.com/6", "203.0.113.7")).CreatorIPHash; hash != creatorIPHash(cfg, net.ParseIP("192.0.2.1")) {
+		t.Errorf("Expected the hash of the remote address for a spoofed X-Real-IP, got %s", hash)
+	}
+
+	cfg.RecordCreatorIP = false
+	if hash := details(shorten("https://example.com/4", "203.0.113.7")).CreatorIPHash; hash != "" {
+		t.Errorf("Expected no hash when disabled, got %s", hash)
+	}
+}
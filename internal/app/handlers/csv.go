@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// maxAliasLength is the maximum length of a desired alias in a CSV import.
+const maxAliasLength = 64
+
+// errReservedAlias is returned by checkAlias for aliases listed in cfg.ReservedAliases.
+var errReservedAlias = errors.New("alias is reserved")
+
+// csvRow is a row of a CSV import and its outcome.
+type csvRow struct {
+	original string
+	alias    string
+	short    string
+	err      string
+}
+
+// HandleShortenCSVPost handles POST /api/shorten/csv requests, shortening the URLs
+// of a CSV document at once. Each row holds an original URL, optionally followed by
+// a desired alias. All new mappings are stored in a single storage operation
+// (one transaction for DBStorage).
+//
+// The response is a CSV document with an "original,short,error" header and a row
+// for every request row, in the same order. Rows that can't be shortened, such as
+// malformed rows or taken or reserved aliases (see cfg.ReservedAliases), have an
+// empty short URL and the reason in the error column instead of failing the whole import.
+//
+// HTTP methods: POST
+// Request body: text/csv
+// Response: text/csv
+//
+// Response codes:
+//   - 201: CSV processed, possibly with rejected rows
+//   - 400: Invalid request (content type or empty document)
+//   - 401: User not authorized
+//   - 429: User would hold more than the maximum number of URLs
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+//   - 507: Storage holds the maximum number of URLs
+func HandleShortenCSVPost(cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	if !strings.Contains(r.Header.Get("Content-Type"), "text/csv") {
+		httpError(w, "Invalid content type", http.StatusBadRequest)
+		return
+	}
+	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+	if !ok {
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := readCSVRows(cfg, r.Body)
+	if err != nil {
+		httpError(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		httpError(w, "Empty CSV", http.StatusBadRequest)
+		return
+	}
+
+	// Resolve a short URL for every valid row first, like HandleBatchShortenPost:
+	// stored URLs reuse their short URL and repeated URLs share one, while deleted
+	// URLs get a new one replacing their mapping.
+	assigned := make(map[string]string, len(rows))
+	urlsToSave := make(map[string]string, len(rows))
+	replacing := make(map[string]string)
+	var codes []string
+	for i := range rows {
+		row := &rows[i]
+		if row.err != "" {
+			continue
+		}
+		key := row.original
+		if cfg.NormalizeURLs {
+			key = storage.NormalizeURL(key)
+		}
+		shortURL, exists := assigned[key]
+		deletedShortURL := ""
+		if !exists {
+			shortURL, exists = storageInstance.GetShortURLByOriginalURL(row.original)
+			if exists {
+				if _, _, isDeleted := storageInstance.GetURL(shortURL); isDeleted {
+					deletedShortURL, exists = shortURL, false
+				}
+			}
+		}
+		switch {
+		case exists && row.alias != "" && row.alias != shortURL:
+			row.err = "URL already exists"
+			continue
+		case exists:
+		case row.alias != "":
+			if _, taken := urlsToSave[row.alias]; taken {
+				row.err = "Alias already taken"
+				continue
+			}
+			if _, taken, _ := storageInstance.GetURL(row.alias); taken {
+				row.err = "Alias already taken"
+				continue
+			}
+			shortURL = row.alias
+			urlsToSave[shortURL] = row.original
+		default:
+			code, err := nextShortURL(userID)
+			if err != nil {
+				for _, code := range codes {
+					releaseShortURL(code)
+				}
+				logger.Error("Failed to generate short URL", zap.Error(err))
+				httpError(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			codes = append(codes, code)
+			shortURL = namespacedCode(userID, code)
+			urlsToSave[shortURL] = row.original
+		}
+		if deletedShortURL != "" {
+			replacing[shortURL] = deletedShortURL
+		}
+		assigned[key] = shortURL
+		row.short = shortURL
+	}
+
+	if len(urlsToSave) > 0 {
+		var failed map[string]error
+		release, err := checkQuota(cfg, userID, len(urlsToSave))
+		if err == nil {
+			failed, err = storeURLs(urlsToSave, replacing, userID)
+			release()
+		}
+		for _, code := range codes {
+			releaseShortURL(code)
+		}
+		if err != nil {
+			httpError(w, "Failed to save URL mapping", storageErrorStatus(err))
+			return
+		}
+		for i := range rows {
+			if err, ok := failed[rows[i].short]; ok {
+				rows[i].short, rows[i].err = "", batchItemError(err)
+			}
+		}
+		for shortURL := range failed {
+			delete(urlsToSave, shortURL)
+		}
+		saved := make([]string, 0, len(urlsToSave))
+		for shortURL := range urlsToSave {
+			saved = append(saved, shortURL)
+		}
+		recordContentType(cfg, saved, storage.ContentTypeCSV)
+		recordCreatorIP(cfg, r, saved)
+
+		if cfg.FileStorage != "" {
+			if err := storage.SaveURLMappings(cfg.FileStorage, urlsToSave); err != nil {
+				logger.Warn("Failed to save URL mappings to file", zap.Error(err))
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusCreated)
+	out := csv.NewWriter(w)
+	out.Write([]string{"original", "short", "error"})
+	for _, row := range rows {
+		short := ""
+		if row.short != "" {
+			short = shortLink(cfg, row.short)
+		}
+		if err := out.Write([]string{row.original, short, row.err}); err != nil {
+			logger.Error("Failed to write CSV response", zap.Error(err))
+			return
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		logger.Error("Failed to write CSV response", zap.Error(err))
+	}
+}
+
+// readCSVRows reads the rows of a CSV import, recording why malformed rows are
+// rejected. Returns an error only if the document can't be read.
+func readCSVRows(cfg *config.Config, body io.Reader) ([]csvRow, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var rows []csvRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rows = append(rows, csvRow{err: "Malformed row"})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := csvRow{original: strings.TrimSpace(record[0])}
+		if len(record) > 1 {
+			row.alias = strings.TrimSpace(record[1])
+		}
+		row.err = validateCSVRow(cfg, row, len(record))
+		rows = append(rows, row)
+	}
+}
+
+// validateCSVRow returns why a CSV import row is rejected, or "" if it is valid.
+func validateCSVRow(cfg *config.Config, row csvRow, fields int) string {
+	if fields > 2 {
+		return "Too many columns"
+	}
+	u, err := url.Parse(row.original)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "Invalid URL"
+	}
+	if err := validateTarget(cfg, row.original); err != nil {
+		return err.Error()
+	}
+	if row.alias != "" && !validAlias(row.alias) {
+		return "Invalid alias"
+	}
+	if errors.Is(checkAlias(cfg, row.alias), errReservedAlias) {
+		return "Alias is reserved"
+	}
+	return ""
+}
+
+// checkAlias returns errReservedAlias if alias is one of cfg.ReservedAliases,
+// regardless of case.
+func checkAlias(cfg *config.Config, alias string) error {
+	for _, reserved := range cfg.ReservedAliases {
+		if strings.EqualFold(alias, reserved) {
+			return errReservedAlias
+		}
+	}
+	return nil
+}
+
+// validAlias reports whether alias can be used as a short URL: up to
+// maxAliasLength letters, digits, '-' and '_', the characters of generated codes.
+func validAlias(alias string) bool {
+	if len(alias) > maxAliasLength {
+		return false
+	}
+	for _, r := range alias {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
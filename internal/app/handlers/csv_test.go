@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+)
+
+func TestHandleShortenCSVPost(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("taken", "https://taken.example.com", "user2")
+
+	body := strings.Join([]string{
+		"https://example.com/1",
+		"https://example.com/2,promo",
+		"not a url",
+		"https://example.com/3,taken",
+		"https://example.com/4,bad alias",
+		"https://example.com/1",
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenCSVPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "text/csv" {
+		t.Errorf("Expected text/csv, got %s", contentType)
+	}
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(records) != 7 || !reflect.DeepEqual(records[0], []string{"original", "short", "error"}) {
+		t.Fatalf("Expected a header and 6 rows, got %v", records)
+	}
+	rows := records[1:]
+
+	generated := rows[0][1]
+	if !strings.HasPrefix(generated, cfg.BaseURL+"/") || rows[0][2] != "" {
+		t.Errorf("Expected a generated short URL for row 1, got %v", rows[0])
+	}
+	if rows[1][1] != cfg.BaseURL+"/promo" || rows[1][2] != "" {
+		t.Errorf("Expected the alias for row 2, got %v", rows[1])
+	}
+	for i, expected := range map[int]string{2: "Invalid URL", 3: "Alias already taken", 4: "Invalid alias"} {
+		if rows[i][1] != "" || rows[i][2] != expected {
+			t.Errorf("Expected row %d to be rejected with %q, got %v", i+1, expected, rows[i])
+		}
+	}
+	if rows[5][1] != generated {
+		t.Errorf("Expected a repeated URL to share %s, got %v", generated, rows[5])
+	}
+
+	// Only the valid rows are stored
+	if originalURL, exists, _ := testStorage.GetURL("promo"); !exists || originalURL != "https://example.com/2" {
+		t.Errorf("Expected promo to be stored, got %q (exists: %v)", originalURL, exists)
+	}
+	if urls := testStorage.GetAllURLs(); len(urls) != 3 {
+		t.Errorf("Expected 3 stored URLs, got %d", len(urls))
+	}
+}
+
+func TestHandleShortenCSVPost_DeletedURLs(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("gone1", "https://example.com/1", "user1")
+	testStorage.AddURL("gone2", "https://example.com/2", "user1")
+	testStorage.DeleteURLs([]string{"gone1", "gone2"}, "user1")
+
+	body := "https://example.com/1\nhttps://example.com/2,promo"
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenCSVPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) != 3 {
+		t.Fatalf("Expected a header and 2 rows, got %v (err: %v)", records, err)
+	}
+
+	// Deleted URLs get a new short URL, or the requested alias, instead of a dead link
+	generated := strings.TrimPrefix(records[1][1], cfg.BaseURL+"/")
+	if generated == "" || generated == "gone1" || records[1][2] != "" {
+		t.Errorf("Expected a new short URL for row 1, got %v", records[1])
+	}
+	if records[2][1] != cfg.BaseURL+"/promo" || records[2][2] != "" {
+		t.Errorf("Expected the alias for row 2, got %v", records[2])
+	}
+	for shortURL, expected := range map[string]string{generated: "https://example.com/1", "promo": "https://example.com/2"} {
+		if originalURL, exists, isDeleted := testStorage.GetURL(shortURL); !exists || isDeleted || originalURL != expected {
+			t.Errorf("Expected %s to resolve to %s, got %q (exists: %v, deleted: %v)", shortURL, expected, originalURL, exists, isDeleted)
+		}
+	}
+}
+
+func TestHandleShortenCSVPost_ReservedAliases(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.ReservedAliases = config.DefaultReservedAliases
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	body := strings.Join([]string{
+		"https://example.com/1,api",
+		"https://example.com/2,Health",
+		"https://example.com/3,healthy",
+	}, "\n")
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenCSVPost(cfg, w, req)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil || len(records) != 4 {
+		t.Fatalf("Expected a header and 3 rows, got %v (err: %v)", records, err)
+	}
+	rows := records[1:]
+	// Reserved aliases are rejected regardless of case
+	for _, row := range rows[:2] {
+		if row[1] != "" || row[2] != "Alias is reserved" {
+			t.Errorf("Expected %s to be rejected as reserved, got %v", row[0], row)
+		}
+	}
+	if rows[2][1] != cfg.BaseURL+"/healthy" || rows[2][2] != "" {
+		t.Errorf("Expected the alias healthy to be claimed, got %v", rows[2])
+	}
+	if _, exists, _ := testStorage.GetURL("api"); exists {
+		t.Error("Expected the reserved alias api not to be stored")
+	}
+}
+
+func TestHandleShortenCSVPost_InvalidRequest(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	InitStorage(storage.NewURLStorage())
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "Wrong content type", contentType: "application/json", body: "https://example.com"},
+		{name: "Empty document", contentType: "text/csv", body: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
+			HandleShortenCSVPost(cfg, w, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
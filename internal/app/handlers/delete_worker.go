@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
+)
+
+// deleteQueue tracks the deletions accepted by HandleDeleteUserURLs that are
+// not applied yet, and when the last of them was applied.
+var deleteQueue struct {
+	mu         sync.Mutex
+	depth      int
+	progressed time.Time
+}
+
+// enqueueDelete records an accepted deletion.
+func enqueueDelete() {
+	deleteQueue.mu.Lock()
+	defer deleteQueue.mu.Unlock()
+	// Waiting for the first deletion of an idle queue starts now
+	if deleteQueue.depth == 0 {
+		deleteQueue.progressed = time.Now()
+	}
+	deleteQueue.depth++
+	metrics.DeleteQueueDepth.Inc()
+}
+
+// finishDelete records that an accepted deletion was applied or failed.
+func finishDelete() {
+	deleteQueue.mu.Lock()
+	defer deleteQueue.mu.Unlock()
+	deleteQueue.depth--
+	deleteQueue.progressed = time.Now()
+	metrics.DeleteQueueDepth.Dec()
+}
+
+// DeleteWorkerHealthy reports whether accepted deletions are being applied: it
+// returns false if deletions are pending and none was finished within timeout,
+// so that a stuck storage is detectable from metrics.
+func DeleteWorkerHealthy(timeout time.Duration) bool {
+	deleteQueue.mu.Lock()
+	defer deleteQueue.mu.Unlock()
+	return deleteQueue.depth == 0 || time.Since(deleteQueue.progressed) < timeout
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// stalledDeleteStorage is a storage whose deletions hang until released.
+type stalledDeleteStorage struct {
+	*storage.URLStorage
+	release chan struct{}
+}
+
+func (s stalledDeleteStorage) DeleteURLs(shortURLs []string, userID string) error {
+	<-s.release
+	return s.URLStorage.DeleteURLs(shortURLs, userID)
+}
+
+// gaugeValue returns the current value of gauge.
+func gaugeValue(t *testing.T, gauge prometheus.Metric) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := gauge.Write(&m); err != nil {
+		t.Fatalf("Failed to read gauge: %v", err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestDeleteWorkerHealth_StalledWorker(t *testing.T) {
+	timeout := 50 * time.Millisecond
+	metrics.SetDeleteWorkerHealthFunc(func() bool { return DeleteWorkerHealthy(timeout) })
+
+	testStorage := stalledDeleteStorage{storage.NewURLStorage(), make(chan struct{})}
+	testStorage.AddURL("short1", "https://example.com", "user1")
+	InitStorage(testStorage)
+	depth := gaugeValue(t, metrics.DeleteQueueDepth)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["short1"]`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleDeleteUserURLs(testutils.CreateTestConfigWithDefaults(t)).ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+
+	if got := gaugeValue(t, metrics.DeleteQueueDepth); got != depth+1 {
+		t.Errorf("Expected queue depth %v, got %v", depth+1, got)
+	}
+	if got := gaugeValue(t, metrics.DeleteWorkerHealthy); got != 1 {
+		t.Errorf("Expected a healthy worker within the timeout, got %v", got)
+	}
+
+	time.Sleep(2 * timeout)
+	if got := gaugeValue(t, metrics.DeleteWorkerHealthy); got != 0 {
+		t.Errorf("Expected a stalled worker to be unhealthy, got %v", got)
+	}
+
+	close(testStorage.release)
+	if err := WaitForDeletes(context.Background()); err != nil {
+		t.Fatalf("Expected pending deletes to finish, got %v", err)
+	}
+	if got := gaugeValue(t, metrics.DeleteQueueDepth); got != depth {
+		t.Errorf("Expected queue depth %v, got %v", depth, got)
+	}
+	if got := gaugeValue(t, metrics.DeleteWorkerHealthy); got != 1 {
+		t.Errorf("Expected an idle worker to be healthy, got %v", got)
+	}
+}
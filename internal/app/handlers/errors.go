@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the JSON body of all error responses of the handlers.
+//
+// Example JSON:
+//
+//	{
+//	  "error": "URL not found",
+//	  "code": "not_found"
+//	}
+type ErrorResponse struct {
+	// Error is a human-readable description of the error
+	Error string `json:"error"`
+	// Code is a stable machine-readable error code derived from the status code
+	Code string `json:"code"`
+}
+
+// httpError replies with the status code and an ErrorResponse carrying message.
+// It is the JSON counterpart of http.Error.
+func httpError(w http.ResponseWriter, message string, status int) {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", "application/json")
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: errorCode(status)})
+}
+
+// errorCode returns the machine-readable code of a status, e.g. "not_found" for 404.
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '_'
+		}
+	}, text)
+}
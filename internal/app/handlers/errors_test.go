@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPError(t *testing.T) {
+	tests := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusBadRequest, "bad_request"},
+		{http.StatusNotFound, "not_found"},
+		{http.StatusRequestEntityTooLarge, "request_entity_too_large"},
+		{http.StatusInsufficientStorage, "insufficient_storage"},
+		{599, "error"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Length", "42")
+		httpError(w, "Something failed", tt.status)
+
+		if w.Code != tt.status {
+			t.Errorf("Expected status %d, got %d", tt.status, w.Code)
+		}
+		if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("Expected application/json, got %s", contentType)
+		}
+		if w.Header().Get("Content-Length") != "" {
+			t.Error("Expected Content-Length to be removed")
+		}
+		var resp ErrorResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to parse error response: %v", err)
+		}
+		if resp.Error != "Something failed" || resp.Code != tt.code {
+			t.Errorf("Expected {Something failed %s}, got %+v", tt.code, resp)
+		}
+	}
+}
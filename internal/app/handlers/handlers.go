@@ -2,47 +2,96 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"maps"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
 	"github.com/achufistov/shortygopher.git/internal/app/middleware"
 	"github.com/achufistov/shortygopher.git/internal/app/storage"
 	"github.com/go-chi/chi/v5"
+	"github.com/skip2/go-qrcode"
+	"go.uber.org/zap"
+)
+
+// Bounds and default of the QR code image size in pixels.
+const (
+	defaultQRSize = 256
+	minQRSize     = 64
+	maxQRSize     = 1024
 )
 
 var storageInstance storage.Storage
 
+// logger logs failures of the handlers; set by InitLogger.
+var logger = zap.NewNop()
+
+// pendingDeletes tracks deletions still running in the background.
+var pendingDeletes sync.WaitGroup
+
 // ShortenRequest represents a URL shortening request in JSON format.
-// Used in the POST /api/shorten endpoint.
+// Used in the POST /api/shorten endpoint. Protected short URLs only resolve
+// through the signed link returned on creation (see InitSignedLinks); they are
+// not saved to the file storage, which can't record the protection.
 //
 // Example JSON:
 //
 //	{
-//	  "url": "https://example.com/very/long/path"
+//	  "url": "https://example.com/very/long/path",
+//	  "protected": true
 //	}
 type ShortenRequest struct {
 	OriginalURL string `json:"url"`
+	Protected   bool   `json:"protected,omitempty"`
 }
 
 // ShortenResponse represents a URL shortening response in JSON format.
-// Returned from the POST /api/shorten endpoint.
+// Returned from the POST /api/shorten endpoint. AlreadyExists mirrors the
+// 409 Conflict status for clients that don't inspect status codes.
+// SignedURL is the link resolving a newly created protected short URL.
 //
 // Example JSON:
 //
 //	{
-//	  "result": "http://localhost:8080/abc123"
+//	  "result": "http://localhost:8080/abc123",
+//	  "already_exists": false,
+//	  "signed_url": "http://localhost:8080/abc123?token=1767225600.c2lnbmF0dXJl"
 //	}
 type ShortenResponse struct {
-	ShortURL string `json:"result"`
+	ShortURL      string `json:"result"`
+	AlreadyExists bool   `json:"already_exists"`
+	SignedURL     string `json:"signed_url,omitempty"`
+}
+
+// ShortenV2Response represents a URL shortening response in JSON format.
+// Returned from the POST /api/v2/shorten endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "short_url": "http://localhost:8080/abc123"
+//	}
+type ShortenV2Response struct {
+	ShortURL  string `json:"short_url"`
+	SignedURL string `json:"signed_url,omitempty"`
 }
 
+// legacyShortenWarning is the Warning header value of the legacy POST /api/shorten
+// responses, sent when cfg.DeprecationWarnings is enabled.
+const legacyShortenWarning = `299 - "Deprecated endpoint, use /api/v2/shorten"`
+
 // BatchRequest represents one item in a batch request for shortening multiple URLs.
 // Used in the POST /api/shorten/batch endpoint.
 type BatchRequest struct {
@@ -52,9 +101,206 @@ type BatchRequest struct {
 
 // BatchResponse represents one item in a batch response for shortening multiple URLs.
 // Returned from the POST /api/shorten/batch endpoint.
+// Items the storage failed to store carry an error instead of a short URL.
 type BatchResponse struct {
 	CorrelationID string `json:"correlation_id"`
-	ShortURL      string `json:"short_url"`
+	ShortURL      string `json:"short_url,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ExpandResponse represents the original URL behind a short URL.
+// Returned from the GET /api/expand/{id} endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "original_url": "https://example.com",
+//	  "deleted": false
+//	}
+type ExpandResponse struct {
+	OriginalURL string `json:"original_url"`
+	Deleted     bool   `json:"deleted"`
+}
+
+// BuildInfo describes the running build. Empty values are reported as "N/A".
+type BuildInfo struct {
+	Version string
+	Date    string
+	Commit  string
+}
+
+// VersionResponse represents the running build in JSON format.
+// Returned from the GET /api/version endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "version": "v1.2.0",
+//	  "build_date": "2024-05-01",
+//	  "build_commit": "abc1234"
+//	}
+type VersionResponse struct {
+	Version     string `json:"version"`
+	BuildDate   string `json:"build_date"`
+	BuildCommit string `json:"build_commit"`
+}
+
+// Health statuses reported by the GET /health endpoint.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// HealthResponse represents the service health in JSON format.
+// Returned from the GET /health endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "status": "ok",
+//	  "storage": "ok",
+//	  "version": "v1.2.0",
+//	  "build_date": "2024-05-01",
+//	  "build_commit": "abc1234",
+//	  "uptime": "1h2m3s"
+//	}
+type HealthResponse struct {
+	Status      string `json:"status"`
+	Storage     string `json:"storage"`
+	Version     string `json:"version"`
+	BuildDate   string `json:"build_date"`
+	BuildCommit string `json:"build_commit"`
+	Uptime      string `json:"uptime"`
+}
+
+// UserURLResponse represents one URL in the GET /api/user/urls response.
+// Empty optional fields are omitted unless verbose JSON is enabled.
+//
+// Example JSON:
+//
+//	{
+//	  "short_url": "http://localhost:8080/abc123",
+//	  "original_url": "https://example.com",
+//	  "is_deleted": true,
+//	  "content_type": "text",
+//	  "created_at": "2024-05-01T12:00:00Z",
+//	  "deleted_at": "2024-05-02T08:30:00Z",
+//	  "title": "Example Domain",
+//	  "favicon_url": "https://example.com/favicon.ico"
+//	}
+type UserURLResponse struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	IsDeleted   bool       `json:"is_deleted,omitempty"`
+	ContentType string     `json:"content_type,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	FaviconURL  string     `json:"favicon_url,omitempty"`
+}
+
+// verboseUserURLResponse mirrors UserURLResponse but always includes optional fields.
+// The field sets must stay identical so that the types remain convertible.
+type verboseUserURLResponse struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	IsDeleted   bool       `json:"is_deleted"`
+	ContentType string     `json:"content_type"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeletedAt   *time.Time `json:"deleted_at"`
+	Title       string     `json:"title"`
+	FaviconURL  string     `json:"favicon_url"`
+}
+
+// DeleteResponse echoes the short URLs accepted for deletion in JSON format.
+// Returned from the DELETE /api/user/urls endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "accepted": ["abc123", "def456"],
+//	  "count": 2
+//	}
+type DeleteResponse struct {
+	Accepted []string `json:"accepted"`
+	Count    int      `json:"count"`
+}
+
+// PurgeResponse reports how many URLs were permanently removed in JSON format.
+// Returned from the DELETE /api/user endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "removed": 3
+//	}
+type PurgeResponse struct {
+	Removed int `json:"removed"`
+}
+
+// StatsResponse represents storage statistics in JSON format.
+// Returned from the GET /api/internal/stats endpoint.
+// urls counts all stored URLs, including the soft-deleted ones counted in deleted.
+// redirects counts short URL lookups since startup by resulting status code.
+//
+// Example JSON:
+//
+//	{
+//	  "urls": 120,
+//	  "deleted": 4,
+//	  "users": 7,
+//	  "window": {"duration": "24h0m0s", "created": 15, "deleted": 2},
+//	  "redirects": {"307": 5400, "404": 12, "410": 3}
+//	}
+type StatsResponse struct {
+	URLs      int                  `json:"urls"`
+	Deleted   int                  `json:"deleted"`
+	Users     int                  `json:"users"`
+	Window    *WindowStatsResponse `json:"window,omitempty"`
+	Redirects map[string]int64     `json:"redirects,omitempty"`
+}
+
+// WindowStatsResponse contains the number of URLs created and deleted within a time window.
+type WindowStatsResponse struct {
+	Duration string `json:"duration"`
+	Created  int    `json:"created"`
+	Deleted  int    `json:"deleted"`
+}
+
+// FlushResponse reports the result of a file storage flush in JSON format.
+// Returned from the POST /api/internal/flush endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "written": 120
+//	}
+type FlushResponse struct {
+	Written int `json:"written"`
+}
+
+// URLDetailsResponse describes a short URL and its owner in JSON format.
+// Returned from the GET /api/internal/urls/{id} endpoint.
+//
+// Example JSON:
+//
+//	{
+//	  "short_url": "abc123",
+//	  "original_url": "https://example.com",
+//	  "user_id": "6f1c0c1e-3b5a-4d8e-9a51-2f0e8d3c7b42",
+//	  "created_at": "2024-05-01T12:00:00Z",
+//	  "is_deleted": false,
+//	  "creator_ip_hash": "9f2b5c0e..."
+//	}
+type URLDetailsResponse struct {
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	IsDeleted   bool       `json:"is_deleted"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+	// CreatorIPHash is the salted hash of the creator's IP address, if recorded
+	CreatorIPHash string `json:"creator_ip_hash,omitempty"`
 }
 
 // InitStorage initializes the global storage instance.
@@ -68,191 +314,536 @@ func InitStorage(storage storage.Storage) {
 	storageInstance = storage
 }
 
+// InitLogger sets the logger handlers report failures and warnings to.
+// Nothing is logged until it is called.
+func InitLogger(l *zap.Logger) {
+	logger = l
+}
+
 // HandlePost handles POST / requests for URL shortening in text format.
-// Accepts the original URL in the request body as text/plain.
-// Returns the shortened URL in the response body.
+// Accepts the original URL in the request body as text/plain, as JSON, or as
+// the url field of a form. Returns the shortened URL in the response body.
+// With cfg.PostRedirectGet, form submissions from browsers (accepting text/html)
+// are redirected to the short URL with 303 See Other instead, whether the URL
+// was created or already existed.
 //
 // HTTP methods: POST
-// Content-Type: text/plain
+// Content-Type: text/plain, application/json or application/x-www-form-urlencoded
+// Headers: Idempotency-Key - optional key making retries return the first result
 // Response: text/plain with shortened URL
 //
 // Response codes:
 //   - 201: URL successfully shortened
-//   - 400: Invalid request method or Content-Type
+//   - 303: URL shortened or already existing, for browser form submissions
+//   - 400: Invalid request method, Content-Type, idempotency key, or non-HTTPS URL when HTTPS is required
 //   - 401: User not authorized
-//   - 409: URL already exists
+//   - 409: URL already exists; Location holds the existing short URL
+//     Also returned while a request with the same idempotency key is in progress
+//   - 422: Idempotency key was used for another URL
+//   - 429: User holds the maximum number of URLs
 //   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+//   - 507: Storage holds the maximum number of URLs
 func HandlePost(cfg *config.Config, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusBadRequest)
+		httpError(w, "Invalid request method", http.StatusBadRequest)
 		return
 	}
 
-	var originalURL string
+	var originalURL, submittedAs string
 
 	contentType := r.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "application/json") && !strings.Contains(contentType, "text/plain") {
-		http.Error(w, "Invalid content type", http.StatusBadRequest)
-		return
-	}
-
-	if strings.Contains(contentType, "application/json") {
+	switch {
+	case strings.Contains(contentType, "application/json"):
 		var req ShortenRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			httpError(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		originalURL = req.OriginalURL
-	} else {
+		originalURL, submittedAs = req.OriginalURL, storage.ContentTypeJSON
+	case strings.Contains(contentType, "text/plain"):
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			httpError(w, "Invalid request body", http.StatusBadRequest)
 			return
 		}
-		originalURL = string(body)
+		originalURL, submittedAs = string(body), storage.ContentTypeText
+	case strings.Contains(contentType, "application/x-www-form-urlencoded"):
+		if err := r.ParseForm(); err != nil {
+			httpError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		originalURL, submittedAs = r.PostForm.Get("url"), storage.ContentTypeForm
+	default:
+		httpError(w, "Invalid content type", http.StatusBadRequest)
+		return
 	}
 
 	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	shortURL := generateShortURL()
-	err := storageInstance.AddURL(shortURL, originalURL, userID)
-	if err != nil {
-		if err.Error() == "URL already exists" {
-			existingShortURL, exists := storageInstance.GetShortURLByOriginalURL(originalURL)
-			if !exists {
-				http.Error(w, "Failed to get existing short URL", http.StatusInternalServerError)
-				return
-			}
-			w.Header().Set("Content-Type", "text/plain")
-			w.WriteHeader(http.StatusConflict)
-			fmt.Fprintf(w, "%s/%s", cfg.BaseURL, existingShortURL)
+	if err := validateTarget(cfg, originalURL); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	seeOther := cfg.PostRedirectGet && submittedAs == storage.ContentTypeForm &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+	write := func(status int, shortURL string) {
+		if seeOther {
+			w.Header().Set("Location", shortLink(cfg, shortURL))
+			w.WriteHeader(http.StatusSeeOther)
 			return
 		}
-		http.Error(w, "Failed to save URL mapping", http.StatusInternalServerError)
+		if status == http.StatusConflict {
+			w.Header().Set("Location", shortLink(cfg, shortURL))
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(status)
+		fmt.Fprint(w, shortLink(cfg, shortURL))
+	}
+	idempotency, written := reserveIdempotent(cfg, w, r, userID, originalURL, write)
+	if written {
+		return
+	}
+	defer idempotency.release()
+
+	shortURL, exists, err := createShortURL(cfg, originalURL, userID)
+	if errors.Is(err, errExistingURLMissing) {
+		httpError(w, "Failed to get existing short URL", http.StatusInternalServerError)
+		return
+	}
+	if err != nil {
+		httpError(w, "Failed to save URL mapping", storageErrorStatus(err))
+		return
+	}
+	if exists {
+		idempotency.save(shortURL, http.StatusConflict)
+		write(http.StatusConflict, shortURL)
 		return
 	}
+	recordContentType(cfg, []string{shortURL}, submittedAs)
+	recordCreatorIP(cfg, r, []string{shortURL})
+	recordMetadata(cfg, shortURL, originalURL)
+	idempotency.save(shortURL, http.StatusCreated)
 
 	if cfg.FileStorage != "" {
 		if err := storage.SaveSingleURLMapping(cfg.FileStorage, shortURL, originalURL); err != nil {
-			log.Printf("Warning: Failed to save URL mapping to file: %v", err)
+			logger.Warn("Failed to save URL mapping to file", zap.Error(err))
 		}
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintf(w, "%s/%s", cfg.BaseURL, shortURL)
+	write(http.StatusCreated, shortURL)
 }
 
 // HandleShortenPost handles POST /api/shorten requests for URL shortening in JSON format.
 // Accepts JSON with original URL and returns JSON with shortened URL.
+// The endpoint is superseded by POST /api/v2/shorten; with cfg.DeprecationWarnings
+// enabled its responses carry a Warning header pointing clients to it.
 //
 // HTTP methods: POST
 // Content-Type: application/json
+// Headers: Idempotency-Key - optional key making retries return the first result
 // Response: application/json with ShortenResponse object
 //
 // Response codes:
 //   - 201: URL successfully shortened
-//   - 400: Invalid request method or JSON
+//   - 400: Invalid request method, JSON, idempotency key, non-HTTPS URL when HTTPS is required,
+//     or protection requested without signed links enabled
 //   - 401: User not authorized
-//   - 409: URL already exists
+//   - 409: URL already exists; Location holds the existing short URL, which isn't protected anew
+//     Also returned while a request with the same idempotency key is in progress
+//   - 422: Idempotency key was used for another URL
+//   - 429: User holds the maximum number of URLs
 //   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+//   - 507: Storage holds the maximum number of URLs
 func HandleShortenPost(cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	if cfg.DeprecationWarnings {
+		w.Header().Set("Warning", legacyShortenWarning)
+	}
+	shortenJSON(cfg, w, r, func(shortURL, signedURL string, exists bool) interface{} {
+		return ShortenResponse{ShortURL: shortURL, AlreadyExists: exists, SignedURL: signedURL}
+	})
+}
+
+// HandleShortenV2Post handles POST /api/v2/shorten requests for URL shortening in JSON format.
+// It works like HandleShortenPost, but returns the short URL in the short_url field.
+//
+// HTTP methods: POST
+// Content-Type: application/json
+// Headers: Idempotency-Key - optional key making retries return the first result
+// Response: application/json with ShortenV2Response object
+//
+// Response codes:
+//   - 201: URL successfully shortened
+//   - 400: Invalid request method, JSON, idempotency key, non-HTTPS URL when HTTPS is required,
+//     or protection requested without signed links enabled
+//   - 401: User not authorized
+//   - 409: URL already exists; Location holds the existing short URL, which isn't protected anew
+//     Also returned while a request with the same idempotency key is in progress
+//   - 422: Idempotency key was used for another URL
+//   - 429: User holds the maximum number of URLs
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+//   - 507: Storage holds the maximum number of URLs
+func HandleShortenV2Post(cfg *config.Config, w http.ResponseWriter, r *http.Request) {
+	shortenJSON(cfg, w, r, func(shortURL, signedURL string, _ bool) interface{} {
+		return ShortenV2Response{ShortURL: shortURL, SignedURL: signedURL}
+	})
+}
+
+// shortenJSON shortens the URL of a JSON ShortenRequest and responds with the
+// object returned by response for the full short URL, the signed link of a newly
+// created protected URL and whether the URL already existed.
+func shortenJSON(cfg *config.Config, w http.ResponseWriter, r *http.Request, response func(shortURL, signedURL string, exists bool) interface{}) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusBadRequest)
+		httpError(w, "Invalid request method", http.StatusBadRequest)
 		return
 	}
 
 	var req ShortenRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		httpError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
 	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if err := validateTarget(cfg, req.OriginalURL); err != nil {
+		httpError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Protected && !signedLinksEnabled() {
+		httpError(w, "Protected URLs are not supported", http.StatusBadRequest)
+		return
+	}
+
+	write := func(status int, shortURL string) {
+		var signedURL string
+		if req.Protected && status == http.StatusCreated {
+			signedURL = signedLink(cfg, shortURL)
+		}
+		resp := response(shortLink(cfg, shortURL), signedURL, status == http.StatusConflict)
+		if status == http.StatusConflict {
+			w.Header().Set("Location", shortLink(cfg, shortURL))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpError(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+	idempotency, written := reserveIdempotent(cfg, w, r, userID, req.OriginalURL, write)
+	if written {
 		return
 	}
+	defer idempotency.release()
 
-	shortURL := generateShortURL()
-	err := storageInstance.AddURL(shortURL, req.OriginalURL, userID)
+	shortURL, exists, err := createShortURL(cfg, req.OriginalURL, userID)
+	if errors.Is(err, errExistingURLMissing) {
+		httpError(w, "Failed to get existing short URL", http.StatusInternalServerError)
+		return
+	}
 	if err != nil {
-		if err.Error() == "URL already exists" {
-			existingShortURL, exists := storageInstance.GetShortURLByOriginalURL(req.OriginalURL)
-			if !exists {
-				http.Error(w, "Failed to get existing short URL", http.StatusInternalServerError)
-				return
-			}
-			resp := ShortenResponse{
-				ShortURL: fmt.Sprintf("%s/%s", cfg.BaseURL, existingShortURL),
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusConflict)
-			if err := json.NewEncoder(w).Encode(resp); err != nil {
-				http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		httpError(w, "Failed to save URL mapping", storageErrorStatus(err))
+		return
+	}
+	if exists {
+		idempotency.save(shortURL, http.StatusConflict)
+		write(http.StatusConflict, shortURL)
+		return
+	}
+	if req.Protected {
+		if err := storageInstance.SetProtected([]string{shortURL}); err != nil {
+			logger.Error("Failed to protect URL", zap.String("short_url", shortURL), zap.Error(err))
+			// The URL must not resolve without a token, so it's deleted instead
+			if err := storageInstance.DeleteURLs([]string{shortURL}, userID); err != nil {
+				logger.Error("Failed to delete unprotected URL", zap.String("short_url", shortURL), zap.Error(err))
 			}
+			httpError(w, "Failed to save URL mapping", storageErrorStatus(err))
 			return
 		}
-		http.Error(w, "Failed to save URL mapping", http.StatusInternalServerError)
-		return
 	}
+	recordContentType(cfg, []string{shortURL}, storage.ContentTypeJSON)
+	recordCreatorIP(cfg, r, []string{shortURL})
+	recordMetadata(cfg, shortURL, req.OriginalURL)
+	idempotency.save(shortURL, http.StatusCreated)
 
-	if cfg.FileStorage != "" {
+	if cfg.FileStorage != "" && !req.Protected {
 		if err := storage.SaveSingleURLMapping(cfg.FileStorage, shortURL, req.OriginalURL); err != nil {
-			log.Printf("Warning: Failed to save URL mapping to file: %v", err)
+			logger.Warn("Failed to save URL mapping to file", zap.Error(err))
 		}
 	}
 
-	resp := ShortenResponse{
-		ShortURL: fmt.Sprintf("%s/%s", cfg.BaseURL, shortURL),
-	}
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
-		return
-	}
+	write(http.StatusCreated, shortURL)
 }
 
 // HandleGet handles GET /{id} requests for redirecting to the original URL.
 // Looks up the original URL by short identifier and performs HTTP redirect.
+// HEAD requests get the same status code and Location header without a body.
+// With HTML redirects enabled (see InitHTMLRedirects), browsers get a redirect
+// page instead of a 307.
+// Browsers may get a custom page or redirect for unknown URLs, see InitNotFound.
+// Protected URLs only resolve with a valid signed token, see InitSignedLinks.
 //
-// HTTP methods: GET
+// HTTP methods: GET, HEAD
 // URL parameters: id - short URL identifier
+// Query parameters: token - signed token of a protected URL
 // Response: HTTP redirect (307 Temporary Redirect)
 //
 // Response codes:
+//   - 200: HTML redirect page to original URL
+//   - 302: Redirect of browsers to the configured not-found landing page
 //   - 307: Successful redirect to original URL
 //   - 400: Invalid request method
+//   - 401: Protected URL requested without a token
+//   - 403: Invalid or expired token of a protected URL
 //   - 404: URL not found
 //   - 410: URL was deleted
+//   - 503: Storage temporarily unavailable (circuit breaker open)
 func HandleGet(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Invalid request method", http.StatusBadRequest)
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		httpError(w, "Invalid request method", http.StatusBadRequest)
 		return
 	}
 
 	id := chi.URLParam(r, "id")
-	originalURL, exists, isDeleted := storageInstance.GetURL(id)
+	originalURL, exists, isDeleted, protected := lookupURL(id)
 
 	if !exists {
-		http.Error(w, "URL not found", http.StatusNotFound)
+		if err := storage.Available(storageInstance); err != nil {
+			countRedirect(http.StatusServiceUnavailable)
+			writeError(w, r, "Storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		writeNotFound(w, r, id)
 		return
 	}
 
+	// Checked before deletion, so that protected URLs reveal nothing without a token
+	if protected {
+		if err := verifyLinkToken(id, r.URL.Query().Get("token")); err != nil {
+			message, status := linkTokenError(err)
+			countRedirect(status)
+			writeError(w, r, message, status)
+			return
+		}
+	}
+
 	if isDeleted {
-		http.Error(w, "URL has been deleted", http.StatusGone)
+		countRedirect(http.StatusGone)
+		writeError(w, r, "URL has been deleted", http.StatusGone)
+		return
+	}
+
+	if wantsHTMLRedirect(r, originalURL) {
+		countRedirect(http.StatusOK)
+		writeHTMLRedirect(w, originalURL)
 		return
 	}
 
+	countRedirect(http.StatusTemporaryRedirect)
 	w.Header().Set("Location", originalURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
 }
 
+// countRedirect counts a HandleGet outcome in metrics.RedirectsTotal.
+func countRedirect(status int) {
+	metrics.RedirectsTotal.WithLabelValues(strconv.Itoa(status)).Inc()
+}
+
+// HandleExpand handles GET /api/expand/{id} requests for resolving a short URL
+// without following the redirect. Like HandleGet, it requires the signed token
+// of protected URLs.
+//
+// HTTP methods: GET
+// URL parameters: id - short URL identifier
+// Query parameters: token - signed token of a protected URL
+// Response: application/json with ExpandResponse object
+//
+// Response codes:
+//   - 200: URL found
+//   - 401: Protected URL requested without a token
+//   - 403: Invalid or expired token of a protected URL
+//   - 404: URL not found
+//   - 410: URL was deleted (the body is still returned with deleted set to true)
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleExpand(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	originalURL, exists, isDeleted, protected := lookupURL(id)
+	if !exists {
+		if err := storage.Available(storageInstance); err != nil {
+			httpError(w, "Storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		httpError(w, "URL not found", http.StatusNotFound)
+		return
+	}
+	if protected {
+		if err := verifyLinkToken(id, r.URL.Query().Get("token")); err != nil {
+			message, status := linkTokenError(err)
+			httpError(w, message, status)
+			return
+		}
+	}
+
+	status := http.StatusOK
+	if isDeleted {
+		status = http.StatusGone
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(ExpandResponse{OriginalURL: originalURL, Deleted: isDeleted}); err != nil {
+		httpError(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// HandleGetQR returns a handler rendering a QR code that encodes the short URL.
+// Like HandleGet, it requires the signed token of protected URLs, which the
+// encoded short URL then includes.
+//
+// HTTP methods: GET
+// URL parameters: id - short URL identifier
+// Query parameters: size - image width and height in pixels (64-1024, default 256),
+// token - signed token of a protected URL
+// Response: image/png
+//
+// Response codes:
+//   - 200: QR code rendered
+//   - 400: Invalid size
+//   - 401: Protected URL requested without a token
+//   - 403: Invalid or expired token of a protected URL
+//   - 404: URL not found
+//   - 410: URL was deleted
+//   - 500: Failed to render QR code
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleGetQR(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		size := defaultQRSize
+		if sizeParam := r.URL.Query().Get("size"); sizeParam != "" {
+			var err error
+			size, err = strconv.Atoi(sizeParam)
+			if err != nil || size < minQRSize || size > maxQRSize {
+				httpError(w, fmt.Sprintf("Invalid size: must be between %d and %d", minQRSize, maxQRSize), http.StatusBadRequest)
+				return
+			}
+		}
+
+		id := chi.URLParam(r, "id")
+		_, exists, isDeleted, protected := lookupURL(id)
+		if !exists {
+			if err := storage.Available(storageInstance); err != nil {
+				httpError(w, "Storage unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			httpError(w, "URL not found", http.StatusNotFound)
+			return
+		}
+		link := shortLink(cfg, id)
+		if protected {
+			token := r.URL.Query().Get("token")
+			if err := verifyLinkToken(id, token); err != nil {
+				message, status := linkTokenError(err)
+				httpError(w, message, status)
+				return
+			}
+			link += "?token=" + token
+		}
+		if isDeleted {
+			httpError(w, "URL has been deleted", http.StatusGone)
+			return
+		}
+
+		png, err := qrcode.Encode(link, qrcode.Medium, size)
+		if err != nil {
+			httpError(w, "Failed to render QR code", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.WriteHeader(http.StatusOK)
+		w.Write(png)
+	}
+}
+
+// HandleHealth returns a handler reporting service health, build information and uptime.
+// Uptime is counted from the moment the handler is created.
+// Unlike /ping, the response body describes each check.
+//
+// HTTP methods: GET
+// Response: application/json with HealthResponse object
+//
+// Response codes:
+//   - 200: All checks pass (status "ok")
+//   - 503: Storage ping failed (status "degraded")
+func HandleHealth(info BuildInfo) http.HandlerFunc {
+	started := time.Now()
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := HealthResponse{
+			Status:      HealthOK,
+			Storage:     HealthOK,
+			Version:     valueOrNA(info.Version),
+			BuildDate:   valueOrNA(info.Date),
+			BuildCommit: valueOrNA(info.Commit),
+			Uptime:      time.Since(started).Round(time.Second).String(),
+		}
+
+		status := http.StatusOK
+		if err := pingStorage(r.Context(), storageInstance); err != nil {
+			resp.Status = HealthDegraded
+			logger.Warn("Health check: storage ping failed", zap.Error(err))
+			resp.Storage = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpError(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// HandleVersion returns a handler reporting the running build.
+//
+// HTTP methods: GET
+// URL: /api/version
+// Response: application/json with VersionResponse object
+//
+// Response codes:
+//   - 200: Build information returned
+func HandleVersion(info BuildInfo) http.HandlerFunc {
+	resp := VersionResponse{
+		Version:     valueOrNA(info.Version),
+		BuildDate:   valueOrNA(info.Date),
+		BuildCommit: valueOrNA(info.Commit),
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			httpError(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// valueOrNA returns "N/A" for empty build values.
+func valueOrNA(value string) string {
+	if value == "" {
+		return "N/A"
+	}
+	return value
+}
+
 // HandlePing returns a handler for checking storage availability.
 // The endpoint is used for health checks and monitoring.
 //
@@ -263,23 +854,40 @@ func HandleGet(w http.ResponseWriter, r *http.Request) {
 // Response codes:
 //   - 200: Storage is available
 //   - 400: Invalid request method
-//   - 500: Storage is unavailable
+//   - 500: Storage is unavailable or didn't answer within pingTimeout
+//   - 503: Storage temporarily unavailable (circuit breaker open)
 func HandlePing(storageInstance storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			http.Error(w, "Invalid request method", http.StatusBadRequest)
+			httpError(w, "Invalid request method", http.StatusBadRequest)
 			return
 		}
-		if err := storageInstance.Ping(); err != nil {
-			http.Error(w, "Failed to ping storage", http.StatusInternalServerError)
+		if err := pingStorage(r.Context(), storageInstance); err != nil {
+			httpError(w, "Failed to ping storage", storageErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	}
 }
 
+// pingTimeout bounds how long health checks wait for the storage to answer a ping.
+var pingTimeout = 2 * time.Second
+
+// pingStorage pings s within pingTimeout of the request context, so an
+// unreachable database fails the check instead of hanging it.
+func pingStorage(ctx context.Context, s storage.Storage) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	return s.PingContext(ctx)
+}
+
 // HandleBatchShortenPost handles POST /api/shorten/batch requests for shortening multiple URLs at once.
 // Accepts an array of BatchRequest and returns an array of BatchResponse with shortened URLs.
+// Responses are always returned in the same order as the request items, so the i-th
+// response corresponds to the i-th request regardless of how the items are processed.
+// With cfg.DedupWithinBatch, repeated original URLs share one short URL; otherwise
+// batches repeating an original URL are rejected. With cfg.PartialBatches, items
+// the database fails to store are reported with an error while the others are stored.
 //
 // HTTP methods: POST
 // Content-Type: application/json
@@ -294,158 +902,862 @@ func HandlePing(storageInstance storage.Storage) http.HandlerFunc {
 //
 // Response codes:
 //   - 201: URLs successfully shortened
-//   - 400: Invalid request method, JSON, or empty array
+//   - 400: Invalid request method, JSON, empty array, repeated URL without deduplication,
+//     or non-HTTPS URL when HTTPS is required
 //   - 401: User not authorized
+//   - 429: User would hold more than the maximum number of URLs
 //   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+//   - 507: Storage holds the maximum number of URLs
 func HandleBatchShortenPost(cfg *config.Config, w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusBadRequest)
+		httpError(w, "Invalid request method", http.StatusBadRequest)
 		return
 	}
 	userID, ok := r.Context().Value(middleware.UserIDKey).(string)
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		httpError(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 	var batchRequests []BatchRequest
 	if err := json.NewDecoder(r.Body).Decode(&batchRequests); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		httpError(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	if len(batchRequests) == 0 {
-		http.Error(w, "Empty batch", http.StatusBadRequest)
+		httpError(w, "Empty batch", http.StatusBadRequest)
 		return
 	}
-
-	batchResponses := make([]BatchResponse, 0, len(batchRequests))
-
-	urlsToSave := make(map[string]string, len(batchRequests))
-
 	for _, req := range batchRequests {
-		shortURL := generateShortURL()
-		err := storageInstance.AddURL(shortURL, req.OriginalURL, userID)
-		if err != nil && err.Error() != "URL already exists" {
-			http.Error(w, "Failed to save URL mapping", http.StatusInternalServerError)
+		if err := validateTarget(cfg, req.OriginalURL); err != nil {
+			httpError(w, fmt.Sprintf("Correlation ID %s: %v", req.CorrelationID, err), http.StatusBadRequest)
 			return
 		}
-		batchResponses = append(batchResponses, BatchResponse{
-			CorrelationID: req.CorrelationID,
-			ShortURL:      fmt.Sprintf("%s/%s", cfg.BaseURL, shortURL),
-		})
-		urlsToSave[shortURL] = req.OriginalURL
 	}
 
-	if cfg.FileStorage != "" && len(urlsToSave) > 0 {
-		if err := storage.SaveURLMappings(cfg.FileStorage, urlsToSave); err != nil {
-			log.Printf("Warning: Failed to save URL mappings to file: %v", err)
-		}
+	// Resolve a short URL for every item first: already stored URLs reuse their
+	// existing short URL, and an original repeated within the batch shares one code,
+	// since original URLs (or their normalized forms) are unique in storage.
+	// Deleted URLs get a new code replacing their mapping, like in HandlePost.
+	shortURLs := make([]string, len(batchRequests))
+	assigned := make(map[string]string, len(batchRequests))
+	urlsToSave := make(map[string]string, len(batchRequests))
+	replacing := make(map[string]string)
+	var codes []string
+
+	for i, req := range batchRequests {
+		key := req.OriginalURL
+		if cfg.NormalizeURLs {
+			key = storage.NormalizeURL(key)
+		}
+		if shortURL, ok := assigned[key]; ok {
+			if !cfg.DedupWithinBatch {
+				for _, code := range codes {
+					releaseShortURL(code)
+				}
+				httpError(w, fmt.Sprintf("Duplicate original URL in batch: %s", req.OriginalURL), http.StatusBadRequest)
+				return
+			}
+			shortURLs[i] = shortURL
+			continue
+		}
+		shortURL, exists := storageInstance.GetShortURLByOriginalURL(req.OriginalURL)
+		isDeleted := false
+		if exists {
+			_, _, isDeleted = storageInstance.GetURL(shortURL)
+		}
+		if !exists || isDeleted {
+			code, err := nextShortURL(userID)
+			if err != nil {
+				for _, code := range codes {
+					releaseShortURL(code)
+				}
+				logger.Error("Failed to generate short URL", zap.Error(err))
+				httpError(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			codes = append(codes, code)
+			deletedShortURL := shortURL
+			shortURL = namespacedCode(userID, code)
+			urlsToSave[shortURL] = req.OriginalURL
+			if isDeleted {
+				replacing[shortURL] = deletedShortURL
+			}
+		}
+		assigned[key] = shortURL
+		shortURLs[i] = shortURL
+	}
+
+	var failed map[string]error
+	if len(urlsToSave) > 0 {
+		release, err := checkQuota(cfg, userID, len(urlsToSave))
+		if err == nil {
+			failed, err = storeURLs(urlsToSave, replacing, userID)
+			release()
+		}
+		for _, code := range codes {
+			releaseShortURL(code)
+		}
+		if err != nil {
+			httpError(w, "Failed to save URL mapping", storageErrorStatus(err))
+			return
+		}
+		for shortURL := range failed {
+			delete(urlsToSave, shortURL)
+		}
+		saved := make([]string, 0, len(urlsToSave))
+		for shortURL := range urlsToSave {
+			saved = append(saved, shortURL)
+		}
+		recordContentType(cfg, saved, storage.ContentTypeJSON)
+		recordCreatorIP(cfg, r, saved)
+	}
+
+	// Responses are placed by input index to guarantee the output order
+	// matches the request order.
+	batchResponses := make([]BatchResponse, len(batchRequests))
+	for i, req := range batchRequests {
+		if err, ok := failed[shortURLs[i]]; ok {
+			logger.Error("Failed to save URL of batch item",
+				zap.String("correlation_id", req.CorrelationID), zap.Error(err))
+			batchResponses[i] = BatchResponse{CorrelationID: req.CorrelationID, Error: batchItemError(err)}
+			continue
+		}
+		batchResponses[i] = BatchResponse{
+			CorrelationID: req.CorrelationID,
+			ShortURL:      shortLink(cfg, shortURLs[i]),
+		}
+	}
+
+	if cfg.FileStorage != "" && len(urlsToSave) > 0 {
+		if err := storage.SaveURLMappings(cfg.FileStorage, urlsToSave); err != nil {
+			logger.Warn("Failed to save URL mappings to file", zap.Error(err))
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	if err := json.NewEncoder(w).Encode(batchResponses); err != nil {
-		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		httpError(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
 
+// batchItemError describes why a batch item failed to be stored, without
+// exposing storage details.
+func batchItemError(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrURLExists):
+		return "URL already exists"
+	case errors.Is(err, storage.ErrShortURLExists):
+		return "Short URL already exists"
+	default:
+		return "Failed to save URL mapping"
+	}
+}
+
 // HandleGetUserURLs returns a handler for getting all URLs created by the authenticated user.
 // Requires user authentication via JWT token in cookies.
+// URLs are returned in the order they were created, oldest first by default.
 //
 // HTTP methods: GET
+// Query parameters:
+//   - limit: maximum number of URLs to return (optional, all by default)
+//   - offset: number of URLs to skip (optional, 0 by default)
+//   - sort: "oldest" or "newest" first (optional, "oldest" by default)
+//
 // Content-Type: application/json
-// Response: JSON array of user's URLs with short_url and original_url fields
+// Response: JSON array of UserURLResponse objects; is_deleted is omitted when false
+// unless cfg.VerboseJSON is enabled. content_type (text, json or form) is only
+// listed when cfg.RecordContentType is enabled, title and favicon_url once fetched
+// with cfg.FetchMetadata enabled
 //
 // Response codes:
 //   - 200: URLs successfully retrieved
-//   - 204: User has no URLs
+//   - 204: User has no URLs in the requested page
+//   - 400: Invalid limit, offset or sort
 //   - 401: User not authenticated
 //   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
 func HandleGetUserURLs(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, ok := r.Context().Value(middleware.UserIDKey).(string)
 		if !ok || userID == "" {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			httpError(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
-		urls, err := storageInstance.GetURLsByUser(userID)
+		page, err := parsePage(r)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		urls, err := storageInstance.GetUserURLs(userID, page)
+		if err != nil {
+			httpError(w, "Internal server error", storageErrorStatus(err))
 			return
 		}
 		if len(urls) == 0 {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		response := make([]struct {
-			ShortURL    string `json:"short_url"`
-			OriginalURL string `json:"original_url"`
-		}, 0)
-		for short, original := range urls {
-			response = append(response, struct {
-				ShortURL    string `json:"short_url"`
-				OriginalURL string `json:"original_url"`
-			}{
-				ShortURL:    fmt.Sprintf("%s/%s", cfg.BaseURL, short),
-				OriginalURL: original,
-			})
+		response := make([]UserURLResponse, 0, len(urls))
+		for _, u := range urls {
+			resp := UserURLResponse{
+				ShortURL:    shortLink(cfg, u.ShortURL),
+				OriginalURL: u.OriginalURL,
+				IsDeleted:   u.IsDeleted,
+				CreatedAt:   u.CreatedAt.UTC(),
+				Title:       u.Title,
+				FaviconURL:  u.FaviconURL,
+			}
+			if u.IsDeleted && !u.DeletedAt.IsZero() {
+				deletedAt := u.DeletedAt.UTC()
+				resp.DeletedAt = &deletedAt
+			}
+			if cfg.RecordContentType {
+				resp.ContentType = u.ContentType
+			}
+			response = append(response, resp)
 		}
+
+		var body interface{} = response
+		if cfg.VerboseJSON {
+			verbose := make([]verboseUserURLResponse, len(response))
+			for i, resp := range response {
+				verbose[i] = verboseUserURLResponse(resp)
+			}
+			body = verbose
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			httpError(w, "Failed to encode response", http.StatusInternalServerError)
 		}
 	}
 }
 
 // HandleDeleteUserURLs returns a handler for asynchronously deleting specified URLs.
 // Accepts a JSON array of short URL IDs and marks them for deletion.
+// Only URLs owned by the authenticated user are deleted; other IDs are ignored.
 //
 // HTTP methods: DELETE
 // Content-Type: application/json
 // Request body: JSON array of short URL strings
+// Response: application/json with DeleteResponse object echoing the submitted IDs,
+// including those of other users that are ignored
 //
 // Response codes:
-//   - 202: Deletion request accepted (async operation)
-//   - 400: Invalid request method or JSON body
+//   - 202: Deletion request accepted (async operation, see WaitForDeletes)
+//   - 400: Invalid request method, JSON body or more than cfg.MaxDeleteBatch URLs
+//   - 401: User not authenticated
 func HandleDeleteUserURLs(cfg *config.Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodDelete {
-			http.Error(w, "Invalid request method", http.StatusBadRequest)
+			httpError(w, "Invalid request method", http.StatusBadRequest)
 			return
 		}
 
 		var shortURLs []string
 		if err := json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			httpError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if cfg.MaxDeleteBatch > 0 && len(shortURLs) > cfg.MaxDeleteBatch {
+			httpError(w, fmt.Sprintf("Too many URLs: at most %d can be deleted at once", cfg.MaxDeleteBatch), http.StatusBadRequest)
 			return
 		}
 
-		deleteChan := make(chan error)
+		userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+		if !ok || userID == "" {
+			httpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
 
+		pendingDeletes.Add(1)
+		enqueueDelete()
 		go func() {
-			err := storageInstance.DeleteURLs(shortURLs, "")
-			deleteChan <- err
+			defer pendingDeletes.Done()
+			defer finishDelete()
+			if err := storageInstance.DeleteURLs(shortURLs, userID); err != nil {
+				logger.Error("Failed to delete URLs", zap.String("user_id", userID), zap.Error(err))
+			} else {
+				logger.Debug("URLs deleted", zap.String("user_id", userID), zap.Int("count", len(shortURLs)))
+			}
 		}()
 
+		resp := DeleteResponse{Accepted: shortURLs, Count: len(shortURLs)}
+		if resp.Accepted == nil {
+			resp.Accepted = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logger.Error("Failed to write delete response", zap.Error(err))
+		}
+	}
+}
 
-		go func() {
-			err := <-deleteChan
-			if err != nil {
-				log.Printf("Failed to delete URLs: %v", err)
-			} else {
-				log.Println("URLs deleted successfully")
+// HandleRestoreUserURLs returns a handler for restoring deleted URLs.
+// Accepts a JSON array of short URL IDs and clears their deleted flag, so that
+// they redirect again. Only URLs owned by the authenticated user are restored;
+// other IDs are ignored. Deletions accepted before are applied first.
+//
+// HTTP methods: POST
+// Content-Type: application/json
+// Request body: JSON array of short URL strings
+//
+// Response codes:
+//   - 204: URLs restored
+//   - 400: Invalid request method or JSON body
+//   - 401: User not authenticated
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleRestoreUserURLs(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			httpError(w, "Invalid request method", http.StatusBadRequest)
+			return
+		}
+
+		var shortURLs []string
+		if err := json.NewDecoder(r.Body).Decode(&shortURLs); err != nil {
+			httpError(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+		if !ok || userID == "" {
+			httpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// A deletion still in flight would otherwise undo the restore; the wait
+		// only fails once the client is gone
+		if err := WaitForDeletes(r.Context()); err != nil {
+			return
+		}
+		if err := storageInstance.RestoreURLs(shortURLs, userID); err != nil {
+			logger.Error("Failed to restore URLs", zap.String("user_id", userID), zap.Error(err))
+			httpError(w, "Failed to restore URLs", storageErrorStatus(err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandlePurgeUser returns a handler for permanently removing all URLs of the
+// authenticated user. Unlike HandleDeleteUserURLs, the URLs aren't kept as
+// tombstones: their short URLs answer 404 afterwards and may be reused.
+// URLs of other users are never touched. With file storage, the file is
+// rewritten without the purged URLs, so that they aren't loaded again.
+//
+// HTTP methods: DELETE
+// Response: application/json with PurgeResponse object
+//
+// Response codes:
+//   - 200: URLs removed
+//   - 400: Invalid request method
+//   - 401: User not authenticated
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandlePurgeUser(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			httpError(w, "Invalid request method", http.StatusBadRequest)
+			return
+		}
+
+		userID, ok := r.Context().Value(middleware.UserIDKey).(string)
+		if !ok || userID == "" {
+			httpError(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		removed, err := storageInstance.PurgeUsers([]string{userID})
+		if err != nil {
+			logger.Error("Failed to purge user URLs", zap.String("user_id", userID), zap.Error(err))
+			httpError(w, "Failed to remove URLs", storageErrorStatus(err))
+			return
+		}
+		logger.Info("User URLs purged", zap.String("user_id", userID), zap.Int("count", removed))
+		if cfg.FileStorage != "" && removed > 0 {
+			// The URLs are gone from storage already; the file is rewritten again at shutdown
+			if _, err := storage.SaveAllURLs(cfg.FileStorage, storageInstance); err != nil {
+				logger.Error("Failed to remove purged URLs from file", zap.Error(err))
 			}
-		}()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(PurgeResponse{Removed: removed}); err != nil {
+			logger.Error("Failed to write purge response", zap.Error(err))
+		}
 	}
 }
 
-func generateShortURL() string {
-	b := make([]byte, 6)
-	_, err := rand.Read(b)
+// WaitForDeletes waits until all deletions accepted by HandleDeleteUserURLs are applied,
+// or returns the context error if ctx is done first. Call it after the HTTP server has
+// stopped accepting requests so that no deletions are dropped on shutdown.
+func WaitForDeletes(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pendingDeletes.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HandleGetStats handles GET /api/internal/stats requests for storage statistics.
+// Must be protected by TrustedSubnetMiddleware.
+//
+// HTTP methods: GET
+// Query parameters: window - optional duration (e.g. "24h") for counting URLs
+// created and deleted within that period
+// Response: application/json with StatsResponse object, including the redirect
+// outcomes counted since startup
+//
+// Response codes:
+//   - 200: Statistics successfully retrieved
+//   - 400: Invalid window duration
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleGetStats(w http.ResponseWriter, r *http.Request) {
+	var window time.Duration
+	if windowParam := r.URL.Query().Get("window"); windowParam != "" {
+		var err error
+		window, err = time.ParseDuration(windowParam)
+		if err != nil || window <= 0 {
+			httpError(w, "Invalid window duration", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stats, err := storageInstance.GetStats()
 	if err != nil {
-		log.Fatal(err)
+		httpError(w, "Failed to get stats", storageErrorStatus(err))
+		return
+	}
+
+	resp := StatsResponse{
+		URLs:      stats.URLs,
+		Deleted:   stats.DeletedURLs,
+		Users:     stats.Users,
+		Redirects: metrics.RedirectCounts(),
+	}
+
+	if window > 0 {
+		windowStats, err := storageInstance.GetWindowStats(time.Now().Add(-window))
+		if err != nil {
+			httpError(w, "Failed to get stats", storageErrorStatus(err))
+			return
+		}
+		resp.Window = &WindowStatsResponse{
+			Duration: window.String(),
+			Created:  windowStats.CreatedURLs,
+			Deleted:  windowStats.DeletedURLs,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		httpError(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// HandleGetURLDetails handles GET /api/internal/urls/{id} requests, looking up
+// who created a short URL, e.g. when investigating abuse. Deleted URLs are
+// reported as well. Must be protected by TrustedSubnetMiddleware.
+//
+// HTTP methods: GET
+// URL parameters: id - short URL identifier
+// Response: application/json with URLDetailsResponse object
+//
+// Response codes:
+//   - 200: URL found
+//   - 404: URL not found
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleGetURLDetails(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	info, exists, err := storageInstance.GetURLInfo(id)
+	if err != nil {
+		logger.Error("Failed to look up URL", zap.String("short_url", id), zap.Error(err))
+		httpError(w, "Internal server error", storageErrorStatus(err))
+		return
+	}
+	if !exists {
+		httpError(w, "URL not found", http.StatusNotFound)
+		return
+	}
+
+	resp := URLDetailsResponse{
+		ShortURL:      id,
+		OriginalURL:   info.OriginalURL,
+		UserID:        info.UserID,
+		CreatedAt:     info.CreatedAt.UTC(),
+		IsDeleted:     info.IsDeleted,
+		CreatorIPHash: info.CreatorIPHash,
+	}
+	if info.IsDeleted && !info.DeletedAt.IsZero() {
+		deletedAt := info.DeletedAt.UTC()
+		resp.DeletedAt = &deletedAt
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		httpError(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// HandleExport handles GET /api/internal/export requests, dumping complete records
+// of all stored URLs, including their owners and deletion flags, for backups and
+// migration between storages. Records are streamed as JSON Lines, one
+// storage.URLMapping object per line. Must be protected by TrustedSubnetMiddleware.
+//
+// HTTP methods: GET
+// Response: application/x-ndjson with storage.URLMapping objects
+//
+// Response codes:
+//   - 200: Records exported
+//   - 500: Internal server error
+//   - 503: Storage temporarily unavailable (circuit breaker open)
+func HandleExport(w http.ResponseWriter, r *http.Request) {
+	records, err := storageInstance.Export()
+	if err != nil {
+		logger.Error("Failed to export URLs", zap.Error(err))
+		httpError(w, "Internal server error", storageErrorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	for _, record := range records {
+		if err := encoder.Encode(record); err != nil {
+			logger.Error("Failed to write export", zap.Error(err))
+			return
+		}
+	}
+}
+
+// HandleFlush returns a handler for POST /api/internal/flush requests that rewrite the
+// configured file with all URLs from storage right away, e.g. before a backup.
+// Must be protected by TrustedSubnetMiddleware.
+//
+// HTTP methods: POST
+// Response: application/json with FlushResponse object
+//
+// Response codes:
+//   - 200: Mappings written to the file
+//   - 400: File storage is not configured
+//   - 500: Failed to write the file
+func HandleFlush(cfg *config.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if cfg.FileStorage == "" {
+			httpError(w, "File storage is not configured", http.StatusBadRequest)
+			return
+		}
+
+		written, err := storage.SaveAllURLs(cfg.FileStorage, storageInstance)
+		if err != nil {
+			logger.Error("Failed to flush URL mappings", zap.Error(err))
+			httpError(w, "Failed to flush URL mappings", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(FlushResponse{Written: written}); err != nil {
+			httpError(w, "Failed to encode response", http.StatusInternalServerError)
+		}
+	}
+}
+
+// parsePage reads the limit, offset and sort query parameters.
+func parsePage(r *http.Request) (storage.Page, error) {
+	var page storage.Page
+	query := r.URL.Query()
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return page, fmt.Errorf("invalid limit: must be a positive integer")
+		}
+		page.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return page, fmt.Errorf("invalid offset: must be a non-negative integer")
+		}
+		page.Offset = n
+	}
+	switch query.Get("sort") {
+	case "", "oldest":
+	case "newest":
+		page.NewestFirst = true
+	default:
+		return page, fmt.Errorf("invalid sort: must be oldest or newest")
+	}
+	return page, nil
+}
+
+// shortLink returns the full short URL of code: cfg.BaseURL followed by
+// cfg.PathPrefix, unless the base URL already ends with it.
+func shortLink(cfg *config.Config, code string) string {
+	base := cfg.BaseURL
+	if !strings.HasSuffix(base, cfg.PathPrefix) {
+		base += cfg.PathPrefix
+	}
+	return base + "/" + code
+}
+
+// validateTarget checks that originalURL may be shortened under the configured policy.
+// With cfg.RequireHTTPSTargets, only https:// URLs are accepted.
+func validateTarget(cfg *config.Config, originalURL string) error {
+	if !cfg.RequireHTTPSTargets {
+		return nil
+	}
+	u, err := url.Parse(originalURL)
+	if err != nil || !strings.EqualFold(u.Scheme, "https") || u.Host == "" {
+		return errors.New("only https:// URLs can be shortened")
+	}
+	return nil
+}
+
+// storageErrorStatus returns the HTTP status code for a storage error:
+// 503 while the storage circuit breaker is open, 507 when the storage is full,
+// 429 when the user's URL quota is exceeded, 500 otherwise.
+func storageErrorStatus(err error) int {
+	if errors.Is(err, storage.ErrCircuitOpen) {
+		return http.StatusServiceUnavailable
+	}
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		return http.StatusTooManyRequests
+	}
+	if errors.Is(err, storage.ErrStorageFull) {
+		return http.StatusInsufficientStorage
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError replies with an error message, or with the status code alone for HEAD requests.
+func writeError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(code)
+		return
+	}
+	httpError(w, message, code)
+}
+
+// recordContentType records the content type shortURLs were submitted with
+// if cfg.RecordContentType is enabled. Failures are logged, as the URLs are already stored.
+func recordContentType(cfg *config.Config, shortURLs []string, contentType string) {
+	if !cfg.RecordContentType {
+		return
+	}
+	if err := storageInstance.SetContentType(shortURLs, contentType); err != nil {
+		logger.Warn("Failed to record content type", zap.Error(err))
+	}
+}
+
+// quotaLocks holds the locks of users whose quota is being checked, see lockQuota.
+var quotaLocks = struct {
+	sync.Mutex
+	users map[string]*quotaLock
+}{users: make(map[string]*quotaLock)}
+
+// quotaLock serializes the quota checks of a user. refs counts the requests
+// holding or waiting for it, so that it's dropped once none are left.
+type quotaLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockQuota locks the quota of the user and returns the function unlocking it.
+// Each user has their own lock, so users never wait for each other.
+func lockQuota(userID string) func() {
+	quotaLocks.Lock()
+	l, ok := quotaLocks.users[userID]
+	if !ok {
+		l = &quotaLock{}
+		quotaLocks.users[userID] = l
+	}
+	l.refs++
+	quotaLocks.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		quotaLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(quotaLocks.users, userID)
+		}
+		quotaLocks.Unlock()
+	}
+}
+
+// checkQuota returns storage.ErrQuotaExceeded if storing n more URLs would make
+// the user hold more than cfg.MaxURLsPerUser URLs that aren't deleted.
+// Otherwise it returns a function that callers must call as soon as the URLs are
+// stored: until then, other checks of the user wait, so that concurrent requests
+// can't all pass the check before any of them stores its URLs. The quota is only
+// enforced atomically within an instance; instances sharing a database may each
+// let a request through at the limit.
+func checkQuota(cfg *config.Config, userID string, n int) (func(), error) {
+	if cfg.MaxURLsPerUser == 0 || n == 0 {
+		return func() {}, nil
+	}
+	release := lockQuota(userID)
+	count, err := storageInstance.CountUserURLs(userID)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if count+n > cfg.MaxURLsPerUser {
+		release()
+		return nil, storage.ErrQuotaExceeded
+	}
+	return release, nil
+}
+
+// errExistingURLMissing is returned by createShortURL if the storage reports a
+// URL as already stored but the existing mapping can't be found.
+var errExistingURLMissing = errors.New("failed to get existing short URL")
+
+// createShortURL stores originalURL for the user within their quota and returns
+// its short URL. If the URL is already stored and not deleted, it returns the
+// existing short URL and exists is true; that doesn't count against the quota,
+// since no mapping is created. A deleted mapping is replaced rather than
+// returned as a dead link.
+func createShortURL(cfg *config.Config, originalURL, userID string) (shortURL string, exists bool, err error) {
+	release, err := checkQuota(cfg, userID, 1)
+	if errors.Is(err, storage.ErrQuotaExceeded) {
+		if existingShortURL, ok := liveShortURL(originalURL); ok {
+			return existingShortURL, true, nil
+		}
+	}
+	if err != nil {
+		return "", false, err
+	}
+	shortURL, err = addURL(originalURL, userID)
+	if errors.Is(err, storage.ErrURLExists) {
+		existingShortURL, found := storageInstance.GetShortURLByOriginalURL(originalURL)
+		switch {
+		case !found:
+			err = errExistingURLMissing
+		case !isDeletedURL(existingShortURL):
+			release()
+			return existingShortURL, true, nil
+		default:
+			shortURL, err = replaceDeletedURL(existingShortURL, originalURL, userID)
+		}
+	}
+	release()
+	return shortURL, false, err
+}
+
+// liveShortURL returns the short URL of originalURL if it's stored and not deleted.
+func liveShortURL(originalURL string) (string, bool) {
+	shortURL, ok := storageInstance.GetShortURLByOriginalURL(originalURL)
+	if !ok || isDeletedURL(shortURL) {
+		return "", false
+	}
+	return shortURL, true
+}
+
+// isDeletedURL reports whether the stored short URL is deleted.
+func isDeletedURL(shortURL string) bool {
+	_, _, isDeleted := storageInstance.GetURL(shortURL)
+	return isDeleted
+}
+
+// maxShortURLAttempts limits how many codes are tried when generated short URLs collide.
+const maxShortURLAttempts = 3
+
+// addURL stores originalURL under a freshly generated short URL and returns it.
+// A new code is generated if the previous one is already taken.
+func addURL(originalURL, userID string) (string, error) {
+	return withNewShortURL(userID, func(shortURL string) error {
+		return storageInstance.AddURL(shortURL, originalURL, userID)
+	})
+}
+
+// replaceDeletedURL replaces the deleted mapping of originalURL stored under
+// deletedShortURL with a mapping under a freshly generated short URL and returns it.
+func replaceDeletedURL(deletedShortURL, originalURL, userID string) (string, error) {
+	return withNewShortURL(userID, func(shortURL string) error {
+		return storageInstance.ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID)
+	})
+}
+
+// storeURLs stores the mappings of urls owned by userID. New mappings are stored
+// in a single storage operation (one transaction for DBStorage), while those in
+// replacing replace the deleted mapping of their original URL stored under the
+// short URL they map to. Returns the short URLs that failed to be stored with
+// their errors, or an error if storing failed as a whole.
+func storeURLs(urls, replacing map[string]string, userID string) (map[string]error, error) {
+	failed := make(map[string]error)
+	added := make(map[string]string, len(urls))
+	for shortURL, originalURL := range urls {
+		if _, ok := replacing[shortURL]; !ok {
+			added[shortURL] = originalURL
+		}
+	}
+	if len(added) > 0 {
+		err := storageInstance.AddURLs(added, userID)
+		var batchErr *storage.BatchError
+		if errors.As(err, &batchErr) {
+			maps.Copy(failed, batchErr.Failed)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for shortURL, deletedShortURL := range replacing {
+		if err := storageInstance.ReplaceDeletedURL(deletedShortURL, shortURL, urls[shortURL], userID); err != nil {
+			failed[shortURL] = err
+		}
+	}
+	return failed, nil
+}
+
+// withNewShortURL calls store with freshly generated short URLs of the user until
+// one is not taken, up to maxShortURLAttempts times, and returns the stored one.
+func withNewShortURL(userID string, store func(shortURL string) error) (string, error) {
+	var err error
+	for i := 0; i < maxShortURLAttempts; i++ {
+		code, genErr := nextShortURL(userID)
+		if genErr != nil {
+			return "", genErr
+		}
+		shortURL := namespacedCode(userID, code)
+		err = store(shortURL)
+		releaseShortURL(code)
+		if !errors.Is(err, storage.ErrShortURLExists) {
+			return shortURL, err
+		}
+	}
+	return "", err
+}
+
+// randRead fills short codes with secure random bytes; replaced in tests.
+var randRead = rand.Read
+
+// generateShortURL returns a random 6-character short code. It fails only if
+// the system's secure random source does.
+func generateShortURL() (string, error) {
+	b := make([]byte, 6)
+	if _, err := randRead(b); err != nil {
+		return "", fmt.Errorf("failed to generate short URL: %w", err)
 	}
-	return base64.URLEncoding.EncodeToString(b)[:6]
+	return base64.URLEncoding.EncodeToString(b)[:6], nil
 }
@@ -1,13 +1,25 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
 	"github.com/achufistov/shortygopher.git/internal/app/middleware"
 	"github.com/achufistov/shortygopher.git/internal/app/storage"
 	"github.com/achufistov/shortygopher.git/tests/testutils"
@@ -15,8 +27,11 @@ import (
 )
 
 func TestGenerateShortURL(t *testing.T) {
-	shortURL1 := generateShortURL()
-	shortURL2 := generateShortURL()
+	shortURL1, err := generateShortURL()
+	if err != nil {
+		t.Fatalf("generateShortURL() returned error: %v", err)
+	}
+	shortURL2, _ := generateShortURL()
 
 	// Check that URLs are generated
 	if shortURL1 == "" {
@@ -48,6 +63,40 @@ func TestGenerateShortURL(t *testing.T) {
 	}
 }
 
+func TestGenerateShortURL_RandomSourceFails(t *testing.T) {
+	defer func(read func([]byte) (int, error)) { randRead = read }(randRead)
+	randRead = func([]byte) (int, error) { return 0, errors.New("entropy unavailable") }
+
+	if _, err := generateShortURL(); err == nil {
+		t.Fatal("Expected an error when the random source fails")
+	}
+
+	// The failure is reported to the client instead of stopping the process
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	InitStorage(storage.NewURLStorage())
+	InitCodePool(0)
+	for _, tt := range []struct {
+		name    string
+		handler func(*config.Config, http.ResponseWriter, *http.Request)
+		target  string
+		body    string
+	}{
+		{"shorten", HandleShortenPost, "/api/shorten", `{"url":"https://example.com"}`},
+		{"batch", HandleBatchShortenPost, "/api/shorten/batch", `[{"correlation_id":"1","original_url":"https://example.com"}]`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
+			tt.handler(cfg, w, req)
+			if w.Code != http.StatusInternalServerError {
+				t.Errorf("Expected status 500, got %d", w.Code)
+			}
+		})
+	}
+}
+
 func TestInitStorage(t *testing.T) {
 	testStorage := storage.NewURLStorage()
 
@@ -135,6 +184,68 @@ func TestHandlePost_InvalidContentType(t *testing.T) {
 	}
 }
 
+func TestHandlePost_PostRedirectGet(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.PostRedirectGet = true
+	InitStorage(storage.NewURLStorage())
+
+	tests := []struct {
+		name           string
+		contentType    string
+		accept         string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "Browser form",
+			contentType:    "application/x-www-form-urlencoded",
+			accept:         "text/html,application/xhtml+xml",
+			body:           "url=https%3A%2F%2Fbrowser.example.com",
+			expectedStatus: http.StatusSeeOther,
+		},
+		{
+			name:           "API client",
+			contentType:    "text/plain",
+			accept:         "*/*",
+			body:           "https://api.example.com",
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "Form without HTML",
+			contentType:    "application/x-www-form-urlencoded",
+			accept:         "text/plain",
+			body:           "url=https%3A%2F%2Fscript.example.com",
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			req.Header.Set("Accept", tt.accept)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+
+			HandlePost(cfg, w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus == http.StatusSeeOther {
+				if location := w.Header().Get("Location"); !strings.HasPrefix(location, cfg.BaseURL+"/") {
+					t.Errorf("Expected Location to be the short URL, got %q", location)
+				}
+				return
+			}
+			if body := w.Body.String(); !strings.HasPrefix(body, cfg.BaseURL+"/") {
+				t.Errorf("Expected the short URL in the body, got %q", body)
+			}
+		})
+	}
+}
+
 func TestHandleShortenPost_Success(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
 	testStorage := storage.NewURLStorage()
@@ -171,6 +282,65 @@ func TestHandleShortenPost_Success(t *testing.T) {
 	}
 }
 
+func TestHandleShortenPost_DeprecationWarning(t *testing.T) {
+	tests := []struct {
+		name        string
+		handler     func(cfg *config.Config, w http.ResponseWriter, r *http.Request)
+		warnings    bool
+		wantWarning string
+	}{
+		{name: "legacy", handler: HandleShortenPost, warnings: true, wantWarning: legacyShortenWarning},
+		{name: "legacy without warnings", handler: HandleShortenPost, warnings: false},
+		{name: "v2", handler: HandleShortenV2Post, warnings: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.DeprecationWarnings = tt.warnings
+			InitStorage(storage.NewURLStorage())
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+			tt.handler(cfg, w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d", w.Code)
+			}
+			if warning := w.Header().Get("Warning"); warning != tt.wantWarning {
+				t.Errorf("Expected Warning %q, got %q", tt.wantWarning, warning)
+			}
+		})
+	}
+}
+
+func TestHandleShortenV2Post(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	InitStorage(storage.NewURLStorage())
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+	w := httptest.NewRecorder()
+	HandleShortenV2Post(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !strings.HasPrefix(response["short_url"], cfg.BaseURL+"/") {
+		t.Errorf("Expected short_url with base URL %s, got %v", cfg.BaseURL, response)
+	}
+	if _, ok := response["result"]; ok {
+		t.Error("Expected no legacy result field")
+	}
+}
+
 func TestHandleShortenPost_InvalidMethod(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
 
@@ -283,233 +453,1397 @@ func TestHandleGet_DeletedURL(t *testing.T) {
 	}
 }
 
-func TestHandleBatchShortenPost_Success(t *testing.T) {
-	cfg := testutils.CreateTestConfigWithDefaults(t)
+func TestHandleGet_Head(t *testing.T) {
 	testStorage := storage.NewURLStorage()
 	InitStorage(testStorage)
 
-	batchReq := []BatchRequest{
-		{CorrelationID: "1", OriginalURL: "https://example.com"},
-		{CorrelationID: "2", OriginalURL: "https://google.com"},
-	}
-	jsonData, _ := json.Marshal(batchReq)
-
-	req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
-	req.Header.Set("Content-Type", "application/json")
-	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
-	req = req.WithContext(ctx)
-	w := httptest.NewRecorder()
-
-	HandleBatchShortenPost(cfg, w, req)
+	testStorage.AddURL("test123", "https://example.com", "user1")
+	testStorage.AddURL("gone123", "https://gone.com", "user1")
+	testStorage.DeleteURLs([]string{"gone123"}, "user1")
 
-	if w.Code != http.StatusCreated {
-		t.Errorf("Expected status 201, got %d", w.Code)
+	r := chi.NewRouter()
+	r.Head("/{id}", HandleGet)
+
+	tests := []struct {
+		name         string
+		path         string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "existing URL", path: "/test123", wantCode: http.StatusTemporaryRedirect, wantLocation: "https://example.com"},
+		{name: "missing URL", path: "/nonexistent", wantCode: http.StatusNotFound},
+		{name: "deleted URL", path: "/gone123", wantCode: http.StatusGone},
 	}
 
-	var response []BatchResponse
-	err := json.NewDecoder(w.Body).Decode(&response)
-	if err != nil {
-		t.Fatalf("Failed to decode response: %v", err)
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodHead, tt.path, nil)
+			w := httptest.NewRecorder()
 
-	if len(response) != 2 {
-		t.Errorf("Expected 2 responses, got %d", len(response))
-	}
+			r.ServeHTTP(w, req)
 
-	for _, resp := range response {
-		if resp.CorrelationID == "" {
-			t.Error("Expected non-empty correlation ID")
-		}
-		if !strings.HasPrefix(resp.ShortURL, cfg.BaseURL) {
-			t.Errorf("Expected short URL to start with %s, got %s", cfg.BaseURL, resp.ShortURL)
-		}
+			if w.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Expected Location %q, got %q", tt.wantLocation, location)
+			}
+			if w.Body.Len() != 0 {
+				t.Errorf("Expected empty body, got %q", w.Body.String())
+			}
+		})
 	}
 }
 
-func TestHandleBatchShortenPost_EmptyBatch(t *testing.T) {
-	cfg := testutils.CreateTestConfigWithDefaults(t)
+func TestHandleExpand(t *testing.T) {
 	testStorage := storage.NewURLStorage()
 	InitStorage(testStorage)
 
-	emptyBatch := []BatchRequest{}
-	jsonData, _ := json.Marshal(emptyBatch)
-
-	req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
-	req.Header.Set("Content-Type", "application/json")
-	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
-	req = req.WithContext(ctx)
-	w := httptest.NewRecorder()
-
-	HandleBatchShortenPost(cfg, w, req)
+	testStorage.AddURL("test123", "https://example.com", "user1")
+	testStorage.AddURL("gone123", "https://gone.com", "user1")
+	testStorage.DeleteURLs([]string{"gone123"}, "user1")
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status 400, got %d", w.Code)
+	r := chi.NewRouter()
+	r.Get("/api/expand/{id}", HandleExpand)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantBody *ExpandResponse
+	}{
+		{
+			name:     "existing URL",
+			path:     "/api/expand/test123",
+			wantCode: http.StatusOK,
+			wantBody: &ExpandResponse{OriginalURL: "https://example.com"},
+		},
+		{
+			name:     "missing URL",
+			path:     "/api/expand/nonexistent",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "deleted URL",
+			path:     "/api/expand/gone123",
+			wantCode: http.StatusGone,
+			wantBody: &ExpandResponse{OriginalURL: "https://gone.com", Deleted: true},
+		},
 	}
-}
-
-func TestHandlePing_Success(t *testing.T) {
-	testStorage := storage.NewURLStorage()
-	InitStorage(testStorage)
-
-	handler := HandlePing(testStorage)
 
-	req := httptest.NewRequest("GET", "/ping", nil)
-	w := httptest.NewRecorder()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+			r.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+			if w.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if w.Header().Get("Location") != "" {
+				t.Error("Expected no redirect")
+			}
+			if tt.wantBody == nil {
+				return
+			}
+			var resp ExpandResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp != *tt.wantBody {
+				t.Errorf("Expected %+v, got %+v", *tt.wantBody, resp)
+			}
+		})
 	}
 }
 
-func TestHandlePing_InvalidMethod(t *testing.T) {
+func TestHandleGet_CountsRedirects(t *testing.T) {
 	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("test123", "https://example.com", "user1")
+	testStorage.AddURL("gone123", "https://gone.com", "user1")
+	testStorage.DeleteURLs([]string{"gone123"}, "user1")
 
-	handler := HandlePing(testStorage)
-
-	req := httptest.NewRequest("POST", "/ping", nil)
-	w := httptest.NewRecorder()
-
-	handler.ServeHTTP(w, req)
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status 400, got %d", w.Code)
+	tests := []struct {
+		path   string
+		status string
+	}{
+		{path: "/test123", status: "307"},
+		{path: "/nonexistent", status: "404"},
+		{path: "/gone123", status: "410"},
 	}
-
-	expectedBody := "Invalid request method\n"
-	if w.Body.String() != expectedBody {
-		t.Errorf("Expected body '%s', got '%s'", expectedBody, w.Body.String())
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			before := metrics.RedirectCounts()
+
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			after := metrics.RedirectCounts()
+			for status := range after {
+				want := before[status]
+				if status == tt.status {
+					want++
+				}
+				if after[status] != want {
+					t.Errorf("Expected %s counter to be %d, got %d", status, want, after[status])
+				}
+			}
+			if _, ok := after[tt.status]; !ok {
+				t.Errorf("Expected %s counter to be reported, got %v", tt.status, after)
+			}
+		})
 	}
 }
 
-func TestHandleGetUserURLs_Unauthorized(t *testing.T) {
+func TestHandleGetQR(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
 	testStorage := storage.NewURLStorage()
 	InitStorage(testStorage)
+	testStorage.AddURL("test123", "https://example.com", "user1")
 
-	handler := HandleGetUserURLs(cfg)
+	r := chi.NewRouter()
+	r.Get("/{id}/qr", HandleGetQR(cfg))
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+		wantSize int
+	}{
+		{name: "default size", path: "/test123/qr", wantCode: http.StatusOK, wantSize: defaultQRSize},
+		{name: "custom size", path: "/test123/qr?size=128", wantCode: http.StatusOK, wantSize: 128},
+		{name: "size too small", path: "/test123/qr?size=8", wantCode: http.StatusBadRequest},
+		{name: "size not a number", path: "/test123/qr?size=big", wantCode: http.StatusBadRequest},
+		{name: "unknown URL", path: "/nonexistent/qr", wantCode: http.StatusNotFound},
+	}
 
-	// Request without userID in context
-	req := httptest.NewRequest("GET", "/api/user/urls", nil)
-	w := httptest.NewRecorder()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+			r.ServeHTTP(w, req)
 
-	if w.Code != http.StatusUnauthorized {
-		t.Errorf("Expected status 401, got %d", w.Code)
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+				t.Errorf("Expected Content-Type image/png, got %s", ct)
+			}
+			img, err := png.Decode(bytes.NewReader(w.Body.Bytes()))
+			if err != nil {
+				t.Fatalf("Response is not a valid PNG: %v", err)
+			}
+			if width := img.Bounds().Dx(); width != tt.wantSize {
+				t.Errorf("Expected image width %d, got %d", tt.wantSize, width)
+			}
+		})
 	}
 }
 
-func TestHandleGetUserURLs_NoURLs(t *testing.T) {
+func TestHandlePost_NormalizedURLs(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
-	testStorage := storage.NewURLStorage()
-	InitStorage(testStorage)
+	cfg.FileStorage = ""
+	cfg.NormalizeURLs = true
+	InitStorage(storage.NewURLStorageWithOptions(storage.URLStorageOptions{NormalizeURLs: true}))
+
+	post := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(url))
+		req.Header.Set("Content-Type", "text/plain")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+		w := httptest.NewRecorder()
+		HandlePost(cfg, w, req)
+		return w
+	}
 
-	handler := HandleGetUserURLs(cfg)
+	first := post("https://Example.com/path/")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", first.Code)
+	}
+	id := strings.TrimPrefix(first.Body.String(), cfg.BaseURL+"/")
 
-	// Request with userID in context but no URLs
-	req := httptest.NewRequest("GET", "/api/user/urls", nil)
-	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
-	req = req.WithContext(ctx)
+	// The redirect goes to the URL exactly as submitted
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
 	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	if location := w.Header().Get("Location"); location != "https://Example.com/path/" {
+		t.Errorf("Expected Location to preserve the submitted URL, got %s", location)
+	}
 
-	handler.ServeHTTP(w, req)
-
-	if w.Code != http.StatusNoContent {
-		t.Errorf("Expected status 204, got %d", w.Code)
+	// A URL differing only in host case and trailing slash is a duplicate
+	second := post("https://example.com/path")
+	if second.Code != http.StatusConflict {
+		t.Errorf("Expected status 409, got %d", second.Code)
+	}
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("Expected existing short URL %s, got %s", first.Body.String(), second.Body.String())
 	}
 }
 
-func TestHandleGetUserURLs_WithURLs(t *testing.T) {
+func TestHandlers_CircuitOpen(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
-	testStorage := storage.NewURLStorage()
-	InitStorage(testStorage)
+	cfg.FileStorage = ""
+	backend := storage.NewURLStorage()
+	backend.AddURL("test123", "https://example.com", "user1")
+	breaker := storage.NewCircuitBreaker(failingPingStorage{backend}, storage.CircuitBreakerOptions{
+		Threshold:    1,
+		ResetTimeout: time.Hour,
+	})
+	InitStorage(breaker)
+
+	// A single failure opens the breaker
+	breaker.Ping()
 
-	// Add some URLs for the test user
-	userID := "test-user"
-	testStorage.AddURL("short1", "https://example.com", userID)
-	testStorage.AddURL("short2", "https://google.com", userID)
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, "user1")))
+		})
+	})
+	r.Get("/{id}", HandleGet)
+	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		HandleShortenPost(cfg, w, r)
+	})
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{name: "redirect", req: httptest.NewRequest(http.MethodGet, "/test123", nil)},
+		{name: "shorten", req: httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://new.com"}`))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, tt.req)
+			if w.Code != http.StatusServiceUnavailable {
+				t.Errorf("Expected status 503, got %d", w.Code)
+			}
+		})
+	}
+}
 
-	handler := HandleGetUserURLs(cfg)
+// failingPingStorage is a storage whose pings always fail.
+type failingPingStorage struct {
+	*storage.URLStorage
+}
 
-	req := httptest.NewRequest("GET", "/api/user/urls", nil)
-	ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
-	req = req.WithContext(ctx)
-	w := httptest.NewRecorder()
+func (s failingPingStorage) Ping() error {
+	return fmt.Errorf("connection refused")
+}
 
-	handler.ServeHTTP(w, req)
+func (s failingPingStorage) PingContext(ctx context.Context) error {
+	return s.Ping()
+}
 
-	if w.Code != http.StatusOK {
-		t.Errorf("Expected status 200, got %d", w.Code)
+func TestHandleVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		info BuildInfo
+		want VersionResponse
+	}{
+		{
+			name: "all values set",
+			info: BuildInfo{Version: "v1.2.0", Date: "2024-05-01", Commit: "abc1234"},
+			want: VersionResponse{Version: "v1.2.0", BuildDate: "2024-05-01", BuildCommit: "abc1234"},
+		},
+		{
+			name: "unset values",
+			info: BuildInfo{Version: "v1.2.0"},
+			want: VersionResponse{Version: "v1.2.0", BuildDate: "N/A", BuildCommit: "N/A"},
+		},
 	}
 
-	// Check Content-Type
-	expectedContentType := "application/json"
-	if contentType := w.Header().Get("Content-Type"); contentType != expectedContentType {
-		t.Errorf("Expected Content-Type '%s', got '%s'", expectedContentType, contentType)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			HandleVersion(tt.info).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/version", nil))
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %s", ct)
+			}
+			var resp VersionResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, resp)
+			}
+		})
 	}
+}
 
-	// Parse response
-	var response []struct {
-		ShortURL    string `json:"short_url"`
-		OriginalURL string `json:"original_url"`
+func TestHandlers_StorageFull(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	testStorage := storage.NewURLStorageWithOptions(storage.URLStorageOptions{MaxURLs: 2})
+	testStorage.AddURL("short1", "https://a.com", "user1")
+	testStorage.AddURL("short2", "https://b.com", "user1")
+	InitStorage(testStorage)
+
+	tests := []struct {
+		name    string
+		target  string
+		body    string
+		handler func(cfg *config.Config, w http.ResponseWriter, r *http.Request)
+	}{
+		{name: "post", target: "/", body: "https://c.com", handler: HandlePost},
+		{name: "shorten", target: "/api/shorten", body: `{"url":"https://c.com"}`, handler: HandleShortenPost},
+		{name: "batch", target: "/api/shorten/batch", body: `[{"correlation_id":"1","original_url":"https://c.com"}]`, handler: HandleBatchShortenPost},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.name == "post" {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
 
-	err := json.NewDecoder(w.Body).Decode(&response)
-	if err != nil {
-		t.Fatalf("Failed to decode response: %v", err)
+			tt.handler(cfg, w, req)
+
+			if w.Code != http.StatusInsufficientStorage {
+				t.Errorf("Expected status 507, got %d", w.Code)
+			}
+		})
 	}
+	if testStorage.Count() != 2 {
+		t.Errorf("Expected storage to stay at the cap, got %d URLs", testStorage.Count())
+	}
+}
 
-	if len(response) != 2 {
-		t.Errorf("Expected 2 URLs in response, got %d", len(response))
+func TestHandlers_MaxURLsPerUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		body     string
+		handler  func(cfg *config.Config, w http.ResponseWriter, r *http.Request)
+		held     int
+		wantCode int
+	}{
+		{name: "post below limit", target: "/", body: "https://c.com", handler: HandlePost, held: 1, wantCode: http.StatusCreated},
+		{name: "post at limit", target: "/", body: "https://c.com", handler: HandlePost, held: 2, wantCode: http.StatusTooManyRequests},
+		{name: "shorten at limit", target: "/api/shorten", body: `{"url":"https://c.com"}`, handler: HandleShortenPost, held: 2, wantCode: http.StatusTooManyRequests},
+		// Re-posting a stored URL creates no mapping, but replacing a deleted one does
+		{name: "post existing at limit", target: "/", body: "https://0.example.com", handler: HandlePost, held: 2, wantCode: http.StatusConflict},
+		{name: "shorten existing at limit", target: "/api/shorten", body: `{"url":"https://1.example.com"}`, handler: HandleShortenPost, held: 2, wantCode: http.StatusConflict},
+		{name: "post deleted at limit", target: "/", body: "https://deleted.example.com", handler: HandlePost, held: 2, wantCode: http.StatusTooManyRequests},
+		{name: "batch up to limit", target: "/api/shorten/batch", body: `[{"correlation_id":"1","original_url":"https://c.com"}]`, handler: HandleBatchShortenPost, held: 1, wantCode: http.StatusCreated},
+		{
+			name:     "batch above limit",
+			target:   "/api/shorten/batch",
+			body:     `[{"correlation_id":"1","original_url":"https://c.com"},{"correlation_id":"2","original_url":"https://d.com"}]`,
+			handler:  HandleBatchShortenPost,
+			held:     1,
+			wantCode: http.StatusTooManyRequests,
+		},
 	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			cfg.MaxURLsPerUser = 2
+			testStorage := storage.NewURLStorage()
+			for i := 0; i < tt.held; i++ {
+				testStorage.AddURL(fmt.Sprintf("short%d", i), fmt.Sprintf("https://%d.example.com", i), "user1")
+			}
+			// Deleted URLs and other users' URLs don't count
+			testStorage.AddURL("deleted", "https://deleted.example.com", "user1")
+			testStorage.DeleteURLs([]string{"deleted"}, "user1")
+			testStorage.AddURL("other", "https://other.example.com", "user2")
+			InitStorage(testStorage)
+
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(tt.body))
+			if tt.target == "/" {
+				req.Header.Set("Content-Type", "text/plain")
+			}
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
 
-	// Verify URLs are correct
-	foundShort1 := false
-	foundShort2 := false
+			tt.handler(cfg, w, req)
 
-	for _, item := range response {
-		switch item.OriginalURL {
-		case "https://example.com":
-			if item.ShortURL != "http://localhost:8080/short1" {
-				t.Errorf("Expected short URL 'http://localhost:8080/short1', got '%s'", item.ShortURL)
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantCode, w.Code, w.Body.String())
 			}
-			foundShort1 = true
-		case "https://google.com":
-			if item.ShortURL != "http://localhost:8080/short2" {
-				t.Errorf("Expected short URL 'http://localhost:8080/short2', got '%s'", item.ShortURL)
+			count, _ := testStorage.CountUserURLs("user1")
+			if tt.wantCode == http.StatusTooManyRequests && count != tt.held {
+				t.Errorf("Expected no URLs to be stored over the limit, got %d", count)
 			}
-			foundShort2 = true
-		}
+		})
 	}
+}
 
-	if !foundShort1 {
-		t.Error("Expected to find short1 URL in response")
-	}
-	if !foundShort2 {
-		t.Error("Expected to find short2 URL in response")
-	}
+// slowCountStorage is a storage whose CountUserURLs takes a while, widening the
+// window between a quota check and storing the URL.
+type slowCountStorage struct {
+	*storage.URLStorage
 }
 
-func TestHandleDeleteUserURLs_InvalidMethod(t *testing.T) {
-	cfg := testutils.CreateTestConfigWithDefaults(t)
+func (s slowCountStorage) CountUserURLs(userID string) (int, error) {
+	count, err := s.URLStorage.CountUserURLs(userID)
+	time.Sleep(5 * time.Millisecond)
+	return count, err
+}
 
-	handler := HandleDeleteUserURLs(cfg)
+func TestHandlers_MaxURLsPerUser_Concurrent(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.MaxURLsPerUser = 3
+	testStorage := storage.NewURLStorage()
+	InitStorage(slowCountStorage{testStorage})
+
+	const requests = 10
+	var created atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf("https://%d.example.com", i)))
+			req.Header.Set("Content-Type", "text/plain")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
+			HandlePost(cfg, w, req)
+			if w.Code == http.StatusCreated {
+				created.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
 
-	req := httptest.NewRequest("GET", "/api/user/urls", nil)
-	w := httptest.NewRecorder()
+	if got := created.Load(); got != 3 {
+		t.Errorf("Expected 3 URLs created up to the limit, got %d", got)
+	}
+	if count, _ := testStorage.CountUserURLs("user1"); count != 3 {
+		t.Errorf("Expected the user to hold 3 URLs, got %d", count)
+	}
+}
 
-	handler.ServeHTTP(w, req)
+// failingWriteStorage is a storage whose AddURL fails while down is set.
+type failingWriteStorage struct {
+	*storage.URLStorage
+	down *atomic.Bool
+}
 
-	if w.Code != http.StatusBadRequest {
-		t.Errorf("Expected status 400, got %d", w.Code)
+func (s failingWriteStorage) AddURL(shortURL, originalURL, userID string) error {
+	if s.down.Load() {
+		return fmt.Errorf("connection refused")
 	}
+	return s.URLStorage.AddURL(shortURL, originalURL, userID)
 }
 
-func TestHandleDeleteUserURLs_InvalidJSON(t *testing.T) {
+func TestHandleShortenPost_WriteFallback(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
-
+	cfg.FileStorage = ""
+	backend := storage.NewURLStorage()
+	down := &atomic.Bool{}
+	down.Store(true)
+	fallback, err := storage.NewWriteFallback(failingWriteStorage{backend, down}, storage.WriteFallbackOptions{
+		Path:           filepath.Join(t.TempDir(), "urls.wal"),
+		ReplayInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create write fallback: %v", err)
+	}
+	defer fallback.Close()
+	InitStorage(fallback)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 while the storage is down, got %d", w.Code)
+	}
+	var resp ShortenResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	shortURL := strings.TrimPrefix(resp.ShortURL, cfg.BaseURL+"/")
+
+	if _, exists, _ := backend.GetURL(shortURL); exists {
+		t.Fatal("Expected URL not to reach the storage while it is down")
+	}
+
+	// Once the storage recovers, the queued write is replayed
+	down.Store(false)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if originalURL, exists, _ := backend.GetURL(shortURL); exists {
+			if originalURL != "https://example.com" {
+				t.Errorf("Expected https://example.com, got %s", originalURL)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected queued URL to be persisted after recovery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHandleHealth(t *testing.T) {
+	tests := []struct {
+		name        string
+		storage     storage.Storage
+		wantCode    int
+		wantStatus  string
+		wantStorage string
+	}{
+		{
+			name:        "healthy storage",
+			storage:     storage.NewURLStorage(),
+			wantCode:    http.StatusOK,
+			wantStatus:  HealthOK,
+			wantStorage: HealthOK,
+		},
+		{
+			name:        "failing storage ping",
+			storage:     failingPingStorage{storage.NewURLStorage()},
+			wantCode:    http.StatusServiceUnavailable,
+			wantStatus:  HealthDegraded,
+			wantStorage: "unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InitStorage(tt.storage)
+			handler := HandleHealth(BuildInfo{Version: "v1.2.0", Commit: "abc1234"})
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			if w.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %s", ct)
+			}
+
+			var resp map[string]string
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			want := map[string]string{
+				"status":       tt.wantStatus,
+				"storage":      tt.wantStorage,
+				"version":      "v1.2.0",
+				"build_date":   "N/A",
+				"build_commit": "abc1234",
+			}
+			for field, value := range want {
+				if resp[field] != value {
+					t.Errorf("Expected %s %q, got %q", field, value, resp[field])
+				}
+			}
+			if _, err := time.ParseDuration(resp["uptime"]); err != nil {
+				t.Errorf("Expected uptime to be a duration, got %q", resp["uptime"])
+			}
+		})
+	}
+}
+
+func TestHandleShortenPost_RequireHTTPSTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		require  bool
+		url      string
+		wantCode int
+	}{
+		{name: "https accepted", require: true, url: "https://example.com", wantCode: http.StatusCreated},
+		{name: "http rejected", require: true, url: "http://example.com", wantCode: http.StatusBadRequest},
+		{name: "no scheme rejected", require: true, url: "example.com", wantCode: http.StatusBadRequest},
+		{name: "http accepted when not enforced", require: false, url: "http://example.com", wantCode: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			cfg.RequireHTTPSTargets = tt.require
+			testStorage := storage.NewURLStorage()
+			InitStorage(testStorage)
+
+			body := fmt.Sprintf(`{"url":%q}`, tt.url)
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+
+			HandleShortenPost(cfg, w, req)
+
+			if w.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode == http.StatusBadRequest && testStorage.Count() != 0 {
+				t.Error("Expected rejected URL not to be stored")
+			}
+		})
+	}
+}
+
+func TestHandleBatchShortenPost_RequireHTTPSTargets(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.RequireHTTPSTargets = true
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	body := `[
+		{"correlation_id": "1", "original_url": "https://example.com"},
+		{"correlation_id": "2", "original_url": "http://insecure.com"}
+	]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+	w := httptest.NewRecorder()
+
+	HandleBatchShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+	if testStorage.Count() != 0 {
+		t.Errorf("Expected nothing to be stored, got %d URLs", testStorage.Count())
+	}
+}
+
+func TestHandleBatchShortenPost_Success(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	batchReq := []BatchRequest{
+		{CorrelationID: "1", OriginalURL: "https://example.com"},
+		{CorrelationID: "2", OriginalURL: "https://google.com"},
+	}
+	jsonData, _ := json.Marshal(batchReq)
+
+	req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
+	req.Header.Set("Content-Type", "application/json")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	HandleBatchShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", w.Code)
+	}
+
+	var response []BatchResponse
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(response) != 2 {
+		t.Errorf("Expected 2 responses, got %d", len(response))
+	}
+
+	for _, resp := range response {
+		if resp.CorrelationID == "" {
+			t.Error("Expected non-empty correlation ID")
+		}
+		if !strings.HasPrefix(resp.ShortURL, cfg.BaseURL) {
+			t.Errorf("Expected short URL to start with %s, got %s", cfg.BaseURL, resp.ShortURL)
+		}
+	}
+}
+
+func TestHandleBatchShortenPost_PreservesInputOrder(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	const batches = 8
+	const itemsPerBatch = 50
+
+	var wg sync.WaitGroup
+	errs := make(chan string, batches*itemsPerBatch)
+
+	// Several batches are processed concurrently; each must come back in its own input order.
+	for b := 0; b < batches; b++ {
+		wg.Add(1)
+		go func(b int) {
+			defer wg.Done()
+
+			batchReq := make([]BatchRequest, itemsPerBatch)
+			for i := range batchReq {
+				// Correlation IDs are intentionally not sorted
+				batchReq[i] = BatchRequest{
+					CorrelationID: fmt.Sprintf("%d-%d", b, itemsPerBatch-i),
+					OriginalURL:   fmt.Sprintf("https://example.com/%d/%d", b, i),
+				}
+			}
+			jsonData, _ := json.Marshal(batchReq)
+
+			req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
+			req.Header.Set("Content-Type", "application/json")
+			ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
+			req = req.WithContext(ctx)
+			w := httptest.NewRecorder()
+
+			HandleBatchShortenPost(cfg, w, req)
+
+			var response []BatchResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				errs <- fmt.Sprintf("batch %d: failed to decode response: %v", b, err)
+				return
+			}
+			if len(response) != len(batchReq) {
+				errs <- fmt.Sprintf("batch %d: expected %d responses, got %d", b, len(batchReq), len(response))
+				return
+			}
+
+			for i, resp := range response {
+				if resp.CorrelationID != batchReq[i].CorrelationID {
+					errs <- fmt.Sprintf("batch %d: position %d has correlation ID %s, want %s",
+						b, i, resp.CorrelationID, batchReq[i].CorrelationID)
+				}
+				shortID := strings.TrimPrefix(resp.ShortURL, cfg.BaseURL+"/")
+				if originalURL, _, _ := testStorage.GetURL(shortID); originalURL != batchReq[i].OriginalURL {
+					errs <- fmt.Sprintf("batch %d: position %d resolves to %s, want %s",
+						b, i, originalURL, batchReq[i].OriginalURL)
+				}
+			}
+		}(b)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestHandleBatchShortenPost_DedupWithinBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		dedup    bool
+		wantCode int
+	}{
+		{name: "enabled", dedup: true, wantCode: http.StatusCreated},
+		{name: "disabled", dedup: false, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			cfg.DedupWithinBatch = tt.dedup
+			testStorage := storage.NewURLStorage()
+			InitStorage(testStorage)
+
+			body := `[
+				{"correlation_id": "a", "original_url": "https://example.com/same"},
+				{"correlation_id": "b", "original_url": "https://example.com/other"},
+				{"correlation_id": "c", "original_url": "https://example.com/same"}
+			]`
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+
+			HandleBatchShortenPost(cfg, w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusCreated {
+				if testStorage.Count() != 0 {
+					t.Errorf("Expected nothing to be stored, got %d URLs", testStorage.Count())
+				}
+				return
+			}
+
+			var response []BatchResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if response[0].ShortURL != response[2].ShortURL {
+				t.Errorf("Expected a and c to share a short URL, got %s and %s", response[0].ShortURL, response[2].ShortURL)
+			}
+			if response[0].ShortURL == response[1].ShortURL {
+				t.Error("Expected different URLs to get different short URLs")
+			}
+			if testStorage.Count() != 2 {
+				t.Errorf("Expected 2 stored URLs, got %d", testStorage.Count())
+			}
+		})
+	}
+}
+
+func TestHandleBatchShortenPost_ExistingURL(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	testStorage.AddURL("exist1", "https://example.com", "other-user")
+
+	batchReq := []BatchRequest{
+		{CorrelationID: "1", OriginalURL: "https://example.com"},
+		{CorrelationID: "2", OriginalURL: "https://google.com"},
+	}
+	jsonData, _ := json.Marshal(batchReq)
+
+	req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
+	req.Header.Set("Content-Type", "application/json")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	HandleBatchShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+
+	var response []BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(response) != 2 {
+		t.Fatalf("Expected 2 responses, got %d", len(response))
+	}
+
+	if response[0].ShortURL != cfg.BaseURL+"/exist1" {
+		t.Errorf("Expected existing short URL %s/exist1, got %s", cfg.BaseURL, response[0].ShortURL)
+	}
+
+	newShortID := strings.TrimPrefix(response[1].ShortURL, cfg.BaseURL+"/")
+	if originalURL, exists, _ := testStorage.GetURL(newShortID); !exists || originalURL != "https://google.com" {
+		t.Errorf("Expected new short URL to resolve to https://google.com, got %q (exists=%v)", originalURL, exists)
+	}
+	if testStorage.Count() != 2 {
+		t.Errorf("Expected 2 stored URLs, got %d", testStorage.Count())
+	}
+}
+
+func TestHandleBatchShortenPost_DeletedURL(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	shorten := func() string {
+		t.Helper()
+		body := `[{"correlation_id":"1","original_url":"https://example.com"}]`
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		HandleBatchShortenPost(cfg, w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+		var response []BatchResponse
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response) != 1 || response[0].Error != "" {
+			t.Fatalf("Expected a short URL, got %+v", response)
+		}
+		return strings.TrimPrefix(response[0].ShortURL, cfg.BaseURL+"/")
+	}
+
+	deleted := shorten()
+	if err := testStorage.DeleteURLs([]string{deleted}, "user1"); err != nil {
+		t.Fatalf("Failed to delete URL: %v", err)
+	}
+
+	// The deleted mapping is replaced rather than returned as a dead link
+	shortURL := shorten()
+	if shortURL == deleted {
+		t.Fatalf("Expected a new short URL instead of the deleted %s", deleted)
+	}
+	if originalURL, exists, isDeleted := testStorage.GetURL(shortURL); !exists || isDeleted || originalURL != "https://example.com" {
+		t.Errorf("Expected %s to resolve to https://example.com, got %q (exists: %v, deleted: %v)", shortURL, originalURL, exists, isDeleted)
+	}
+	if _, exists, _ := testStorage.GetURL(deleted); exists {
+		t.Errorf("Expected the deleted mapping %s to be replaced", deleted)
+	}
+}
+
+// partialBatchStorage is a storage storing batches partially like DBStorage
+// with PartialBatches enabled: URLs on the rejected list fail, the others are stored.
+type partialBatchStorage struct {
+	*storage.URLStorage
+	rejected map[string]bool
+}
+
+func (s partialBatchStorage) AddURLs(urls map[string]string, userID string) error {
+	failed := make(map[string]error)
+	valid := make(map[string]string)
+	for shortURL, originalURL := range urls {
+		if s.rejected[originalURL] {
+			failed[shortURL] = fmt.Errorf("invalid byte sequence")
+			continue
+		}
+		valid[shortURL] = originalURL
+	}
+	if err := s.URLStorage.AddURLs(valid, userID); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return &storage.BatchError{Failed: failed}
+	}
+	return nil
+}
+
+func TestHandleBatchShortenPost_PartialBatch(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	backend := storage.NewURLStorage()
+	InitStorage(partialBatchStorage{backend, map[string]bool{"https://invalid.example.com": true}})
+
+	body := `[{"correlation_id":"1","original_url":"https://example.com"},
+		{"correlation_id":"2","original_url":"https://invalid.example.com"},
+		{"correlation_id":"3","original_url":"https://google.com"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleBatchShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	var responses []BatchResponse
+	if err := json.NewDecoder(w.Body).Decode(&responses); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("Expected 3 responses, got %d", len(responses))
+	}
+	for _, i := range []int{0, 2} {
+		if responses[i].ShortURL == "" || responses[i].Error != "" {
+			t.Errorf("Expected a short URL for correlation ID %s, got %+v", responses[i].CorrelationID, responses[i])
+		}
+	}
+	if failed := responses[1]; failed.CorrelationID != "2" || failed.ShortURL != "" || failed.Error != "Failed to save URL mapping" {
+		t.Errorf("Expected the invalid URL to be reported, got %+v", failed)
+	}
+	if count := backend.Count(); count != 2 {
+		t.Errorf("Expected the 2 valid URLs to be stored, got %d", count)
+	}
+}
+
+func TestHandleBatchShortenPost_EmptyBatch(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	emptyBatch := []BatchRequest{}
+	jsonData, _ := json.Marshal(emptyBatch)
+
+	req := httptest.NewRequest("POST", "/api/shorten/batch", strings.NewReader(string(jsonData)))
+	req.Header.Set("Content-Type", "application/json")
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	HandleBatchShortenPost(cfg, w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandlePing_Success(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	handler := HandlePing(testStorage)
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+}
+
+// blockingPingStorage is a storage whose pings never answer until given up.
+type blockingPingStorage struct {
+	*storage.URLStorage
+}
+
+func (s blockingPingStorage) PingContext(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestHandlePing_Timeout(t *testing.T) {
+	defer func(timeout time.Duration) { pingTimeout = timeout }(pingTimeout)
+	pingTimeout = 50 * time.Millisecond
+
+	handler := HandlePing(blockingPingStorage{storage.NewURLStorage()})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+		done <- w
+	}()
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", w.Code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the ping to give up after the timeout")
+	}
+}
+
+func TestHandlePing_InvalidMethod(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+
+	handler := HandlePing(testStorage)
+
+	req := httptest.NewRequest("POST", "/ping", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected application/json, got %s", contentType)
+	}
+	var resp ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse error response: %v", err)
+	}
+	if resp.Error != "Invalid request method" || resp.Code != "bad_request" {
+		t.Errorf("Unexpected error response %+v", resp)
+	}
+}
+
+func TestHandleGetUserURLs_Unauthorized(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	handler := HandleGetUserURLs(cfg)
+
+	// Request without userID in context
+	req := httptest.NewRequest("GET", "/api/user/urls", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestHandleGetUserURLs_NoURLs(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	handler := HandleGetUserURLs(cfg)
+
+	// Request with userID in context but no URLs
+	req := httptest.NewRequest("GET", "/api/user/urls", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, "test-user")
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+}
+
+func TestHandleGetUserURLs_Pagination(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	for i := 1; i <= 5; i++ {
+		testStorage.AddURL(fmt.Sprintf("short%d", i), fmt.Sprintf("https://example.com/%d", i), "test-user")
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantShort []string
+	}{
+		{name: "first page", query: "?limit=2", wantCode: http.StatusOK, wantShort: []string{"short1", "short2"}},
+		{name: "second page", query: "?limit=2&offset=2", wantCode: http.StatusOK, wantShort: []string{"short3", "short4"}},
+		{name: "last partial page", query: "?limit=2&offset=4", wantCode: http.StatusOK, wantShort: []string{"short5"}},
+		{name: "out of range offset", query: "?limit=2&offset=10", wantCode: http.StatusNoContent},
+		{name: "invalid limit", query: "?limit=0", wantCode: http.StatusBadRequest},
+		{name: "invalid offset", query: "?offset=-1", wantCode: http.StatusBadRequest},
+		{name: "newest first", query: "?sort=newest&limit=2", wantCode: http.StatusOK, wantShort: []string{"short5", "short4"}},
+		{name: "invalid sort", query: "?sort=random", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls"+tt.query, nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+
+			HandleGetUserURLs(cfg).ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var response []UserURLResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(response) != len(tt.wantShort) {
+				t.Fatalf("Expected %d URLs, got %d", len(tt.wantShort), len(response))
+			}
+			for i, short := range tt.wantShort {
+				if want := cfg.BaseURL + "/" + short; response[i].ShortURL != want {
+					t.Errorf("Expected URL %d to be %s, got %s", i, want, response[i].ShortURL)
+				}
+				if response[i].CreatedAt.IsZero() {
+					t.Errorf("Expected URL %d to have a creation time", i)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGetUserURLs_OptionalFields(t *testing.T) {
+	tests := []struct {
+		name        string
+		verbose     bool
+		wantDeleted bool
+	}{
+		{name: "lean", verbose: false, wantDeleted: false},
+		{name: "verbose", verbose: true, wantDeleted: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.VerboseJSON = tt.verbose
+			testStorage := storage.NewURLStorage()
+			InitStorage(testStorage)
+			testStorage.AddURL("short1", "https://example.com", "test-user")
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+			w := httptest.NewRecorder()
+
+			HandleGetUserURLs(cfg).ServeHTTP(w, req)
+
+			var response []map[string]interface{}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(response) != 1 {
+				t.Fatalf("Expected 1 URL in response, got %d", len(response))
+			}
+			if _, ok := response[0]["is_deleted"]; ok != tt.wantDeleted {
+				t.Errorf("Expected is_deleted present = %v, got %v in %v", tt.wantDeleted, ok, response[0])
+			}
+			for _, field := range []string{"short_url", "original_url", "created_at"} {
+				if _, ok := response[0][field]; !ok {
+					t.Errorf("Expected %s to be present", field)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleGetUserURLs_DeletedAt(t *testing.T) {
+	for _, verbose := range []bool{false, true} {
+		cfg := testutils.CreateTestConfigWithDefaults(t)
+		cfg.VerboseJSON = verbose
+		testStorage := storage.NewURLStorage()
+		InitStorage(testStorage)
+		testStorage.AddURL("gone", "https://gone.com", "test-user")
+		testStorage.AddURL("live", "https://live.com", "test-user")
+		testStorage.DeleteURLs([]string{"gone"}, "test-user")
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+		w := httptest.NewRecorder()
+
+		HandleGetUserURLs(cfg).ServeHTTP(w, req)
+
+		var response []map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(response) != 2 {
+			t.Fatalf("Expected 2 URLs in response, got %d", len(response))
+		}
+		deletedAt, ok := response[0]["deleted_at"].(string)
+		if !ok {
+			t.Fatalf("Expected deleted_at for the deleted URL, got %v", response[0])
+		}
+		if _, err := time.Parse(time.RFC3339, deletedAt); err != nil {
+			t.Errorf("Expected an RFC 3339 deleted_at, got %q", deletedAt)
+		}
+		if value, present := response[1]["deleted_at"]; value != nil || present != verbose {
+			t.Errorf("Expected deleted_at of the live URL to be null (verbose: %v), got %v", verbose, response[1])
+		}
+	}
+}
+
+func TestHandleGetUserURLs_ContentType(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.RecordContentType = true
+	InitStorage(storage.NewURLStorage())
+
+	shorten := func(target, contentType, body string, handler func(*config.Config, http.ResponseWriter, *http.Request)) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+		w := httptest.NewRecorder()
+		handler(cfg, w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201 shortening %s, got %d", body, w.Code)
+		}
+	}
+	shorten("/", "text/plain", "https://text.example.com", HandlePost)
+	shorten("/api/shorten", "application/json", `{"url":"https://json.example.com"}`, HandleShortenPost)
+	shorten("/", "application/x-www-form-urlencoded", "url=https%3A%2F%2Fform.example.com", HandlePost)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+	w := httptest.NewRecorder()
+	HandleGetUserURLs(cfg).ServeHTTP(w, req)
+
+	var response []UserURLResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	got := make(map[string]string, len(response))
+	for _, item := range response {
+		got[item.OriginalURL] = item.ContentType
+	}
+	want := map[string]string{
+		"https://text.example.com": storage.ContentTypeText,
+		"https://json.example.com": storage.ContentTypeJSON,
+		"https://form.example.com": storage.ContentTypeForm,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected content types %v, got %v", want, got)
+	}
+}
+
+func TestHandleGetUserURLs_WithURLs(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	// Add some URLs for the test user
+	userID := "test-user"
+	testStorage.AddURL("short1", "https://example.com", userID)
+	testStorage.AddURL("short2", "https://google.com", userID)
+
+	handler := HandleGetUserURLs(cfg)
+
+	req := httptest.NewRequest("GET", "/api/user/urls", nil)
+	ctx := context.WithValue(req.Context(), middleware.UserIDKey, userID)
+	req = req.WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", w.Code)
+	}
+
+	// Check Content-Type
+	expectedContentType := "application/json"
+	if contentType := w.Header().Get("Content-Type"); contentType != expectedContentType {
+		t.Errorf("Expected Content-Type '%s', got '%s'", expectedContentType, contentType)
+	}
+
+	// Parse response
+	var response []struct {
+		ShortURL    string `json:"short_url"`
+		OriginalURL string `json:"original_url"`
+	}
+
+	err := json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if len(response) != 2 {
+		t.Errorf("Expected 2 URLs in response, got %d", len(response))
+	}
+
+	// Verify URLs are correct
+	foundShort1 := false
+	foundShort2 := false
+
+	for _, item := range response {
+		switch item.OriginalURL {
+		case "https://example.com":
+			if item.ShortURL != "http://localhost:8080/short1" {
+				t.Errorf("Expected short URL 'http://localhost:8080/short1', got '%s'", item.ShortURL)
+			}
+			foundShort1 = true
+		case "https://google.com":
+			if item.ShortURL != "http://localhost:8080/short2" {
+				t.Errorf("Expected short URL 'http://localhost:8080/short2', got '%s'", item.ShortURL)
+			}
+			foundShort2 = true
+		}
+	}
+
+	if !foundShort1 {
+		t.Error("Expected to find short1 URL in response")
+	}
+	if !foundShort2 {
+		t.Error("Expected to find short2 URL in response")
+	}
+}
+
+func TestHandleDeleteUserURLs_InvalidMethod(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+
+	handler := HandleDeleteUserURLs(cfg)
+
+	req := httptest.NewRequest("GET", "/api/user/urls", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteUserURLs_InvalidJSON(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+
 	handler := HandleDeleteUserURLs(cfg)
 
 	req := httptest.NewRequest("DELETE", "/api/user/urls", strings.NewReader("invalid json"))
@@ -526,6 +1860,8 @@ func TestHandleDeleteUserURLs_ValidRequest(t *testing.T) {
 	cfg := testutils.CreateTestConfigWithDefaults(t)
 	testStorage := storage.NewURLStorage()
 	InitStorage(testStorage)
+	testStorage.AddURL("short1", "https://example.com", "user1")
+	testStorage.AddURL("short2", "https://google.com", "user2")
 
 	handler := HandleDeleteUserURLs(cfg)
 
@@ -538,6 +1874,7 @@ func TestHandleDeleteUserURLs_ValidRequest(t *testing.T) {
 
 	req := httptest.NewRequest("DELETE", "/api/user/urls", strings.NewReader(string(jsonData)))
 	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
 	w := httptest.NewRecorder()
 
 	handler.ServeHTTP(w, req)
@@ -545,6 +1882,481 @@ func TestHandleDeleteUserURLs_ValidRequest(t *testing.T) {
 	if w.Code != http.StatusAccepted {
 		t.Errorf("Expected status 202, got %d", w.Code)
 	}
+	var resp DeleteResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(resp.Accepted, urlsToDelete) || resp.Count != len(urlsToDelete) {
+		t.Errorf("Expected the submitted IDs %v echoed, got %+v", urlsToDelete, resp)
+	}
+	if err := WaitForDeletes(context.Background()); err != nil {
+		t.Errorf("Expected pending deletes to finish, got %v", err)
+	}
+
+	// Only the requester's own URL is deleted
+	if _, _, isDeleted := testStorage.GetURL("short1"); !isDeleted {
+		t.Error("Expected short1 to be deleted by its owner")
+	}
+	if _, _, isDeleted := testStorage.GetURL("short2"); isDeleted {
+		t.Error("Expected short2 of another user to be kept")
+	}
+}
+
+func TestHandleDeleteUserURLs_Unauthorized(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["short1"]`))
+	w := httptest.NewRecorder()
+	HandleDeleteUserURLs(cfg).ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteUserURLs_MaxDeleteBatch(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.MaxDeleteBatch = 2
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("short1", "https://example.com", "user1")
+	testStorage.AddURL("short2", "https://google.com", "user1")
+	testStorage.AddURL("short3", "https://github.com", "user1")
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{name: "Over the limit", body: `["short1","short2","short3"]`, expectedStatus: http.StatusBadRequest},
+		{name: "At the limit", body: `["short1","short2"]`, expectedStatus: http.StatusAccepted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+			w := httptest.NewRecorder()
+			HandleDeleteUserURLs(cfg).ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if err := WaitForDeletes(context.Background()); err != nil {
+				t.Errorf("Expected pending deletes to finish, got %v", err)
+			}
+		})
+	}
+
+	// The rejected request queued no deletion
+	if _, _, isDeleted := testStorage.GetURL("short3"); isDeleted {
+		t.Error("Expected short3 to be kept")
+	}
+	if _, _, isDeleted := testStorage.GetURL("short1"); !isDeleted {
+		t.Error("Expected short1 to be deleted")
+	}
+}
+
+func TestHandleRestoreUserURLs(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("short1", "https://example.com", "user1")
+	testStorage.AddURL("short2", "https://google.com", "user2")
+
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
+	r.Delete("/api/user/urls", HandleDeleteUserURLs(cfg))
+	r.Post("/api/user/urls/restore", HandleRestoreUserURLs(cfg))
+
+	do := func(method, path, body, userID string) int {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	do(http.MethodDelete, "/api/user/urls", `["short1"]`, "user1")
+	do(http.MethodDelete, "/api/user/urls", `["short2"]`, "user2")
+	if err := WaitForDeletes(context.Background()); err != nil {
+		t.Fatalf("Expected pending deletes to finish, got %v", err)
+	}
+	if code := do(http.MethodGet, "/short1", "", "user1"); code != http.StatusGone {
+		t.Fatalf("Expected status 410 after deletion, got %d", code)
+	}
+
+	// Restoring is limited to the owner's URLs
+	if code := do(http.MethodPost, "/api/user/urls/restore", `["short1","short2"]`, "user1"); code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", code)
+	}
+	if code := do(http.MethodGet, "/short1", "", "user1"); code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected status 307 after restore, got %d", code)
+	}
+	if code := do(http.MethodGet, "/short2", "", "user1"); code != http.StatusGone {
+		t.Errorf("Expected another user's URL to stay deleted, got %d", code)
+	}
+
+	if code := do(http.MethodPost, "/api/user/urls/restore", `not json`, "user1"); code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid JSON, got %d", code)
+	}
+	if code := do(http.MethodPost, "/api/user/urls/restore", `["short1"]`, ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a user, got %d", code)
+	}
+}
+
+func TestHandlePurgeUser(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = filepath.Join(t.TempDir(), "urls.json")
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("short1", "https://example.com", "user1")
+	testStorage.AddURL("short2", "https://example.org", "user1")
+	testStorage.AddURL("short3", "https://google.com", "user2")
+	testStorage.DeleteURLs([]string{"short2"}, "user1")
+	if _, err := storage.SaveAllURLs(cfg.FileStorage, testStorage); err != nil {
+		t.Fatalf("Failed to save URLs: %v", err)
+	}
+
+	do := func(method, userID string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/user", nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		HandlePurgeUser(cfg)(w, req)
+		return w
+	}
+
+	w := do(http.MethodDelete, "user1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp PurgeResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	// Soft-deleted URLs are removed for good as well
+	if resp.Removed != 2 {
+		t.Errorf("Expected 2 removed URLs, got %d", resp.Removed)
+	}
+	for _, shortURL := range []string{"short1", "short2"} {
+		if _, exists, _ := testStorage.GetURLInfo(shortURL); exists {
+			t.Errorf("Expected %s to be removed", shortURL)
+		}
+	}
+	info, exists, _ := testStorage.GetURLInfo("short3")
+	if !exists || info.UserID != "user2" || info.IsDeleted {
+		t.Errorf("Expected another user's URL to stay untouched, got %+v (exists: %v)", info, exists)
+	}
+	// The purged URLs aren't loaded from the file again
+	saved, err := storage.LoadURLMappings(cfg.FileStorage)
+	if err != nil {
+		t.Fatalf("Failed to load file: %v", err)
+	}
+	if len(saved) != 1 || saved["short3"] == "" {
+		t.Errorf("Expected only short3 left in the file, got %v", saved)
+	}
+
+	// Purging again finds nothing left
+	w = do(http.MethodDelete, "user1")
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.Removed != 0 {
+		t.Errorf("Expected nothing removed on the second purge, got %+v (err: %v)", resp, err)
+	}
+
+	if w := do(http.MethodGet, "user1"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for GET, got %d", w.Code)
+	}
+	if w := do(http.MethodDelete, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a user, got %d", w.Code)
+	}
+}
+
+// slowDeleteStorage is a storage whose DeleteURLs takes a while to apply.
+type slowDeleteStorage struct {
+	*storage.URLStorage
+	deleted *atomic.Bool
+}
+
+func (s slowDeleteStorage) DeleteURLs(shortURLs []string, userID string) error {
+	time.Sleep(50 * time.Millisecond)
+	s.deleted.Store(true)
+	return nil
+}
+
+func TestWaitForDeletes(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	deleted := &atomic.Bool{}
+	InitStorage(slowDeleteStorage{storage.NewURLStorage(), deleted})
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["short1"]`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleDeleteUserURLs(cfg).ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Expected status 202, got %d", w.Code)
+	}
+
+	// Shutdown waits for the accepted deletion to be applied
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := WaitForDeletes(ctx); err != nil {
+		t.Fatalf("Expected pending deletes to finish, got %v", err)
+	}
+	if !deleted.Load() {
+		t.Error("Expected deletion to be applied before shutdown completes")
+	}
+}
+
+func TestWaitForDeletes_Deadline(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	InitStorage(slowDeleteStorage{storage.NewURLStorage(), &atomic.Bool{}})
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["short1"]`))
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	HandleDeleteUserURLs(cfg).ServeHTTP(httptest.NewRecorder(), req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := WaitForDeletes(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	WaitForDeletes(context.Background())
+}
+
+func TestHandleFlush(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = filepath.Join(t.TempDir(), "urls.json")
+	testStorage := storage.NewURLStorage()
+	testStorage.AddURL("short1", "https://a.com", "user1")
+	testStorage.AddURL("short2", "https://b.com", "user2")
+	testStorage.SetProtected([]string{"short2"})
+	InitStorage(testStorage)
+	// Saved earlier and removed from storage since
+	if err := storage.SaveSingleURLMapping(cfg.FileStorage, "purged", "https://c.com"); err != nil {
+		t.Fatalf("Failed to save mapping: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/internal/flush", nil)
+	w := httptest.NewRecorder()
+	HandleFlush(cfg).ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp FlushResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Written != 2 {
+		t.Errorf("Expected 2 written mappings, got %d", resp.Written)
+	}
+
+	saved, err := storage.LoadURLMappings(cfg.FileStorage)
+	if err != nil {
+		t.Fatalf("Failed to load flushed file: %v", err)
+	}
+	if len(saved) != 2 || saved["short1"] != "https://a.com" || saved["short2"] != "https://b.com" {
+		t.Errorf("Expected flushed file to contain the stored URLs, got %v", saved)
+	}
+	records, err := storage.LoadURLRecords(cfg.FileStorage)
+	if err != nil {
+		t.Fatalf("Failed to load flushed file: %v", err)
+	}
+	for _, record := range records {
+		if record.ShortURL == "short2" && !record.Protected {
+			t.Errorf("Expected the protected flag to be flushed, got %+v", record)
+		}
+	}
+}
+
+func TestHandleExport(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("abc123", "https://example.com", "user1")
+	testStorage.AddURL("gone", "https://gone.com", "user2")
+	testStorage.DeleteURLs([]string{"gone"}, "user2")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/internal/export", nil)
+	w := httptest.NewRecorder()
+	HandleExport(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("Expected application/x-ndjson, got %s", contentType)
+	}
+
+	// The exported lines import into a fresh store
+	var records []storage.URLMapping
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var record storage.URLMapping
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("Failed to parse line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	restored := storage.NewURLStorage()
+	if err := restored.Import(records); err != nil {
+		t.Fatalf("Failed to import exported records: %v", err)
+	}
+	if info, exists, _ := restored.GetURLInfo("gone"); !exists || info.UserID != "user2" || !info.IsDeleted {
+		t.Errorf("Expected deleted URL of user2 to be restored, got %+v", info)
+	}
+	if info, exists, _ := restored.GetURLInfo("abc123"); !exists || info.UserID != "user1" || info.IsDeleted {
+		t.Errorf("Expected URL of user1 to be restored, got %+v", info)
+	}
+}
+
+func TestHandleGetURLDetails(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	testStorage.URLs["abc123"] = storage.URLInfo{OriginalURL: "https://example.com", UserID: "user1", CreatedAt: createdAt}
+	testStorage.URLs["gone"] = storage.URLInfo{
+		OriginalURL: "https://gone.com",
+		UserID:      "user2",
+		CreatedAt:   createdAt,
+		IsDeleted:   true,
+		DeletedAt:   createdAt.Add(time.Hour),
+	}
+
+	r := chi.NewRouter()
+	r.Get("/api/internal/urls/{id}", HandleGetURLDetails)
+
+	tests := []struct {
+		name           string
+		id             string
+		expectedStatus int
+		expected       URLDetailsResponse
+	}{
+		{
+			name:           "Existing URL",
+			id:             "abc123",
+			expectedStatus: http.StatusOK,
+			expected:       URLDetailsResponse{ShortURL: "abc123", OriginalURL: "https://example.com", UserID: "user1", CreatedAt: createdAt},
+		},
+		{
+			name:           "Deleted URL",
+			id:             "gone",
+			expectedStatus: http.StatusOK,
+			expected:       URLDetailsResponse{ShortURL: "gone", OriginalURL: "https://gone.com", UserID: "user2", CreatedAt: createdAt, IsDeleted: true},
+		},
+		{
+			name:           "Missing URL",
+			id:             "missing",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/internal/urls/"+tt.id, nil))
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp URLDetailsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if tt.expected.IsDeleted {
+				if resp.DeletedAt == nil || !resp.DeletedAt.Equal(createdAt.Add(time.Hour)) {
+					t.Errorf("Expected deletion time %v, got %v", createdAt.Add(time.Hour), resp.DeletedAt)
+				}
+				resp.DeletedAt = nil
+			}
+			if !reflect.DeepEqual(resp, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, resp)
+			}
+		})
+	}
+}
+
+func TestHandleGetStats(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+
+	now := time.Now()
+	testStorage.URLs["old"] = storage.URLInfo{OriginalURL: "https://old.com", UserID: "user1", CreatedAt: now.Add(-48 * time.Hour)}
+	testStorage.URLs["deleted"] = storage.URLInfo{
+		OriginalURL: "https://deleted.com",
+		UserID:      "user2",
+		CreatedAt:   now.Add(-48 * time.Hour),
+		IsDeleted:   true,
+		DeletedAt:   now.Add(-time.Hour),
+	}
+	testStorage.AddURL("new", "https://new.com", "user1")
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectWindow   *WindowStatsResponse
+	}{
+		{
+			name:           "Lifetime stats",
+			query:          "",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Stats within window",
+			query:          "?window=24h",
+			expectedStatus: http.StatusOK,
+			expectWindow:   &WindowStatsResponse{Duration: "24h0m0s", Created: 1, Deleted: 1},
+		},
+		{
+			name:           "Invalid window",
+			query:          "?window=yesterday",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Negative window",
+			query:          "?window=-1h",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			HandleGetStats(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var resp StatsResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.URLs != 3 || resp.Deleted != 1 || resp.Users != 2 {
+				t.Errorf("Expected 3 URLs with 1 deleted and 2 users, got %+v", resp)
+			}
+			if tt.expectWindow == nil {
+				if resp.Window != nil {
+					t.Errorf("Expected no window stats, got %+v", resp.Window)
+				}
+				return
+			}
+			if resp.Window == nil || *resp.Window != *tt.expectWindow {
+				t.Errorf("Expected window stats %+v, got %+v", tt.expectWindow, resp.Window)
+			}
+		})
+	}
 }
 
 func TestShortenRequest(t *testing.T) {
@@ -598,3 +2410,195 @@ func TestBatchResponse(t *testing.T) {
 		t.Errorf("Expected ShortURL 'http://localhost:8080/abc123', got '%s'", resp.ShortURL)
 	}
 }
+
+// conflictTestStorages returns the storages the 409 conflict flow is checked against.
+// DBStorage is included only when TEST_DATABASE_DSN points to a PostgreSQL instance.
+func conflictTestStorages(t *testing.T) map[string]func(t *testing.T) storage.Storage {
+	return map[string]func(t *testing.T) storage.Storage{
+		"memory": func(t *testing.T) storage.Storage {
+			return storage.NewURLStorage()
+		},
+		"sqlite": func(t *testing.T) storage.Storage {
+			s, err := storage.NewSQLiteStorage(filepath.Join(t.TempDir(), "urls.db"), storage.SQLiteOptions{})
+			if err != nil {
+				t.Fatalf("NewSQLiteStorage() returned error: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"database": func(t *testing.T) storage.Storage {
+			dsn := os.Getenv("TEST_DATABASE_DSN")
+			if dsn == "" {
+				t.Skip("TEST_DATABASE_DSN is not set")
+			}
+			s, err := storage.NewDBStorage(dsn)
+			if err != nil {
+				t.Fatalf("NewDBStorage() returned error: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+	}
+}
+
+func TestHandlePost_ExistingURL_ReturnsConflict(t *testing.T) {
+	for name, newStorage := range conflictTestStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			InitStorage(newStorage(t))
+			originalURL := fmt.Sprintf("https://example.com/%d", time.Now().UnixNano())
+
+			post := func() *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(originalURL))
+				req.Header.Set("Content-Type", "text/plain")
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+				w := httptest.NewRecorder()
+				HandlePost(cfg, w, req)
+				return w
+			}
+
+			first := post()
+			if first.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d", first.Code)
+			}
+			if location := first.Header().Get("Location"); location != "" {
+				t.Errorf("Expected no Location on 201, got %s", location)
+			}
+			second := post()
+			if second.Code != http.StatusConflict {
+				t.Errorf("Expected status 409, got %d", second.Code)
+			}
+			if second.Body.String() != first.Body.String() {
+				t.Errorf("Expected existing short URL %s, got %s", first.Body.String(), second.Body.String())
+			}
+			if location := second.Header().Get("Location"); location != first.Body.String() {
+				t.Errorf("Expected Location %s on 409, got %q", first.Body.String(), location)
+			}
+		})
+	}
+}
+
+func TestHandleShortenPost_ExistingURL_ReturnsConflict(t *testing.T) {
+	for name, newStorage := range conflictTestStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			InitStorage(newStorage(t))
+			body := fmt.Sprintf(`{"url":"https://example.com/%d"}`, time.Now().UnixNano())
+
+			post := func() (int, string, ShortenResponse) {
+				req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+				w := httptest.NewRecorder()
+				HandleShortenPost(cfg, w, req)
+
+				if !strings.Contains(w.Body.String(), `"already_exists":`) {
+					t.Errorf("Expected already_exists in the response, got %s", w.Body.String())
+				}
+				var resp ShortenResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				return w.Code, w.Header().Get("Location"), resp
+			}
+
+			firstCode, firstLocation, first := post()
+			if firstCode != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d", firstCode)
+			}
+			if firstLocation != "" {
+				t.Errorf("Expected no Location on 201, got %s", firstLocation)
+			}
+			if first.AlreadyExists {
+				t.Error("Expected already_exists to be false for a fresh URL")
+			}
+			secondCode, secondLocation, second := post()
+			if secondCode != http.StatusConflict {
+				t.Errorf("Expected status 409, got %d", secondCode)
+			}
+			if !second.AlreadyExists {
+				t.Error("Expected already_exists to be true for a repeat shorten")
+			}
+			if second.ShortURL != first.ShortURL {
+				t.Errorf("Expected existing short URL %s, got %s", first.ShortURL, second.ShortURL)
+			}
+			if secondLocation != first.ShortURL {
+				t.Errorf("Expected Location %s on 409, got %q", first.ShortURL, secondLocation)
+			}
+		})
+	}
+}
+
+func TestShortLink(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		prefix   string
+		expected string
+	}{
+		{name: "no prefix", baseURL: "http://localhost:8080", expected: "http://localhost:8080/abc"},
+		{name: "prefix", baseURL: "http://localhost:8080", prefix: "/s", expected: "http://localhost:8080/s/abc"},
+		{name: "base URL with prefix", baseURL: "https://example.com/s", prefix: "/s", expected: "https://example.com/s/abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{BaseURL: tt.baseURL, PathPrefix: tt.prefix}
+			if got := shortLink(cfg, "abc"); got != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestHandleShortenPost_DeletedURL_CreatesLiveMapping(t *testing.T) {
+	for name, newStorage := range conflictTestStorages(t) {
+		t.Run(name, func(t *testing.T) {
+			cfg := testutils.CreateTestConfigWithDefaults(t)
+			cfg.FileStorage = ""
+			testStorage := newStorage(t)
+			InitStorage(testStorage)
+			body := fmt.Sprintf(`{"url":"https://example.com/%d"}`, time.Now().UnixNano())
+
+			post := func() (int, string) {
+				req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+				req.Header.Set("Content-Type", "application/json")
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "test-user"))
+				w := httptest.NewRecorder()
+				HandleShortenPost(cfg, w, req)
+
+				var resp ShortenResponse
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatalf("Failed to decode response: %v", err)
+				}
+				return w.Code, strings.TrimPrefix(resp.ShortURL, cfg.BaseURL+"/")
+			}
+
+			code, deleted := post()
+			if code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d", code)
+			}
+			if err := testStorage.DeleteURLs([]string{deleted}, "test-user"); err != nil {
+				t.Fatalf("DeleteURLs() returned error: %v", err)
+			}
+
+			code, shortURL := post()
+			if code != http.StatusCreated {
+				t.Errorf("Expected status 201 for a deleted URL, got %d", code)
+			}
+			if shortURL == deleted {
+				t.Fatalf("Expected a fresh short URL instead of the deleted %s", deleted)
+			}
+			if _, exists, isDeleted := testStorage.GetURL(shortURL); !exists || isDeleted {
+				t.Errorf("Expected %s to be live, got exists: %v, deleted: %v", shortURL, exists, isDeleted)
+			}
+
+			// The live mapping is returned from now on
+			if code, again := post(); code != http.StatusConflict || again != shortURL {
+				t.Errorf("Expected status 409 with %s, got %d with %s", shortURL, code, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// htmlRedirects enables HTML redirect pages, see InitHTMLRedirects.
+var htmlRedirects bool
+
+// InitHTMLRedirects enables or disables HTML redirect pages. When enabled,
+// HandleGet answers clients accepting text/html with a 200 page that redirects
+// through a meta refresh tag and a JavaScript fallback, for environments that
+// strip 3xx redirects, such as some email clients. Other clients still get 307.
+func InitHTMLRedirects(enabled bool) {
+	htmlRedirects = enabled
+}
+
+// redirectPage is the HTML redirect page. html/template escapes the destination
+// for each context: the meta tag, the link and the script.
+var redirectPage = template.Must(template.New("redirect").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="0; url={{.}}">
+<title>Redirecting</title>
+</head>
+<body>
+<p>Redirecting to <a href="{{.}}">{{.}}</a></p>
+<script>window.location.replace({{.}});</script>
+</body>
+</html>
+`))
+
+// wantsHTMLRedirect reports whether the redirect to originalURL should be served
+// as an HTML page. Only http and https destinations are served this way, so that
+// the script fallback can never run other URL schemes.
+func wantsHTMLRedirect(r *http.Request, originalURL string) bool {
+	if !htmlRedirects || !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	lower := strings.ToLower(originalURL)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// writeHTMLRedirect replies with the HTML redirect page to originalURL.
+func writeHTMLRedirect(w http.ResponseWriter, originalURL string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if err := redirectPage.Execute(w, originalURL); err != nil {
+		logger.Error("Failed to write redirect page", zap.Error(err))
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHandleGet_HTMLRedirect(t *testing.T) {
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	testStorage.AddURL("page123", "https://example.com/page?a=1", "user1")
+	testStorage.AddURL("script123", "javascript:alert(1)", "user1")
+
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		path     string
+		accept   string
+		wantCode int
+	}{
+		{name: "disabled", enabled: false, path: "/page123", accept: "text/html", wantCode: http.StatusTemporaryRedirect},
+		{name: "browser", enabled: true, path: "/page123", accept: "text/html,application/xhtml+xml", wantCode: http.StatusOK},
+		{name: "API client", enabled: true, path: "/page123", accept: "application/json", wantCode: http.StatusTemporaryRedirect},
+		{name: "non-HTTP destination", enabled: true, path: "/script123", accept: "text/html", wantCode: http.StatusTemporaryRedirect},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InitHTMLRedirects(tt.enabled)
+			defer InitHTMLRedirects(false)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			body := w.Body.String()
+			if !strings.Contains(body, `<meta http-equiv="refresh" content="0; url=https://example.com/page?a=1">`) {
+				t.Errorf("Expected meta refresh to the destination, got %s", body)
+			}
+			if !strings.Contains(body, `<a href="https://example.com/page?a=1">`) {
+				t.Errorf("Expected link to the destination, got %s", body)
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/html") {
+				t.Errorf("Expected HTML content type, got %s", contentType)
+			}
+		})
+	}
+}
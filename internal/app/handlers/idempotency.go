@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// IdempotencyKeyHeader is the request header carrying a client-chosen key that
+// makes retries of a shortening request return the result of the first attempt.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed for an idempotency key.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLength is the maximum accepted length of an idempotency key.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyReservationTTL is how long an idempotency key stays reserved for
+// a request that neither saves a result nor releases the key, as when the
+// process stops while handling it.
+const idempotencyReservationTTL = time.Minute
+
+// idempotencyReservation is a request's reservation of its idempotency key, see
+// reserveIdempotent. Requests without a key get a nil reservation, whose
+// methods do nothing.
+type idempotencyReservation struct {
+	cfg         *config.Config
+	userID      string
+	key         string
+	originalURL string
+	saved       bool
+}
+
+// reserveIdempotent reserves the request's idempotency key, so that retries
+// arriving while the request is handled don't shorten the URL again. If the
+// key is taken, it replays the saved result by calling write with the saved
+// status code and short URL. It reports whether the response was written, which
+// is also the case when the key is invalid, was used for another URL or its
+// request is still in progress. A saved short URL that has since been deleted
+// isn't replayed; the request takes the key over instead. Requests without a
+// key are never replayed. Unless the response was written, callers must call
+// release once done.
+func reserveIdempotent(cfg *config.Config, w http.ResponseWriter, r *http.Request, userID, originalURL string, write func(status int, shortURL string)) (*idempotencyReservation, bool) {
+	key := r.Header.Get(IdempotencyKeyHeader)
+	if key == "" {
+		return nil, false
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		httpError(w, "Idempotency key is too long", http.StatusBadRequest)
+		return nil, true
+	}
+
+	res := &idempotencyReservation{cfg: cfg, userID: userID, key: key, originalURL: originalURL}
+	pending := storage.IdempotentResult{OriginalURL: originalURL, ExpiresAt: time.Now().Add(idempotencyReservationTTL)}
+	saved, reserved, err := storageInstance.ReserveIdempotentResult(userID, key, pending)
+	if err != nil {
+		logger.Error("Failed to reserve idempotency key", zap.Error(err))
+		return nil, false
+	}
+	if reserved {
+		return res, false
+	}
+	if saved.OriginalURL != originalURL {
+		httpError(w, "Idempotency key was used for another URL", http.StatusUnprocessableEntity)
+		return nil, true
+	}
+	if saved.Status == 0 {
+		httpError(w, "A request with this idempotency key is in progress", http.StatusConflict)
+		return nil, true
+	}
+
+	if info, exists, err := storageInstance.GetURLInfo(saved.ShortURL); err == nil && (!exists || info.IsDeleted) {
+		// The short URL is gone, so the request is handled anew
+		if err := storageInstance.SaveIdempotentResult(userID, key, pending); err != nil {
+			logger.Error("Failed to reserve idempotency key", zap.Error(err))
+			return nil, false
+		}
+		return res, false
+	}
+
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	write(saved.Status, saved.ShortURL)
+	return nil, true
+}
+
+// save saves the result of the request for replaying it to retries sent with
+// the same idempotency key. Failing to save only disables the replay.
+func (res *idempotencyReservation) save(shortURL string, status int) {
+	if res == nil {
+		return
+	}
+	ttl := res.cfg.IdempotencyTTL.Duration
+	if ttl <= 0 {
+		ttl = config.DefaultIdempotencyTTL
+	}
+
+	err := storageInstance.SaveIdempotentResult(res.userID, res.key, storage.IdempotentResult{
+		OriginalURL: res.originalURL,
+		ShortURL:    shortURL,
+		Status:      status,
+		ExpiresAt:   time.Now().Add(ttl),
+	})
+	if err != nil {
+		logger.Error("Failed to save idempotency key", zap.Error(err))
+		return
+	}
+	res.saved = true
+}
+
+// release frees the key of a request that didn't save a result, so that its
+// retries are handled anew rather than reported as in progress.
+func (res *idempotencyReservation) release() {
+	if res == nil || res.saved {
+		return
+	}
+	// An expired result counts as none
+	err := storageInstance.SaveIdempotentResult(res.userID, res.key, storage.IdempotentResult{
+		OriginalURL: res.originalURL,
+		ExpiresAt:   time.Now(),
+	})
+	if err != nil {
+		logger.Error("Failed to release idempotency key", zap.Error(err))
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        func(originalURL string) string
+		handler     func(w http.ResponseWriter, r *http.Request)
+	}{
+		{
+			name:        "POST /",
+			contentType: "text/plain",
+			body:        func(originalURL string) string { return originalURL },
+			handler:     func(w http.ResponseWriter, r *http.Request) { HandlePost(cfg, w, r) },
+		},
+		{
+			name:        "POST /api/shorten",
+			contentType: "application/json",
+			body:        func(originalURL string) string { return `{"url":"` + originalURL + `"}` },
+			handler:     func(w http.ResponseWriter, r *http.Request) { HandleShortenPost(cfg, w, r) },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testStorage := storage.NewURLStorage()
+			InitStorage(testStorage)
+
+			send := func(key, userID, originalURL string) *httptest.ResponseRecorder {
+				req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body(originalURL)))
+				req.Header.Set("Content-Type", tt.contentType)
+				if key != "" {
+					req.Header.Set(IdempotencyKeyHeader, key)
+				}
+				req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+				w := httptest.NewRecorder()
+				tt.handler(w, req)
+				return w
+			}
+
+			first := send("key-1", "user1", "https://example.com")
+			if first.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d", first.Code)
+			}
+
+			// The retry gets the same response without creating another URL
+			retry := send("key-1", "user1", "https://example.com")
+			if retry.Code != first.Code || retry.Body.String() != first.Body.String() {
+				t.Errorf("Expected replay of %d %q, got %d %q", first.Code, first.Body.String(), retry.Code, retry.Body.String())
+			}
+			if retry.Header().Get(IdempotentReplayedHeader) != "true" {
+				t.Error("Expected the retry to be marked as replayed")
+			}
+			if urls := testStorage.GetAllURLs(); len(urls) != 1 {
+				t.Errorf("Expected 1 stored URL, got %d", len(urls))
+			}
+
+			// Keys are scoped to the user
+			if w := send("key-1", "user2", "https://other.example.com"); w.Code != http.StatusCreated {
+				t.Errorf("Expected another user's key to be independent, got status %d", w.Code)
+			}
+
+			if w := send("key-1", "user1", "https://other.example.com"); w.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Expected status 422 for a key reused with another URL, got %d", w.Code)
+			}
+			if w := send(strings.Repeat("k", maxIdempotencyKeyLength+1), "user1", "https://example.com"); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for a too long key, got %d", w.Code)
+			}
+
+			// Conflicts are replayed with their original status
+			conflict := send("key-2", "user1", "https://example.com")
+			if conflict.Code != http.StatusConflict {
+				t.Fatalf("Expected status 409, got %d", conflict.Code)
+			}
+			retry = send("key-2", "user1", "https://example.com")
+			if retry.Code != http.StatusConflict || retry.Body.String() != conflict.Body.String() {
+				t.Errorf("Expected replay of the conflict, got %d %q", retry.Code, retry.Body.String())
+			}
+
+			// A retry of a request still in progress isn't handled again
+			testStorage.ReserveIdempotentResult("user1", "key-3", storage.IdempotentResult{
+				OriginalURL: "https://example.com",
+				ExpiresAt:   time.Now().Add(time.Minute),
+			})
+			if w := send("key-3", "user1", "https://example.com"); w.Code != http.StatusConflict || w.Header().Get(IdempotentReplayedHeader) != "" {
+				t.Errorf("Expected status 409 for a key in progress, got %d", w.Code)
+			}
+
+			// A short URL deleted since isn't replayed
+			shortURL, _ := testStorage.GetShortURLByOriginalURL("https://example.com")
+			testStorage.DeleteURLs([]string{shortURL}, "user1")
+			retry = send("key-1", "user1", "https://example.com")
+			if retry.Code != http.StatusCreated || retry.Header().Get(IdempotentReplayedHeader) != "" {
+				t.Errorf("Expected the deleted URL to be shortened anew, got %d", retry.Code)
+			}
+			if _, _, isDeleted := testStorage.GetURL(shortURL); isDeleted {
+				t.Error("Expected the deleted URL to be replaced")
+			}
+
+			// A failed request releases its key
+			cfg.MaxURLsPerUser = 1
+			defer func() { cfg.MaxURLsPerUser = 0 }()
+			if w := send("key-4", "user1", "https://new.example.com"); w.Code != http.StatusTooManyRequests {
+				t.Fatalf("Expected status 429, got %d", w.Code)
+			}
+			cfg.MaxURLsPerUser = 0
+			if w := send("key-4", "user1", "https://new.example.com"); w.Code != http.StatusCreated {
+				t.Errorf("Expected the released key to be handled anew, got %d", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"go.uber.org/zap"
+)
+
+// maxMetadataBytes caps how much of a target page is read looking for its metadata.
+const maxMetadataBytes = 512 << 10
+
+// maxTitleLength caps the length of stored page titles.
+const maxTitleLength = 256
+
+// maxMetadataRedirects caps how many redirects are followed fetching a page.
+const maxMetadataRedirects = 10
+
+// errInternalTarget rejects metadata fetches of pages that aren't public.
+var errInternalTarget = errors.New("target is not a public http(s) address")
+
+// metadataClient fetches target pages for their metadata; replaced in tests.
+var metadataClient = newMetadataClient()
+
+// newMetadataClient returns a client that only connects to public addresses,
+// so that shortened URLs can't make the service fetch internal pages, such as
+// cloud metadata endpoints, and list their titles. Addresses are checked after
+// DNS resolution, including those of redirect targets.
+func newMetadataClient() *http.Client {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, Control: publicAddressOnly}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would connect on our behalf, bypassing the address check
+	transport.Proxy = nil
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxMetadataRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxMetadataRedirects)
+			}
+			return checkMetadataURL(req.URL)
+		},
+	}
+}
+
+// publicAddressOnly is a net.Dialer Control function refusing connections to
+// addresses that aren't public, see publicIP.
+func publicAddressOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errInternalTarget, host)
+	}
+	return nil
+}
+
+// publicIP reports whether ip is neither loopback, private, link-local,
+// multicast nor unspecified.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// checkMetadataURL returns errInternalTarget unless u is an http or https URL
+// whose host, if an IP address, is public. Host names are checked once resolved.
+func checkMetadataURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: scheme %q", errInternalTarget, u.Scheme)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !publicIP(ip) {
+		return fmt.Errorf("%w: %s", errInternalTarget, ip)
+	}
+	return nil
+}
+
+// Patterns extracting page metadata from HTML documents.
+var (
+	titlePattern  = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	linkPattern   = regexp.MustCompile(`(?is)<link\s[^>]*>`)
+	attrPattern   = regexp.MustCompile(`(?is)([a-z-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	spacesPattern = regexp.MustCompile(`\s+`)
+)
+
+// pageMetadata describes a target page.
+type pageMetadata struct {
+	title      string
+	faviconURL string
+}
+
+// metadataJob is a short URL waiting for the metadata of the page it points to.
+type metadataJob struct {
+	shortURL    string
+	originalURL string
+}
+
+// metadataWorker fetches page metadata in the background, so that shortening
+// requests don't wait for target pages. A bounded number of fetches run at once
+// and a bounded number of URLs wait for them.
+type metadataWorker struct {
+	jobs    chan metadataJob
+	timeout time.Duration
+	stop    chan struct{}
+	done    sync.WaitGroup
+}
+
+// metadataFetcher is the active metadata worker, nil when fetching is disabled.
+var metadataFetcher *metadataWorker
+
+// InitMetadataWorker starts workers fetching page metadata of shortened URLs,
+// each fetch bounded by timeout, with up to queueSize URLs waiting. Zero workers
+// disable fetching. Any previously started worker is stopped. Must be called
+// after InitStorage.
+func InitMetadataWorker(workers, queueSize int, timeout time.Duration) {
+	StopMetadataWorker()
+	if workers <= 0 {
+		return
+	}
+	metadataFetcher = &metadataWorker{
+		jobs:    make(chan metadataJob, queueSize),
+		timeout: timeout,
+		stop:    make(chan struct{}),
+	}
+	metadataFetcher.done.Add(workers)
+	for i := 0; i < workers; i++ {
+		go metadataFetcher.run()
+	}
+}
+
+// StopMetadataWorker stops the active metadata worker once the running fetches
+// finish. Metadata of waiting URLs is not fetched.
+func StopMetadataWorker() {
+	if metadataFetcher != nil {
+		close(metadataFetcher.stop)
+		metadataFetcher.done.Wait()
+		metadataFetcher = nil
+	}
+}
+
+// run fetches and records page metadata until the worker is stopped.
+func (mw *metadataWorker) run() {
+	defer mw.done.Done()
+	for {
+		select {
+		case job := <-mw.jobs:
+			mw.process(job)
+		case <-mw.stop:
+			return
+		}
+	}
+}
+
+// process fetches and records the page metadata of a job. Failures are logged,
+// as the URL is already stored.
+func (mw *metadataWorker) process(job metadataJob) {
+	ctx, cancel := context.WithTimeout(context.Background(), mw.timeout)
+	defer cancel()
+
+	meta, err := fetchMetadata(ctx, job.originalURL)
+	if err != nil {
+		logger.Warn("Failed to fetch page metadata", zap.String("url", job.originalURL), zap.Error(err))
+		return
+	}
+	if meta == (pageMetadata{}) {
+		return
+	}
+	if err := storageInstance.SetMetadata(job.shortURL, meta.title, meta.faviconURL); err != nil {
+		logger.Warn("Failed to record page metadata", zap.Error(err))
+	}
+}
+
+// recordMetadata queues fetching the title and favicon URL of the page
+// originalURL points to for shortURL if cfg.FetchMetadata is enabled. The
+// metadata is listed once fetched; it is skipped while the queue is full.
+func recordMetadata(cfg *config.Config, shortURL, originalURL string) {
+	if !cfg.FetchMetadata || metadataFetcher == nil {
+		return
+	}
+	select {
+	case metadataFetcher.jobs <- metadataJob{shortURL: shortURL, originalURL: originalURL}:
+	default:
+		logger.Warn("Metadata queue is full, skipping", zap.String("url", originalURL))
+	}
+}
+
+// fetchMetadata fetches the page at pageURL and extracts its metadata from the
+// first maxMetadataBytes of the document. Only public http(s) pages are fetched.
+func fetchMetadata(ctx context.Context, pageURL string) (pageMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+	if err != nil {
+		return pageMetadata{}, err
+	}
+	if err := checkMetadataURL(req.URL); err != nil {
+		return pageMetadata{}, err
+	}
+	req.Header.Set("Accept", "text/html")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return pageMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return pageMetadata{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return pageMetadata{}, fmt.Errorf("unexpected content type %q", mediaType)
+	}
+	document, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataBytes))
+	if err != nil {
+		return pageMetadata{}, err
+	}
+	// Relative favicon URLs resolve against the page after redirects
+	return parseMetadata(string(document), resp.Request.URL), nil
+}
+
+// parseMetadata extracts the title and the favicon URL, resolved against base,
+// from an HTML document.
+func parseMetadata(document string, base *url.URL) pageMetadata {
+	var meta pageMetadata
+	if match := titlePattern.FindStringSubmatch(document); match != nil {
+		title := strings.TrimSpace(spacesPattern.ReplaceAllString(html.UnescapeString(match[1]), " "))
+		if len(title) > maxTitleLength {
+			title = strings.ToValidUTF8(title[:maxTitleLength], "")
+		}
+		meta.title = title
+	}
+
+	for _, link := range linkPattern.FindAllString(document, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrPattern.FindAllStringSubmatch(link, -1) {
+			attrs[strings.ToLower(attr[1])] = html.UnescapeString(attr[2] + attr[3] + attr[4])
+		}
+		if !isIconRel(attrs["rel"]) || attrs["href"] == "" {
+			continue
+		}
+		if href, err := base.Parse(strings.TrimSpace(attrs["href"])); err == nil &&
+			(href.Scheme == "http" || href.Scheme == "https") {
+			meta.faviconURL = href.String()
+			break
+		}
+	}
+	return meta
+}
+
+// isIconRel reports whether a link rel attribute declares a favicon,
+// e.g. "icon" or "shortcut icon".
+func isIconRel(rel string) bool {
+	for _, value := range strings.Fields(strings.ToLower(rel)) {
+		if value == "icon" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+)
+
+// stubTransport answers every request with an HTML document once released.
+type stubTransport struct {
+	document string
+	release  chan struct{}
+}
+
+func (t stubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	select {
+	case <-t.release:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(t.document)),
+		Request:    req,
+	}, nil
+}
+
+// listUserURLs returns the URLs GET /api/user/urls lists for userID.
+func listUserURLs(t *testing.T, cfg *config.Config, userID string) []UserURLResponse {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+	w := httptest.NewRecorder()
+	HandleGetUserURLs(cfg).ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var urls []UserURLResponse
+	if err := json.NewDecoder(w.Body).Decode(&urls); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return urls
+}
+
+func TestHandleShortenPost_FetchMetadata(t *testing.T) {
+	transport := stubTransport{
+		document: `<html><head><TITLE> Example &amp; Co
+		</TITLE><link rel="shortcut icon" href="/static/icon.png"></head></html>`,
+		release: make(chan struct{}),
+	}
+	defer func(client *http.Client) { metadataClient = client }(metadataClient)
+	metadataClient = &http.Client{Transport: transport}
+
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FetchMetadata = true
+	InitStorage(storage.NewURLStorage())
+	InitMetadataWorker(1, 10, 5*time.Second)
+	defer StopMetadataWorker()
+
+	// The shortening responds while the target page is still being fetched
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com/page"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenPost(cfg, w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", w.Code, w.Body.String())
+	}
+	if urls := listUserURLs(t, cfg, "user1"); len(urls) != 1 || urls[0].Title != "" {
+		t.Fatalf("Expected 1 URL without metadata yet, got %+v", urls)
+	}
+
+	close(transport.release)
+	var urls []UserURLResponse
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if urls = listUserURLs(t, cfg, "user1"); urls[0].Title != "" {
+			break
+		}
+	}
+	if urls[0].Title != "Example & Co" {
+		t.Errorf("Expected title %q, got %q", "Example & Co", urls[0].Title)
+	}
+	if urls[0].FaviconURL != "https://example.com/static/icon.png" {
+		t.Errorf("Expected favicon URL https://example.com/static/icon.png, got %q", urls[0].FaviconURL)
+	}
+}
+
+func TestRecordMetadata_QueueFull(t *testing.T) {
+	transport := stubTransport{release: make(chan struct{})}
+	defer func(client *http.Client) { metadataClient = client }(metadataClient)
+	metadataClient = &http.Client{Transport: transport}
+
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FetchMetadata = true
+	InitStorage(storage.NewURLStorage())
+	InitMetadataWorker(1, 1, 5*time.Second)
+	defer StopMetadataWorker()
+	defer close(transport.release)
+
+	// Queuing never blocks: the first job is fetched, the second waits and the
+	// third is skipped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			recordMetadata(cfg, "short", "https://example.com")
+			time.Sleep(10 * time.Millisecond)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected queuing metadata fetches not to block")
+	}
+}
+
+// redirectTransport redirects every request to location.
+type redirectTransport struct {
+	location string
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusFound,
+		Header:     http.Header{"Location": {t.location}},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+func TestFetchMetadata_InternalTargets(t *testing.T) {
+	var fetched atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetched.Add(1)
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>Internal</title>"))
+	}))
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+	localhostURL := "http://localhost:" + serverURL.Port() + "/"
+
+	tests := []struct {
+		name   string
+		client *http.Client
+		target string
+	}{
+		{"loopback address", newMetadataClient(), server.URL},
+		{"name resolving to loopback", newMetadataClient(), localhostURL},
+		{"metadata endpoint", newMetadataClient(), "http://169.254.169.254/latest/meta-data/"},
+		{"private address", newMetadataClient(), "http://10.0.0.1/"},
+		{"unsupported scheme", newMetadataClient(), "file:///etc/passwd"},
+		{"redirect to loopback", &http.Client{
+			Transport:     redirectTransport{location: server.URL},
+			CheckRedirect: newMetadataClient().CheckRedirect,
+		}, "https://example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func(client *http.Client) { metadataClient = client }(metadataClient)
+			metadataClient = tt.client
+
+			meta, err := fetchMetadata(context.Background(), tt.target)
+			if !errors.Is(err, errInternalTarget) {
+				t.Errorf("Expected errInternalTarget, got %+v (err: %v)", meta, err)
+			}
+		})
+	}
+	if n := fetched.Load(); n != 0 {
+		t.Errorf("Expected the internal server not to be fetched, got %d requests", n)
+	}
+}
+
+func TestParseMetadata(t *testing.T) {
+	base, _ := url.Parse("https://example.com/a/page")
+	tests := []struct {
+		name     string
+		document string
+		want     pageMetadata
+	}{
+		{name: "empty", document: "", want: pageMetadata{}},
+		{name: "title only", document: "<title>Hello</title>", want: pageMetadata{title: "Hello"}},
+		{
+			name:     "relative icon",
+			document: `<link href='icon.svg' rel=icon>`,
+			want:     pageMetadata{faviconURL: "https://example.com/a/icon.svg"},
+		},
+		{
+			name:     "stylesheets skipped",
+			document: `<link rel="stylesheet" href="/a.css"><link rel="icon" href="//cdn.example.org/i.ico">`,
+			want:     pageMetadata{faviconURL: "https://cdn.example.org/i.ico"},
+		},
+		{
+			name:     "non-http icon ignored",
+			document: `<link rel="icon" href="data:image/png;base64,AAAA">`,
+			want:     pageMetadata{},
+		},
+		{
+			name:     "long title truncated",
+			document: "<title>" + strings.Repeat("a", 2*maxTitleLength) + "</title>",
+			want:     pageMetadata{title: strings.Repeat("a", maxTitleLength)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseMetadata(tt.document, base); got != tt.want {
+				t.Errorf("parseMetadata() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
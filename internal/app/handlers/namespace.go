@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// namespaceLength is the length of the tenant token prefixing namespaced short codes.
+// With 6-character codes, namespaced codes are 10 characters long.
+const namespaceLength = 4
+
+// codeNamespacing enables per-user short code namespaces, see InitCodeNamespacing.
+var codeNamespacing bool
+
+// InitCodeNamespacing enables or disables per-user short code namespaces.
+// When enabled, generated codes are prefixed with a token derived from the
+// ID of the user creating them, so codes of different tenants never collide.
+// HandleGet doesn't route by the token: custom aliases are used as they are
+// and existing codes are not affected, so short URLs are resolved by their full
+// code, which is unique across tenants.
+func InitCodeNamespacing(enabled bool) {
+	codeNamespacing = enabled
+}
+
+// tenantToken derives the URL-safe namespace token of a user ID.
+func tenantToken(userID string) string {
+	sum := sha256.Sum256([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(sum[:])[:namespaceLength]
+}
+
+// namespacedCode prefixes code with the tenant token of userID if namespacing is enabled.
+func namespacedCode(userID, code string) string {
+	if !codeNamespacing {
+		return code
+	}
+	return tenantToken(userID) + code
+}
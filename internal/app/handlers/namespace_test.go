@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestCodeNamespacing(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	InitCodeNamespacing(true)
+	defer InitCodeNamespacing(false)
+
+	shorten := func(userID, originalURL string) string {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(originalURL))
+		req.Header.Set("Content-Type", "text/plain")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		HandlePost(cfg, w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+		return strings.TrimPrefix(w.Body.String(), cfg.BaseURL+"/")
+	}
+
+	codes := map[string][]string{
+		"tenant-a": {shorten("tenant-a", "https://a.example.com/1"), shorten("tenant-a", "https://a.example.com/2")},
+		"tenant-b": {shorten("tenant-b", "https://b.example.com/1")},
+	}
+
+	urlSafe := regexp.MustCompile(`^[A-Za-z0-9_-]{10}$`)
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
+	for tenant, tenantCodes := range codes {
+		for _, code := range tenantCodes {
+			if !urlSafe.MatchString(code) {
+				t.Errorf("Expected a URL-safe 10-character code, got %q", code)
+			}
+			if !strings.HasPrefix(code, tenantToken(tenant)) {
+				t.Errorf("Expected code %s to carry the namespace of %s", code, tenant)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+code, nil))
+			if w.Code != http.StatusTemporaryRedirect {
+				t.Errorf("Expected code %s to resolve, got status %d", code, w.Code)
+			}
+			if location := w.Header().Get("Location"); !strings.HasPrefix(location, "https://"+tenant[len(tenant)-1:]+".example.com") {
+				t.Errorf("Expected code %s to resolve within %s, got %s", code, tenant, location)
+			}
+		}
+	}
+	if tenantToken("tenant-a") == tenantToken("tenant-b") {
+		t.Error("Expected tenants to get different namespaces")
+	}
+
+	// Custom aliases and codes stored before namespacing aren't prefixed
+	testStorage.AddURL("abc", "https://alias.example.com", "tenant-a")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/abc", nil))
+	if w.Code != http.StatusTemporaryRedirect {
+		t.Errorf("Expected a code without namespace to resolve, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/xyz", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown code to be rejected, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Customized not-found responses of HandleGet, see InitNotFound.
+var (
+	notFoundPage     *template.Template
+	notFoundRedirect string
+)
+
+// InitNotFound customizes how HandleGet answers clients accepting text/html
+// for unknown short URLs. With redirectURL set, they are redirected there with
+// 302 Found, e.g. to a landing page. Otherwise, with templatePath set, they get
+// the HTML template at that path with 404; the template is executed with the
+// requested short URL identifier as its data. Other clients always get the plain
+// 404 response. Empty arguments disable the customizations.
+func InitNotFound(templatePath, redirectURL string) error {
+	notFoundPage, notFoundRedirect = nil, redirectURL
+	if templatePath == "" {
+		return nil
+	}
+	page, err := template.ParseFiles(templatePath)
+	if err != nil {
+		return fmt.Errorf("failed to parse not-found template: %w", err)
+	}
+	notFoundPage = page
+	return nil
+}
+
+// writeNotFound replies to a request for the unknown short URL id, counting the
+// outcome in metrics.RedirectsTotal.
+func writeNotFound(w http.ResponseWriter, r *http.Request, id string) {
+	browser := strings.Contains(r.Header.Get("Accept"), "text/html")
+	switch {
+	case browser && notFoundRedirect != "":
+		countRedirect(http.StatusFound)
+		http.Redirect(w, r, notFoundRedirect, http.StatusFound)
+	case browser && notFoundPage != nil:
+		countRedirect(http.StatusNotFound)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := notFoundPage.Execute(w, id); err != nil {
+			logger.Error("Failed to write not-found page", zap.Error(err))
+		}
+	default:
+		countRedirect(http.StatusNotFound)
+		writeError(w, r, "URL not found", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestHandleGet_NotFoundCustomization(t *testing.T) {
+	InitStorage(storage.NewURLStorage())
+	templatePath := filepath.Join(t.TempDir(), "404.html")
+	if err := os.WriteFile(templatePath, []byte(`<h1>No link {{.}} here</h1>`), 0644); err != nil {
+		t.Fatalf("Failed to write template: %v", err)
+	}
+
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
+
+	tests := []struct {
+		name         string
+		template     string
+		redirect     string
+		accept       string
+		wantCode     int
+		wantType     string
+		wantBody     string
+		wantLocation string
+	}{
+		{name: "plain", accept: "text/html", wantCode: http.StatusNotFound, wantType: "application/json", wantBody: `"not_found"`},
+		{name: "HTML page", template: templatePath, accept: "text/html,application/xhtml+xml", wantCode: http.StatusNotFound, wantType: "text/html", wantBody: "<h1>No link missing1 here</h1>"},
+		{name: "HTML page for API client", template: templatePath, accept: "application/json", wantCode: http.StatusNotFound, wantType: "application/json", wantBody: `"not_found"`},
+		{name: "redirect", template: templatePath, redirect: "https://example.com/landing", accept: "text/html", wantCode: http.StatusFound, wantLocation: "https://example.com/landing"},
+		{name: "redirect for API client", redirect: "https://example.com/landing", accept: "*/*", wantCode: http.StatusNotFound, wantType: "application/json", wantBody: `"not_found"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := InitNotFound(tt.template, tt.redirect); err != nil {
+				t.Fatalf("InitNotFound() returned error: %v", err)
+			}
+			defer InitNotFound("", "")
+
+			req := httptest.NewRequest(http.MethodGet, "/missing1", nil)
+			req.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if location := w.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Expected Location %q, got %q", tt.wantLocation, location)
+			}
+			if tt.wantType == "" {
+				return
+			}
+			if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tt.wantType) {
+				t.Errorf("Expected %s, got %s", tt.wantType, contentType)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tt.wantBody) {
+				t.Errorf("Expected body containing %s, got %s", tt.wantBody, body)
+			}
+			if tt.wantType == "application/json" && !json.Valid(w.Body.Bytes()) {
+				t.Errorf("Expected a JSON error body, got %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestInitNotFound_InvalidTemplate(t *testing.T) {
+	defer InitNotFound("", "")
+	if err := InitNotFound(filepath.Join(t.TempDir(), "missing.html"), ""); err == nil {
+		t.Error("Expected an error for a missing template")
+	}
+
+	path := filepath.Join(t.TempDir(), "404.html")
+	os.WriteFile(path, []byte(`{{.`), 0644)
+	if err := InitNotFound(path, ""); err == nil {
+		t.Error("Expected an error for an unparsable template")
+	}
+}
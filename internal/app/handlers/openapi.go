@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// openAPIVersion is the OpenAPI specification version of the API description.
+const openAPIVersion = "3.0.3"
+
+// openAPISchemas are the types described in the components section of the API
+// description. Their schemas are derived from the structs and their json tags,
+// so the description can't drift from the actual requests and responses.
+var openAPISchemas = map[string]reflect.Type{
+	"ShortenRequest":    reflect.TypeOf(ShortenRequest{}),
+	"ShortenResponse":   reflect.TypeOf(ShortenResponse{}),
+	"ShortenV2Response": reflect.TypeOf(ShortenV2Response{}),
+	"BatchRequest":      reflect.TypeOf(BatchRequest{}),
+	"BatchResponse":     reflect.TypeOf(BatchResponse{}),
+	"UserURLResponse":   reflect.TypeOf(UserURLResponse{}),
+	"DeleteResponse":    reflect.TypeOf(DeleteResponse{}),
+	"PurgeResponse":     reflect.TypeOf(PurgeResponse{}),
+	"ErrorResponse":     reflect.TypeOf(ErrorResponse{}),
+}
+
+// openAPIDocument is the JSON-encoded API description served by HandleOpenAPI.
+var openAPIDocument = mustMarshalOpenAPI()
+
+// HandleOpenAPI handles GET /openapi.json requests, describing the public API
+// as an OpenAPI 3 document for generating clients.
+//
+// HTTP methods: GET
+// Response: application/json with the OpenAPI document
+//
+// Response codes:
+//   - 200: API description
+func HandleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(openAPIDocument); err != nil {
+		logger.Error("Failed to write OpenAPI document", zap.Error(err))
+	}
+}
+
+// mustMarshalOpenAPI builds and encodes the API description.
+func mustMarshalOpenAPI() []byte {
+	document, err := json.Marshal(openAPISpec())
+	if err != nil {
+		panic("handlers: failed to encode OpenAPI document: " + err.Error())
+	}
+	return document
+}
+
+// object is a JSON object of the API description.
+type object = map[string]interface{}
+
+// openAPISpec returns the API description.
+func openAPISpec() object {
+	schemas := object{}
+	for name, t := range openAPISchemas {
+		schemas[name] = schemaOf(t)
+	}
+
+	return object{
+		"openapi": openAPIVersion,
+		"info": object{
+			"title":       "ShortyGopher URL shortener",
+			"description": "Shortens URLs and redirects short URLs to the original ones. Users are identified by the auth_token cookie, issued on the first request.",
+			"version":     "1.0.0",
+		},
+		"paths": object{
+			"/": object{
+				"post": object{
+					"summary":    "Shorten a URL submitted as text, JSON or form",
+					"parameters": []object{idempotencyKeyParameter()},
+					"requestBody": object{
+						"required": true,
+						"content": object{
+							"text/plain":       object{"schema": object{"type": "string", "format": "uri"}},
+							"application/json": object{"schema": schemaRef("ShortenRequest")},
+							"application/x-www-form-urlencoded": object{"schema": object{
+								"type":       "object",
+								"properties": object{"url": object{"type": "string", "format": "uri"}},
+								"required":   []string{"url"},
+							}},
+						},
+					},
+					"responses": object{
+						"201": textResponse("Short URL"),
+						"303": object{
+							"description": "Redirect to the short URL, for browser form submissions when enabled",
+							"headers": object{"Location": object{
+								"schema": object{"type": "string", "format": "uri"},
+							}},
+						},
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authorized"),
+						"409": withLocation(textResponse("The URL is already shortened; the existing short URL")),
+						"422": errorResponse("Idempotency key was used for another URL"),
+						"429": errorResponse("User holds the maximum number of URLs"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+						"507": errorResponse("Storage holds the maximum number of URLs"),
+					},
+				},
+			},
+			"/api/shorten": object{
+				"post": object{
+					"summary":     "Shorten a URL",
+					"description": "Deprecated in favor of /api/v2/shorten.",
+					"deprecated":  true,
+					"parameters":  []object{idempotencyKeyParameter()},
+					"requestBody": jsonBody(schemaRef("ShortenRequest")),
+					"responses": object{
+						"201": jsonResponse("Short URL", schemaRef("ShortenResponse")),
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authorized"),
+						"409": withLocation(jsonResponse("The URL is already shortened; the existing short URL", schemaRef("ShortenResponse"))),
+						"422": errorResponse("Idempotency key was used for another URL"),
+						"429": errorResponse("User holds the maximum number of URLs"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+						"507": errorResponse("Storage holds the maximum number of URLs"),
+					},
+				},
+			},
+			"/api/v2/shorten": object{
+				"post": object{
+					"summary":     "Shorten a URL",
+					"parameters":  []object{idempotencyKeyParameter()},
+					"requestBody": jsonBody(schemaRef("ShortenRequest")),
+					"responses": object{
+						"201": jsonResponse("Short URL", schemaRef("ShortenV2Response")),
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authorized"),
+						"409": withLocation(jsonResponse("The URL is already shortened; the existing short URL", schemaRef("ShortenV2Response"))),
+						"422": errorResponse("Idempotency key was used for another URL"),
+						"429": errorResponse("User holds the maximum number of URLs"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+						"507": errorResponse("Storage holds the maximum number of URLs"),
+					},
+				},
+			},
+			"/api/shorten/batch": object{
+				"post": object{
+					"summary":     "Shorten several URLs at once",
+					"description": "Responses are returned in the order of the request items.",
+					"requestBody": jsonBody(arrayOf(schemaRef("BatchRequest"))),
+					"responses": object{
+						"201": jsonResponse("Short URLs", arrayOf(schemaRef("BatchResponse"))),
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authorized"),
+						"429": errorResponse("User holds the maximum number of URLs"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+						"507": errorResponse("Storage holds the maximum number of URLs"),
+					},
+				},
+			},
+			"/api/shorten/csv": object{
+				"post": object{
+					"summary":     "Shorten the URLs of a CSV document",
+					"description": "Each row holds an original URL, optionally followed by a desired alias. The response has an original,short,error row for every request row; rejected rows carry the reason in the error column.",
+					"requestBody": object{
+						"required": true,
+						"content":  object{"text/csv": object{"schema": object{"type": "string"}}},
+					},
+					"responses": object{
+						"201": object{
+							"description": "Short URLs and per-row errors",
+							"content":     object{"text/csv": object{"schema": object{"type": "string"}}},
+						},
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authorized"),
+						"429": errorResponse("User holds the maximum number of URLs"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+						"507": errorResponse("Storage holds the maximum number of URLs"),
+					},
+				},
+			},
+			"/{id}": object{
+				"get": object{
+					"summary": "Redirect to the original URL",
+					"parameters": []object{{
+						"name":     "id",
+						"in":       "path",
+						"required": true,
+						"schema":   object{"type": "string"},
+					}, queryParameter("token", "Signed token of a protected URL", object{"type": "string"})},
+					"responses": object{
+						"200": object{
+							"description": "HTML page redirecting to the original URL, for browsers when enabled",
+							"content":     object{"text/html": object{"schema": object{"type": "string"}}},
+						},
+						"307": object{
+							"description": "Redirect to the original URL",
+							"headers": object{"Location": object{
+								"schema": object{"type": "string", "format": "uri"},
+							}},
+						},
+						"302": object{
+							"description": "Redirect of browsers to the configured page for unknown short URLs",
+							"headers": object{"Location": object{
+								"schema": object{"type": "string", "format": "uri"},
+							}},
+						},
+						"401": errorResponse("Protected URL requested without a token"),
+						"403": errorResponse("Invalid or expired token of a protected URL"),
+						"404": errorResponse("URL not found; browsers get the configured HTML page instead when enabled"),
+						"410": errorResponse("URL was deleted"),
+						"503": errorResponse("Storage temporarily unavailable"),
+					},
+				},
+			},
+			"/api/user/urls": object{
+				"get": object{
+					"summary": "List the user's URLs",
+					"parameters": []object{
+						queryParameter("limit", "Maximum number of URLs to return", object{"type": "integer", "minimum": 1}),
+						queryParameter("offset", "Number of URLs to skip", object{"type": "integer", "minimum": 0}),
+						queryParameter("sort", "Order of the URLs by creation", object{"type": "string", "enum": []string{"oldest", "newest"}}),
+					},
+					"responses": object{
+						"200": jsonResponse("The user's URLs", arrayOf(schemaRef("UserURLResponse"))),
+						"204": object{"description": "The user has no URLs in the requested page"},
+						"400": errorResponse("Invalid limit, offset or sort"),
+						"401": errorResponse("User not authenticated"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+					},
+				},
+				"delete": object{
+					"summary":     "Delete the user's URLs",
+					"description": "Deletion is asynchronous; URLs of other users are ignored.",
+					"requestBody": jsonBody(arrayOf(object{"type": "string"})),
+					"responses": object{
+						"202": jsonResponse("Deletion accepted for the submitted short URLs", schemaRef("DeleteResponse")),
+						"400": errorResponse("Invalid request"),
+						"401": errorResponse("User not authenticated"),
+					},
+				},
+			},
+			"/api/user": object{
+				"delete": object{
+					"summary":     "Permanently remove all of the user's URLs",
+					"description": "Unlike deletion, no tombstones are kept; URLs of other users are never touched.",
+					"responses": object{
+						"200": jsonResponse("Number of removed URLs", schemaRef("PurgeResponse")),
+						"400": errorResponse("Invalid request method"),
+						"401": errorResponse("User not authenticated"),
+						"500": errorResponse("Internal server error"),
+						"503": errorResponse("Storage temporarily unavailable"),
+					},
+				},
+			},
+			"/ready": object{
+				"get": object{
+					"summary": "Check whether the service is ready to serve traffic",
+					"responses": object{
+						"200": object{"description": "The service is ready"},
+						"503": errorResponse("Storage was not confirmed available yet"),
+					},
+				},
+			},
+			"/ping": object{
+				"get": object{
+					"summary": "Check storage availability",
+					"responses": object{
+						"200": object{"description": "Storage is available"},
+						"500": errorResponse("Storage is unavailable"),
+						"503": errorResponse("Storage temporarily unavailable"),
+					},
+				},
+			},
+		},
+		"components": object{"schemas": schemas},
+	}
+}
+
+// schemaOf derives the JSON schema of t from its Go type and json tags.
+// Fields without omitempty are required.
+func schemaOf(t reflect.Type) object {
+	if t == reflect.TypeOf(time.Time{}) {
+		return object{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.String:
+		return object{"type": "string"}
+	case reflect.Bool:
+		return object{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return object{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return object{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return arrayOf(schemaOf(t.Elem()))
+	case reflect.Map:
+		return object{"type": "object", "additionalProperties": schemaOf(t.Elem())}
+	case reflect.Struct:
+		properties := object{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = schemaOf(field.Type)
+			if !strings.Contains(options, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := object{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	default:
+		return object{}
+	}
+}
+
+// schemaRef references a schema of the components section.
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+// arrayOf returns the schema of an array of items.
+func arrayOf(items object) object {
+	return object{"type": "array", "items": items}
+}
+
+// jsonBody returns a required JSON request body.
+func jsonBody(schema object) object {
+	return object{
+		"required": true,
+		"content":  object{"application/json": object{"schema": schema}},
+	}
+}
+
+// jsonResponse returns a JSON response.
+func jsonResponse(description string, schema object) object {
+	return object{
+		"description": description,
+		"content":     object{"application/json": object{"schema": schema}},
+	}
+}
+
+// textResponse returns a plain text response carrying a URL.
+func textResponse(description string) object {
+	return object{
+		"description": description,
+		"content":     object{"text/plain": object{"schema": object{"type": "string", "format": "uri"}}},
+	}
+}
+
+// withLocation adds the Location header, holding the existing short URL, to response.
+func withLocation(response object) object {
+	response["headers"] = object{"Location": object{
+		"schema": object{"type": "string", "format": "uri"},
+	}}
+	return response
+}
+
+// errorResponse returns a JSON error response.
+func errorResponse(description string) object {
+	return jsonResponse(description, schemaRef("ErrorResponse"))
+}
+
+// queryParameter returns an optional query parameter.
+func queryParameter(name, description string, schema object) object {
+	return object{"name": name, "in": "query", "description": description, "schema": schema}
+}
+
+// idempotencyKeyParameter returns the optional Idempotency-Key header parameter.
+func idempotencyKeyParameter() object {
+	return object{
+		"name":        IdempotencyKeyHeader,
+		"in":          "header",
+		"description": "Key making retries return the result of the first request",
+		"schema":      object{"type": "string", "maxLength": maxIdempotencyKeyLength},
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHandleOpenAPI(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	w := httptest.NewRecorder()
+	HandleOpenAPI(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected application/json, got %s", contentType)
+	}
+
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Info    struct {
+			Title   string `json:"title"`
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Type       string                     `json:"type"`
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Errorf("Expected an OpenAPI 3 document, got version %q", doc.OpenAPI)
+	}
+	if doc.Info.Title == "" || doc.Info.Version == "" {
+		t.Error("Expected info with title and version")
+	}
+
+	for _, path := range []string{"/", "/api/shorten", "/api/v2/shorten", "/api/shorten/batch", "/{id}", "/api/user/urls", "/api/user", "/ping"} {
+		operations, ok := doc.Paths[path]
+		if !ok {
+			t.Errorf("Expected path %s to be described", path)
+			continue
+		}
+		for method, raw := range operations {
+			var operation struct {
+				Responses map[string]struct {
+					Description string `json:"description"`
+				} `json:"responses"`
+			}
+			if err := json.Unmarshal(raw, &operation); err != nil {
+				t.Fatalf("Failed to parse %s %s: %v", method, path, err)
+			}
+			if len(operation.Responses) == 0 {
+				t.Errorf("Expected responses for %s %s", method, path)
+			}
+			for code, response := range operation.Responses {
+				if status, err := strconv.Atoi(code); err != nil || http.StatusText(status) == "" {
+					t.Errorf("Invalid status code %q for %s %s", code, method, path)
+				}
+				if response.Description == "" {
+					t.Errorf("Expected a description of %s for %s %s", code, method, path)
+				}
+			}
+		}
+	}
+
+	// Every reference resolves to a component schema
+	for _, ref := range strings.Split(w.Body.String(), `"$ref":"`)[1:] {
+		name := strings.TrimPrefix(ref[:strings.Index(ref, `"`)], "#/components/schemas/")
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Unresolved reference to %s", name)
+		}
+	}
+
+	// Schemas follow the json tags of the structs
+	shorten := doc.Components.Schemas["ShortenRequest"]
+	if _, ok := shorten.Properties["url"]; !ok || !reflect.DeepEqual(shorten.Required, []string{"url"}) {
+		t.Errorf("Expected ShortenRequest to require url, got %+v", shorten)
+	}
+	userURL := doc.Components.Schemas["UserURLResponse"]
+	for _, property := range []string{"short_url", "original_url", "is_deleted", "content_type", "created_at"} {
+		if _, ok := userURL.Properties[property]; !ok {
+			t.Errorf("Expected UserURLResponse property %s", property)
+		}
+	}
+	for _, required := range userURL.Required {
+		if required == "is_deleted" || required == "content_type" {
+			t.Errorf("Expected omitted-when-empty property %s to be optional", required)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"go.uber.org/zap"
+)
+
+// ready is set once the storage answered a ping, see WaitForStorage.
+var ready atomic.Bool
+
+// SetReady marks the service ready or not ready to serve requests needing storage.
+func SetReady(r bool) {
+	ready.Store(r)
+}
+
+// WaitForStorage pings s every interval until it answers, then marks the
+// service ready. Returns false if ctx is done first.
+func WaitForStorage(ctx context.Context, s storage.Storage, interval time.Duration) bool {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := pingStorage(ctx, s)
+		if err == nil {
+			ready.Store(true)
+			return true
+		}
+		logger.Warn("Storage is not ready yet", zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// HandleReady handles GET /ready requests, reporting whether the storage was
+// confirmed available since startup. Unlike GET /health, which reports liveness
+// and the current storage state, it is meant for load balancers deciding
+// whether to route traffic to the instance.
+//
+// HTTP methods: GET
+// Response: text/plain
+//
+// Response codes:
+//   - 200: Service is ready
+//   - 503: Storage was not confirmed available yet
+func HandleReady(w http.ResponseWriter, r *http.Request) {
+	if !ready.Load() {
+		httpError(w, "Not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ready"))
+}
+
+// RequireReady is middleware answering 503 Service Unavailable until the
+// service is ready, so that requests aren't failed by storage still starting up.
+func RequireReady(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !ready.Load() {
+			w.Header().Set("Retry-After", "1")
+			httpError(w, "Service is starting up", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+)
+
+// warmingUpStorage is a storage whose pings fail until up is set.
+type warmingUpStorage struct {
+	*storage.URLStorage
+	up    *atomic.Bool
+	pings *atomic.Int32
+}
+
+func (s warmingUpStorage) PingContext(ctx context.Context) error {
+	s.pings.Add(1)
+	if !s.up.Load() {
+		return fmt.Errorf("the database system is starting up")
+	}
+	return nil
+}
+
+func TestWaitForStorage_SlowStorage(t *testing.T) {
+	SetReady(false)
+	t.Cleanup(func() { SetReady(false) })
+
+	s := warmingUpStorage{URLStorage: storage.NewURLStorage(), up: &atomic.Bool{}, pings: &atomic.Int32{}}
+	InitStorage(s)
+	cfg := &config.Config{BaseURL: "http://localhost:8080"}
+	shorten := RequireReady(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		HandleShortenPost(cfg, w, r)
+	}))
+	serve := func() (int, int) {
+		w := httptest.NewRecorder()
+		HandleReady(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		shortenW := httptest.NewRecorder()
+		shorten.ServeHTTP(shortenW, req)
+		return w.Code, shortenW.Code
+	}
+
+	done := make(chan bool)
+	go func() { done <- WaitForStorage(context.Background(), s, time.Millisecond) }()
+
+	// Not ready while pings fail
+	for s.pings.Load() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if readyCode, shortenCode := serve(); readyCode != http.StatusServiceUnavailable || shortenCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 before the storage is ready, got %d for /ready and %d for shortening", readyCode, shortenCode)
+	}
+
+	s.up.Store(true)
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatal("Expected WaitForStorage to report the storage ready")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitForStorage didn't return after the storage came up")
+	}
+	if readyCode, shortenCode := serve(); readyCode != http.StatusOK || shortenCode != http.StatusCreated {
+		t.Errorf("Expected 200 for /ready and 201 for shortening once ready, got %d and %d", readyCode, shortenCode)
+	}
+}
+
+func TestWaitForStorage_Canceled(t *testing.T) {
+	SetReady(false)
+	s := failingPingStorage{storage.NewURLStorage()}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if WaitForStorage(ctx, s, time.Millisecond) {
+		t.Error("Expected WaitForStorage to give up when the context is done")
+	}
+
+	w := httptest.NewRecorder()
+	HandleReady(w, httptest.NewRequest(http.MethodGet, "/ready", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", w.Code)
+	}
+}
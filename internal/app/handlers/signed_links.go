@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+// linkSigningKey signs the tokens of protected short URLs, see InitSignedLinks.
+var linkSigningKey []byte
+
+// linkNow returns the current time when checking token expiry; replaced in tests.
+var linkNow = time.Now
+
+// Reasons a protected short URL doesn't resolve, see verifyLinkToken.
+var (
+	errTokenMissing = errors.New("token required")
+	errTokenInvalid = errors.New("invalid token")
+	errTokenExpired = errors.New("token expired")
+)
+
+// InitSignedLinks enables protected short URLs, which only resolve with a
+// ?token= parameter signed with secretKey, so that they can't be found by
+// enumerating short URLs. Once enabled, HandleGet and HandleExpand look up
+// whether a URL is protected; an empty secretKey disables protection again.
+func InitSignedLinks(secretKey string) {
+	linkSigningKey = nil
+	if secretKey != "" {
+		linkSigningKey = []byte(secretKey)
+	}
+}
+
+// signedLinksEnabled reports whether protected short URLs are supported.
+func signedLinksEnabled() bool {
+	return linkSigningKey != nil
+}
+
+// signedLink returns the full short URL of the protected code with a token
+// valid for cfg.SignedLinkTTL.
+func signedLink(cfg *config.Config, code string) string {
+	return shortLink(cfg, code) + "?token=" + linkToken(code, linkNow().Add(cfg.SignedLinkTTL.Duration))
+}
+
+// linkToken returns a token granting access to the short URL code until
+// expiresAt: the Unix expiry time and its signature, separated by a dot.
+func linkToken(code string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	return expiry + "." + linkSignature(code, expiry)
+}
+
+// linkSignature returns the URL-safe HMAC-SHA256 of code and expiry.
+func linkSignature(code, expiry string) string {
+	mac := hmac.New(sha256.New, linkSigningKey)
+	mac.Write([]byte(code + "." + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyLinkToken checks that token grants access to the short URL code.
+// Returns errTokenMissing, errTokenInvalid or errTokenExpired otherwise.
+func verifyLinkToken(code, token string) error {
+	if token == "" {
+		return errTokenMissing
+	}
+	expiry, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(linkSignature(code, expiry))) {
+		return errTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return errTokenInvalid
+	}
+	if linkNow().Unix() >= expiresAt {
+		return errTokenExpired
+	}
+	return nil
+}
+
+// linkTokenError returns the message and status code answering a request for a
+// protected short URL rejected by verifyLinkToken with err.
+func linkTokenError(err error) (string, int) {
+	switch {
+	case errors.Is(err, errTokenMissing):
+		return "Token required", http.StatusUnauthorized
+	case errors.Is(err, errTokenExpired):
+		return "Token expired", http.StatusForbidden
+	default:
+		return "Invalid token", http.StatusForbidden
+	}
+}
+
+// lookupURL returns the original URL of the short URL code and whether it
+// exists, is deleted and is protected. Protection is only looked up with signed
+// links enabled; storage errors are reported as a missing URL, like GetURL does.
+func lookupURL(code string) (originalURL string, exists, isDeleted, protected bool) {
+	if !signedLinksEnabled() {
+		originalURL, exists, isDeleted = storageInstance.GetURL(code)
+		return originalURL, exists, isDeleted, false
+	}
+	info, exists, err := storageInstance.GetURLInfo(code)
+	if err != nil || !exists {
+		return "", false, false, false
+	}
+	return info.OriginalURL, true, info.IsDeleted, info.Protected
+}
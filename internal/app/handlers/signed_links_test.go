@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/middleware"
+	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/achufistov/shortygopher.git/tests/testutils"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestSignedLinks(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	cfg.SignedLinkTTL = config.Duration{Duration: time.Hour}
+	testStorage := storage.NewURLStorage()
+	InitStorage(testStorage)
+	InitSignedLinks("test-secret")
+	t.Cleanup(func() { InitSignedLinks("") })
+
+	now := time.Now()
+	linkNow = func() time.Time { return now }
+	t.Cleanup(func() { linkNow = time.Now })
+
+	shorten := func(body string) ShortenResponse {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+		w := httptest.NewRecorder()
+		HandleShortenPost(cfg, w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("Expected status 201, got %d", w.Code)
+		}
+		var resp ShortenResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return resp
+	}
+	r := chi.NewRouter()
+	r.Get("/{id}", HandleGet)
+	r.Get("/api/expand/{id}", HandleExpand)
+	r.Get("/{id}/qr", HandleGetQR(cfg))
+	get := func(path string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	resp := shorten(`{"url":"https://example.com/private","protected":true}`)
+	if !strings.HasPrefix(resp.SignedURL, resp.ShortURL+"?token=") {
+		t.Fatalf("Expected a signed URL for %s, got %q", resp.ShortURL, resp.SignedURL)
+	}
+	signed, err := url.Parse(resp.SignedURL)
+	if err != nil {
+		t.Fatalf("Failed to parse signed URL: %v", err)
+	}
+	id := strings.TrimPrefix(signed.Path, "/")
+	token := signed.Query().Get("token")
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"valid token", "/" + id + "?token=" + token, http.StatusTemporaryRedirect},
+		{"missing token", "/" + id, http.StatusUnauthorized},
+		{"token of another URL", "/" + id + "?token=" + linkToken("other", now.Add(time.Hour)), http.StatusForbidden},
+		{"forged expiry", "/" + id + "?token=" + strings.Replace(token, ".", "9.", 1), http.StatusForbidden},
+		{"malformed token", "/" + id + "?token=garbage", http.StatusForbidden},
+		{"expand with valid token", "/api/expand/" + id + "?token=" + token, http.StatusOK},
+		{"expand without token", "/api/expand/" + id, http.StatusUnauthorized},
+		{"QR code with valid token", "/" + id + "/qr?token=" + token, http.StatusOK},
+		{"QR code without token", "/" + id + "/qr", http.StatusUnauthorized},
+		{"QR code with invalid token", "/" + id + "/qr?token=garbage", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := get(tt.path); code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, code)
+			}
+		})
+	}
+
+	t.Run("expired token", func(t *testing.T) {
+		linkNow = func() time.Time { return now.Add(time.Hour) }
+		defer func() { linkNow = func() time.Time { return now } }()
+		if code := get("/" + id + "?token=" + token); code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", code)
+		}
+	})
+
+	t.Run("deleted URL", func(t *testing.T) {
+		testStorage.AddURL("gone", "https://example.com/gone", "user1")
+		testStorage.SetProtected([]string{"gone"})
+		testStorage.DeleteURLs([]string{"gone"}, "user1")
+		// Deletion isn't revealed without a token
+		if code := get("/gone"); code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", code)
+		}
+		if code := get("/gone?token=" + linkToken("gone", now.Add(time.Minute))); code != http.StatusGone {
+			t.Errorf("Expected status 410, got %d", code)
+		}
+	})
+
+	t.Run("unprotected URL", func(t *testing.T) {
+		resp := shorten(`{"url":"https://example.com/public"}`)
+		if resp.SignedURL != "" {
+			t.Errorf("Expected no signed URL, got %q", resp.SignedURL)
+		}
+		if code := get(strings.TrimPrefix(resp.ShortURL, cfg.BaseURL)); code != http.StatusTemporaryRedirect {
+			t.Errorf("Expected status 307 without a token, got %d", code)
+		}
+	})
+}
+
+func TestSignedLinks_Disabled(t *testing.T) {
+	cfg := testutils.CreateTestConfigWithDefaults(t)
+	cfg.FileStorage = ""
+	InitStorage(storage.NewURLStorage())
+	InitSignedLinks("")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://example.com","protected":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, "user1"))
+	w := httptest.NewRecorder()
+	HandleShortenPost(cfg, w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
@@ -0,0 +1,159 @@
+// Package metrics defines the Prometheus metrics exported by the service.
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Registry holds all service metrics together with the Go runtime and process collectors.
+var Registry = prometheus.NewRegistry()
+
+var (
+	// HTTPRequestsTotal counts handled HTTP requests by method, route pattern and status code.
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_http_requests_total",
+		Help: "Total number of HTTP requests.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes HTTP request latencies by method and route pattern.
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shortener_http_request_duration_seconds",
+		Help:    "HTTP request latencies in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// HTTPRequestsByTierTotal counts handled HTTP requests by user tier, method,
+	// route pattern and status code. Only updated when tiers are configured.
+	HTTPRequestsByTierTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_http_tier_requests_total",
+		Help: "Total number of HTTP requests by user tier.",
+	}, []string{"tier", "method", "route", "status"})
+
+	// HTTPRequestDurationByTier observes HTTP request latencies by user tier, method
+	// and route pattern. Only updated when tiers are configured.
+	HTTPRequestDurationByTier = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shortener_http_tier_request_duration_seconds",
+		Help:    "HTTP request latencies in seconds by user tier.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"tier", "method", "route"})
+
+	// CircuitBreakerState reports the storage circuit breaker state:
+	// 0 closed, 1 half-open, 2 open.
+	CircuitBreakerState = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shortener_storage_circuit_breaker_state",
+		Help: "Storage circuit breaker state (0 closed, 1 half-open, 2 open).",
+	})
+
+	// RedirectsTotal counts short URL lookups by the resulting status code:
+	// 307 redirected, 200 redirected by an HTML page, 404 not found, 410 deleted,
+	// 503 storage unavailable.
+	// Counters are updated atomically, without locks.
+	RedirectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shortener_redirects_total",
+		Help: "Total number of short URL lookups by resulting status code.",
+	}, []string{"status"})
+
+	// GzipWritersActive reports the number of responses being compressed.
+	GzipWritersActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shortener_gzip_writers_active",
+		Help: "Number of responses being gzip-compressed.",
+	})
+
+	// DeleteQueueDepth reports the number of accepted deletions not applied yet.
+	DeleteQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "shortener_delete_queue_depth",
+		Help: "Number of accepted deletions not applied yet.",
+	})
+
+	// DeleteWorkerHealthy reports 1 while deletions are being applied and 0 if
+	// none was applied within the configured timeout while the queue is not
+	// empty; see SetDeleteWorkerHealthFunc.
+	DeleteWorkerHealthy = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "shortener_delete_worker_healthy",
+		Help: "Whether the delete worker makes progress (1) or is stalled (0).",
+	}, func() float64 {
+		if fn, ok := deleteWorkerHealth.Load().(func() bool); ok && !fn() {
+			return 0
+		}
+		return 1
+	})
+)
+
+// storageSize returns the number of stored URLs; set by SetStorageSizeFunc.
+var storageSize atomic.Value
+
+// deleteWorkerHealth reports whether the delete worker makes progress; set by
+// SetDeleteWorkerHealthFunc.
+var deleteWorkerHealth atomic.Value
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		HTTPRequestsByTierTotal,
+		HTTPRequestDurationByTier,
+		CircuitBreakerState,
+		RedirectsTotal,
+		GzipWritersActive,
+		DeleteQueueDepth,
+		DeleteWorkerHealthy,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "shortener_storage_urls",
+			Help: "Number of URLs in storage.",
+		}, func() float64 {
+			if fn, ok := storageSize.Load().(func() int); ok {
+				return float64(fn())
+			}
+			return 0
+		}),
+	)
+}
+
+// SetStorageSizeFunc sets the function reporting the number of stored URLs.
+// It is called on every scrape.
+func SetStorageSizeFunc(fn func() int) {
+	storageSize.Store(fn)
+}
+
+// SetDeleteWorkerHealthFunc sets the function reporting whether the delete
+// worker makes progress. It is called on every scrape.
+func SetDeleteWorkerHealthFunc(fn func() bool) {
+	deleteWorkerHealth.Store(fn)
+}
+
+// RedirectCounts returns the current values of RedirectsTotal by status code.
+// Status codes that were never counted are omitted.
+func RedirectCounts() map[string]int64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		RedirectsTotal.Collect(ch)
+		close(ch)
+	}()
+
+	counts := make(map[string]int64)
+	for metric := range ch {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		for _, label := range m.GetLabel() {
+			if label.GetName() == "status" {
+				counts[label.GetValue()] = int64(m.GetCounter().GetValue())
+			}
+		}
+	}
+	return counts
+}
+
+// Handler returns the HTTP handler serving metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
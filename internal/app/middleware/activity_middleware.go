@@ -0,0 +1,21 @@
+package middleware
+
+import "net/http"
+
+// ActivityRecorder records activity of users, see cleanup.Tracker.
+type ActivityRecorder interface {
+	Touch(userID string)
+}
+
+// ActivityMiddleware returns HTTP middleware that records activity of the
+// authenticated user with recorder. Must be registered after AuthMiddleware.
+func ActivityMiddleware(recorder ActivityRecorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if userID, ok := r.Context().Value(UserIDKey).(string); ok && userID != "" {
+				recorder.Touch(userID)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
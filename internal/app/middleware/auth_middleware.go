@@ -17,6 +17,20 @@ type contextKey string
 // Used by authentication middleware to pass user information between handlers.
 const UserIDKey contextKey = "userID"
 
+// issuedUserKey is the context key marking user IDs issued by AuthMiddleware for
+// the request itself, rather than taken from a valid token (see issuedUser).
+const issuedUserKey contextKey = "issuedUser"
+
+// authCookieName is the name of the cookie carrying the JWT auth token.
+const authCookieName = "auth_token"
+
+// issuedUser reports whether the user ID of r was issued for r by AuthMiddleware,
+// as is the case for every request of a client sending no valid token.
+func issuedUser(r *http.Request) bool {
+	issued, _ := r.Context().Value(issuedUserKey).(bool)
+	return issued
+}
+
 // AuthMiddleware returns HTTP middleware that handles JWT-based authentication.
 // Validates existing JWT tokens from cookies or creates new ones for unauthenticated users.
 // Sets user ID in request context for downstream handlers to access.
@@ -26,11 +40,12 @@ const UserIDKey contextKey = "userID"
 //   - Validates JWT token if present
 //   - Generates new JWT token and sets cookie for new users
 //   - Adds user ID to request context using UserIDKey
+//   - Records the token's tier claim, if any, for the request metrics (see SetUserTier)
 func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var userID string
-			cookie, err := r.Cookie("auth_token")
+			cookie, err := r.Cookie(authCookieName)
 
 			if err == nil {
 				token, err := jwt.Parse(cookie.Value, func(token *jwt.Token) (interface{}, error) {
@@ -45,15 +60,22 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 						if uid, ok := claims["user_id"].(string); ok {
 							userID = uid
 						}
+						if tier, ok := claims["tier"].(string); ok {
+							SetUserTier(r, tier)
+						}
 					}
 				}
 			}
 
 			if userID == "" {
+				ttl := cfg.TokenTTL.Duration
+				if ttl <= 0 {
+					ttl = config.DefaultTokenTTL
+				}
 				userID = uuid.NewString()
 				token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 					"user_id": userID,
-					"exp":     time.Now().Add(24 * time.Hour).Unix(),
+					"exp":     time.Now().Add(ttl).Unix(),
 				})
 
 				tokenString, err := token.SignedString([]byte(cfg.SecretKey))
@@ -63,12 +85,14 @@ func AuthMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
 				}
 
 				http.SetCookie(w, &http.Cookie{
-					Name:     "auth_token",
+					Name:     authCookieName,
 					Value:    tokenString,
 					Path:     "/",
 					HttpOnly: true,
-					MaxAge:   86400,
+					MaxAge:   int(ttl / time.Second),
 				})
+
+				r = r.WithContext(context.WithValue(r.Context(), issuedUserKey, true))
 			}
 
 			ctx := context.WithValue(r.Context(), UserIDKey, userID)
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+// maxBufferedResponseSize is the largest response body that is buffered.
+// Larger responses, such as exports, are streamed without Content-Length.
+const maxBufferedResponseSize = 256 << 10
+
+// bufferedResponseWriter holds the response in memory until the handler returns,
+// so that an accurate Content-Length can be sent. It switches to streaming once
+// the body grows beyond the limit or the handler flushes explicitly.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	buf       bytes.Buffer
+	status    int
+	limit     int
+	streaming bool
+}
+
+// WriteHeader records the status code until the response is sent.
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+// Write buffers b, or streams it if the buffer limit would be exceeded.
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.buf.Len()+len(b) > w.limit {
+		if err := w.startStreaming(); err != nil {
+			return 0, err
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	return w.buf.Write(b)
+}
+
+// Flush sends the buffered data and switches to streaming.
+func (w *bufferedResponseWriter) Flush() {
+	if !w.streaming {
+		if err := w.startStreaming(); err != nil {
+			return
+		}
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// startStreaming sends the status and the buffered data without Content-Length.
+func (w *bufferedResponseWriter) startStreaming() error {
+	w.streaming = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// finish sends the buffered response with Content-Length if it was not streamed.
+func (w *bufferedResponseWriter) finish(r *http.Request) {
+	if w.streaming {
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if bodyAllowed(w.status) && r.Method != http.MethodHead {
+		w.Header().Set("Content-Length", strconv.Itoa(w.buf.Len()))
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(w.buf.Bytes())
+}
+
+// bodyAllowed reports whether a response with the given status may carry a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// ResponseBufferingMiddleware returns HTTP middleware that buffers response bodies
+// to set an accurate Content-Length header. Does nothing unless cfg.BufferResponses is enabled.
+//
+// Bodies larger than 256 KiB, and responses the handler flushes explicitly,
+// are streamed without Content-Length.
+func ResponseBufferingMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.BufferResponses {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bw := &bufferedResponseWriter{ResponseWriter: w, limit: maxBufferedResponseSize}
+			next.ServeHTTP(bw, r)
+			bw.finish(r)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestResponseBufferingMiddleware(t *testing.T) {
+	small := []byte(`{"result":"http://localhost:8080/abc123"}`)
+	large := bytes.Repeat([]byte("a"), maxBufferedResponseSize+1)
+
+	tests := []struct {
+		name              string
+		enabled           bool
+		body              []byte
+		wantContentLength string
+	}{
+		{name: "small body is buffered", enabled: true, body: small, wantContentLength: strconv.Itoa(len(small))},
+		{name: "large body is streamed", enabled: true, body: large, wantContentLength: ""},
+		{name: "disabled", enabled: false, body: small, wantContentLength: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{BufferResponses: tt.enabled}
+			handler := ResponseBufferingMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				// Write in two chunks to exercise the limit check across writes
+				w.Write(tt.body[:len(tt.body)/2])
+				w.Write(tt.body[len(tt.body)/2:])
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusCreated {
+				t.Errorf("Expected status 201, got %d", w.Code)
+			}
+			if got := w.Header().Get("Content-Length"); got != tt.wantContentLength {
+				t.Errorf("Expected Content-Length %q, got %q", tt.wantContentLength, got)
+			}
+			if !bytes.Equal(w.Body.Bytes(), tt.body) {
+				t.Errorf("Expected body of %d bytes, got %d bytes", len(tt.body), w.Body.Len())
+			}
+		})
+	}
+}
+
+func TestResponseBufferingMiddleware_NoContent(t *testing.T) {
+	cfg := &config.Config{BufferResponses: true}
+	handler := ResponseBufferingMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/user/urls", nil))
+
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Expected no Content-Length for 204, got %q", got)
+	}
+}
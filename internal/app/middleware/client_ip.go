@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+// parseCIDRs parses the networks of cidrs. Invalid entries are skipped,
+// as the list is validated by config.LoadConfig.
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// inNetworks reports whether ip belongs to any of networks.
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClientIP parses an IPv4 or IPv6 address as found in client IP headers.
+// Besides plain addresses it accepts host:port forms, bracketed IPv6 addresses
+// ("[::1]", "[::1]:8080") and IPv6 zones ("fe80::1%eth0"), which are dropped.
+// Returns nil if s is not a valid address.
+func parseClientIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	} else if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// remoteIP returns the IP address of the connection's remote end.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP resolves the IP address of the client sending r. Client IP headers are
+// only trusted from proxies: behind proxies listed in proxies, it is resolved from
+// X-Forwarded-For (see forwardedClientIP), or taken from X-Real-IP when the
+// request comes from one of them. Otherwise it is the connection's remote address.
+// Returns nil if no valid address is found.
+func clientIP(r *http.Request, proxies []*net.IPNet) net.IP {
+	if ip := forwardedClientIP(r, proxies); ip != nil {
+		return ip
+	}
+	remote := parseClientIP(remoteIP(r))
+	if remote != nil && inNetworks(remote, proxies) {
+		if ip := parseClientIP(r.Header.Get("X-Real-IP")); ip != nil {
+			return ip
+		}
+	}
+	return remote
+}
+
+// ClientIP returns the IP address of the client sending r, trusting client IP
+// headers only from the proxies in cfg.ProxyCIDRs, see clientIP.
+// Returns nil if no valid address is found.
+func ClientIP(cfg *config.Config, r *http.Request) net.IP {
+	return clientIP(r, parseCIDRs(cfg.ProxyCIDRs))
+}
+
+// forwardedClientIP resolves the client IP from the X-Forwarded-For chain, followed by
+// the connection's remote address as the last hop. Addresses from proxies are skipped
+// from the right, so the result is the nearest address not belonging to a known proxy;
+// if every hop is a proxy, the leftmost one is returned.
+//
+// Returns nil if no proxies are configured, X-Forwarded-For is missing or
+// the chain contains an invalid address.
+func forwardedClientIP(r *http.Request, proxies []*net.IPNet) net.IP {
+	headers := r.Header.Values("X-Forwarded-For")
+	if len(proxies) == 0 || len(headers) == 0 {
+		return nil
+	}
+
+	var chain []net.IP
+	for _, header := range headers {
+		for _, hop := range strings.Split(header, ",") {
+			ip := parseClientIP(hop)
+			if ip == nil {
+				return nil
+			}
+			chain = append(chain, ip)
+		}
+	}
+	if ip := parseClientIP(remoteIP(r)); ip != nil {
+		chain = append(chain, ip)
+	}
+
+	for i := len(chain) - 1; i > 0; i-- {
+		if !inNetworks(chain[i], proxies) {
+			return chain[i]
+		}
+	}
+	return chain[0]
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestForwardedClientIP(t *testing.T) {
+	proxies := parseCIDRs([]string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16"})
+
+	tests := []struct {
+		name       string
+		proxies    []string
+		xff        []string
+		remoteAddr string
+		want       string
+	}{
+		{
+			name:       "private hops skipped",
+			xff:        []string{"203.0.113.7, 192.168.1.10, 10.1.2.3"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed leftmost entry ignored",
+			xff:        []string{"198.51.100.1, 203.0.113.7, 172.16.5.5"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "multiple headers",
+			xff:        []string{"203.0.113.7", "10.1.2.3"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "untrusted remote address",
+			xff:        []string{"203.0.113.7"},
+			remoteAddr: "198.51.100.9:1234",
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "all hops are proxies",
+			xff:        []string{"192.168.1.10, 10.1.2.3"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "192.168.1.10",
+		},
+		{
+			name:       "invalid hop",
+			xff:        []string{"203.0.113.7, unknown"},
+			remoteAddr: "10.0.0.1:1234",
+			want:       "<nil>",
+		},
+		{
+			name:       "no header",
+			remoteAddr: "10.0.0.1:1234",
+			want:       "<nil>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, xff := range tt.xff {
+				req.Header.Add("X-Forwarded-For", xff)
+			}
+
+			if got := forwardedClientIP(req, proxies).String(); got != tt.want {
+				t.Errorf("Expected client IP %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("no proxies configured", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		if ip := forwardedClientIP(req, nil); ip != nil {
+			t.Errorf("Expected X-Forwarded-For to be ignored, got %s", ip)
+		}
+	})
+}
+
+func TestParseClientIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "203.0.113.7", want: "203.0.113.7"},
+		{in: " 203.0.113.7 ", want: "203.0.113.7"},
+		{in: "203.0.113.7:8080", want: "203.0.113.7"},
+		{in: "2001:db8::1", want: "2001:db8::1"},
+		{in: "[2001:db8::1]", want: "2001:db8::1"},
+		{in: "[2001:db8::1]:8080", want: "2001:db8::1"},
+		{in: "fe80::1%eth0", want: "fe80::1"},
+		{in: "[fe80::1%eth0]:8080", want: "fe80::1"},
+		{in: "not-an-ip", want: "<nil>"},
+		{in: "[not-an-ip]", want: "<nil>"},
+		{in: "", want: "<nil>"},
+	}
+	for _, tt := range tests {
+		if got := parseClientIP(tt.in).String(); got != tt.want {
+			t.Errorf("parseClientIP(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimitMiddleware_ForwardedFor(t *testing.T) {
+	cfg := &config.Config{RateLimitRPS: 1, RateLimitBurst: 1, ProxyCIDRs: []string{"10.0.0.0/8"}}
+	handler := RateLimitMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(xff string) int {
+		req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set("X-Forwarded-For", xff)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Clients behind the same proxy get their own buckets
+	if code := send("203.0.113.7, 10.1.2.3"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if code := send("203.0.113.8, 10.1.2.3"); code != http.StatusOK {
+		t.Errorf("Expected status 200 for another client, got %d", code)
+	}
+	if code := send("203.0.113.7, 10.4.5.6"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 for the same client via another proxy, got %d", code)
+	}
+}
+
+func TestTrustedSubnetMiddleware_ForwardedFor(t *testing.T) {
+	cfg := &config.Config{TrustedSubnet: "203.0.113.0/24", ProxyCIDRs: []string{"10.0.0.0/8"}}
+	handler := TrustedSubnetMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name string
+		xff  string
+		want int
+	}{
+		{name: "client inside subnet", xff: "203.0.113.7, 10.1.2.3", want: http.StatusOK},
+		{name: "client outside subnet", xff: "198.51.100.1, 10.1.2.3", want: http.StatusForbidden},
+		{name: "spoofed entry behind real client", xff: "203.0.113.7, 198.51.100.1, 10.1.2.3", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			req.Header.Set("X-Forwarded-For", tt.xff)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
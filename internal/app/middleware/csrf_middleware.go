@@ -0,0 +1,95 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+const (
+	// CSRFCookieName is the name of the cookie carrying the CSRF token.
+	CSRFCookieName = "csrf_token"
+
+	// CSRFHeaderName is the request header that must echo the CSRF cookie value.
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// CSRFMiddleware returns HTTP middleware implementing double-submit CSRF protection.
+// Does nothing unless cfg.CSRFProtection is enabled.
+//
+// The middleware:
+//   - Issues a csrf_token cookie to clients that don't have one yet
+//   - Requires POST and DELETE requests authenticated by the auth_token cookie
+//     to send the same token in the X-CSRF-Token header
+//   - Skips the check for requests authenticated with a bearer token, since
+//     those credentials are not attached by the browser automatically
+//   - Responds with 403 Forbidden when the token is missing or doesn't match
+func CSRFMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.CSRFProtection {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var csrfToken string
+			if cookie, err := r.Cookie(CSRFCookieName); err == nil {
+				csrfToken = cookie.Value
+			}
+
+			if requiresCSRFCheck(r) {
+				headerToken := r.Header.Get(CSRFHeaderName)
+				if csrfToken == "" || headerToken == "" ||
+					subtle.ConstantTimeCompare([]byte(csrfToken), []byte(headerToken)) != 1 {
+					http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+					return
+				}
+			}
+
+			if csrfToken == "" {
+				token, err := generateCSRFToken()
+				if err != nil {
+					http.Error(w, "Failed to generate CSRF token", http.StatusInternalServerError)
+					return
+				}
+
+				// The cookie is intentionally readable from JavaScript so that
+				// browser clients can copy it into the X-CSRF-Token header.
+				http.SetCookie(w, &http.Cookie{
+					Name:     CSRFCookieName,
+					Value:    token,
+					Path:     "/",
+					SameSite: http.SameSiteStrictMode,
+					MaxAge:   86400,
+				})
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// requiresCSRFCheck reports whether the request is state-changing and carries
+// cookie-based credentials that a browser could have attached on its own.
+func requiresCSRFCheck(r *http.Request) bool {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return false
+	}
+	if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		return false
+	}
+	_, err := r.Cookie(authCookieName)
+	return err == nil
+}
+
+// generateCSRFToken returns a random hex-encoded token.
+func generateCSRFToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
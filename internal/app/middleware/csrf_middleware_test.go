@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func newCSRFTestHandler(cfg *config.Config, called *bool) http.Handler {
+	return CSRFMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*called = true
+		w.WriteHeader(http.StatusCreated)
+	}))
+}
+
+func TestCSRFMiddleware_RejectsInvalidToken(t *testing.T) {
+	cfg := &config.Config{CSRFProtection: true}
+
+	tests := []struct {
+		name        string
+		cookieToken string
+		headerToken string
+	}{
+		{name: "missing cookie and header"},
+		{name: "missing header", cookieToken: "token-a"},
+		{name: "missing cookie", headerToken: "token-a"},
+		{name: "mismatched token", cookieToken: "token-a", headerToken: "token-b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			called := false
+			handler := newCSRFTestHandler(cfg, &called)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: "jwt"})
+			if tt.cookieToken != "" {
+				req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: tt.cookieToken})
+			}
+			if tt.headerToken != "" {
+				req.Header.Set(CSRFHeaderName, tt.headerToken)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != http.StatusForbidden {
+				t.Errorf("Expected status 403, got %d", w.Code)
+			}
+			if called {
+				t.Error("Expected next handler not to be called")
+			}
+		})
+	}
+}
+
+func TestCSRFMiddleware_AcceptsMatchingToken(t *testing.T) {
+	cfg := &config.Config{CSRFProtection: true}
+	called := false
+	handler := newCSRFTestHandler(cfg, &called)
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/user/urls", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: "jwt"})
+	req.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: "token-a"})
+	req.Header.Set(CSRFHeaderName, "token-a")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected status 201, got %d", w.Code)
+	}
+	if !called {
+		t.Error("Expected next handler to be called")
+	}
+}
+
+func TestCSRFMiddleware_SkipsBearerAuth(t *testing.T) {
+	cfg := &config.Config{CSRFProtection: true}
+	called := false
+	handler := newCSRFTestHandler(cfg, &called)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+	req.Header.Set("Authorization", "Bearer some-token")
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if !called {
+		t.Error("Expected next handler to be called for bearer auth")
+	}
+}
+
+func TestCSRFMiddleware_IssuesTokenCookie(t *testing.T) {
+	cfg := &config.Config{CSRFProtection: true}
+	called := false
+	handler := newCSRFTestHandler(cfg, &called)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	result := w.Result()
+	defer result.Body.Close()
+
+	var csrfCookie *http.Cookie
+	for _, cookie := range result.Cookies() {
+		if cookie.Name == CSRFCookieName {
+			csrfCookie = cookie
+		}
+	}
+	if csrfCookie == nil || csrfCookie.Value == "" {
+		t.Fatal("Expected csrf_token cookie to be set")
+	}
+	if csrfCookie.HttpOnly {
+		t.Error("Expected csrf_token cookie to be readable by clients")
+	}
+}
+
+func TestCSRFMiddleware_Disabled(t *testing.T) {
+	cfg := &config.Config{}
+	called := false
+	handler := newCSRFTestHandler(cfg, &called)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+	req.AddCookie(&http.Cookie{Name: authCookieName, Value: "jwt"})
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if !called {
+		t.Error("Expected next handler to be called when CSRF protection is disabled")
+	}
+}
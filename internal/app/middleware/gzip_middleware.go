@@ -1,18 +1,67 @@
 package middleware
 
 import (
+	"bufio"
+	"compress/flate"
 	"compress/gzip"
 	"io"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
+	"github.com/andybalholm/brotli"
 )
 
-var gzipWriterPool = sync.Pool{
-	New: func() interface{} {
-		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
-		return w
-	},
+// gzipWriterPools holds a pool of gzip writers per compression level.
+var gzipWriterPools sync.Map
+
+// gzipWriterPool returns the pool of gzip writers compressing at level;
+// 0 selects gzip.DefaultCompression, like config.Config.GzipLevel.
+func gzipWriterPool(level int) *sync.Pool {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if pool, ok := gzipWriterPools.Load(level); ok {
+		return pool.(*sync.Pool)
+	}
+	pool, _ := gzipWriterPools.LoadOrStore(level, &sync.Pool{
+		New: func() interface{} {
+			// The level is validated by config.LoadConfig; an invalid one
+			// falls back to the default compression.
+			w, err := gzip.NewWriterLevel(io.Discard, level)
+			if err != nil {
+				w, _ = gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+			}
+			return w
+		},
+	})
+	return pool.(*sync.Pool)
+}
+
+// activeGzipWriters is the number of gzip writers taken from the pool and not yet returned.
+var activeGzipWriters atomic.Int64
+
+// acquireGzipWriter takes a gzip writer from pool, unless maxWriters (if positive)
+// are already in use; it then returns nil and the response is served uncompressed.
+func acquireGzipWriter(pool *sync.Pool, maxWriters int) *gzip.Writer {
+	active := activeGzipWriters.Add(1)
+	if maxWriters > 0 && active > int64(maxWriters) {
+		activeGzipWriters.Add(-1)
+		return nil
+	}
+	metrics.GzipWritersActive.Inc()
+	return pool.Get().(*gzip.Writer)
+}
+
+// releaseGzipWriter returns a gzip writer taken by acquireGzipWriter to pool.
+func releaseGzipWriter(pool *sync.Pool, gz *gzip.Writer) {
+	gz.Reset(io.Discard)
+	pool.Put(gz)
+	activeGzipWriters.Add(-1)
+	metrics.GzipWritersActive.Dec()
 }
 
 // gzipResponseWriter wraps http.ResponseWriter to provide gzip compression functionality.
@@ -21,18 +70,22 @@ type gzipResponseWriter struct {
 	http.ResponseWriter
 	gzWriter   *gzip.Writer
 	shouldGzip bool
+	pool       *sync.Pool
+	maxWriters int
 }
 
 // WriteHeader writes the HTTP status code and sets up gzip compression if needed.
 // Configures compression headers and initializes gzip writer from pool.
+// When the writer cap is reached the response is served uncompressed.
 func (w *gzipResponseWriter) WriteHeader(statusCode int) {
 	contentType := w.Header().Get("Content-Type")
 	if w.shouldGzip && shouldCompress(contentType) {
-		w.Header().Set("Content-Encoding", "gzip")
 		w.Header().Add("Vary", "Accept-Encoding")
-
-		w.gzWriter = gzipWriterPool.Get().(*gzip.Writer)
-		w.gzWriter.Reset(w.ResponseWriter)
+		if gz := acquireGzipWriter(w.pool, w.maxWriters); gz != nil {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.gzWriter = gz
+			w.gzWriter.Reset(w.ResponseWriter)
+		}
 	}
 	w.ResponseWriter.WriteHeader(statusCode)
 }
@@ -52,8 +105,7 @@ func (w *gzipResponseWriter) Close() {
 	if w.gzWriter != nil {
 		w.gzWriter.Close()
 
-		w.gzWriter.Reset(io.Discard)
-		gzipWriterPool.Put(w.gzWriter)
+		releaseGzipWriter(w.pool, w.gzWriter)
 		w.gzWriter = nil
 	}
 }
@@ -78,40 +130,120 @@ func shouldCompress(contentType string) bool {
 	return false
 }
 
+// decompressedBody wraps a decompressing reader so that closing it also closes
+// the decoder (when it has one) and the original request body.
+type decompressedBody struct {
+	io.Reader
+	decoder io.Closer
+	body    io.Closer
+}
+
+// Close releases the decoder and the underlying request body.
+func (b *decompressedBody) Close() error {
+	if b.decoder != nil {
+		b.decoder.Close()
+	}
+	return b.body.Close()
+}
+
+// compressedRequest reports whether the request body declares a Content-Encoding
+// that would be decompressed.
+func compressedRequest(r *http.Request) bool {
+	encoding := r.Header.Get("Content-Encoding")
+	return strings.Contains(encoding, "gzip") || strings.Contains(encoding, "deflate") || strings.Contains(encoding, "br")
+}
+
+// newRequestDecoder returns a reader that decompresses body according to the
+// Content-Encoding header value. Returns nil if the encoding is not supported.
+func newRequestDecoder(contentEncoding string, body io.ReadCloser) (io.ReadCloser, error) {
+	switch {
+	case strings.Contains(contentEncoding, "gzip"):
+		gzReader, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		return &decompressedBody{Reader: gzReader, decoder: gzReader, body: body}, nil
+	case strings.Contains(contentEncoding, "deflate"):
+		flateReader := flate.NewReader(body)
+		return &decompressedBody{Reader: flateReader, decoder: flateReader, body: body}, nil
+	case strings.Contains(contentEncoding, "br"):
+		return &decompressedBody{Reader: brotli.NewReader(body), body: body}, nil
+	}
+	return nil, nil
+}
+
+// decompressRequestBody replaces r.Body with a decompressing reader when the request
+// declares a supported Content-Encoding and returns the new body so the caller can close it.
+// Deflate and brotli streams have no header to validate upfront, so the first bytes are
+// decoded eagerly to reject malformed bodies before they reach the handlers.
+func decompressRequestBody(r *http.Request) (io.Closer, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+
+	decoder, err := newRequestDecoder(r.Header.Get("Content-Encoding"), r.Body)
+	if err != nil || decoder == nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(decoder)
+	if _, err := buffered.Peek(1); err != nil && err != io.EOF {
+		decoder.Close()
+		return nil, err
+	}
+
+	r.Body = &decompressedBody{Reader: buffered, body: decoder}
+	return r.Body, nil
+}
+
 // GzipMiddleware returns HTTP middleware that handles gzip compression for both requests and responses.
-// Automatically decompresses incoming gzip requests and compresses outgoing responses when supported.
+// Decompresses incoming gzip, deflate and brotli requests and compresses outgoing responses when supported.
 //
 // Features:
-//   - Decompresses incoming requests with Content-Encoding: gzip
+//   - Decompresses incoming requests with Content-Encoding: gzip, deflate or br,
+//     unless cfg.DecompressRequests is false; such requests then get 415 Unsupported Media Type
 //   - Compresses responses for clients that Accept-Encoding: gzip
 //   - Uses sync.Pool for efficient gzip writer reuse
+//   - Compresses at cfg.GzipLevel
+//   - Serves responses uncompressed while cfg.MaxGzipWriters responses are being compressed
 //   - Supports text/plain, application/json, and other compressible content types
 //   - Handles application/x-gzip content type conversion
-func GzipMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// process incoming gzip
-		if strings.Contains(r.Header.Get("Content-Encoding"), "gzip") {
-			gzReader, err := gzip.NewReader(r.Body)
-			if err != nil {
-				http.Error(w, "Invalid gzip body", http.StatusBadRequest)
-				return
+func GzipMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	pool := gzipWriterPool(cfg.GzipLevel)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// process incoming compressed bodies
+			if !cfg.DecompressRequests {
+				if compressedRequest(r) {
+					http.Error(w, "Compressed request bodies are not supported", http.StatusUnsupportedMediaType)
+					return
+				}
+			} else {
+				body, err := decompressRequestBody(r)
+				if err != nil {
+					http.Error(w, "Invalid compressed body", http.StatusBadRequest)
+					return
+				}
+				if body != nil {
+					defer body.Close()
+				}
 			}
-			defer gzReader.Close()
-			r.Body = gzReader
-		}
 
-		if r.Header.Get("Content-Type") == "application/x-gzip" {
-			r.Header.Set("Content-Type", "text/plain")
-		}
+			if r.Header.Get("Content-Type") == "application/x-gzip" {
+				r.Header.Set("Content-Type", "text/plain")
+			}
 
-		acceptsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
+			acceptsGzip := strings.Contains(r.Header.Get("Accept-Encoding"), "gzip")
 
-		gzw := &gzipResponseWriter{
-			ResponseWriter: w,
-			shouldGzip:     acceptsGzip,
-		}
-		defer gzw.Close()
+			gzw := &gzipResponseWriter{
+				ResponseWriter: w,
+				shouldGzip:     acceptsGzip,
+				pool:           pool,
+				maxWriters:     cfg.MaxGzipWriters,
+			}
+			defer gzw.Close()
 
-		next.ServeHTTP(gzw, r)
-	})
+			next.ServeHTTP(gzw, r)
+		})
+	}
 }
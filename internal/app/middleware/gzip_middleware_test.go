@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestGzipMiddleware_DecompressRequests(t *testing.T) {
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte(`{"url":"https://example.com"}`))
+	gz.Close()
+
+	tests := []struct {
+		name       string
+		decompress bool
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "enabled", decompress: true, wantStatus: http.StatusOK, wantBody: `{"url":"https://example.com"}`},
+		{name: "disabled", decompress: false, wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received string
+			handler := GzipMiddleware(&config.Config{DecompressRequests: tt.decompress})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received = string(body)
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(compressed.Bytes()))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if received != tt.wantBody {
+				t.Errorf("Expected handler to receive %q, got %q", tt.wantBody, received)
+			}
+		})
+	}
+
+	t.Run("disabled with plain body", func(t *testing.T) {
+		handler := GzipMiddleware(&config.Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}))
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader([]byte("https://example.com")))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected uncompressed request to pass, got %d", w.Code)
+		}
+	})
+}
+
+func TestGzipMiddleware_MaxGzipWriters(t *testing.T) {
+	const requests, maxWriters = 8, 3
+
+	// Every handler holds its response open until all of them have started,
+	// so all responses compete for a gzip writer at once
+	var started sync.WaitGroup
+	started.Add(requests)
+	release := make(chan struct{})
+	handler := GzipMiddleware(&config.Config{MaxGzipWriters: maxWriters})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		started.Done()
+		<-release
+		w.Write([]byte(strings.Repeat(`{"result":"http://localhost:8080/abc"}`, 100)))
+	}))
+
+	recorders := make([]*httptest.ResponseRecorder, requests)
+	var done sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		done.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer done.Done()
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			handler.ServeHTTP(w, req)
+		}(recorders[i])
+	}
+	started.Wait()
+	close(release)
+	done.Wait()
+
+	compressed := 0
+	for _, w := range recorders {
+		if w.Header().Get("Content-Encoding") != "gzip" {
+			if !strings.HasPrefix(w.Body.String(), `{"result"`) {
+				t.Errorf("Expected an uncompressed body, got %q", w.Body.String())
+			}
+			continue
+		}
+		compressed++
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("Failed to read compressed body: %v", err)
+		}
+		if _, err := io.ReadAll(gz); err != nil {
+			t.Errorf("Failed to decompress body: %v", err)
+		}
+	}
+	if compressed != maxWriters {
+		t.Errorf("Expected %d compressed responses, got %d", maxWriters, compressed)
+	}
+	if active := activeGzipWriters.Load(); active != 0 {
+		t.Errorf("Expected all gzip writers to be released, %d still active", active)
+	}
+}
+
+func TestGzipMiddleware_GzipLevel(t *testing.T) {
+	body := strings.Repeat(`{"result":"http://localhost:8080/abc"}`, 100)
+	tests := []struct {
+		name  string
+		level int
+		// wantXFL is the extra flags byte of the gzip header, which records
+		// whether the fastest or the best compression was used
+		wantXFL byte
+	}{
+		{name: "default", level: 0, wantXFL: 0},
+		{name: "best speed", level: gzip.BestSpeed, wantXFL: 4},
+		{name: "best compression", level: gzip.BestCompression, wantXFL: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := GzipMiddleware(&config.Config{GzipLevel: tt.level})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(body))
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/user/urls", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Header().Get("Content-Encoding") != "gzip" {
+				t.Fatal("Expected a gzip-encoded response")
+			}
+			compressed := w.Body.Bytes()
+			if len(compressed) < 10 || compressed[8] != tt.wantXFL {
+				t.Errorf("Expected gzip header extra flags %d, got header %v", tt.wantXFL, compressed[:min(len(compressed), 10)])
+			}
+			gz, err := gzip.NewReader(w.Body)
+			if err != nil {
+				t.Fatalf("Failed to read compressed body: %v", err)
+			}
+			decompressed, err := io.ReadAll(gz)
+			if err != nil {
+				t.Fatalf("Failed to decompress body: %v", err)
+			}
+			if string(decompressed) != body {
+				t.Errorf("Expected the body to round-trip, got %q", decompressed)
+			}
+		})
+	}
+}
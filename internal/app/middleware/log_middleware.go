@@ -1,7 +1,12 @@
 package middleware
 
 import (
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/zap"
@@ -35,6 +40,80 @@ func LoggingMiddleware(logger *zap.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// clfTimeLayout is the timestamp layout of the Common Log Format.
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// CommonLogMiddleware returns HTTP middleware that writes one access log line
+// per request to out in the Common Log Format used by Apache and NGINX:
+//
+//	host ident authuser [date] "request" status bytes
+//
+// The ident and authuser fields are always "-", and bytes is "-" for empty responses.
+func CommonLogMiddleware(out io.Writer) func(http.Handler) http.Handler {
+	return accessLogMiddleware(out, func(r *http.Request, status, size int, start time.Time) string {
+		return formatCommonLog(r, status, size, start) + "\n"
+	})
+}
+
+// CombinedLogMiddleware returns HTTP middleware that writes one access log line
+// per request to out in the Combined Log Format used by Apache and NGINX,
+// extended with the request duration in seconds:
+//
+//	host ident authuser [date] "request" status bytes "referer" "user-agent" duration
+//
+// Fields are as in CommonLogMiddleware; a missing referer or user agent is "-".
+func CombinedLogMiddleware(out io.Writer) func(http.Handler) http.Handler {
+	return accessLogMiddleware(out, func(r *http.Request, status, size int, start time.Time) string {
+		return fmt.Sprintf("%s %s %s %.3f\n", formatCommonLog(r, status, size, start),
+			quoteLogField(r.Referer()), quoteLogField(r.UserAgent()), time.Since(start).Seconds())
+	})
+}
+
+// accessLogMiddleware returns HTTP middleware that writes the line format returns
+// for every request to out, once the response is complete.
+func accessLogMiddleware(out io.Writer, format func(r *http.Request, status, size int, start time.Time) string) func(http.Handler) http.Handler {
+	var mu sync.Mutex
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			line := format(r, rw.status, rw.size, start)
+			mu.Lock()
+			defer mu.Unlock()
+			io.WriteString(out, line)
+		})
+	}
+}
+
+// formatCommonLog formats a Common Log Format line, without the trailing newline.
+func formatCommonLog(r *http.Request, status, size int, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	bytes := "-"
+	if size > 0 {
+		bytes = fmt.Sprint(size)
+	}
+	return fmt.Sprintf("%s - - [%s] \"%s %s %s\" %d %s",
+		host, start.Format(clfTimeLayout), r.Method, r.RequestURI, r.Proto, status, bytes)
+}
+
+// quoteLogField quotes a request header value for an access log line, escaping
+// quotes and control characters so the line stays parseable. Empty values are "-".
+func quoteLogField(value string) string {
+	if value == "" {
+		return `"-"`
+	}
+	return strconv.Quote(value)
+}
+
 // responseWriter wraps http.ResponseWriter to capture response status and size.
 // Used by logging middleware to record response metadata.
 type responseWriter struct {
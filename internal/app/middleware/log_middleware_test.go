@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestCommonLogMiddleware(t *testing.T) {
+	clfLine := regexp.MustCompile(`^(\S+) - - \[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "([A-Z]+) (\S+) (HTTP/\d\.\d)" (\d{3}) (\d+|-)\n$`)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		handler    http.HandlerFunc
+		wantStatus string
+		wantBytes  string
+	}{
+		{
+			name:   "response with body",
+			method: http.MethodPost,
+			target: "/api/shorten?x=1",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("hello"))
+			},
+			wantStatus: "201",
+			wantBytes:  "5",
+		},
+		{
+			name:       "empty response",
+			method:     http.MethodGet,
+			target:     "/abc123",
+			handler:    func(w http.ResponseWriter, r *http.Request) {},
+			wantStatus: "200",
+			wantBytes:  "-",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			handler := CommonLogMiddleware(&out)(tt.handler)
+
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.RemoteAddr = "192.0.2.10:54321"
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			match := clfLine.FindStringSubmatch(out.String())
+			if match == nil {
+				t.Fatalf("Expected a Common Log Format line, got %q", out.String())
+			}
+			if match[1] != "192.0.2.10" {
+				t.Errorf("Expected host 192.0.2.10, got %s", match[1])
+			}
+			if match[3] != tt.method || match[4] != tt.target {
+				t.Errorf("Expected request %s %s, got %s %s", tt.method, tt.target, match[3], match[4])
+			}
+			if match[6] != tt.wantStatus {
+				t.Errorf("Expected status %s, got %s", tt.wantStatus, match[6])
+			}
+			if match[7] != tt.wantBytes {
+				t.Errorf("Expected bytes %s, got %s", tt.wantBytes, match[7])
+			}
+		})
+	}
+}
+
+func TestCombinedLogMiddleware(t *testing.T) {
+	combinedLine := regexp.MustCompile(`^(\S+) - - \[(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "([A-Z]+) (\S+) (HTTP/\d\.\d)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)" (\d+\.\d{3})\n$`)
+
+	var out bytes.Buffer
+	handler := CombinedLogMiddleware(&out)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTemporaryRedirect)
+		w.Write([]byte("moved"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+	req.RemoteAddr = "192.0.2.10:54321"
+	req.Header.Set("User-Agent", `curl/8.0 "quoted"`)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	match := combinedLine.FindStringSubmatch(out.String())
+	if match == nil {
+		t.Fatalf("Expected a Combined Log Format line, got %q", out.String())
+	}
+	if match[1] != "192.0.2.10" {
+		t.Errorf("Expected host 192.0.2.10, got %s", match[1])
+	}
+	if match[3] != http.MethodGet || match[4] != "/abc123" {
+		t.Errorf("Expected request GET /abc123, got %s %s", match[3], match[4])
+	}
+	if match[6] != "307" || match[7] != "5" {
+		t.Errorf("Expected status 307 and 5 bytes, got %s and %s", match[6], match[7])
+	}
+	if match[8] != "-" {
+		t.Errorf("Expected no referer, got %s", match[8])
+	}
+	if match[9] != `curl/8.0 \"quoted\"` {
+		t.Errorf("Expected the escaped user agent, got %s", match[9])
+	}
+}
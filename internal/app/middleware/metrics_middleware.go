@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
+	"github.com/go-chi/chi/v5"
+)
+
+// UserTierHeader is the request header carrying the user's tier, set by a gateway
+// in front of the service. A tier claim of the auth token takes precedence.
+const UserTierHeader = "X-User-Tier"
+
+// OtherTier labels the metrics of requests whose tier isn't configured.
+const OtherTier = "other"
+
+// userTierKey is the context key of the tier recorded by SetUserTier.
+const userTierKey contextKey = "userTier"
+
+// SetUserTier records the tier of the request's user for the request metrics.
+// Middleware running inside MetricsMiddlewareWithTiers calls it once the user
+// is known, e.g. from a token claim.
+func SetUserTier(r *http.Request, tier string) {
+	if recorded, ok := r.Context().Value(userTierKey).(*string); ok {
+		*recorded = tier
+	}
+}
+
+// MetricsMiddleware records request totals and latencies in Prometheus metrics.
+// Requests are labeled with the chi route pattern (e.g. "/{id}") rather than the raw
+// path to keep label cardinality bounded; unmatched requests are labeled "unmatched".
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return MetricsMiddlewareWithTiers(&config.Config{})(next)
+}
+
+// MetricsMiddlewareWithTiers works like MetricsMiddleware and, when cfg.MetricsTiers
+// is set, also counts requests by the user's tier. The tier is taken from SetUserTier
+// or the UserTierHeader; tiers not listed in cfg.MetricsTiers, including a missing
+// one, are labeled OtherTier.
+func MetricsMiddlewareWithTiers(cfg *config.Config) func(http.Handler) http.Handler {
+	tiers := make(map[string]bool, len(cfg.MetricsTiers))
+	for _, tier := range cfg.MetricsTiers {
+		tiers[tier] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			var tier string
+			if len(tiers) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), userTierKey, &tier))
+			}
+
+			rw := &responseWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			elapsed := time.Since(start).Seconds()
+			metrics.HTTPRequestsTotal.WithLabelValues(r.Method, route, strconv.Itoa(status)).Inc()
+			metrics.HTTPRequestDuration.WithLabelValues(r.Method, route).Observe(elapsed)
+
+			if len(tiers) == 0 {
+				return
+			}
+			if tier == "" {
+				tier = r.Header.Get(UserTierHeader)
+			}
+			if !tiers[tier] {
+				tier = OtherTier
+			}
+			metrics.HTTPRequestsByTierTotal.WithLabelValues(tier, r.Method, route, strconv.Itoa(status)).Inc()
+			metrics.HTTPRequestDurationByTier.WithLabelValues(tier, r.Method, route).Observe(elapsed)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v5"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// tierRequests returns the number of requests to GET /tiered counted for tier.
+func tierRequests(t *testing.T, tier string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.HTTPRequestsByTierTotal.WithLabelValues(tier, http.MethodGet, "/tiered", "200").Write(&m); err != nil {
+		t.Fatalf("Failed to read counter: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestMetricsMiddlewareWithTiers(t *testing.T) {
+	cfg := &config.Config{SecretKey: "secret", MetricsTiers: []string{"free", "pro"}}
+
+	r := chi.NewRouter()
+	r.Use(MetricsMiddlewareWithTiers(cfg))
+	r.Use(AuthMiddleware(cfg))
+	r.Get("/tiered", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Counters are global, so compare against the values before the requests
+	before := map[string]float64{}
+	for _, tier := range []string{"free", "pro", OtherTier, "enterprise"} {
+		before[tier] = tierRequests(t, tier)
+	}
+
+	send := func(tierHeader string, cookie *http.Cookie) {
+		req := httptest.NewRequest(http.MethodGet, "/tiered", nil)
+		if tierHeader != "" {
+			req.Header.Set(UserTierHeader, tierHeader)
+		}
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	send("free", nil)
+	send("free", nil)
+	send("pro", nil)
+	send("enterprise", nil)
+	send("", nil)
+
+	// A tier claim of the auth token takes precedence over the header
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": "user1",
+		"tier":    "pro",
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(cfg.SecretKey))
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	send("free", &http.Cookie{Name: authCookieName, Value: token})
+
+	expected := map[string]float64{"free": 2, "pro": 2, OtherTier: 2, "enterprise": 0}
+	for tier, delta := range expected {
+		if got := tierRequests(t, tier) - before[tier]; got != delta {
+			t.Errorf("Expected %v more requests of tier %q, got %v", delta, tier, got)
+		}
+	}
+}
+
+func TestMetricsMiddlewareWithTiers_Disabled(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(MetricsMiddlewareWithTiers(&config.Config{}))
+	r.Get("/tiered", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	before := tierRequests(t, "free") + tierRequests(t, OtherTier)
+	req := httptest.NewRequest(http.MethodGet, "/tiered", nil)
+	req.Header.Set(UserTierHeader, "free")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := tierRequests(t, "free") + tierRequests(t, OtherTier) - before; got != 0 {
+		t.Errorf("Expected no tier metrics without configured tiers, got %v", got)
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/go-chi/chi/v5"
+)
+
+// allowMethods are the methods probed when listing the methods a route supports,
+// in the order they are listed in the Allow header.
+var allowMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// OptionsMiddleware returns HTTP middleware answering OPTIONS requests on every
+// route of routes with 204 No Content and an Allow header listing the methods
+// the route supports, e.g. "POST, OPTIONS". Requests to unknown routes are passed
+// on and end up as 404. It does nothing unless cfg.AnswerOptions is set.
+//
+// Must be registered with the router's Use, passing the router itself.
+func OptionsMiddleware(cfg *config.Config, routes chi.Routes) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.AnswerOptions {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			path := r.URL.Path
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+				path = rctx.RoutePath
+			}
+
+			var allowed []string
+			for _, method := range allowMethods {
+				if routes.Match(chi.NewRouteContext(), method, path) {
+					allowed = append(allowed, method)
+				}
+			}
+			if len(allowed) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Allow", strings.Join(append(allowed, http.MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
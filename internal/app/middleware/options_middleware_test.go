@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestOptionsMiddleware(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	newRouter := func(cfg *config.Config) chi.Router {
+		r := chi.NewRouter()
+		r.Use(OptionsMiddleware(cfg, r))
+		r.Post("/api/shorten", ok)
+		r.Get("/api/user/urls", ok)
+		r.Delete("/api/user/urls", ok)
+		r.Get("/{id}", ok)
+		r.Head("/{id}", ok)
+		return r
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedAllow  string
+	}{
+		{name: "POST route", path: "/api/shorten", expectedStatus: http.StatusNoContent, expectedAllow: "POST, OPTIONS"},
+		{name: "Several methods", path: "/api/user/urls", expectedStatus: http.StatusNoContent, expectedAllow: "GET, DELETE, OPTIONS"},
+		{name: "Route with parameter", path: "/abc123", expectedStatus: http.StatusNoContent, expectedAllow: "GET, HEAD, OPTIONS"},
+		{name: "Unknown route", path: "/api/unknown/route", expectedStatus: http.StatusNotFound},
+	}
+
+	r := newRouter(&config.Config{AnswerOptions: true})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodOptions, tt.path, nil))
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if allow := w.Header().Get("Allow"); allow != tt.expectedAllow {
+				t.Errorf("Expected Allow %q, got %q", tt.expectedAllow, allow)
+			}
+		})
+	}
+
+	t.Run("Other methods are passed on", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status 200, got %d", w.Code)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		newRouter(&config.Config{}).ServeHTTP(w, httptest.NewRequest(http.MethodOptions, "/api/shorten", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected status 405 when disabled, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"container/list"
+	"encoding/json"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"golang.org/x/time/rate"
+)
+
+// RateLimitedResponse is the JSON body of 429 Too Many Requests responses.
+//
+// Example JSON:
+//
+//	{
+//	  "error": "rate_limited",
+//	  "retry_after": 1
+//	}
+type RateLimitedResponse struct {
+	// Error is always "rate_limited"
+	Error string `json:"error"`
+	// RetryAfter is the number of seconds to wait, as in the Retry-After header
+	RetryAfter int `json:"retry_after"`
+}
+
+// maxRateLimitKeys bounds the number of clients tracked by the rate limiter.
+// The least recently seen clients are evicted first.
+const maxRateLimitKeys = 10000
+
+// rateLimiter keeps a token bucket per client in a bounded LRU.
+type rateLimiter struct {
+	limit   rate.Limit
+	burst   int
+	maxKeys int
+	now     func() time.Time
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element
+	lru      *list.List
+}
+
+// limiterEntry is an element of rateLimiter.lru.
+type limiterEntry struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+func newRateLimiter(rps float64, burst, maxKeys int) *rateLimiter {
+	return &rateLimiter{
+		limit:    rate.Limit(rps),
+		burst:    burst,
+		maxKeys:  maxKeys,
+		now:      time.Now,
+		limiters: make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// get returns the limiter of key, creating it and evicting the oldest one if needed.
+func (rl *rateLimiter) get(key string) *rate.Limiter {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if elem, ok := rl.limiters[key]; ok {
+		rl.lru.MoveToFront(elem)
+		return elem.Value.(*limiterEntry).limiter
+	}
+
+	if rl.lru.Len() >= rl.maxKeys {
+		oldest := rl.lru.Back()
+		rl.lru.Remove(oldest)
+		delete(rl.limiters, oldest.Value.(*limiterEntry).key)
+	}
+
+	limiter := rate.NewLimiter(rl.limit, rl.burst)
+	rl.limiters[key] = rl.lru.PushFront(&limiterEntry{key: key, limiter: limiter})
+	return limiter
+}
+
+// allow takes a token for key. If none is available, it returns false
+// and how long the client should wait before retrying.
+func (rl *rateLimiter) allow(key string) (bool, time.Duration) {
+	now := rl.now()
+	reservation := rl.get(key).ReserveN(now, 1)
+	if !reservation.OK() {
+		return false, time.Second
+	}
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// rateLimitKey identifies the client: the authenticated user ID, or the client IP otherwise.
+// User IDs issued for the request itself don't identify anyone, as clients sending no
+// token get a new one every time, so such requests are keyed by the client IP as well.
+// The client IP is resolved from client IP headers of proxies, see clientIP.
+func rateLimitKey(r *http.Request, proxies []*net.IPNet) string {
+	if userID, ok := r.Context().Value(UserIDKey).(string); ok && userID != "" && !issuedUser(r) {
+		return "user:" + userID
+	}
+	if ip := clientIP(r, proxies); ip != nil {
+		return "ip:" + ip.String()
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// RateLimitMiddleware returns HTTP middleware limiting the request rate per client
+// with a token bucket of cfg.RateLimitBurst tokens refilled at cfg.RateLimitRPS per second.
+// Does nothing unless cfg.RateLimitRPS is positive.
+//
+// Clients are identified by the user ID of a valid auth token, falling back to the client
+// IP, so the middleware should be installed after AuthMiddleware. Clients without a token
+// are limited by IP, however many user IDs they are issued. Behind proxies listed in
+// cfg.ProxyCIDRs, the client IP is taken from X-Forwarded-For or X-Real-IP. Requests over the limit
+// get 429 Too Many Requests with a Retry-After header and a RateLimitedResponse body.
+func RateLimitMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if cfg.RateLimitRPS <= 0 {
+			return next
+		}
+		limiter := newRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, maxRateLimitKeys)
+		return rateLimitHandler(limiter, parseCIDRs(cfg.ProxyCIDRs), next)
+	}
+}
+
+func rateLimitHandler(rl *rateLimiter, proxies []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := rl.allow(rateLimitKey(r, proxies)); !ok {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(RateLimitedResponse{Error: "rate_limited", RetryAfter: seconds})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestRateLimitMiddleware_ExhaustAndRecover(t *testing.T) {
+	now := time.Now()
+	rl := newRateLimiter(1, 2, maxRateLimitKeys)
+	rl.now = func() time.Time { return now }
+	handler := rateLimitHandler(rl, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		req = req.WithContext(context.WithValue(req.Context(), UserIDKey, userID))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// The burst of 2 is allowed, the third request is rejected
+	for i := 0; i < 2; i++ {
+		if w := send("user1"); w.Code != http.StatusOK {
+			t.Fatalf("Request %d: expected status 200, got %d", i+1, w.Code)
+		}
+	}
+	w := send("user1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429, got %d", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Expected Retry-After 1, got %q", got)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected application/json, got %s", contentType)
+	}
+	var body RateLimitedResponse
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body != (RateLimitedResponse{Error: "rate_limited", RetryAfter: 1}) {
+		t.Errorf("Expected rate_limited with retry_after 1, got %+v", body)
+	}
+
+	// Other clients have their own bucket
+	if w := send("user2"); w.Code != http.StatusOK {
+		t.Errorf("Expected another user to be allowed, got %d", w.Code)
+	}
+
+	// One token is refilled after a second
+	now = now.Add(time.Second)
+	if w := send("user1"); w.Code != http.StatusOK {
+		t.Errorf("Expected request to be allowed after the window, got %d", w.Code)
+	}
+	if w := send("user1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 after the refilled token was used, got %d", w.Code)
+	}
+}
+
+func TestRateLimitMiddleware_FallsBackToIP(t *testing.T) {
+	cfg := &config.Config{RateLimitRPS: 1, RateLimitBurst: 1}
+	handler := RateLimitMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(remoteAddr string) int {
+		req := httptest.NewRequest(http.MethodGet, "/abc123", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("10.0.0.1:1234"); code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", code)
+	}
+	if code := send("10.0.0.1:5678"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 for the same IP on another port, got %d", code)
+	}
+	if code := send("10.0.0.2:1234"); code != http.StatusOK {
+		t.Errorf("Expected status 200 for another IP, got %d", code)
+	}
+}
+
+func TestRateLimitMiddleware_IssuedUsersLimitedByIP(t *testing.T) {
+	cfg := &config.Config{SecretKey: "secret", RateLimitRPS: 1, RateLimitBurst: 1}
+	handler := AuthMiddleware(cfg)(RateLimitMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	send := func(remoteAddr string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/shorten", nil)
+		req.RemoteAddr = remoteAddr
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// Every request without a cookie is issued a new user ID, but they share the IP's bucket
+	first := send("10.0.0.1:1234")
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", first.Code)
+	}
+	if w := send("10.0.0.1:1234"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status 429 for another request without a cookie, got %d", w.Code)
+	}
+
+	// A client presenting its token has its own bucket
+	if w := send("10.0.0.1:1234", first.Result().Cookies()...); w.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for an authenticated user, got %d", w.Code)
+	}
+}
+
+func TestRateLimiter_EvictsOldestKeys(t *testing.T) {
+	rl := newRateLimiter(1, 1, 2)
+
+	first := rl.get("a")
+	rl.get("b")
+	rl.get("a")
+	rl.get("c") // evicts b, the least recently used
+
+	if len(rl.limiters) != 2 {
+		t.Errorf("Expected 2 tracked keys, got %d", len(rl.limiters))
+	}
+	if _, ok := rl.limiters["b"]; ok {
+		t.Error("Expected b to be evicted")
+	}
+	if rl.get("a") != first {
+		t.Error("Expected a to keep its limiter")
+	}
+}
+
+func TestRateLimitMiddleware_Disabled(t *testing.T) {
+	cfg := &config.Config{}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	for i := 0; i < 100; i++ {
+		w := httptest.NewRecorder()
+		RateLimitMiddleware(cfg)(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 with rate limiting disabled, got %d", w.Code)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestSignedRequestMiddleware(t *testing.T) {
+	const key = "shared-signing-key"
+	cfg := &config.Config{InternalSigningKey: key}
+
+	var gotBody string
+	handler := SignedRequestMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	newRequest := func(body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/api/internal/flush", strings.NewReader(body))
+		SignInternalRequest(req, key, []byte(body))
+		return req
+	}
+
+	tests := []struct {
+		name           string
+		request        func() *http.Request
+		expectedStatus int
+	}{
+		{
+			name:           "Valid signature",
+			request:        func() *http.Request { return newRequest(`{"a":1}`) },
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Expired timestamp",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/api/internal/flush", strings.NewReader(`{"a":1}`))
+				timestamp := strconv.FormatInt(time.Now().Add(-2*SignatureMaxAge).Unix(), 10)
+				req.Header.Set(InternalTimestampHeader, timestamp)
+				req.Header.Set(InternalSignatureHeader, internalSignature(key, timestamp, req.Method, req.URL.RequestURI(), []byte(`{"a":1}`)))
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Tampered body",
+			request: func() *http.Request {
+				req := newRequest(`{"a":1}`)
+				req.Body = io.NopCloser(strings.NewReader(`{"a":2}`))
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Tampered query",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/api/internal/flush?scope=pending", nil)
+				SignInternalRequest(req, key, nil)
+				req.URL.RawQuery = "scope=all"
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Body too large",
+			request:        func() *http.Request { return newRequest(strings.Repeat("a", maxSignedBodySize+1)) },
+			expectedStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "Wrong key",
+			request: func() *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/api/internal/flush", nil)
+				SignInternalRequest(req, "other-key", nil)
+				return req
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Unsigned request",
+			request:        func() *http.Request { return httptest.NewRequest(http.MethodPost, "/api/internal/flush", nil) },
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, tt.request())
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+
+	t.Run("Body is passed on", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, newRequest(`{"b":2}`))
+		if w.Code != http.StatusOK || gotBody != `{"b":2}` {
+			t.Errorf("Expected 200 with the signed body, got %d with %q", w.Code, gotBody)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		req := newRequest(`{"c":3}`)
+		replay := req.Clone(req.Context())
+		replay.Body = io.NopCloser(strings.NewReader(`{"c":3}`))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, replay)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected replayed request to be rejected, got %d", w.Code)
+		}
+	})
+}
+
+func TestSignedRequestMiddleware_Disabled(t *testing.T) {
+	handler := SignedRequestMiddleware(&config.Config{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/internal/flush", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected unsigned requests to pass without a signing key, got %d", w.Code)
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+)
+
+// TrailingSlashMiddleware returns HTTP middleware that makes routes with a trailing slash
+// resolve to the same handler as the route without it, according to cfg.TrailingSlash.
+//
+// Modes:
+//   - "strip": serves /abc123/ as /abc123 without a round trip
+//   - "redirect": answers /abc123/ with a 301 redirect to /abc123
+//   - "": leaves routing unchanged
+//
+// Must be registered with the router's Use so it runs before route matching.
+func TrailingSlashMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	switch cfg.TrailingSlash {
+	case config.TrailingSlashStrip:
+		return chimiddleware.StripSlashes
+	case config.TrailingSlashRedirect:
+		return chimiddleware.RedirectSlashes
+	default:
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+}
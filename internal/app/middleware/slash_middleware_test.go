@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+	"github.com/go-chi/chi/v5"
+)
+
+func newSlashTestRouter(mode string) http.Handler {
+	r := chi.NewRouter()
+	r.Use(TrailingSlashMiddleware(&config.Config{TrailingSlash: mode}))
+	r.Get("/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(chi.URLParam(r, "id")))
+	})
+	return r
+}
+
+func TestTrailingSlashMiddleware_Strip(t *testing.T) {
+	router := newSlashTestRouter(config.TrailingSlashStrip)
+
+	for _, path := range []string{"/abc123", "/abc123/"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected status 200, got %d", path, w.Code)
+		}
+		if w.Body.String() != "abc123" {
+			t.Errorf("%s: expected id 'abc123', got '%s'", path, w.Body.String())
+		}
+	}
+}
+
+func TestTrailingSlashMiddleware_Redirect(t *testing.T) {
+	router := newSlashTestRouter(config.TrailingSlashRedirect)
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123/", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusMovedPermanently {
+		t.Errorf("Expected status 301, got %d", w.Code)
+	}
+	if location := w.Header().Get("Location"); !strings.HasSuffix(location, "/abc123") {
+		t.Errorf("Expected Location to point at '/abc123', got '%s'", location)
+	}
+}
+
+func TestTrailingSlashMiddleware_Disabled(t *testing.T) {
+	router := newSlashTestRouter("")
+
+	req := httptest.NewRequest(http.MethodGet, "/abc123/", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+// InternalKeyHeader is the request header carrying the internal API key.
+const InternalKeyHeader = "X-Internal-Key"
+
+// TrustedSubnetMiddleware returns HTTP middleware restricting access to clients
+// from cfg.TrustedSubnet. The client IP is taken from the X-Real-IP header, or resolved
+// from X-Forwarded-For when proxies are configured in cfg.ProxyCIDRs and the header is set.
+// Both IPv4 and IPv6 subnets are supported; client IPs may be bracketed, carry
+// a port or an IPv6 zone, e.g. "[fe80::1%eth0]:8080".
+// When cfg.InternalAPIKey is set, requests with a matching X-Internal-Key header
+// are allowed from any IP.
+//
+// Responds with 403 Forbidden when the API key doesn't match and:
+//   - No trusted subnet is configured
+//   - No client IP is found or it is not a valid IP address
+//   - The client IP is outside the trusted subnet
+func TrustedSubnetMiddleware(cfg *config.Config) func(http.Handler) http.Handler {
+	var subnet *net.IPNet
+	if cfg.TrustedSubnet != "" {
+		// The subnet is validated by config.LoadConfig; an unparsable value denies all access.
+		_, subnet, _ = net.ParseCIDR(cfg.TrustedSubnet)
+	}
+	proxies := parseCIDRs(cfg.ProxyCIDRs)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if validInternalKey(cfg.InternalAPIKey, r.Header.Get(InternalKeyHeader)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if subnet == nil {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			ip := forwardedClientIP(r, proxies)
+			if ip == nil {
+				ip = parseClientIP(r.Header.Get("X-Real-IP"))
+			}
+			if ip == nil || !subnet.Contains(ip) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validInternalKey reports whether key is configured and matches the provided one.
+func validInternalKey(key, provided string) bool {
+	if key == "" || provided == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(key), []byte(provided)) == 1
+}
@@ -0,0 +1,153 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/achufistov/shortygopher.git/internal/app/config"
+)
+
+func TestTrustedSubnetMiddleware(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedSubnet  string
+		apiKey         string
+		realIP         string
+		providedKey    string
+		expectedStatus int
+	}{
+		{
+			name:           "IP inside trusted subnet",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "192.168.1.42",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IP outside trusted subnet",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "10.0.0.1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Missing X-Real-IP",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid X-Real-IP",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "not-an-ip",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "No trusted subnet configured",
+			trustedSubnet:  "",
+			realIP:         "192.168.1.42",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Valid API key from untrusted IP",
+			trustedSubnet:  "192.168.1.0/24",
+			apiKey:         "secret-key",
+			realIP:         "10.0.0.1",
+			providedKey:    "secret-key",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Valid API key without trusted subnet",
+			apiKey:         "secret-key",
+			providedKey:    "secret-key",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Wrong API key from untrusted IP",
+			trustedSubnet:  "192.168.1.0/24",
+			apiKey:         "secret-key",
+			realIP:         "10.0.0.1",
+			providedKey:    "wrong-key",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Wrong API key from trusted IP",
+			trustedSubnet:  "192.168.1.0/24",
+			apiKey:         "secret-key",
+			realIP:         "192.168.1.42",
+			providedKey:    "wrong-key",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv6 inside trusted subnet",
+			trustedSubnet:  "2001:db8::/64",
+			realIP:         "2001:db8::42",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv6 at the end of trusted subnet",
+			trustedSubnet:  "2001:db8::/64",
+			realIP:         "2001:db8::ffff:ffff:ffff:ffff",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv6 just past trusted subnet",
+			trustedSubnet:  "2001:db8::/64",
+			realIP:         "2001:db8:0:1::",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "IPv6 with zone",
+			trustedSubnet:  "fe80::/10",
+			realIP:         "fe80::1%eth0",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bracketed IPv6 with port",
+			trustedSubnet:  "2001:db8::/64",
+			realIP:         "[2001:db8::42]:8080",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv4 with port",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "192.168.1.42:8080",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv4 client with IPv6 subnet",
+			trustedSubnet:  "2001:db8::/64",
+			realIP:         "192.168.1.42",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Empty API key is never accepted",
+			realIP:         "10.0.0.1",
+			providedKey:    "",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{TrustedSubnet: tt.trustedSubnet, InternalAPIKey: tt.apiKey}
+			handler := TrustedSubnetMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.providedKey != "" {
+				req.Header.Set(InternalKeyHeader, tt.providedKey)
+			}
+			w := httptest.NewRecorder()
+
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,397 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by CircuitBreaker while the underlying storage is considered unavailable.
+var ErrCircuitOpen = errors.New("storage circuit breaker is open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+// Circuit breaker states. The values are exported as the circuit breaker gauge.
+const (
+	// CircuitClosed passes all calls to the underlying storage
+	CircuitClosed CircuitState = iota
+	// CircuitHalfOpen lets a single call through to probe the underlying storage
+	CircuitHalfOpen
+	// CircuitOpen fails all calls immediately
+	CircuitOpen
+)
+
+// String returns the state name.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitHalfOpen:
+		return "half-open"
+	case CircuitOpen:
+		return "open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerOptions contains settings for CircuitBreaker.
+type CircuitBreakerOptions struct {
+	// Threshold is the number of consecutive failures that opens the circuit
+	Threshold int
+	// ResetTimeout is how long the circuit stays open before a probe call is allowed
+	ResetTimeout time.Duration
+	// OnStateChange is called with the new state whenever the state changes (optional)
+	OnStateChange func(CircuitState)
+}
+
+// CircuitBreaker is a Storage decorator that stops calling the underlying storage
+// after repeated failures, so that requests fail fast instead of waiting for timeouts.
+//
+// Only errors returned by the underlying storage count as failures; duplicate URL
+// errors are expected results. While the circuit is open, methods returning an
+// error return ErrCircuitOpen and other methods return empty results; use
+// Available to tell those apart from missing data.
+type CircuitBreaker struct {
+	next Storage
+	opts CircuitBreakerOptions
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker wraps next with a circuit breaker.
+func NewCircuitBreaker(next Storage, opts CircuitBreakerOptions) *CircuitBreaker {
+	return &CircuitBreaker{next: next, opts: opts, now: time.Now}
+}
+
+// Available returns ErrCircuitOpen if s is, or wraps, a CircuitBreaker that is currently open.
+func Available(s Storage) error {
+	for {
+		switch v := s.(type) {
+		case *CircuitBreaker:
+			if v.State() == CircuitOpen {
+				return ErrCircuitOpen
+			}
+			return nil
+		case interface{ Unwrap() Storage }:
+			s = v.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// State returns the current state, moving to half-open once the reset timeout has passed.
+func (cb *CircuitBreaker) State() CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refresh()
+	return cb.state
+}
+
+// refresh moves an open circuit to half-open after the reset timeout. Requires cb.mu.
+func (cb *CircuitBreaker) refresh() {
+	if cb.state == CircuitOpen && cb.now().Sub(cb.openedAt) >= cb.opts.ResetTimeout {
+		cb.setState(CircuitHalfOpen)
+	}
+}
+
+// setState changes the state and notifies the listener. Requires cb.mu.
+func (cb *CircuitBreaker) setState(state CircuitState) {
+	if cb.state == state {
+		return
+	}
+	cb.state = state
+	if state == CircuitOpen {
+		cb.openedAt = cb.now()
+	}
+	if cb.opts.OnStateChange != nil {
+		cb.opts.OnStateChange(state)
+	}
+}
+
+// allow reports whether a call may reach the underlying storage.
+// In the half-open state only one probe call is allowed at a time.
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.refresh()
+	switch cb.state {
+	case CircuitClosed:
+		return true
+	case CircuitHalfOpen:
+		if cb.probing {
+			return false
+		}
+		cb.probing = true
+		return true
+	}
+	return false
+}
+
+// record updates the state with the result of a call.
+func (cb *CircuitBreaker) record(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+
+	if err == nil || errors.Is(err, ErrURLExists) || errors.Is(err, ErrShortURLExists) || partiallyStored(err) {
+		cb.failures = 0
+		cb.setState(CircuitClosed)
+		return
+	}
+
+	cb.failures++
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.opts.Threshold {
+		cb.setState(CircuitOpen)
+	}
+}
+
+// call runs fn through the breaker.
+func (cb *CircuitBreaker) call(fn func() error) error {
+	if !cb.allow() {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	cb.record(err)
+	return err
+}
+
+// closed reports whether calls without an error result may reach the underlying storage.
+// Such calls can't tell whether the storage failed, so they never probe a half-open circuit.
+func (cb *CircuitBreaker) closed() bool {
+	return cb.State() == CircuitClosed
+}
+
+// AddURL adds a URL mapping through the breaker.
+func (cb *CircuitBreaker) AddURL(shortURL, originalURL, userID string) error {
+	return cb.call(func() error { return cb.next.AddURL(shortURL, originalURL, userID) })
+}
+
+// AddURLs adds URL mappings through the breaker.
+func (cb *CircuitBreaker) AddURLs(urls map[string]string, userID string) error {
+	return cb.call(func() error { return cb.next.AddURLs(urls, userID) })
+}
+
+// GetURL returns the original URL, or reports it missing while the circuit isn't closed.
+func (cb *CircuitBreaker) GetURL(shortURL string) (string, bool, bool) {
+	if !cb.closed() {
+		return "", false, false
+	}
+	return cb.next.GetURL(shortURL)
+}
+
+// GetURLsByUser returns the user's URL mappings through the breaker.
+func (cb *CircuitBreaker) GetURLsByUser(userID string) (map[string]string, error) {
+	var urls map[string]string
+	err := cb.call(func() (err error) {
+		urls, err = cb.next.GetURLsByUser(userID)
+		return err
+	})
+	return urls, err
+}
+
+// GetUserURLs returns a page of the user's URLs through the breaker.
+func (cb *CircuitBreaker) GetUserURLs(userID string, page Page) ([]UserURL, error) {
+	var urls []UserURL
+	err := cb.call(func() (err error) {
+		urls, err = cb.next.GetUserURLs(userID, page)
+		return err
+	})
+	return urls, err
+}
+
+// CountUserURLs counts the user's URLs through the breaker.
+func (cb *CircuitBreaker) CountUserURLs(userID string) (int, error) {
+	var count int
+	err := cb.call(func() (err error) {
+		count, err = cb.next.CountUserURLs(userID)
+		return err
+	})
+	return count, err
+}
+
+// GetAllURLs returns all URL mappings, or none while the circuit isn't closed.
+func (cb *CircuitBreaker) GetAllURLs() map[string]string {
+	if !cb.closed() {
+		return map[string]string{}
+	}
+	return cb.next.GetAllURLs()
+}
+
+// Count returns the number of stored URLs, or 0 while the circuit isn't closed.
+func (cb *CircuitBreaker) Count() int {
+	if !cb.closed() {
+		return 0
+	}
+	return cb.next.Count()
+}
+
+// GetShortURLByOriginalURL finds a short URL, or reports it missing while the circuit isn't closed.
+func (cb *CircuitBreaker) GetShortURLByOriginalURL(originalURL string) (string, bool) {
+	if !cb.closed() {
+		return "", false
+	}
+	return cb.next.GetShortURLByOriginalURL(originalURL)
+}
+
+// DeleteURLs marks URLs as deleted through the breaker.
+func (cb *CircuitBreaker) DeleteURLs(shortURLs []string, userID string) error {
+	return cb.call(func() error { return cb.next.DeleteURLs(shortURLs, userID) })
+}
+
+// ReplaceDeletedURL replaces a deleted mapping through the breaker.
+func (cb *CircuitBreaker) ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error {
+	return cb.call(func() error {
+		return cb.next.ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID)
+	})
+}
+
+// RestoreURLs restores deleted URLs through the breaker.
+func (cb *CircuitBreaker) RestoreURLs(shortURLs []string, userID string) error {
+	return cb.call(func() error { return cb.next.RestoreURLs(shortURLs, userID) })
+}
+
+// SetContentType records URL content types through the breaker.
+func (cb *CircuitBreaker) SetContentType(shortURLs []string, contentType string) error {
+	return cb.call(func() error { return cb.next.SetContentType(shortURLs, contentType) })
+}
+
+// SetCreatorIPHash records creator IP hashes through the breaker.
+func (cb *CircuitBreaker) SetCreatorIPHash(shortURLs []string, hash string) error {
+	return cb.call(func() error { return cb.next.SetCreatorIPHash(shortURLs, hash) })
+}
+
+// SetProtected protects URLs through the breaker.
+func (cb *CircuitBreaker) SetProtected(shortURLs []string) error {
+	return cb.call(func() error { return cb.next.SetProtected(shortURLs) })
+}
+
+// SetMetadata records page metadata through the breaker.
+func (cb *CircuitBreaker) SetMetadata(shortURL, title, faviconURL string) error {
+	return cb.call(func() error { return cb.next.SetMetadata(shortURL, title, faviconURL) })
+}
+
+// GetIdempotentResult returns a saved idempotency key result through the breaker.
+func (cb *CircuitBreaker) GetIdempotentResult(userID, key string) (IdempotentResult, bool, error) {
+	var result IdempotentResult
+	var exists bool
+	err := cb.call(func() (err error) {
+		result, exists, err = cb.next.GetIdempotentResult(userID, key)
+		return err
+	})
+	return result, exists, err
+}
+
+// SaveIdempotentResult saves an idempotency key result through the breaker.
+func (cb *CircuitBreaker) SaveIdempotentResult(userID, key string, result IdempotentResult) error {
+	return cb.call(func() error { return cb.next.SaveIdempotentResult(userID, key, result) })
+}
+
+// ReserveIdempotentResult reserves an idempotency key through the breaker.
+func (cb *CircuitBreaker) ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error) {
+	var saved IdempotentResult
+	var reserved bool
+	err := cb.call(func() (err error) {
+		saved, reserved, err = cb.next.ReserveIdempotentResult(userID, key, result)
+		return err
+	})
+	return saved, reserved, err
+}
+
+// GetURLInfo returns everything stored about a short URL through the breaker.
+func (cb *CircuitBreaker) GetURLInfo(shortURL string) (URLInfo, bool, error) {
+	var info URLInfo
+	var exists bool
+	err := cb.call(func() (err error) {
+		info, exists, err = cb.next.GetURLInfo(shortURL)
+		return err
+	})
+	return info, exists, err
+}
+
+// GetUserIDs returns the IDs of users owning URLs through the breaker.
+func (cb *CircuitBreaker) GetUserIDs() ([]string, error) {
+	var userIDs []string
+	err := cb.call(func() (err error) {
+		userIDs, err = cb.next.GetUserIDs()
+		return err
+	})
+	return userIDs, err
+}
+
+// PurgeUsers removes all URLs of the specified users through the breaker.
+func (cb *CircuitBreaker) PurgeUsers(userIDs []string) (int, error) {
+	var removed int
+	err := cb.call(func() (err error) {
+		removed, err = cb.next.PurgeUsers(userIDs)
+		return err
+	})
+	return removed, err
+}
+
+// HardDeleteExpired removes expired deleted URLs through the breaker.
+func (cb *CircuitBreaker) HardDeleteExpired(before time.Time) (int, error) {
+	var removed int
+	err := cb.call(func() (err error) {
+		removed, err = cb.next.HardDeleteExpired(before)
+		return err
+	})
+	return removed, err
+}
+
+// Export returns complete records of all stored URLs through the breaker.
+func (cb *CircuitBreaker) Export() ([]URLMapping, error) {
+	var records []URLMapping
+	err := cb.call(func() (err error) {
+		records, err = cb.next.Export()
+		return err
+	})
+	return records, err
+}
+
+// Import stores exported records through the breaker.
+func (cb *CircuitBreaker) Import(records []URLMapping) error {
+	return cb.call(func() error { return cb.next.Import(records) })
+}
+
+// GetStats returns storage statistics through the breaker.
+func (cb *CircuitBreaker) GetStats() (Stats, error) {
+	var stats Stats
+	err := cb.call(func() (err error) {
+		stats, err = cb.next.GetStats()
+		return err
+	})
+	return stats, err
+}
+
+// GetWindowStats returns windowed statistics through the breaker.
+func (cb *CircuitBreaker) GetWindowStats(since time.Time) (WindowStats, error) {
+	var stats WindowStats
+	err := cb.call(func() (err error) {
+		stats, err = cb.next.GetWindowStats(since)
+		return err
+	})
+	return stats, err
+}
+
+// Ping checks the underlying storage through the breaker.
+func (cb *CircuitBreaker) Ping() error {
+	return cb.call(cb.next.Ping)
+}
+
+// PingContext checks the underlying storage through the breaker.
+func (cb *CircuitBreaker) PingContext(ctx context.Context) error {
+	return cb.call(func() error { return cb.next.PingContext(ctx) })
+}
+
+// Close closes the underlying storage regardless of the circuit state.
+func (cb *CircuitBreaker) Close() error {
+	return cb.next.Close()
+}
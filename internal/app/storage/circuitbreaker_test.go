@@ -0,0 +1,143 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// flakyStorage is an in-memory storage whose error-returning calls can be made to fail.
+type flakyStorage struct {
+	*URLStorage
+	err   error
+	calls int
+}
+
+func (s *flakyStorage) AddURL(shortURL, originalURL, userID string) error {
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	return s.URLStorage.AddURL(shortURL, originalURL, userID)
+}
+
+func (s *flakyStorage) AddURLs(urls map[string]string, userID string) error {
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	return s.URLStorage.AddURLs(urls, userID)
+}
+
+func (s *flakyStorage) Ping() error {
+	s.calls++
+	return s.err
+}
+
+func newTestBreaker(next Storage, states *[]CircuitState) (*CircuitBreaker, *time.Time) {
+	now := time.Now()
+	cb := NewCircuitBreaker(next, CircuitBreakerOptions{
+		Threshold:    3,
+		ResetTimeout: time.Minute,
+		OnStateChange: func(state CircuitState) {
+			*states = append(*states, state)
+		},
+	})
+	cb.now = func() time.Time { return now }
+	return cb, &now
+}
+
+func TestCircuitBreaker_OpensAfterFailures(t *testing.T) {
+	backend := &flakyStorage{URLStorage: NewURLStorage(), err: errors.New("connection refused")}
+	var states []CircuitState
+	cb, _ := newTestBreaker(backend, &states)
+
+	for i := 0; i < 3; i++ {
+		if err := cb.Ping(); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("Call %d failed fast before the threshold was reached", i+1)
+		}
+	}
+	if cb.State() != CircuitOpen {
+		t.Fatalf("Expected circuit to be open, got %s", cb.State())
+	}
+
+	// Subsequent calls fail fast without reaching the storage
+	if err := cb.AddURL("short1", "https://example.com", "user1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if err := cb.Ping(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if backend.calls != 3 {
+		t.Errorf("Expected 3 calls to reach the storage, got %d", backend.calls)
+	}
+	if _, exists, _ := cb.GetURL("short1"); exists {
+		t.Error("Expected GetURL to report nothing while open")
+	}
+	if !errors.Is(Available(cb), ErrCircuitOpen) {
+		t.Error("Expected Available to report the open circuit")
+	}
+	if len(states) != 1 || states[0] != CircuitOpen {
+		t.Errorf("Expected a single change to open, got %v", states)
+	}
+}
+
+func TestCircuitBreaker_RecoversAfterTimeout(t *testing.T) {
+	backend := &flakyStorage{URLStorage: NewURLStorage(), err: errors.New("connection refused")}
+	var states []CircuitState
+	cb, now := newTestBreaker(backend, &states)
+
+	for i := 0; i < 3; i++ {
+		cb.Ping()
+	}
+
+	// A failed probe after the timeout opens the circuit again
+	*now = now.Add(time.Minute)
+	if cb.State() != CircuitHalfOpen {
+		t.Fatalf("Expected circuit to be half-open after the timeout, got %s", cb.State())
+	}
+	cb.Ping()
+	if cb.State() != CircuitOpen {
+		t.Fatalf("Expected failed probe to reopen the circuit, got %s", cb.State())
+	}
+
+	// A successful probe closes it
+	*now = now.Add(time.Minute)
+	backend.err = nil
+	if err := cb.AddURL("short1", "https://example.com", "user1"); err != nil {
+		t.Fatalf("Expected probe to succeed, got %v", err)
+	}
+	if cb.State() != CircuitClosed {
+		t.Errorf("Expected circuit to be closed, got %s", cb.State())
+	}
+	if originalURL, exists, _ := cb.GetURL("short1"); !exists || originalURL != "https://example.com" {
+		t.Errorf("Expected stored URL to be readable, got %q (exists: %v)", originalURL, exists)
+	}
+
+	want := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if len(states) != len(want) {
+		t.Fatalf("Expected state changes %v, got %v", want, states)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("Expected state changes %v, got %v", want, states)
+			break
+		}
+	}
+}
+
+func TestCircuitBreaker_DuplicatesAreNotFailures(t *testing.T) {
+	backend := &flakyStorage{URLStorage: NewURLStorage()}
+	var states []CircuitState
+	cb, _ := newTestBreaker(backend, &states)
+
+	cb.AddURL("short1", "https://example.com", "user1")
+	for i := 0; i < 5; i++ {
+		if err := cb.AddURL("short2", "https://example.com", "user1"); !errors.Is(err, ErrURLExists) {
+			t.Fatalf("Expected ErrURLExists, got %v", err)
+		}
+	}
+	if cb.State() != CircuitClosed {
+		t.Errorf("Expected circuit to stay closed, got %s", cb.State())
+	}
+}
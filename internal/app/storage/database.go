@@ -1,69 +1,287 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/lib/pq"
 )
 
 // DBStorage implements the Storage interface using PostgreSQL database.
 // Provides persistent storage for URL mappings with support for user associations and soft deletes.
+// Writes always go to the primary database; reads may be served by an optional read replica.
 type DBStorage struct {
-	db *sql.DB
+	db        *sql.DB
+	replica   *sql.DB
+	normalize func(string) string
+
+	approximateStats bool
+	statsCacheTTL    time.Duration
+	partialBatches   bool
+
+	// statsMu guards the cached result of GetStats
+	statsMu       sync.Mutex
+	cachedStats   Stats
+	statsCachedAt time.Time
+}
+
+// DBOptions contains optional settings for DBStorage.
+type DBOptions struct {
+	// ReplicaDSN is the connection string of a read replica (can be empty)
+	ReplicaDSN string
+
+	// NormalizeURLs detects duplicates by the normalized form of URLs (see NormalizeURL)
+	NormalizeURLs bool
+
+	// MaxOpenConns limits open connections per pool (0 means unlimited)
+	MaxOpenConns int
+	// MaxIdleConns limits idle connections kept per pool (0 keeps the database/sql default)
+	MaxIdleConns int
+	// ConnMaxLifetime is how long a connection may be reused (0 means forever)
+	ConnMaxLifetime time.Duration
+
+	// StatsCacheTTL is how long GetStats results are reused before the database
+	// is queried again (0 disables caching)
+	StatsCacheTTL time.Duration
+	// ApproximateStats estimates the total number of URLs from the planner
+	// statistics in pg_class instead of counting all rows
+	ApproximateStats bool
+
+	// PartialBatches stores the valid mappings of a batch passed to AddURLs even
+	// if others fail, reporting the failed ones with a *BatchError
+	PartialBatches bool
+
+	// WarmPool opens MaxIdleConns connections per pool at startup, so the first
+	// requests don't wait for connections to be established
+	WarmPool bool
+}
+
+// defaultMaxIdleConns is the number of idle connections database/sql keeps
+// unless MaxIdleConns is set.
+const defaultMaxIdleConns = 2
+
+// configurePool applies the connection pool settings of opts to db.
+func configurePool(db *sql.DB, opts DBOptions) {
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+}
+
+// warmPool opens as many connections as the pool keeps idle by pinging over all
+// of them at once, and returns them to the pool. Failures are only logged,
+// as connections are opened on demand anyway.
+func warmPool(db *sql.DB, opts DBOptions) {
+	n := opts.MaxIdleConns
+	if n <= 0 {
+		n = defaultMaxIdleConns
+	}
+	if opts.MaxOpenConns > 0 && n > opts.MaxOpenConns {
+		n = opts.MaxOpenConns
+	}
+
+	// The connections are held until all are open, so each ping gets its own
+	ctx := context.Background()
+	conns := make([]*sql.Conn, n)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				log.Printf("Failed to warm up connection pool: %v", err)
+				return
+			}
+			if err := conn.PingContext(ctx); err != nil {
+				log.Printf("Failed to warm up connection pool: %v", err)
+			}
+			conns[i] = conn
+		}(i)
+	}
+	wg.Wait()
+
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Close()
+		}
+	}
 }
 
 // NewDBStorage creates a new DBStorage instance connected to PostgreSQL.
-// Establishes database connection, verifies connectivity, and creates required tables.
+// Establishes database connection, verifies connectivity, and applies schema migrations.
 // Returns error if connection fails or table creation fails.
 func NewDBStorage(dsn string) (*DBStorage, error) {
-	db, err := sql.Open("postgres", dsn)
+	return NewDBStorageWithOptions(dsn, DBOptions{})
+}
+
+// NewDBStorageWithOptions creates a new DBStorage instance connected to PostgreSQL
+// using the provided options.
+// If a replica is configured but unreachable, the storage falls back to the primary for reads.
+func NewDBStorageWithOptions(dsn string, opts DBOptions) (*DBStorage, error) {
+	return openDBStorage("postgres", dsn, opts)
+}
+
+// openDBStorage opens the primary and optional replica pools with the given driver.
+func openDBStorage(driverName, dsn string, opts DBOptions) (*DBStorage, error) {
+	db, err := sql.Open(driverName, dsn)
 	if err != nil {
 		return nil, fmt.Errorf("failed to establish connection for the database : %v", err)
 	}
+	configurePool(db, opts)
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %v", err)
 	}
 
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS urls (
-		id SERIAL PRIMARY KEY,
-		url TEXT NOT NULL UNIQUE,
-		short_url TEXT NOT NULL UNIQUE,
-		user_id TEXT NOT NULL,
-		is_deleted BOOLEAN DEFAULT FALSE
-	);
-	`
-	if _, err = db.Exec(createTableQuery); err != nil {
-		return nil, fmt.Errorf("unable to create database: %v", err)
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	if opts.WarmPool {
+		warmPool(db, opts)
 	}
 
-	return &DBStorage{db: db}, nil
+	storage := &DBStorage{
+		db:               db,
+		normalize:        normalizer(opts.NormalizeURLs),
+		approximateStats: opts.ApproximateStats,
+		statsCacheTTL:    opts.StatsCacheTTL,
+		partialBatches:   opts.PartialBatches,
+	}
+
+	if opts.ReplicaDSN != "" {
+		replica, err := sql.Open(driverName, opts.ReplicaDSN)
+		if err != nil {
+			log.Printf("Failed to open read replica, using primary for reads: %v", err)
+		} else if err := replica.Ping(); err != nil {
+			log.Printf("Failed to ping read replica, using primary for reads: %v", err)
+			replica.Close()
+		} else {
+			configurePool(replica, opts)
+			if opts.WarmPool {
+				warmPool(replica, opts)
+			}
+			storage.replica = replica
+		}
+	}
+
+	return storage, nil
+}
+
+// queryRead runs a read query on the replica when configured,
+// falling back to the primary if the replica fails.
+func (s *DBStorage) queryRead(query string, args ...interface{}) (*sql.Rows, error) {
+	if s.replica != nil {
+		rows, err := s.replica.Query(query, args...)
+		if err == nil {
+			return rows, nil
+		}
+		log.Printf("Read replica query failed, retrying on primary: %v", err)
+	}
+	return s.db.Query(query, args...)
+}
+
+// queryRowRead runs a single-row read query on the replica when configured,
+// falling back to the primary if the replica fails for any reason other than a missing row.
+func (s *DBStorage) queryRowRead(query string, args []interface{}, dest ...interface{}) error {
+	if s.replica != nil {
+		err := s.replica.QueryRow(query, args...).Scan(dest...)
+		if err == nil || err == sql.ErrNoRows {
+			return err
+		}
+		log.Printf("Read replica query failed, retrying on primary: %v", err)
+	}
+	return s.db.QueryRow(query, args...).Scan(dest...)
+}
+
+// uniqueViolation is the PostgreSQL error code for unique constraint violations.
+const uniqueViolation = "23505"
+
+// conflictError translates unique constraint violations into typed storage errors.
+// Other errors are returned unchanged.
+func conflictError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != uniqueViolation {
+		return err
+	}
+	if strings.Contains(pqErr.Constraint, "short_url") {
+		return ErrShortURLExists
+	}
+	return ErrURLExists
 }
 
 // AddURL adds a new URL mapping to the database.
 // Uses ON CONFLICT to handle duplicate URLs gracefully.
-// Returns error if URL already exists or database operation fails.
+// Returns ErrURLExists if URL already exists, ErrShortURLExists if the short URL
+// is taken, or an error if database operation fails.
 func (s *DBStorage) AddURL(shortURL, originalURL, userID string) error {
 	query := `
-    INSERT INTO urls (url, short_url, user_id)
-    VALUES ($1, $2, $3)
-    ON CONFLICT (url) DO NOTHING
+    INSERT INTO urls (url, normalized_url, short_url, user_id)
+    VALUES ($1, $2, $3, $4)
+    ON CONFLICT (normalized_url) DO NOTHING
     RETURNING short_url;
     `
 	var existingShortURL string
-	err := s.db.QueryRow(query, originalURL, shortURL, userID).Scan(&existingShortURL)
+	err := s.db.QueryRow(query, originalURL, s.normalize(originalURL), shortURL, userID).Scan(&existingShortURL)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return fmt.Errorf("URL already exists")
+			return ErrURLExists
+		}
+		if conflict := conflictError(err); conflict != err {
+			return conflict
+		}
+		return fmt.Errorf("failed to add URL to database: %v", err)
+	}
+	return nil
+}
+
+// ReplaceDeletedURL removes the deleted mapping of originalURL stored under
+// deletedShortURL and adds a live mapping under shortURL in a single transaction.
+func (s *DBStorage) ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	normalizedURL := s.normalize(originalURL)
+	result, err := tx.Exec(`DELETE FROM urls WHERE short_url = $1 AND normalized_url = $2 AND is_deleted`,
+		deletedShortURL, normalizedURL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove deleted URL: %v", err)
+	}
+	if removed, err := result.RowsAffected(); err != nil || removed == 0 {
+		tx.Rollback()
+		if err != nil {
+			return fmt.Errorf("failed to remove deleted URL: %v", err)
+		}
+		return ErrURLExists
+	}
+
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.Exec(query, originalURL, normalizedURL, shortURL, userID); err != nil {
+		tx.Rollback()
+		if conflict := conflictError(err); conflict != err {
+			return conflict
 		}
 		return fmt.Errorf("failed to add URL to database: %v", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
 	return nil
 }
 
 // AddURLs adds multiple URL mappings in a single database transaction.
-// Rolls back all changes if any URL fails to insert.
+// Rolls back all changes if any URL fails to insert, unless PartialBatches is
+// enabled (see addURLsPartially).
 // More efficient than multiple individual AddURL calls.
 func (s *DBStorage) AddURLs(urls map[string]string, userID string) error {
 	tx, err := s.db.Begin()
@@ -71,11 +289,17 @@ func (s *DBStorage) AddURLs(urls map[string]string, userID string) error {
 		return fmt.Errorf("failed to begin transaction: %v", err)
 	}
 
-	query := `INSERT INTO urls (url, short_url, user_id) VALUES ($1, $2, $3)`
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id) VALUES ($1, $2, $3, $4)`
+	if s.partialBatches {
+		return s.addURLsPartially(tx, query, urls, userID)
+	}
 	for shortURL, originalURL := range urls {
-		_, err := tx.Exec(query, originalURL, shortURL, userID)
+		_, err := tx.Exec(query, originalURL, s.normalize(originalURL), shortURL, userID)
 		if err != nil {
 			tx.Rollback()
+			if conflict := conflictError(err); conflict != err {
+				return conflict
+			}
 			return fmt.Errorf("failed to add URL to database: %v", err)
 		}
 	}
@@ -87,25 +311,73 @@ func (s *DBStorage) AddURLs(urls map[string]string, userID string) error {
 	return nil
 }
 
+// addURLsPartially inserts every mapping within its own savepoint, so a failed
+// row is rolled back alone and the others are committed. Returns a *BatchError
+// listing the failed rows, if any.
+func (s *DBStorage) addURLsPartially(tx *sql.Tx, query string, urls map[string]string, userID string) error {
+	failed := make(map[string]error)
+	for shortURL, originalURL := range urls {
+		if _, err := tx.Exec(`SAVEPOINT batch_row`); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to create savepoint: %v", err)
+		}
+		if _, err := tx.Exec(query, originalURL, s.normalize(originalURL), shortURL, userID); err != nil {
+			if _, rollbackErr := tx.Exec(`ROLLBACK TO SAVEPOINT batch_row`); rollbackErr != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to roll back to savepoint: %v", rollbackErr)
+			}
+			if conflict := conflictError(err); conflict != err {
+				failed[shortURL] = conflict
+			} else {
+				failed[shortURL] = fmt.Errorf("failed to add URL to database: %v", err)
+			}
+			continue
+		}
+		if _, err := tx.Exec(`RELEASE SAVEPOINT batch_row`); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to release savepoint: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	if len(failed) > 0 {
+		return &BatchError{Failed: failed}
+	}
+	return nil
+}
+
 // GetURL retrieves the original URL and deletion status for a short URL.
 // Returns original URL, existence flag, and deletion status.
 func (s *DBStorage) GetURL(shortURL string) (string, bool, bool) {
 	var originalURL string
 	var isDeleted bool
 	query := `SELECT url, is_deleted FROM urls WHERE short_url = $1`
-	err := s.db.QueryRow(query, shortURL).Scan(&originalURL, &isDeleted)
+	err := s.queryRowRead(query, []interface{}{shortURL}, &originalURL, &isDeleted)
 	if err != nil {
 		return "", false, false
 	}
 	return originalURL, true, isDeleted
 }
 
+// Count returns the total number of stored URLs, or 0 if the query fails,
+// logging the error.
+func (s *DBStorage) Count() int {
+	var count int
+	if err := s.queryRowRead(`SELECT COUNT(*) FROM urls`, nil, &count); err != nil {
+		log.Printf("Failed to count URLs: %v", err)
+		return 0
+	}
+	return count
+}
+
 // GetAllURLs retrieves all URL mappings from the database.
 // Returns a map of short URL to original URL for all stored mappings.
 func (s *DBStorage) GetAllURLs() map[string]string {
 	urlMap := make(map[string]string)
 	query := `SELECT short_url, url FROM urls`
-	rows, err := s.db.Query(query)
+	rows, err := s.queryRead(query)
 	if err != nil {
 		fmt.Printf("Failed to get URLs from database: %v\n", err)
 		return urlMap
@@ -128,10 +400,11 @@ func (s *DBStorage) GetAllURLs() map[string]string {
 
 // GetShortURLByOriginalURL finds the short URL for a given original URL.
 // Returns short URL and found flag. Useful for checking existing mappings.
+// With normalization enabled, any URL with the same normalized form matches.
 func (s *DBStorage) GetShortURLByOriginalURL(originalURL string) (string, bool) {
 	var shortURL string
-	query := `SELECT short_url FROM urls WHERE url = $1`
-	err := s.db.QueryRow(query, originalURL).Scan(&shortURL)
+	query := `SELECT short_url FROM urls WHERE normalized_url = $1`
+	err := s.db.QueryRow(query, s.normalize(originalURL)).Scan(&shortURL)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", false
@@ -147,7 +420,7 @@ func (s *DBStorage) GetShortURLByOriginalURL(originalURL string) (string, bool)
 func (s *DBStorage) GetURLsByUser(userID string) (map[string]string, error) {
 	urlMap := make(map[string]string)
 	query := `SELECT short_url, url FROM urls WHERE user_id = $1`
-	rows, err := s.db.Query(query, userID)
+	rows, err := s.queryRead(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query URLs by user: %v", err)
 	}
@@ -168,22 +441,367 @@ func (s *DBStorage) GetURLsByUser(userID string) (map[string]string, error) {
 	return urlMap, nil
 }
 
+// GetUserURLs retrieves a page of URLs created by a specific user with their deletion status.
+// Pagination is done in SQL with LIMIT/OFFSET, ordered by insertion
+// (newest first with page.NewestFirst).
+func (s *DBStorage) GetUserURLs(userID string, page Page) ([]UserURL, error) {
+	// LIMIT NULL returns all rows
+	var limit interface{}
+	if page.Limit > 0 {
+		limit = page.Limit
+	}
+	order := "id"
+	if page.NewestFirst {
+		order = "id DESC"
+	}
+	query := `SELECT short_url, url, is_deleted, content_type, created_at, deleted_at, title, favicon_url FROM urls
+	WHERE user_id = $1 ORDER BY ` + order + ` LIMIT $2 OFFSET $3`
+	rows, err := s.queryRead(query, userID, limit, page.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URLs by user: %v", err)
+	}
+	defer rows.Close()
+
+	var result []UserURL
+	for rows.Next() {
+		var u UserURL
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL, &u.IsDeleted, &u.ContentType, &u.CreatedAt,
+			&deletedAt, &u.Title, &u.FaviconURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		u.DeletedAt = deletedAt.Time
+		result = append(result, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	return result, nil
+}
+
+// CountUserURLs returns the number of the specified user's URLs that are not deleted.
+func (s *DBStorage) CountUserURLs(userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM urls WHERE user_id = $1 AND NOT is_deleted`
+	if err := s.db.QueryRow(query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count URLs by user: %v", err)
+	}
+	return count, nil
+}
+
 // DeleteURLs soft-deletes URLs by setting is_deleted flag to true.
+// Only URLs owned by userID are deleted; others are left untouched.
 // Uses PostgreSQL array operations for efficient batch deletion.
 func (s *DBStorage) DeleteURLs(shortURLs []string, userID string) error {
-	query := `UPDATE urls SET is_deleted = TRUE WHERE short_url = ANY($1)`
-	_, err := s.db.Exec(query, pq.Array(shortURLs))
+	query := `UPDATE urls SET is_deleted = TRUE, deleted_at = now()
+	WHERE short_url = ANY($1) AND user_id = $2 AND NOT is_deleted`
+	_, err := s.db.Exec(query, pq.Array(shortURLs), userID)
+	return err
+}
+
+// RestoreURLs clears the deleted flag of specified URLs owned by the given user.
+func (s *DBStorage) RestoreURLs(shortURLs []string, userID string) error {
+	query := `UPDATE urls SET is_deleted = FALSE, deleted_at = NULL
+	WHERE short_url = ANY($1) AND user_id = $2 AND is_deleted`
+	_, err := s.db.Exec(query, pq.Array(shortURLs), userID)
 	return err
 }
 
+// SetContentType records the content type the specified URLs were submitted with.
+func (s *DBStorage) SetContentType(shortURLs []string, contentType string) error {
+	query := `UPDATE urls SET content_type = $1 WHERE short_url = ANY($2)`
+	if _, err := s.db.Exec(query, contentType, pq.Array(shortURLs)); err != nil {
+		return fmt.Errorf("failed to set content type: %v", err)
+	}
+	return nil
+}
+
+// SetCreatorIPHash records the hash of the IP address the specified URLs were created from.
+func (s *DBStorage) SetCreatorIPHash(shortURLs []string, hash string) error {
+	query := `UPDATE urls SET creator_ip_hash = $1 WHERE short_url = ANY($2)`
+	if _, err := s.db.Exec(query, hash, pq.Array(shortURLs)); err != nil {
+		return fmt.Errorf("failed to set creator IP hash: %v", err)
+	}
+	return nil
+}
+
+// SetProtected marks the specified URLs as protected.
+func (s *DBStorage) SetProtected(shortURLs []string) error {
+	query := `UPDATE urls SET protected = TRUE WHERE short_url = ANY($1)`
+	if _, err := s.db.Exec(query, pq.Array(shortURLs)); err != nil {
+		return fmt.Errorf("failed to set protected: %v", err)
+	}
+	return nil
+}
+
+// SetMetadata records the title and favicon URL of the page a short URL points to.
+func (s *DBStorage) SetMetadata(shortURL, title, faviconURL string) error {
+	query := `UPDATE urls SET title = $1, favicon_url = $2 WHERE short_url = $3`
+	if _, err := s.db.Exec(query, title, faviconURL, shortURL); err != nil {
+		return fmt.Errorf("failed to set metadata: %v", err)
+	}
+	return nil
+}
+
+// GetIdempotentResult returns the unexpired result saved for the user's idempotency key.
+func (s *DBStorage) GetIdempotentResult(userID, key string) (IdempotentResult, bool, error) {
+	var result IdempotentResult
+	query := `SELECT original_url, short_url, status, expires_at FROM idempotency_keys
+	WHERE user_id = $1 AND idempotency_key = $2 AND expires_at > now()`
+	// Read from the primary: a retry typically follows the original request
+	// closely, before a replica may have caught up
+	err := s.db.QueryRow(query, userID, key).Scan(&result.OriginalURL, &result.ShortURL, &result.Status, &result.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return IdempotentResult{}, false, nil
+	}
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to query idempotency key: %v", err)
+	}
+	return result, true, nil
+}
+
+// SaveIdempotentResult saves the result of the user's request with an idempotency key.
+// Expired results are deleted on the way.
+func (s *DBStorage) SaveIdempotentResult(userID, key string, result IdempotentResult) error {
+	if _, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= now()`); err != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %v", err)
+	}
+	query := `INSERT INTO idempotency_keys (user_id, idempotency_key, original_url, short_url, status, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE SET original_url = EXCLUDED.original_url,
+		short_url = EXCLUDED.short_url, status = EXCLUDED.status, expires_at = EXCLUDED.expires_at`
+	if _, err := s.db.Exec(query, userID, key, result.OriginalURL, result.ShortURL, result.Status, result.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to save idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReserveIdempotentResult saves result for the user's idempotency key unless
+// an unexpired result is saved for it already, which is returned instead.
+// An expired result is replaced within the insert, so concurrent reservations
+// of a key can't both succeed.
+func (s *DBStorage) ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error) {
+	query := `INSERT INTO idempotency_keys (user_id, idempotency_key, original_url, short_url, status, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE SET original_url = EXCLUDED.original_url,
+		short_url = EXCLUDED.short_url, status = EXCLUDED.status, expires_at = EXCLUDED.expires_at
+	WHERE idempotency_keys.expires_at <= now()`
+	res, err := s.db.Exec(query, userID, key, result.OriginalURL, result.ShortURL, result.Status, result.ExpiresAt)
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	reserved, err := res.RowsAffected()
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	if reserved > 0 {
+		return IdempotentResult{}, true, nil
+	}
+	saved, _, err := s.GetIdempotentResult(userID, key)
+	return saved, false, err
+}
+
+// GetURLInfo returns everything stored about a short URL.
+func (s *DBStorage) GetURLInfo(shortURL string) (URLInfo, bool, error) {
+	var info URLInfo
+	var deletedAt sql.NullTime
+	query := `SELECT url, normalized_url, user_id, is_deleted, created_at, deleted_at, content_type, creator_ip_hash,
+		title, favicon_url, protected
+	FROM urls WHERE short_url = $1`
+	err := s.queryRowRead(query, []interface{}{shortURL},
+		&info.OriginalURL, &info.NormalizedURL, &info.UserID, &info.IsDeleted,
+		&info.CreatedAt, &deletedAt, &info.ContentType, &info.CreatorIPHash,
+		&info.Title, &info.FaviconURL, &info.Protected)
+	if err == sql.ErrNoRows {
+		return URLInfo{}, false, nil
+	}
+	if err != nil {
+		return URLInfo{}, false, fmt.Errorf("failed to query URL info: %v", err)
+	}
+	info.DeletedAt = deletedAt.Time
+	return info, true, nil
+}
+
+// GetUserIDs returns the IDs of all users owning URLs.
+func (s *DBStorage) GetUserIDs() ([]string, error) {
+	rows, err := s.queryRead(`SELECT DISTINCT user_id FROM urls`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user IDs: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return userIDs, nil
+}
+
+// PurgeUsers permanently deletes all URLs of the specified users.
+func (s *DBStorage) PurgeUsers(userIDs []string) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM urls WHERE user_id = ANY($1)`, pq.Array(userIDs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %v", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %v", err)
+	}
+	return int(removed), nil
+}
+
+// HardDeleteExpired permanently deletes URLs deleted before the specified time.
+func (s *DBStorage) HardDeleteExpired(before time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM urls WHERE is_deleted AND deleted_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired URLs: %v", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired URLs: %v", err)
+	}
+	return int(removed), nil
+}
+
+// Export returns complete records of all stored URLs in the order they were inserted.
+func (s *DBStorage) Export() ([]URLMapping, error) {
+	rows, err := s.queryRead(`SELECT short_url, url, user_id, is_deleted, content_type, creator_ip_hash, protected,
+		created_at, deleted_at, title, favicon_url
+	FROM urls ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URLs: %v", err)
+	}
+	defer rows.Close()
+
+	var records []URLMapping
+	for rows.Next() {
+		var record URLMapping
+		var createdAt time.Time
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&record.ShortURL, &record.OriginalURL, &record.UserID,
+			&record.IsDeleted, &record.ContentType, &record.CreatorIPHash, &record.Protected,
+			&createdAt, &deletedAt, &record.Title, &record.FaviconURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		record.CreatedAt = &createdAt
+		if deletedAt.Valid {
+			record.DeletedAt = &deletedAt.Time
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return records, nil
+}
+
+// Import inserts exported records in a single transaction.
+// Rolls back all changes if any record fails to insert.
+func (s *DBStorage) Import(records []URLMapping) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	now := time.Now()
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id, is_deleted, created_at, deleted_at,
+		content_type, creator_ip_hash, protected, title, favicon_url)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	for _, record := range records {
+		createdAt, deletedAt := record.times(now)
+		_, err := tx.Exec(query, record.OriginalURL, s.normalize(record.OriginalURL), record.ShortURL,
+			record.UserID, record.IsDeleted, createdAt, sql.NullTime{Time: deletedAt, Valid: record.IsDeleted},
+			record.ContentType, record.CreatorIPHash, record.Protected, record.Title, record.FaviconURL)
+		if err != nil {
+			tx.Rollback()
+			if conflict := conflictError(err); conflict != err {
+				return conflict
+			}
+			return fmt.Errorf("failed to import URL: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// GetStats returns the total number of URLs, deleted URLs and distinct users.
+// Results are reused for the configured StatsCacheTTL, as counting scans the whole table.
+// The total is counted like Count does, but within the same scan as the other
+// numbers, and unlike Count, failures are returned.
+func (s *DBStorage) GetStats() (Stats, error) {
+	if s.statsCacheTTL > 0 {
+		s.statsMu.Lock()
+		defer s.statsMu.Unlock()
+		if !s.statsCachedAt.IsZero() && time.Since(s.statsCachedAt) < s.statsCacheTTL {
+			return s.cachedStats, nil
+		}
+	}
+
+	var stats Stats
+	query := `SELECT COUNT(*), COUNT(*) FILTER (WHERE is_deleted), COUNT(DISTINCT user_id) FROM urls`
+	if s.approximateStats {
+		// reltuples is -1 until the table is first vacuumed or analyzed
+		query = `SELECT
+			(SELECT CASE WHEN reltuples < 0 THEN (SELECT COUNT(*) FROM urls) ELSE reltuples::bigint END
+			FROM pg_class WHERE oid = 'urls'::regclass),
+			COUNT(*) FILTER (WHERE is_deleted), COUNT(DISTINCT user_id) FROM urls`
+	}
+	if err := s.queryRowRead(query, nil, &stats.URLs, &stats.DeletedURLs, &stats.Users); err != nil {
+		return Stats{}, fmt.Errorf("failed to get stats: %v", err)
+	}
+
+	if s.statsCacheTTL > 0 {
+		s.cachedStats, s.statsCachedAt = stats, time.Now()
+	}
+	return stats, nil
+}
+
+// GetWindowStats counts URLs created and deleted since the given time.
+func (s *DBStorage) GetWindowStats(since time.Time) (WindowStats, error) {
+	var stats WindowStats
+	query := `
+	SELECT
+		COUNT(*) FILTER (WHERE created_at >= $1),
+		COUNT(*) FILTER (WHERE is_deleted AND deleted_at >= $1)
+	FROM urls
+	`
+	if err := s.queryRowRead(query, []interface{}{since}, &stats.CreatedURLs, &stats.DeletedURLs); err != nil {
+		return WindowStats{}, fmt.Errorf("failed to get window stats: %v", err)
+	}
+	return stats, nil
+}
+
 // Ping checks database connectivity.
 // Returns error if database is unreachable.
 func (s *DBStorage) Ping() error {
 	return s.db.Ping()
 }
 
-// Close closes the database connection.
+// PingContext checks database connectivity, giving up when ctx is done.
+// Unlike Ping, it can't hang on an unreachable database past the deadline of ctx.
+func (s *DBStorage) PingContext(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database connection and the read replica connection, if any.
 // Should be called when storage is no longer needed.
 func (s *DBStorage) Close() error {
+	if s.replica != nil {
+		if err := s.replica.Close(); err != nil {
+			return err
+		}
+	}
 	return s.db.Close()
 }
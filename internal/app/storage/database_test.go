@@ -0,0 +1,507 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// countingDriver is a minimal database/sql driver that counts queries per DSN
+// and records executed statements, including transaction commits and rollbacks.
+// DSNs starting with "down" fail every query to simulate an unavailable server.
+// Statements with a NUL byte in a text argument fail like in PostgreSQL.
+// Stats and count queries return a row of fixed counts, all other queries return no rows.
+type countingDriver struct {
+	mu      sync.Mutex
+	queries map[string]int
+	execs   []recordedExec
+}
+
+// recordedExec is a statement executed through countingDriver.
+type recordedExec struct {
+	query string
+	args  []driver.NamedValue
+}
+
+func (d *countingDriver) Open(name string) (driver.Conn, error) {
+	return &countingConn{driver: d, dsn: name}, nil
+}
+
+func (d *countingDriver) count(dsn string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.queries[dsn]
+}
+
+func (d *countingDriver) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.queries = make(map[string]int)
+	d.execs = nil
+}
+
+func (d *countingDriver) lastExec() recordedExec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.execs) == 0 {
+		return recordedExec{}
+	}
+	return d.execs[len(d.execs)-1]
+}
+
+type countingConn struct {
+	driver *countingDriver
+	dsn    string
+}
+
+func (c *countingConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepare is not supported")
+}
+
+func (c *countingConn) Close() error { return nil }
+
+func (c *countingConn) Begin() (driver.Tx, error) {
+	return &countingTx{conn: c}, nil
+}
+
+func (c *countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.driver.mu.Lock()
+	c.driver.execs = append(c.driver.execs, recordedExec{query: query, args: args})
+	c.driver.mu.Unlock()
+	for _, arg := range args {
+		if text, ok := arg.Value.(string); ok && strings.ContainsRune(text, 0) {
+			return nil, &pq.Error{Code: "22021", Message: `invalid byte sequence for encoding "UTF8": 0x00`}
+		}
+	}
+	return driver.RowsAffected(1), nil
+}
+
+// countingTx records the end of a transaction as a COMMIT or ROLLBACK statement.
+type countingTx struct {
+	conn *countingConn
+}
+
+func (tx *countingTx) Commit() error {
+	_, err := tx.conn.ExecContext(context.Background(), "COMMIT", nil)
+	return err
+}
+
+func (tx *countingTx) Rollback() error {
+	_, err := tx.conn.ExecContext(context.Background(), "ROLLBACK", nil)
+	return err
+}
+
+// execQueries returns the executed statements.
+func (d *countingDriver) execQueries() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	queries := make([]string, len(d.execs))
+	for i, exec := range d.execs {
+		queries[i] = exec.query
+	}
+	return queries
+}
+
+func (c *countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.driver.mu.Lock()
+	c.driver.queries[c.dsn]++
+	c.driver.mu.Unlock()
+
+	if strings.HasPrefix(c.dsn, "down") {
+		return nil, errors.New("connection refused")
+	}
+	if strings.Contains(query, "COUNT(DISTINCT user_id)") {
+		return &statsRows{}, nil
+	}
+	if query == "SELECT COUNT(*) FROM urls" {
+		return &countRows{}, nil
+	}
+	return &emptyRows{}, nil
+}
+
+// emptyRows is a result set without rows.
+type emptyRows struct{}
+
+func (r *emptyRows) Columns() []string              { return []string{"a", "b"} }
+func (r *emptyRows) Close() error                   { return nil }
+func (r *emptyRows) Next(dest []driver.Value) error { return io.EOF }
+
+// statsRows is the result of a stats query: 3 URLs, 1 deleted, 2 users.
+type statsRows struct {
+	done bool
+}
+
+func (r *statsRows) Columns() []string { return []string{"urls", "deleted", "users"} }
+func (r *statsRows) Close() error      { return nil }
+func (r *statsRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0], dest[1], dest[2] = int64(3), int64(1), int64(2)
+	return nil
+}
+
+// countRows is the result of a count query: 3 URLs, as in statsRows.
+type countRows struct {
+	done bool
+}
+
+func (r *countRows) Columns() []string { return []string{"count"} }
+func (r *countRows) Close() error      { return nil }
+func (r *countRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = int64(3)
+	return nil
+}
+
+var testDriver = &countingDriver{queries: make(map[string]int)}
+
+func init() {
+	sql.Register("counting", testDriver)
+}
+
+func TestDBStorage_ReadsUseReplica(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{ReplicaDSN: "replica"})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	// Only count queries made after the schema migrations
+	testDriver.reset()
+
+	if _, exists, _ := s.GetURL("abc123"); exists {
+		t.Error("Expected URL not to exist")
+	}
+	if _, err := s.GetURLsByUser("user1"); err != nil {
+		t.Errorf("GetURLsByUser() returned error: %v", err)
+	}
+	s.GetAllURLs()
+
+	if got := testDriver.count("replica"); got != 3 {
+		t.Errorf("Expected 3 queries on replica, got %d", got)
+	}
+	if got := testDriver.count("primary"); got != 0 {
+		t.Errorf("Expected no read queries on primary, got %d", got)
+	}
+
+	// Writes must go to the primary
+	s.AddURL("abc123", "https://example.com", "user1")
+	if got := testDriver.count("primary"); got != 1 {
+		t.Errorf("Expected 1 write query on primary, got %d", got)
+	}
+	if got := testDriver.count("replica"); got != 3 {
+		t.Errorf("Expected replica query count to stay at 3 after a write, got %d", got)
+	}
+}
+
+func TestDBStorage_ReplicaFallbackToPrimary(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{ReplicaDSN: "down-replica"})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	// Only count queries made after the schema migrations
+	testDriver.reset()
+
+	s.GetURL("abc123")
+	if _, err := s.GetURLsByUser("user1"); err != nil {
+		t.Errorf("GetURLsByUser() returned error: %v", err)
+	}
+
+	if got := testDriver.count("down-replica"); got != 2 {
+		t.Errorf("Expected 2 attempts on replica, got %d", got)
+	}
+	if got := testDriver.count("primary"); got != 2 {
+		t.Errorf("Expected 2 fallback queries on primary, got %d", got)
+	}
+}
+
+func TestDBStorage_WithoutReplica(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	// Only count queries made after the schema migrations
+	testDriver.reset()
+
+	s.GetURL("abc123")
+
+	if got := testDriver.count("primary"); got != 1 {
+		t.Errorf("Expected read on primary, got %d queries", got)
+	}
+}
+
+func TestDBStorage_PoolSettings(t *testing.T) {
+	testDriver.reset()
+
+	s, err := openDBStorage("counting", "primary", DBOptions{
+		ReplicaDSN:      "replica",
+		MaxOpenConns:    7,
+		MaxIdleConns:    3,
+		ConnMaxLifetime: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	for name, db := range map[string]*sql.DB{"primary": s.db, "replica": s.replica} {
+		if got := db.Stats().MaxOpenConnections; got != 7 {
+			t.Errorf("Expected %s pool to allow 7 open connections, got %d", name, got)
+		}
+	}
+}
+
+func TestDBStorage_WarmPool(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{
+		ReplicaDSN:   "replica",
+		MaxIdleConns: 4,
+		WarmPool:     true,
+	})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	for name, db := range map[string]*sql.DB{"primary": s.db, "replica": s.replica} {
+		if got := db.Stats().Idle; got != 4 {
+			t.Errorf("Expected %s pool to hold 4 idle connections, got %d", name, got)
+		}
+	}
+
+	// Without warmup, only the connection used at startup is kept
+	cold, err := openDBStorage("counting", "primary", DBOptions{MaxIdleConns: 4})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer cold.Close()
+	if got := cold.db.Stats().Idle; got != 1 {
+		t.Errorf("Expected 1 idle connection without warmup, got %d", got)
+	}
+}
+
+func TestDBStorage_StatsCache(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{StatsCacheTTL: time.Hour})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+	testDriver.reset()
+
+	for i := 0; i < 2; i++ {
+		stats, err := s.GetStats()
+		if err != nil {
+			t.Fatalf("GetStats() returned error: %v", err)
+		}
+		if stats != (Stats{URLs: 3, DeletedURLs: 1, Users: 2}) {
+			t.Errorf("Unexpected stats: %+v", stats)
+		}
+	}
+	if got := testDriver.count("primary"); got != 1 {
+		t.Errorf("Expected the second call within the TTL to be cached, got %d queries", got)
+	}
+
+	// Once the TTL passes, the database is queried again
+	s.statsCachedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := s.GetStats(); err != nil {
+		t.Fatalf("GetStats() returned error: %v", err)
+	}
+	if got := testDriver.count("primary"); got != 2 {
+		t.Errorf("Expected expired stats to be queried again, got %d queries", got)
+	}
+}
+
+func TestDBStorage_StatsWithoutCache(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{ApproximateStats: true})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+	testDriver.reset()
+
+	s.GetStats()
+	s.GetStats()
+	if got := testDriver.count("primary"); got != 2 {
+		t.Errorf("Expected every call to query the database, got %d queries", got)
+	}
+}
+
+func TestDBStorage_DeleteURLsChecksOwner(t *testing.T) {
+	s, err := openDBStorage("counting", "primary", DBOptions{})
+	if err != nil {
+		t.Fatalf("openDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+	testDriver.reset()
+
+	if err := s.DeleteURLs([]string{"abc123"}, "user1"); err != nil {
+		t.Fatalf("DeleteURLs() returned error: %v", err)
+	}
+
+	exec := testDriver.lastExec()
+	if !strings.Contains(exec.query, "user_id = $2") {
+		t.Errorf("Expected delete to be restricted to the owner, got %q", exec.query)
+	}
+	if len(exec.args) != 2 || exec.args[1].Value != "user1" {
+		t.Errorf("Expected the user ID to be bound as the second argument, got %v", exec.args)
+	}
+}
+
+func TestDBStorage_DeleteURLsOwnership(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	s, err := NewDBStorage(dsn)
+	if err != nil {
+		t.Fatalf("NewDBStorage() returned error: %v", err)
+	}
+	defer s.Close()
+
+	shortURL := fmt.Sprintf("own%d", time.Now().UnixNano())
+	if err := s.AddURL(shortURL, "https://"+shortURL+".example.com", "owner"); err != nil {
+		t.Fatalf("AddURL() returned error: %v", err)
+	}
+	defer s.db.Exec("DELETE FROM urls WHERE short_url = $1", shortURL)
+
+	if err := s.DeleteURLs([]string{shortURL}, "intruder"); err != nil {
+		t.Fatalf("DeleteURLs() returned error: %v", err)
+	}
+	if _, _, isDeleted := s.GetURL(shortURL); isDeleted {
+		t.Fatal("Expected a non-owner's delete to be a no-op")
+	}
+
+	if err := s.DeleteURLs([]string{shortURL}, "owner"); err != nil {
+		t.Fatalf("DeleteURLs() returned error: %v", err)
+	}
+	if _, _, isDeleted := s.GetURL(shortURL); !isDeleted {
+		t.Error("Expected the owner's delete to succeed")
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	other := errors.New("connection refused")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "original URL conflict",
+			err:  &pq.Error{Code: uniqueViolation, Constraint: "urls_url_key"},
+			want: ErrURLExists,
+		},
+		{
+			name: "short URL conflict",
+			err:  &pq.Error{Code: uniqueViolation, Constraint: "urls_short_url_key"},
+			want: ErrShortURLExists,
+		},
+		{
+			name: "other error",
+			err:  other,
+			want: other,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := conflictError(tt.err); !errors.Is(got, tt.want) {
+				t.Errorf("conflictError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDBStorage_AddURLs_PartialBatches(t *testing.T) {
+	batch := map[string]string{
+		"valid1":  "https://example.com",
+		"invalid": "https://example.com/\x00",
+		"valid2":  "https://google.com",
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		s, err := openDBStorage("counting", "primary", DBOptions{})
+		if err != nil {
+			t.Fatalf("openDBStorage() returned error: %v", err)
+		}
+		defer s.Close()
+		testDriver.reset()
+
+		err = s.AddURLs(batch, "user1")
+		var batchErr *BatchError
+		if err == nil || errors.As(err, &batchErr) {
+			t.Fatalf("Expected the whole batch to fail, got %v", err)
+		}
+		queries := testDriver.execQueries()
+		if last := queries[len(queries)-1]; last != "ROLLBACK" {
+			t.Errorf("Expected the transaction to be rolled back, got %q", last)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		s, err := openDBStorage("counting", "primary", DBOptions{PartialBatches: true})
+		if err != nil {
+			t.Fatalf("openDBStorage() returned error: %v", err)
+		}
+		defer s.Close()
+		testDriver.reset()
+
+		err = s.AddURLs(batch, "user1")
+		var batchErr *BatchError
+		if !errors.As(err, &batchErr) {
+			t.Fatalf("Expected a *BatchError, got %v", err)
+		}
+		if len(batchErr.Failed) != 1 || batchErr.Failed["invalid"] == nil {
+			t.Errorf("Expected only the invalid URL to be reported, got %v", batchErr.Failed)
+		}
+
+		// Valid rows are released from their savepoints and committed,
+		// the invalid one is rolled back to its savepoint
+		inserted := make(map[string]bool)
+		var rollbacks, releases int
+		for _, exec := range testDriver.execs {
+			switch {
+			case strings.HasPrefix(exec.query, "INSERT"):
+				inserted[exec.args[2].Value.(string)] = true
+			case exec.query == "ROLLBACK TO SAVEPOINT batch_row":
+				rollbacks++
+			case exec.query == "RELEASE SAVEPOINT batch_row":
+				releases++
+			}
+		}
+		if !inserted["valid1"] || !inserted["valid2"] {
+			t.Errorf("Expected valid rows to be inserted, got %v", inserted)
+		}
+		if rollbacks != 1 || releases != 2 {
+			t.Errorf("Expected 1 rollback and 2 releases of savepoints, got %d and %d", rollbacks, releases)
+		}
+		queries := testDriver.execQueries()
+		if last := queries[len(queries)-1]; last != "COMMIT" {
+			t.Errorf("Expected the valid rows to be committed, got %q", last)
+		}
+
+		// A batch without failures is stored without an error
+		if err := s.AddURLs(map[string]string{"valid3": "https://github.com"}, "user1"); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// migration is a schema change applied once per database.
+type migration struct {
+	// version orders migrations and records them in schema_migrations
+	version int
+	// description explains the change in error messages
+	description string
+	// up is the SQL applying the change
+	up string
+}
+
+// migrations lists all schema changes in the order they are applied.
+// Append new migrations to the end; never edit or reorder applied ones.
+//
+// Databases created before migrations were introduced already have some of
+// these changes, so the early migrations are written to be idempotent.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create urls table",
+		up: `
+		CREATE TABLE IF NOT EXISTS urls (
+			id SERIAL PRIMARY KEY,
+			url TEXT NOT NULL UNIQUE,
+			short_url TEXT NOT NULL UNIQUE,
+			user_id TEXT NOT NULL,
+			is_deleted BOOLEAN DEFAULT FALSE
+		);`,
+	},
+	{
+		version:     2,
+		description: "add timestamp columns",
+		up: `
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;`,
+	},
+	{
+		// Duplicates are detected by the normalized form instead of the submitted URL
+		version:     3,
+		description: "add normalized URL column",
+		up: `
+		ALTER TABLE urls ADD COLUMN IF NOT EXISTS normalized_url TEXT;
+		UPDATE urls SET normalized_url = url WHERE normalized_url IS NULL;
+		ALTER TABLE urls ALTER COLUMN normalized_url SET NOT NULL;
+		ALTER TABLE urls DROP CONSTRAINT IF EXISTS urls_url_key;
+		CREATE UNIQUE INDEX IF NOT EXISTS urls_normalized_url_key ON urls (normalized_url);`,
+	},
+	{
+		version:     4,
+		description: "add content type column",
+		up: `
+		ALTER TABLE urls ADD COLUMN content_type TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		version:     5,
+		description: "create idempotency keys table",
+		up: `
+		CREATE TABLE idempotency_keys (
+			user_id TEXT NOT NULL,
+			idempotency_key TEXT NOT NULL,
+			original_url TEXT NOT NULL,
+			short_url TEXT NOT NULL,
+			status INTEGER NOT NULL,
+			expires_at TIMESTAMPTZ NOT NULL,
+			PRIMARY KEY (user_id, idempotency_key)
+		);
+		CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);`,
+	},
+	{
+		version:     6,
+		description: "add creator IP hash column",
+		up: `
+		ALTER TABLE urls ADD COLUMN creator_ip_hash TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		version:     7,
+		description: "add page metadata columns",
+		up: `
+		ALTER TABLE urls ADD COLUMN title TEXT NOT NULL DEFAULT '';
+		ALTER TABLE urls ADD COLUMN favicon_url TEXT NOT NULL DEFAULT '';`,
+	},
+	{
+		version:     8,
+		description: "add protected column",
+		up: `
+		ALTER TABLE urls ADD COLUMN protected BOOLEAN NOT NULL DEFAULT FALSE;`,
+	},
+}
+
+// migrationLockID is the advisory lock key serializing migrations across instances.
+const migrationLockID = 7365742
+
+// migrate applies the migrations missing from the schema_migrations table.
+// Each migration runs in its own transaction together with its version record,
+// so an interrupted run is resumed on the next start.
+func migrate(db *sql.DB) error {
+	ctx := context.Background()
+
+	// Advisory locks belong to a session, so the whole run uses one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection for migrations: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	createQuery := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	`
+	if _, err := conn.ExecContext(ctx, createQuery); err != nil {
+		return fmt.Errorf("unable to create schema_migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		// Without arguments the statements are sent as one simple query,
+		// which PostgreSQL runs in a single implicit transaction
+		query := fmt.Sprintf("%s\nINSERT INTO schema_migrations (version) VALUES (%d);", m.up, m.version)
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+	}
+	return nil
+}
+
+// appliedMigrations returns the versions recorded in schema_migrations.
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+)
+
+func TestMigrations_Ordered(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Expected migration %d to have version %d, got %d", i, i+1, m.version)
+		}
+		if m.description == "" || m.up == "" {
+			t.Errorf("Migration %d lacks a description or SQL", m.version)
+		}
+	}
+}
+
+func TestMigrate_Idempotent(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("sql.Open() returned error: %v", err)
+	}
+	defer db.Close()
+
+	for run := 1; run <= 2; run++ {
+		if err := migrate(db); err != nil {
+			t.Fatalf("Run %d: migrate() returned error: %v", run, err)
+		}
+	}
+
+	var applied int
+	if err := db.QueryRow("SELECT COUNT(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatalf("Failed to count applied migrations: %v", err)
+	}
+	if applied != len(migrations) {
+		t.Errorf("Expected %d applied migrations, got %d", len(migrations), applied)
+	}
+
+	columns := map[string]bool{}
+	rows, err := db.Query("SELECT column_name FROM information_schema.columns WHERE table_name = 'urls'")
+	if err != nil {
+		t.Fatalf("Failed to list columns: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatalf("Failed to read column: %v", err)
+		}
+		columns[name] = true
+	}
+	for _, name := range []string{"id", "url", "normalized_url", "short_url", "user_id", "is_deleted", "created_at", "deleted_at"} {
+		if !columns[name] {
+			t.Errorf("Expected column %s to exist", name)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultPorts maps URL schemes to the ports that can be omitted.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeURL returns the form of rawURL used to detect duplicates.
+// The scheme and host are lowercased, default ports and trailing slashes
+// in the path are removed. URLs that can't be parsed or have no host
+// are returned unchanged.
+//
+// Example:
+//
+//	NormalizeURL("HTTPS://Example.com:443/Path/") // "https://example.com/Path"
+func NormalizeURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); port != "" && defaultPorts[u.Scheme] == port {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = strings.TrimRight(u.RawPath, "/")
+
+	return u.String()
+}
+
+// normalizer returns NormalizeURL when enabled, otherwise a function
+// returning URLs unchanged.
+func normalizer(enabled bool) func(string) string {
+	if enabled {
+		return NormalizeURL
+	}
+	return func(rawURL string) string { return rawURL }
+}
@@ -0,0 +1,27 @@
+package storage
+
+import "testing"
+
+func TestNormalizeURL(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "trailing slash", in: "https://example.com/path/", want: "https://example.com/path"},
+		{name: "root slash", in: "https://example.com/", want: "https://example.com"},
+		{name: "scheme and host case", in: "HTTPS://Example.COM/Path", want: "https://example.com/Path"},
+		{name: "default port", in: "http://example.com:80/a", want: "http://example.com/a"},
+		{name: "non-default port", in: "http://example.com:8080/a", want: "http://example.com:8080/a"},
+		{name: "query is kept", in: "https://example.com/a/?q=1", want: "https://example.com/a?q=1"},
+		{name: "not a URL", in: "not a url", want: "not a url"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeURL(tt.in); got != tt.want {
+				t.Errorf("NormalizeURL(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
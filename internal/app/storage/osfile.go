@@ -13,124 +13,346 @@ import (
 // URLMapping represents a single URL mapping entry for JSON serialization.
 // Used for storing URL data in JSON Lines format.
 type URLMapping struct {
-	UUID        string `json:"uuid"`
+	UUID        string `json:"uuid,omitempty"`
 	ShortURL    string `json:"short_url"`
 	OriginalURL string `json:"original_url"`
 	UserID      string `json:"user_id"`
+	IsDeleted   bool   `json:"is_deleted,omitempty"`
+	// ContentType is the content type the URL was submitted with, if recorded
+	ContentType string `json:"content_type,omitempty"`
+	// CreatorIPHash is the salted hash of the creator's IP address, if recorded
+	CreatorIPHash string `json:"creator_ip_hash,omitempty"`
+	// Protected URLs only resolve with a signed token, see Storage.SetProtected
+	Protected bool `json:"protected,omitempty"`
+	// CreatedAt and DeletedAt are when the URL was created and deleted, if known
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Title and FaviconURL describe the target page, if fetched
+	Title      string `json:"title,omitempty"`
+	FaviconURL string `json:"favicon_url,omitempty"`
+}
+
+// times returns when the URL of the record was created and, if it's deleted,
+// deleted. Times the record doesn't carry default to now.
+func (m URLMapping) times(now time.Time) (createdAt, deletedAt time.Time) {
+	createdAt = now
+	if m.CreatedAt != nil {
+		createdAt = *m.CreatedAt
+	}
+	if m.IsDeleted {
+		deletedAt = now
+		if m.DeletedAt != nil {
+			deletedAt = *m.DeletedAt
+		}
+	}
+	return createdAt, deletedAt
 }
 
 // BatchFileSaver provides efficient batch saving of URL mappings to file.
 // Accumulates URLs in memory and periodically saves them to reduce I/O operations.
+//
+// At most one file write runs at a time. Saves requested while a write is in
+// progress are coalesced: the next write stores everything queued meanwhile.
+//
+// With a pending limit set by SetMaxPending, the URLs are saved as soon as more
+// than that many are queued rather than on the next tick.
 type BatchFileSaver struct {
-	mu           sync.Mutex
-	pendingURLs  map[string]string
+	mu          sync.Mutex
+	pendingURLs map[string]string
+	maxPending  int
+
+	// flush requests an early save from the periodic save goroutine
+	flush chan struct{}
+	// interval changes the period of the periodic save goroutine
+	interval chan time.Duration
+
+	// saveMu serializes file writes; writes counts them
+	saveMu sync.Mutex
+	writes int
+
 	filePath     string
 	saveInterval time.Duration
+	stop         chan struct{}
+	done         chan struct{}
+	closeOnce    sync.Once
 }
 
+// defaultSaveInterval is how often savers created by GetBatchSaver save pending
+// URLs until SetSaveInterval is called.
+const defaultSaveInterval = 5 * time.Second
+
 var (
-	globalSaver     *BatchFileSaver
-	globalSaverOnce sync.Once
+	saversMu sync.Mutex
+	savers   = make(map[string]*BatchFileSaver)
 )
 
-// GetBatchSaver returns a singleton BatchFileSaver instance for the given file path.
-// Ensures only one saver exists per file to avoid conflicts.
+// GetBatchSaver returns the BatchFileSaver instance for the given file path,
+// creating it on first use. Ensures only one saver exists per file to avoid conflicts,
+// while different files get independent savers.
 func GetBatchSaver(filePath string) *BatchFileSaver {
-	globalSaverOnce.Do(func() {
-		globalSaver = &BatchFileSaver{
-			pendingURLs:  make(map[string]string),
-			filePath:     filePath,
-			saveInterval: 5 * time.Second,
-		}
-		go globalSaver.periodicSave()
-	})
-	return globalSaver
+	saversMu.Lock()
+	defer saversMu.Unlock()
+
+	if saver, ok := savers[filePath]; ok {
+		return saver
+	}
+
+	saver := newBatchFileSaver(filePath, defaultSaveInterval)
+	savers[filePath] = saver
+	return saver
+}
+
+// newBatchFileSaver creates a BatchFileSaver and starts its periodic save goroutine.
+func newBatchFileSaver(filePath string, saveInterval time.Duration) *BatchFileSaver {
+	saver := &BatchFileSaver{
+		pendingURLs:  make(map[string]string),
+		filePath:     filePath,
+		saveInterval: saveInterval,
+		flush:        make(chan struct{}, 1),
+		interval:     make(chan time.Duration),
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go saver.periodicSave()
+	return saver
 }
 
 // AddURL adds a URL mapping to the pending save queue.
-// Thread-safe operation that queues URL for next batch save.
+// Thread-safe operation that queues URL for next batch save. Exceeding the
+// pending limit requests an early save, which runs in the background, so
+// AddURL never waits for a file write.
 func (b *BatchFileSaver) AddURL(shortURL, originalURL string) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 	b.pendingURLs[shortURL] = originalURL
+	if b.maxPending > 0 && len(b.pendingURLs) > b.maxPending {
+		select {
+		case b.flush <- struct{}{}:
+		default:
+			// A save is already requested
+		}
+	}
+}
+
+// SetMaxPending sets how many URLs may be queued before they are saved
+// without waiting for the next periodic save (0 disables the limit).
+func (b *BatchFileSaver) SetMaxPending(maxPending int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maxPending = maxPending
+}
+
+// SetSaveInterval changes how often pending URLs are saved. It has no effect
+// once the saver is closed.
+func (b *BatchFileSaver) SetSaveInterval(interval time.Duration) {
+	select {
+	case b.interval <- interval:
+	case <-b.done:
+	}
 }
 
-// periodicSave runs in a goroutine to save pending URLs at regular intervals.
+// Flush immediately writes all pending URLs to file.
+func (b *BatchFileSaver) Flush() error {
+	return b.forceSave()
+}
+
+// Close stops the periodic save goroutine and performs a final save of pending URLs.
+// Safe to call multiple times; the saver can still be flushed explicitly afterwards.
+func (b *BatchFileSaver) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.stop)
+		<-b.done
+	})
+	return b.forceSave()
+}
+
+// periodicSave runs in a goroutine to save pending URLs at regular intervals, and
+// when an early save is requested, until Close is called.
 func (b *BatchFileSaver) periodicSave() {
+	defer close(b.done)
+
 	ticker := time.NewTicker(b.saveInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		b.forceSave()
+	for {
+		select {
+		case <-ticker.C:
+			b.forceSave()
+		case <-b.flush:
+			b.forceSave()
+		case interval := <-b.interval:
+			ticker.Reset(interval)
+		case <-b.stop:
+			return
+		}
 	}
 }
 
+// forceSave writes all pending URLs to file and returns once the URLs queued
+// before the call are stored. If another write is in progress, it waits for it
+// and then either finds its URLs already written or writes all URLs queued meanwhile.
 func (b *BatchFileSaver) forceSave() error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
 
-	if len(b.pendingURLs) == 0 {
+	b.mu.Lock()
+	batch := b.pendingURLs
+	if len(batch) == 0 {
+		b.mu.Unlock()
 		return nil
 	}
+	b.pendingURLs = make(map[string]string)
+	b.mu.Unlock()
 
-	return b.saveToFile()
+	if err := b.saveToFile(batch); err != nil {
+		// Requeue the batch without overwriting mappings queued during the write
+		b.mu.Lock()
+		for shortURL, originalURL := range batch {
+			if _, queued := b.pendingURLs[shortURL]; !queued {
+				b.pendingURLs[shortURL] = originalURL
+			}
+		}
+		b.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
-func (b *BatchFileSaver) saveToFile() error {
-	tmpFile := b.filePath + ".tmp"
-	file, err := os.Create(tmpFile)
+// Replace atomically rewrites the file with exactly records, dropping the
+// mappings saved earlier that records don't contain. Pending URLs stay queued.
+func (b *BatchFileSaver) Replace(records []URLMapping) error {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
+
+	b.writes++
+	return b.writeFile(records)
+}
+
+// saveToFile appends batch to the file in the JSON Lines format read by
+// LoadURLMappings. Requires b.saveMu. Only the new records are written, so a
+// mapping saved again shadows its earlier record rather than replacing it;
+// Replace compacts the file. A failed append is truncated away, so that the
+// batch can be written again without leaving a broken line behind.
+func (b *BatchFileSaver) saveToFile(batch map[string]string) error {
+	b.writes++
+
+	file, err := os.OpenFile(b.filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
 
 	writer := bufio.NewWriter(file)
-	defer writer.Flush()
-
-	for shortURL, originalURL := range b.pendingURLs {
-		mapping := URLMapping{
+	for shortURL, originalURL := range batch {
+		err = writeURLRecord(writer, URLMapping{
 			UUID:        generateUUID(),
 			ShortURL:    shortURL,
 			OriginalURL: originalURL,
 			UserID:      "system",
-		}
-		line, err := json.Marshal(mapping)
+		})
 		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Truncate(stat.Size())
+		return err
+	}
+	return file.Close()
+}
+
+// writeFile writes records to a temporary file and atomically replaces the file
+// with it. Requires b.saveMu.
+func (b *BatchFileSaver) writeFile(records []URLMapping) error {
+	tmpFile := b.filePath + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, mapping := range records {
+		if err := writeURLRecord(writer, mapping); err != nil {
 			return err
 		}
-		writer.Write(line)
-		writer.WriteString("\n")
 	}
 
-	b.pendingURLs = make(map[string]string)
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
 
 	return os.Rename(tmpFile, b.filePath)
 }
 
-// LoadURLMappings loads URL mappings from a JSON Lines file.
-// Returns empty map if file doesn't exist. Skips invalid JSON entries.
-func LoadURLMappings(filePath string) (map[string]string, error) {
-	urlMap := make(map[string]string)
-	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return urlMap, nil
+// writeURLRecord writes a single mapping as one JSON Lines entry.
+func writeURLRecord(w *bufio.Writer, mapping URLMapping) error {
+	line, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(line); err != nil {
+		return err
 	}
+	return w.WriteByte('\n')
+}
 
+// LoadURLRecords reads all mapping records, including their owners, from a JSON Lines file.
+// Returns no records if file doesn't exist. Skips invalid JSON entries.
+// A short URL saved several times yields its last record only.
+func LoadURLRecords(filePath string) ([]URLMapping, error) {
 	file, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
+	var records []URLMapping
+	indexes := make(map[string]int)
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		var mapping URLMapping
 		if err := json.Unmarshal(scanner.Bytes(), &mapping); err != nil {
 			continue
 		}
+		if i, saved := indexes[mapping.ShortURL]; saved {
+			records[i] = mapping
+			continue
+		}
+		indexes[mapping.ShortURL] = len(records)
+		records = append(records, mapping)
+	}
+
+	return records, scanner.Err()
+}
+
+// LoadURLMappings loads URL mappings from a JSON Lines file.
+// Returns empty map if file doesn't exist. Skips invalid JSON entries.
+func LoadURLMappings(filePath string) (map[string]string, error) {
+	records, err := LoadURLRecords(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	urlMap := make(map[string]string, len(records))
+	for _, mapping := range records {
 		urlMap[mapping.ShortURL] = mapping.OriginalURL
 	}
 
-	return urlMap, scanner.Err()
+	return urlMap, nil
 }
 
 // SaveURLMappings saves a map of URL mappings to file using batch saver.
@@ -153,6 +375,24 @@ func SaveSingleURLMapping(filePath string, shortURL, originalURL string) error {
 	return saver.forceSave()
 }
 
+// SaveAllURLs rewrites the file with the complete records of all URLs in store,
+// as returned by Export, and returns the number of written records. Unlike the
+// mappings saved one by one, the records keep their owners and flags, and URLs
+// removed from store are dropped from the file rather than loaded again.
+func SaveAllURLs(filePath string, store Storage) (int, error) {
+	saver := GetBatchSaver(filePath)
+	// Save pending URLs first, so that the rewrite drops those removed meanwhile
+	if err := saver.Flush(); err != nil {
+		return 0, err
+	}
+
+	records, err := store.Export()
+	if err != nil {
+		return 0, err
+	}
+	return len(records), saver.Replace(records)
+}
+
 func generateUUID() string {
 	return uuid.New().String()
 }
@@ -1,9 +1,14 @@
 package storage
 
 import (
+	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestGenerateUUID(t *testing.T) {
@@ -164,14 +169,404 @@ func TestSaveSingleURLMapping(t *testing.T) {
 		t.Errorf("LoadURLMappings() returned error: %v", err)
 	}
 
-	if len(urlMap) < 1 {
-		t.Errorf("Expected at least 1 URL, got %d", len(urlMap))
+	if len(urlMap) != 1 {
+		t.Errorf("Expected 1 URL, got %d", len(urlMap))
 	}
 
-	// Check if our URL is present (there might be other URLs from global saver)
 	if originalURL, exists := urlMap["short1"]; !exists {
 		t.Error("Expected 'short1' URL not found")
 	} else if originalURL != "https://example.com" {
 		t.Errorf("Expected 'https://example.com', got '%s'", originalURL)
 	}
 }
+
+func TestGetBatchSaver_SeparateFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	firstFile := filepath.Join(tempDir, "first.json")
+	secondFile := filepath.Join(tempDir, "second.json")
+
+	if GetBatchSaver(firstFile) == GetBatchSaver(secondFile) {
+		t.Fatal("Expected different savers for different file paths")
+	}
+	if GetBatchSaver(firstFile) != GetBatchSaver(firstFile) {
+		t.Fatal("Expected the same saver for the same file path")
+	}
+
+	if err := SaveSingleURLMapping(firstFile, "first", "https://first.example.com"); err != nil {
+		t.Fatalf("SaveSingleURLMapping() returned error: %v", err)
+	}
+	if err := SaveSingleURLMapping(secondFile, "second", "https://second.example.com"); err != nil {
+		t.Fatalf("SaveSingleURLMapping() returned error: %v", err)
+	}
+
+	firstMap, err := LoadURLMappings(firstFile)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	secondMap, err := LoadURLMappings(secondFile)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+
+	if len(firstMap) != 1 || firstMap["first"] != "https://first.example.com" {
+		t.Errorf("Unexpected contents of first file: %v", firstMap)
+	}
+	if len(secondMap) != 1 || secondMap["second"] != "https://second.example.com" {
+		t.Errorf("Unexpected contents of second file: %v", secondMap)
+	}
+}
+
+func TestBatchFileSaver_Close(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_close.json")
+
+	// Use a long interval so only Close can persist the pending URLs
+	saver := newBatchFileSaver(testFile, time.Hour)
+	saver.AddURL("short1", "https://example.com")
+	saver.AddURL("short2", "https://google.com")
+
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected file not to exist before Close, got err=%v", err)
+	}
+
+	if err := saver.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	// The periodic save goroutine must have exited
+	select {
+	case <-saver.done:
+	default:
+		t.Error("Expected periodic save goroutine to be stopped after Close")
+	}
+
+	urlMap, err := LoadURLMappings(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	if len(urlMap) != 2 {
+		t.Errorf("Expected 2 URLs on disk, got %d", len(urlMap))
+	}
+	if urlMap["short1"] != "https://example.com" || urlMap["short2"] != "https://google.com" {
+		t.Errorf("Unexpected URLs on disk: %v", urlMap)
+	}
+
+	// Closing twice must be safe
+	if err := saver.Close(); err != nil {
+		t.Errorf("Second Close() returned error: %v", err)
+	}
+}
+
+func TestBatchFileSaver_MaxPending(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_max_pending.json")
+
+	// Use a long interval so only the pending limit can trigger a save
+	saver := newBatchFileSaver(testFile, time.Hour)
+	defer saver.Close()
+	saver.SetMaxPending(3)
+
+	for i := 0; i < 3; i++ {
+		saver.AddURL(fmt.Sprintf("short%d", i), fmt.Sprintf("https://example.com/%d", i))
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected no save at the limit, got err=%v", err)
+	}
+
+	saver.AddURL("short3", "https://example.com/3")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		urlMap, err := LoadURLMappings(testFile)
+		if err != nil {
+			t.Fatalf("LoadURLMappings() returned error: %v", err)
+		}
+		if len(urlMap) == 4 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 4 URLs to be saved early, got %d", len(urlMap))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBatchFileSaver_SetSaveInterval(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_interval.json")
+	saver := newBatchFileSaver(testFile, time.Hour)
+	defer saver.Close()
+
+	const interval = 20 * time.Millisecond
+	saver.SetSaveInterval(interval)
+	start := time.Now()
+	saver.AddURL("short1", "https://example.com")
+
+	for {
+		urlMap, err := LoadURLMappings(testFile)
+		if err != nil {
+			t.Fatalf("LoadURLMappings() returned error: %v", err)
+		}
+		if len(urlMap) == 1 {
+			break
+		}
+		if time.Since(start) > 50*interval {
+			t.Fatalf("Expected the URL to be saved within a few %v ticks", interval)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Changing the interval of a closed saver doesn't block
+	saver.Close()
+	saver.SetSaveInterval(time.Second)
+}
+
+func TestBatchFileSaver_CoalescesConcurrentSaves(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_coalesce.json")
+	saver := newBatchFileSaver(testFile, time.Hour)
+	defer saver.Close()
+
+	// Hold the write lock as if a slow write were in progress while all saves are requested
+	saver.saveMu.Lock()
+
+	const saves = 200
+	errs := make(chan error, saves)
+	var wg sync.WaitGroup
+	for i := 0; i < saves; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			saver.AddURL(fmt.Sprintf("short%d", i), fmt.Sprintf("https://example.com/%d", i))
+			errs <- saver.forceSave()
+		}(i)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		saver.mu.Lock()
+		queued := len(saver.pendingURLs)
+		saver.mu.Unlock()
+		if queued == saves {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued URLs, got %d", saves, queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	saver.saveMu.Unlock()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("forceSave() returned error: %v", err)
+		}
+	}
+
+	// Every save returned after its URL was written
+	urlMap, err := LoadURLMappings(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	if len(urlMap) != saves {
+		t.Errorf("Expected %d URLs on disk, got %d", saves, len(urlMap))
+	}
+
+	saver.saveMu.Lock()
+	writes := saver.writes
+	saver.saveMu.Unlock()
+	if writes != 1 {
+		t.Errorf("Expected overlapping saves to share a single write, got %d writes for %d saves", writes, saves)
+	}
+}
+
+func TestBatchFileSaver_RoundTripWithLoader(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_roundtrip.json")
+
+	saver := newBatchFileSaver(testFile, time.Hour)
+	defer saver.Close()
+
+	// Successive saves must accumulate instead of replacing earlier records
+	saver.AddURL("short1", "https://example.com")
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	saver.AddURL("short2", "https://google.com")
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	urlMap, err := LoadURLMappings(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLMappings() returned error: %v", err)
+	}
+	expected := map[string]string{
+		"short1": "https://example.com",
+		"short2": "https://google.com",
+	}
+	if len(urlMap) != len(expected) {
+		t.Fatalf("Expected %d URLs, got %d: %v", len(expected), len(urlMap), urlMap)
+	}
+	for short, original := range expected {
+		if urlMap[short] != original {
+			t.Errorf("Expected %s -> %s, got %s", short, original, urlMap[short])
+		}
+	}
+
+	// Every line written by the saver must be a standalone JSON Lines record
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 JSON lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		var mapping URLMapping
+		if err := json.Unmarshal([]byte(line), &mapping); err != nil {
+			t.Errorf("Line is not a valid JSON record: %q", line)
+		}
+	}
+}
+
+func TestBatchFileSaver_ReadsFileWrittenByHand(t *testing.T) {
+	tempDir := t.TempDir()
+	testFile := filepath.Join(tempDir, "test_existing.json")
+
+	content := `{"uuid":"1","short_url":"short1","original_url":"https://example.com","user_id":"user1"}
+`
+	if err := os.WriteFile(testFile, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	saver := newBatchFileSaver(testFile, time.Hour)
+	saver.AddURL("short2", "https://google.com")
+	if err := saver.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	records, err := LoadURLRecords(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLRecords() returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+	if records[0].UUID != "1" || records[0].UserID != "user1" {
+		t.Errorf("Expected existing record to be preserved, got %+v", records[0])
+	}
+}
+
+func TestBatchFileSaver_AppendsRecords(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_append.json")
+	saver := newBatchFileSaver(testFile, time.Hour)
+	defer saver.Close()
+
+	saver.AddURL("short1", "https://example.com")
+	saver.AddURL("short2", "https://google.com")
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+	saver.AddURL("short1", "https://example.org")
+	if err := saver.Flush(); err != nil {
+		t.Fatalf("Flush() returned error: %v", err)
+	}
+
+	data, err := os.ReadFile(testFile)
+	if err != nil {
+		t.Fatalf("Failed to read file: %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("Expected the second save to append 1 line to 2, got %d lines", lines)
+	}
+	records, err := LoadURLRecords(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLRecords() returned error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %+v", records)
+	}
+	for _, record := range records {
+		if record.ShortURL == "short1" && record.OriginalURL != "https://example.org" {
+			t.Errorf("Expected the last saved record of short1, got %+v", record)
+		}
+	}
+
+	// Replace compacts the file
+	if err := saver.Replace(records); err != nil {
+		t.Fatalf("Replace() returned error: %v", err)
+	}
+	data, _ = os.ReadFile(testFile)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("Expected 2 lines after Replace, got %d", lines)
+	}
+}
+
+func TestSaveAllURLs(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "test_all.json")
+
+	// A URL saved earlier and removed from storage since
+	if err := SaveSingleURLMapping(testFile, "purged", "https://purged.com"); err != nil {
+		t.Fatalf("SaveSingleURLMapping() returned error: %v", err)
+	}
+
+	store := NewURLStorage()
+	store.AddURL("short1", "https://example.com", "user1")
+	store.AddURL("private", "https://private.com", "user1")
+	store.SetProtected([]string{"private"})
+	store.AddURL("gone", "https://gone.com", "user2")
+	store.DeleteURLs([]string{"gone"}, "user2")
+
+	written, err := SaveAllURLs(testFile, store)
+	if err != nil {
+		t.Fatalf("SaveAllURLs() returned error: %v", err)
+	}
+	if written != 3 {
+		t.Errorf("Expected 3 written records, got %d", written)
+	}
+
+	records, err := LoadURLRecords(testFile)
+	if err != nil {
+		t.Fatalf("LoadURLRecords() returned error: %v", err)
+	}
+	saved := make(map[string]URLMapping, len(records))
+	for _, record := range records {
+		saved[record.ShortURL] = record
+	}
+	if len(saved) != 3 {
+		t.Fatalf("Expected 3 records, got %+v", records)
+	}
+	if _, ok := saved["purged"]; ok {
+		t.Error("Expected the URL removed from storage to be dropped from the file")
+	}
+	if record := saved["short1"]; record.UserID != "user1" || record.Protected || record.IsDeleted {
+		t.Errorf("Expected a plain record of user1, got %+v", record)
+	}
+	if !saved["private"].Protected {
+		t.Errorf("Expected the protected flag to be saved, got %+v", saved["private"])
+	}
+	if !saved["gone"].IsDeleted {
+		t.Errorf("Expected the deletion flag to be saved, got %+v", saved["gone"])
+	}
+
+	// The records load back as they were
+	reloaded := NewURLStorage()
+	for _, record := range records {
+		if err := reloaded.Import([]URLMapping{record}); err != nil {
+			t.Fatalf("Import() returned error: %v", err)
+		}
+	}
+	if info, _, _ := reloaded.GetURLInfo("private"); !info.Protected {
+		t.Error("Expected the reloaded URL to stay protected")
+	}
+	if _, _, isDeleted := reloaded.GetURL("gone"); !isDeleted {
+		t.Error("Expected the reloaded URL to stay deleted")
+	}
+	// Reloading doesn't reset the age of URLs and tombstones
+	stored, _, _ := store.GetURLInfo("gone")
+	if info, _, _ := reloaded.GetURLInfo("gone"); !info.CreatedAt.Equal(stored.CreatedAt) || !info.DeletedAt.Equal(stored.DeletedAt) {
+		t.Errorf("Expected the times %v and %v to be kept, got %v and %v",
+			stored.CreatedAt, stored.DeletedAt, info.CreatedAt, info.DeletedAt)
+	}
+}
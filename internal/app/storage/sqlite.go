@@ -0,0 +1,691 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLiteDSNPrefix marks database DSNs selecting SQLiteStorage, e.g. "sqlite://urls.db".
+const SQLiteDSNPrefix = "sqlite://"
+
+// SQLitePath returns the database file path of a SQLite DSN
+// and reports whether dsn selects SQLiteStorage.
+func SQLitePath(dsn string) (string, bool) {
+	if !strings.HasPrefix(dsn, SQLiteDSNPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(dsn, SQLiteDSNPrefix), true
+}
+
+// sqliteSchema mirrors the PostgreSQL schema built by the migrations.
+// Timestamps are stored as Unix nanoseconds, as SQLite has no native time type.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS urls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url TEXT NOT NULL,
+	normalized_url TEXT NOT NULL UNIQUE,
+	short_url TEXT NOT NULL UNIQUE,
+	user_id TEXT NOT NULL,
+	is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at INTEGER NOT NULL,
+	deleted_at INTEGER,
+	content_type TEXT NOT NULL DEFAULT '',
+	creator_ip_hash TEXT NOT NULL DEFAULT '',
+	title TEXT NOT NULL DEFAULT '',
+	favicon_url TEXT NOT NULL DEFAULT '',
+	protected BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	user_id TEXT NOT NULL,
+	idempotency_key TEXT NOT NULL,
+	original_url TEXT NOT NULL,
+	short_url TEXT NOT NULL,
+	status INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	PRIMARY KEY (user_id, idempotency_key)
+);`
+
+// sqliteAddedColumns are the columns of the urls table added after its first
+// release. They are added to databases created without them.
+var sqliteAddedColumns = []struct{ name, definition string }{
+	{"creator_ip_hash", "TEXT NOT NULL DEFAULT ''"},
+	{"title", "TEXT NOT NULL DEFAULT ''"},
+	{"favicon_url", "TEXT NOT NULL DEFAULT ''"},
+	{"protected", "BOOLEAN NOT NULL DEFAULT FALSE"},
+}
+
+// SQLiteOptions contains optional settings for SQLiteStorage.
+type SQLiteOptions struct {
+	// NormalizeURLs detects duplicates by the normalized form of URLs (see NormalizeURL)
+	NormalizeURLs bool
+}
+
+// SQLiteStorage implements the Storage interface using an embedded SQLite database.
+// Provides persistence without a database server, with the same schema and
+// soft delete semantics as DBStorage. Uses the pure-Go modernc.org/sqlite driver.
+//
+// SQLite allows a single writer at a time, so the storage uses one connection.
+type SQLiteStorage struct {
+	db        *sql.DB
+	normalize func(string) string
+}
+
+// NewSQLiteStorage opens or creates the SQLite database at path and creates the schema.
+func NewSQLiteStorage(path string, opts SQLiteOptions) (*SQLiteStorage, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to create table: %v", err)
+	}
+	if err := addSQLiteColumns(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStorage{db: db, normalize: normalizer(opts.NormalizeURLs)}, nil
+}
+
+// addSQLiteColumns adds the missing sqliteAddedColumns to the urls table.
+func addSQLiteColumns(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('urls')`)
+	if err != nil {
+		return fmt.Errorf("failed to query columns: %v", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan column: %v", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows error: %v", err)
+	}
+
+	for _, column := range sqliteAddedColumns {
+		if existing[column.name] {
+			continue
+		}
+		if _, err := db.Exec(`ALTER TABLE urls ADD COLUMN ` + column.name + ` ` + column.definition); err != nil {
+			return fmt.Errorf("failed to add column %s: %v", column.name, err)
+		}
+	}
+	return nil
+}
+
+// sqliteConflictError translates unique constraint violations into typed storage errors.
+// Other errors are returned unchanged.
+func sqliteConflictError(err error) error {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) || sqliteErr.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return err
+	}
+	if strings.Contains(sqliteErr.Error(), "short_url") {
+		return ErrShortURLExists
+	}
+	return ErrURLExists
+}
+
+// placeholders returns a comma-separated list of n query placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// AddURL adds a new URL mapping to the database.
+// Returns ErrURLExists if URL already exists, ErrShortURLExists if the short URL
+// is taken, or an error if database operation fails.
+func (s *SQLiteStorage) AddURL(shortURL, originalURL, userID string) error {
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id, created_at) VALUES (?, ?, ?, ?, ?)`
+	_, err := s.db.Exec(query, originalURL, s.normalize(originalURL), shortURL, userID, time.Now().UnixNano())
+	if err != nil {
+		if conflict := sqliteConflictError(err); conflict != err {
+			return conflict
+		}
+		return fmt.Errorf("failed to add URL to database: %v", err)
+	}
+	return nil
+}
+
+// ReplaceDeletedURL removes the deleted mapping of originalURL stored under
+// deletedShortURL and adds a live mapping under shortURL in a single transaction.
+func (s *SQLiteStorage) ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	normalizedURL := s.normalize(originalURL)
+	result, err := tx.Exec(`DELETE FROM urls WHERE short_url = ? AND normalized_url = ? AND is_deleted`,
+		deletedShortURL, normalizedURL)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove deleted URL: %v", err)
+	}
+	if removed, err := result.RowsAffected(); err != nil || removed == 0 {
+		tx.Rollback()
+		if err != nil {
+			return fmt.Errorf("failed to remove deleted URL: %v", err)
+		}
+		return ErrURLExists
+	}
+
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id, created_at) VALUES (?, ?, ?, ?, ?)`
+	if _, err := tx.Exec(query, originalURL, normalizedURL, shortURL, userID, time.Now().UnixNano()); err != nil {
+		tx.Rollback()
+		if conflict := sqliteConflictError(err); conflict != err {
+			return conflict
+		}
+		return fmt.Errorf("failed to add URL to database: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// AddURLs adds multiple URL mappings in a single database transaction.
+// Rolls back all changes if any URL fails to insert.
+func (s *SQLiteStorage) AddURLs(urls map[string]string, userID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	now := time.Now().UnixNano()
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id, created_at) VALUES (?, ?, ?, ?, ?)`
+	for shortURL, originalURL := range urls {
+		_, err := tx.Exec(query, originalURL, s.normalize(originalURL), shortURL, userID, now)
+		if err != nil {
+			tx.Rollback()
+			if conflict := sqliteConflictError(err); conflict != err {
+				return conflict
+			}
+			return fmt.Errorf("failed to add URL to database: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+
+	return nil
+}
+
+// GetURL retrieves the original URL and deletion status for a short URL.
+// Returns original URL, existence flag, and deletion status.
+func (s *SQLiteStorage) GetURL(shortURL string) (string, bool, bool) {
+	var originalURL string
+	var isDeleted bool
+	query := `SELECT url, is_deleted FROM urls WHERE short_url = ?`
+	if err := s.db.QueryRow(query, shortURL).Scan(&originalURL, &isDeleted); err != nil {
+		return "", false, false
+	}
+	return originalURL, true, isDeleted
+}
+
+// GetURLsByUser retrieves all URL mappings created by a specific user.
+func (s *SQLiteStorage) GetURLsByUser(userID string) (map[string]string, error) {
+	urlMap, err := s.queryURLMap(`SELECT short_url, url FROM urls WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URLs by user: %v", err)
+	}
+	return urlMap, nil
+}
+
+// GetUserURLs retrieves a page of URLs created by a specific user with their deletion status.
+// Pagination is done in SQL with LIMIT/OFFSET, ordered by insertion
+// (newest first with page.NewestFirst).
+func (s *SQLiteStorage) GetUserURLs(userID string, page Page) ([]UserURL, error) {
+	// LIMIT -1 returns all rows
+	limit := -1
+	if page.Limit > 0 {
+		limit = page.Limit
+	}
+	order := "id"
+	if page.NewestFirst {
+		order = "id DESC"
+	}
+	query := `SELECT short_url, url, is_deleted, content_type, created_at, deleted_at, title, favicon_url FROM urls
+	WHERE user_id = ? ORDER BY ` + order + ` LIMIT ? OFFSET ?`
+	rows, err := s.db.Query(query, userID, limit, page.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URLs by user: %v", err)
+	}
+	defer rows.Close()
+
+	var result []UserURL
+	for rows.Next() {
+		var u UserURL
+		var createdAt int64
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&u.ShortURL, &u.OriginalURL, &u.IsDeleted, &u.ContentType, &createdAt,
+			&deletedAt, &u.Title, &u.FaviconURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		u.CreatedAt = time.Unix(0, createdAt)
+		if deletedAt.Valid {
+			u.DeletedAt = time.Unix(0, deletedAt.Int64)
+		}
+		result = append(result, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	return result, nil
+}
+
+// CountUserURLs returns the number of the specified user's URLs that are not deleted.
+func (s *SQLiteStorage) CountUserURLs(userID string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM urls WHERE user_id = ? AND NOT is_deleted`
+	if err := s.db.QueryRow(query, userID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count URLs by user: %v", err)
+	}
+	return count, nil
+}
+
+// Count returns the total number of stored URLs, or 0 if the query fails,
+// logging the error.
+func (s *SQLiteStorage) Count() int {
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM urls`).Scan(&count); err != nil {
+		fmt.Printf("Failed to count URLs: %v\n", err)
+		return 0
+	}
+	return count
+}
+
+// GetAllURLs retrieves all URL mappings from the database.
+func (s *SQLiteStorage) GetAllURLs() map[string]string {
+	urlMap, err := s.queryURLMap(`SELECT short_url, url FROM urls`)
+	if err != nil {
+		fmt.Printf("Failed to get URLs from database: %v\n", err)
+		return map[string]string{}
+	}
+	return urlMap
+}
+
+// queryURLMap runs a query selecting short and original URLs and collects them into a map.
+func (s *SQLiteStorage) queryURLMap(query string, args ...interface{}) (map[string]string, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urlMap := make(map[string]string)
+	for rows.Next() {
+		var shortURL, originalURL string
+		if err := rows.Scan(&shortURL, &originalURL); err != nil {
+			return nil, err
+		}
+		urlMap[shortURL] = originalURL
+	}
+	return urlMap, rows.Err()
+}
+
+// GetShortURLByOriginalURL finds the short URL for a given original URL.
+// With normalization enabled, any URL with the same normalized form matches.
+func (s *SQLiteStorage) GetShortURLByOriginalURL(originalURL string) (string, bool) {
+	var shortURL string
+	query := `SELECT short_url FROM urls WHERE normalized_url = ?`
+	err := s.db.QueryRow(query, s.normalize(originalURL)).Scan(&shortURL)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			fmt.Printf("Failed to get short URL by original URL: %v", err)
+		}
+		return "", false
+	}
+	return shortURL, true
+}
+
+// DeleteURLs soft-deletes URLs by setting is_deleted flag to true.
+// Only URLs owned by userID are deleted; others are left untouched.
+func (s *SQLiteStorage) DeleteURLs(shortURLs []string, userID string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	query := `UPDATE urls SET is_deleted = TRUE, deleted_at = ?
+	WHERE user_id = ? AND short_url IN (` + placeholders(len(shortURLs)) + `) AND NOT is_deleted`
+	args := []interface{}{time.Now().UnixNano(), userID}
+	for _, shortURL := range shortURLs {
+		args = append(args, shortURL)
+	}
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// RestoreURLs clears the deleted flag of specified URLs owned by the given user.
+func (s *SQLiteStorage) RestoreURLs(shortURLs []string, userID string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	query := `UPDATE urls SET is_deleted = FALSE, deleted_at = NULL
+	WHERE user_id = ? AND short_url IN (` + placeholders(len(shortURLs)) + `) AND is_deleted`
+	args := []interface{}{userID}
+	for _, shortURL := range shortURLs {
+		args = append(args, shortURL)
+	}
+	_, err := s.db.Exec(query, args...)
+	return err
+}
+
+// SetContentType records the content type the specified URLs were submitted with.
+func (s *SQLiteStorage) SetContentType(shortURLs []string, contentType string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	query := `UPDATE urls SET content_type = ? WHERE short_url IN (` + placeholders(len(shortURLs)) + `)`
+	args := []interface{}{contentType}
+	for _, shortURL := range shortURLs {
+		args = append(args, shortURL)
+	}
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to set content type: %v", err)
+	}
+	return nil
+}
+
+// SetCreatorIPHash records the hash of the IP address the specified URLs were created from.
+func (s *SQLiteStorage) SetCreatorIPHash(shortURLs []string, hash string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	query := `UPDATE urls SET creator_ip_hash = ? WHERE short_url IN (` + placeholders(len(shortURLs)) + `)`
+	args := []interface{}{hash}
+	for _, shortURL := range shortURLs {
+		args = append(args, shortURL)
+	}
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to set creator IP hash: %v", err)
+	}
+	return nil
+}
+
+// SetProtected marks the specified URLs as protected.
+func (s *SQLiteStorage) SetProtected(shortURLs []string) error {
+	if len(shortURLs) == 0 {
+		return nil
+	}
+	query := `UPDATE urls SET protected = TRUE WHERE short_url IN (` + placeholders(len(shortURLs)) + `)`
+	args := make([]interface{}, 0, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		args = append(args, shortURL)
+	}
+	if _, err := s.db.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to set protected: %v", err)
+	}
+	return nil
+}
+
+// SetMetadata records the title and favicon URL of the page a short URL points to.
+func (s *SQLiteStorage) SetMetadata(shortURL, title, faviconURL string) error {
+	query := `UPDATE urls SET title = ?, favicon_url = ? WHERE short_url = ?`
+	if _, err := s.db.Exec(query, title, faviconURL, shortURL); err != nil {
+		return fmt.Errorf("failed to set metadata: %v", err)
+	}
+	return nil
+}
+
+// GetIdempotentResult returns the unexpired result saved for the user's idempotency key.
+func (s *SQLiteStorage) GetIdempotentResult(userID, key string) (IdempotentResult, bool, error) {
+	var result IdempotentResult
+	var expiresAt int64
+	query := `SELECT original_url, short_url, status, expires_at FROM idempotency_keys
+	WHERE user_id = ? AND idempotency_key = ? AND expires_at > ?`
+	err := s.db.QueryRow(query, userID, key, time.Now().UnixNano()).Scan(&result.OriginalURL, &result.ShortURL, &result.Status, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IdempotentResult{}, false, nil
+	}
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to query idempotency key: %v", err)
+	}
+	result.ExpiresAt = time.Unix(0, expiresAt)
+	return result, true, nil
+}
+
+// SaveIdempotentResult saves the result of the user's request with an idempotency key.
+// Expired results are deleted on the way.
+func (s *SQLiteStorage) SaveIdempotentResult(userID, key string, result IdempotentResult) error {
+	if _, err := s.db.Exec(`DELETE FROM idempotency_keys WHERE expires_at <= ?`, time.Now().UnixNano()); err != nil {
+		return fmt.Errorf("failed to delete expired idempotency keys: %v", err)
+	}
+	query := `INSERT OR REPLACE INTO idempotency_keys (user_id, idempotency_key, original_url, short_url, status, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := s.db.Exec(query, userID, key, result.OriginalURL, result.ShortURL, result.Status, result.ExpiresAt.UnixNano()); err != nil {
+		return fmt.Errorf("failed to save idempotency key: %v", err)
+	}
+	return nil
+}
+
+// ReserveIdempotentResult saves result for the user's idempotency key unless
+// an unexpired result is saved for it already, which is returned instead.
+// An expired result is replaced within the insert, so concurrent reservations
+// of a key can't both succeed.
+func (s *SQLiteStorage) ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error) {
+	query := `INSERT INTO idempotency_keys (user_id, idempotency_key, original_url, short_url, status, expires_at)
+	VALUES (?, ?, ?, ?, ?, ?)
+	ON CONFLICT (user_id, idempotency_key) DO UPDATE SET original_url = excluded.original_url,
+		short_url = excluded.short_url, status = excluded.status, expires_at = excluded.expires_at
+	WHERE idempotency_keys.expires_at <= ?`
+	res, err := s.db.Exec(query, userID, key, result.OriginalURL, result.ShortURL, result.Status,
+		result.ExpiresAt.UnixNano(), time.Now().UnixNano())
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	reserved, err := res.RowsAffected()
+	if err != nil {
+		return IdempotentResult{}, false, fmt.Errorf("failed to reserve idempotency key: %v", err)
+	}
+	if reserved > 0 {
+		return IdempotentResult{}, true, nil
+	}
+	saved, _, err := s.GetIdempotentResult(userID, key)
+	return saved, false, err
+}
+
+// GetURLInfo returns everything stored about a short URL.
+func (s *SQLiteStorage) GetURLInfo(shortURL string) (URLInfo, bool, error) {
+	var info URLInfo
+	var createdAt int64
+	var deletedAt sql.NullInt64
+	query := `SELECT url, normalized_url, user_id, is_deleted, created_at, deleted_at, content_type, creator_ip_hash,
+		title, favicon_url, protected
+	FROM urls WHERE short_url = ?`
+	err := s.db.QueryRow(query, shortURL).Scan(&info.OriginalURL, &info.NormalizedURL, &info.UserID,
+		&info.IsDeleted, &createdAt, &deletedAt, &info.ContentType, &info.CreatorIPHash,
+		&info.Title, &info.FaviconURL, &info.Protected)
+	if errors.Is(err, sql.ErrNoRows) {
+		return URLInfo{}, false, nil
+	}
+	if err != nil {
+		return URLInfo{}, false, fmt.Errorf("failed to query URL info: %v", err)
+	}
+	info.CreatedAt = time.Unix(0, createdAt)
+	if deletedAt.Valid {
+		info.DeletedAt = time.Unix(0, deletedAt.Int64)
+	}
+	return info, true, nil
+}
+
+// GetUserIDs returns the IDs of all users owning URLs.
+func (s *SQLiteStorage) GetUserIDs() ([]string, error) {
+	rows, err := s.db.Query(`SELECT DISTINCT user_id FROM urls`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user IDs: %v", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return userIDs, nil
+}
+
+// PurgeUsers permanently deletes all URLs of the specified users.
+func (s *SQLiteStorage) PurgeUsers(userIDs []string) (int, error) {
+	if len(userIDs) == 0 {
+		return 0, nil
+	}
+	args := make([]interface{}, len(userIDs))
+	for i, userID := range userIDs {
+		args[i] = userID
+	}
+	result, err := s.db.Exec(`DELETE FROM urls WHERE user_id IN (`+placeholders(len(userIDs))+`)`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %v", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge users: %v", err)
+	}
+	return int(removed), nil
+}
+
+// HardDeleteExpired permanently deletes URLs deleted before the specified time.
+func (s *SQLiteStorage) HardDeleteExpired(before time.Time) (int, error) {
+	result, err := s.db.Exec(`DELETE FROM urls WHERE is_deleted AND deleted_at < ?`, before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired URLs: %v", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired URLs: %v", err)
+	}
+	return int(removed), nil
+}
+
+// Export returns complete records of all stored URLs in the order they were inserted.
+func (s *SQLiteStorage) Export() ([]URLMapping, error) {
+	rows, err := s.db.Query(`SELECT short_url, url, user_id, is_deleted, content_type, creator_ip_hash, protected,
+		created_at, deleted_at, title, favicon_url
+	FROM urls ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query URLs: %v", err)
+	}
+	defer rows.Close()
+
+	var records []URLMapping
+	for rows.Next() {
+		var record URLMapping
+		var createdAt int64
+		var deletedAt sql.NullInt64
+		if err := rows.Scan(&record.ShortURL, &record.OriginalURL, &record.UserID,
+			&record.IsDeleted, &record.ContentType, &record.CreatorIPHash, &record.Protected,
+			&createdAt, &deletedAt, &record.Title, &record.FaviconURL); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %v", err)
+		}
+		created := time.Unix(0, createdAt)
+		record.CreatedAt = &created
+		if deletedAt.Valid {
+			deleted := time.Unix(0, deletedAt.Int64)
+			record.DeletedAt = &deleted
+		}
+		records = append(records, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+	return records, nil
+}
+
+// Import inserts exported records in a single transaction.
+// Rolls back all changes if any record fails to insert.
+func (s *SQLiteStorage) Import(records []URLMapping) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+
+	now := time.Now()
+	query := `INSERT INTO urls (url, normalized_url, short_url, user_id, is_deleted, created_at, deleted_at,
+		content_type, creator_ip_hash, protected, title, favicon_url)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	for _, record := range records {
+		createdAt, deletedAt := record.times(now)
+		_, err := tx.Exec(query, record.OriginalURL, s.normalize(record.OriginalURL), record.ShortURL,
+			record.UserID, record.IsDeleted, createdAt.UnixNano(),
+			sql.NullInt64{Int64: deletedAt.UnixNano(), Valid: record.IsDeleted},
+			record.ContentType, record.CreatorIPHash, record.Protected, record.Title, record.FaviconURL)
+		if err != nil {
+			tx.Rollback()
+			if conflict := sqliteConflictError(err); conflict != err {
+				return conflict
+			}
+			return fmt.Errorf("failed to import URL: %v", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// GetStats returns the total number of URLs, deleted URLs and distinct users.
+// The total is counted like Count does, but within the same scan as the other
+// numbers, and unlike Count, failures are returned.
+func (s *SQLiteStorage) GetStats() (Stats, error) {
+	var stats Stats
+	query := `SELECT COUNT(*), COUNT(*) FILTER (WHERE is_deleted), COUNT(DISTINCT user_id) FROM urls`
+	if err := s.db.QueryRow(query).Scan(&stats.URLs, &stats.DeletedURLs, &stats.Users); err != nil {
+		return Stats{}, fmt.Errorf("failed to get stats: %v", err)
+	}
+	return stats, nil
+}
+
+// GetWindowStats counts URLs created and deleted since the given time.
+func (s *SQLiteStorage) GetWindowStats(since time.Time) (WindowStats, error) {
+	var stats WindowStats
+	query := `
+	SELECT
+		COUNT(*) FILTER (WHERE created_at >= ?1),
+		COUNT(*) FILTER (WHERE is_deleted AND deleted_at >= ?1)
+	FROM urls
+	`
+	if err := s.db.QueryRow(query, since.UnixNano()).Scan(&stats.CreatedURLs, &stats.DeletedURLs); err != nil {
+		return WindowStats{}, fmt.Errorf("failed to get window stats: %v", err)
+	}
+	return stats, nil
+}
+
+// Ping checks database availability.
+func (s *SQLiteStorage) Ping() error {
+	return s.db.Ping()
+}
+
+// PingContext checks database availability, giving up when ctx is done.
+func (s *SQLiteStorage) PingContext(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close closes the database.
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
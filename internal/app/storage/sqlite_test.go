@@ -0,0 +1,552 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSQLiteStorage(t *testing.T, opts SQLiteOptions) *SQLiteStorage {
+	t.Helper()
+	s, err := NewSQLiteStorage(filepath.Join(t.TempDir(), "urls.db"), opts)
+	if err != nil {
+		t.Fatalf("Failed to create SQLite storage: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLitePath(t *testing.T) {
+	if path, ok := SQLitePath("sqlite://data/urls.db"); !ok || path != "data/urls.db" {
+		t.Errorf("Expected data/urls.db, got %q (ok: %v)", path, ok)
+	}
+	if _, ok := SQLitePath("postgres://localhost/urls"); ok {
+		t.Error("Expected a PostgreSQL DSN not to select SQLite")
+	}
+}
+
+func TestSQLiteStorage_AddURL(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+
+	if err := storage.AddURL("short1", "https://example.com", "user1"); err != nil {
+		t.Fatalf("AddURL() returned error: %v", err)
+	}
+
+	originalURL, exists, isDeleted := storage.GetURL("short1")
+	if !exists || originalURL != "https://example.com" || isDeleted {
+		t.Errorf("Expected live https://example.com, got %q (exists: %v, deleted: %v)", originalURL, exists, isDeleted)
+	}
+	if _, exists, _ := storage.GetURL("nonexistent"); exists {
+		t.Error("Expected non-existent URL to not exist")
+	}
+}
+
+func TestSQLiteStorage_AddURLs(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+
+	urls := map[string]string{
+		"short1": "https://example.com",
+		"short2": "https://google.com",
+		"short3": "https://github.com",
+	}
+	if err := storage.AddURLs(urls, "user1"); err != nil {
+		t.Fatalf("AddURLs() returned error: %v", err)
+	}
+
+	for shortURL, expectedOriginal := range urls {
+		if originalURL, exists, _ := storage.GetURL(shortURL); !exists || originalURL != expectedOriginal {
+			t.Errorf("Expected %s for %s, got %q (exists: %v)", expectedOriginal, shortURL, originalURL, exists)
+		}
+	}
+	if all := storage.GetAllURLs(); len(all) != 3 {
+		t.Errorf("Expected 3 URLs, got %d", len(all))
+	}
+}
+
+func TestSQLiteStorage_GetURLsByUser(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+
+	user1URLs, err := storage.GetURLsByUser("user1")
+	if err != nil {
+		t.Fatalf("GetURLsByUser() returned error: %v", err)
+	}
+	if len(user1URLs) != 2 || user1URLs["short1"] != "https://example.com" || user1URLs["short2"] != "https://google.com" {
+		t.Errorf("Unexpected URLs for user1: %v", user1URLs)
+	}
+	if emptyURLs, _ := storage.GetURLsByUser("nonexistent"); len(emptyURLs) != 0 {
+		t.Errorf("Expected 0 URLs for non-existent user, got %d", len(emptyURLs))
+	}
+}
+
+func TestSQLiteStorage_GetShortURLByOriginalURL(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{NormalizeURLs: true})
+
+	if _, found := storage.GetShortURLByOriginalURL("https://nonexistent.com"); found {
+		t.Error("Expected non-existent original URL to not be found")
+	}
+
+	storage.AddURL("short1", "https://example.com/", "user1")
+	if shortURL, found := storage.GetShortURLByOriginalURL("HTTPS://Example.com"); !found || shortURL != "short1" {
+		t.Errorf("Expected short1 for the normalized URL, got %q (found: %v)", shortURL, found)
+	}
+}
+
+func TestSQLiteStorage_DeleteURLs(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+
+	// Another user's delete is a no-op
+	if err := storage.DeleteURLs([]string{"short1"}, "user2"); err != nil {
+		t.Fatalf("DeleteURLs() returned error: %v", err)
+	}
+	if _, _, isDeleted := storage.GetURL("short1"); isDeleted {
+		t.Fatal("Expected a non-owner's delete to be a no-op")
+	}
+
+	before := time.Now()
+	if err := storage.DeleteURLs([]string{"short1"}, "user1"); err != nil {
+		t.Fatalf("DeleteURLs() returned error: %v", err)
+	}
+
+	if _, exists, isDeleted := storage.GetURL("short1"); !exists || !isDeleted {
+		t.Errorf("Expected short1 to be kept and marked as deleted (exists: %v, deleted: %v)", exists, isDeleted)
+	}
+	if _, _, isDeleted := storage.GetURL("short2"); isDeleted {
+		t.Error("URL short2 should not be marked as deleted")
+	}
+
+	stats, err := storage.GetWindowStats(before)
+	if err != nil {
+		t.Fatalf("GetWindowStats() returned error: %v", err)
+	}
+	if stats.DeletedURLs != 1 {
+		t.Errorf("Expected 1 URL deleted in the window, got %d", stats.DeletedURLs)
+	}
+}
+
+func TestSQLiteStorage_Conflicts(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "http://example.com", "user1")
+
+	if err := storage.AddURL("short2", "http://example.com", "user2"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists, got %v", err)
+	}
+	if err := storage.AddURL("short1", "http://other.com", "user2"); !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists, got %v", err)
+	}
+
+	err := storage.AddURLs(map[string]string{"short1": "http://example3.com", "short3": "http://example4.com"}, "user1")
+	if !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists from AddURLs, got %v", err)
+	}
+	if _, exists, _ := storage.GetURL("short3"); exists {
+		t.Error("Expected AddURLs to store nothing on conflict")
+	}
+}
+
+func TestSQLiteStorage_GetUserURLs(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	before := time.Now()
+	for _, short := range []string{"c", "b", "a"} {
+		storage.AddURL(short, "https://"+short+".com", "user1")
+	}
+	storage.AddURL("d", "https://d.com", "user2")
+	storage.SetContentType([]string{"b"}, ContentTypeJSON)
+
+	all, err := storage.GetUserURLs("user1", Page{})
+	if err != nil {
+		t.Fatalf("GetUserURLs() returned error: %v", err)
+	}
+	var order []string
+	for _, u := range all {
+		order = append(order, u.ShortURL)
+		if u.CreatedAt.Before(before) {
+			t.Errorf("Expected creation time of %s after %v, got %v", u.ShortURL, before, u.CreatedAt)
+		}
+	}
+	if strings.Join(order, ",") != "c,b,a" {
+		t.Errorf("Expected creation order c,b,a, got %v", order)
+	}
+	if all[1].ContentType != ContentTypeJSON {
+		t.Errorf("Expected recorded content type for b, got %q", all[1].ContentType)
+	}
+
+	page, _ := storage.GetUserURLs("user1", Page{Limit: 1, Offset: 1})
+	if len(page) != 1 || page[0].ShortURL != "b" {
+		t.Errorf("Expected page with b, got %v", page)
+	}
+	if newest, _ := storage.GetUserURLs("user1", Page{NewestFirst: true, Limit: 1}); len(newest) != 1 || newest[0].ShortURL != "a" {
+		t.Errorf("Expected newest-first page with a, got %v", newest)
+	}
+}
+
+func TestSQLiteStorage_DeletedAt(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+
+	before := time.Now()
+	storage.DeleteURLs([]string{"short1"}, "user1")
+
+	urls, err := storage.GetUserURLs("user1", Page{})
+	if err != nil || len(urls) != 2 {
+		t.Fatalf("Expected 2 URLs, got %d (err: %v)", len(urls), err)
+	}
+	if urls[0].DeletedAt.Before(before) {
+		t.Errorf("Expected DeletedAt to be set on delete, got %v", urls[0].DeletedAt)
+	}
+	if !urls[1].DeletedAt.IsZero() {
+		t.Errorf("Expected no DeletedAt for a live URL, got %v", urls[1].DeletedAt)
+	}
+
+	storage.RestoreURLs([]string{"short1"}, "user1")
+	if info, _, _ := storage.GetURLInfo("short1"); !info.DeletedAt.IsZero() {
+		t.Errorf("Expected DeletedAt to be cleared on restore, got %v", info.DeletedAt)
+	}
+}
+
+func TestSQLiteStorage_GetStats(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+	storage.DeleteURLs([]string{"short3"}, "user2")
+
+	stats, err := storage.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats() returned error: %v", err)
+	}
+	if stats.URLs != 3 || stats.DeletedURLs != 1 || stats.Users != 2 {
+		t.Errorf("Expected 3 URLs with 1 deleted and 2 users, got %+v", stats)
+	}
+
+	window, err := storage.GetWindowStats(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetWindowStats() returned error: %v", err)
+	}
+	if window.CreatedURLs != 0 {
+		t.Errorf("Expected no URLs created in a future window, got %d", window.CreatedURLs)
+	}
+}
+
+func TestSQLiteStorage_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.db")
+	storage, err := NewSQLiteStorage(path, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage() returned error: %v", err)
+	}
+	storage.AddURL("short1", "https://example.com", "user1")
+	if err := storage.Close(); err != nil {
+		t.Fatalf("Close() returned error: %v", err)
+	}
+
+	storage, err = NewSQLiteStorage(path, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage() returned error on reopen: %v", err)
+	}
+	defer storage.Close()
+	if err := storage.Ping(); err != nil {
+		t.Errorf("Ping() returned error: %v", err)
+	}
+	if originalURL, exists, _ := storage.GetURL("short1"); !exists || originalURL != "https://example.com" {
+		t.Errorf("Expected URL to persist across reopen, got %q (exists: %v)", originalURL, exists)
+	}
+}
+
+func TestSQLiteStorage_ExportImport(t *testing.T) {
+	source := newTestSQLiteStorage(t, SQLiteOptions{})
+	source.AddURL("short1", "https://example.com", "user1")
+	source.AddURL("short2", "https://google.com", "user1")
+	source.AddURL("short3", "https://github.com", "user2")
+	source.DeleteURLs([]string{"short2"}, "user1")
+	source.SetContentType([]string{"short3"}, ContentTypeJSON)
+
+	records, err := source.Export()
+	if err != nil {
+		t.Fatalf("Export() returned error: %v", err)
+	}
+	expected := []URLMapping{
+		{ShortURL: "short1", OriginalURL: "https://example.com", UserID: "user1"},
+		{ShortURL: "short2", OriginalURL: "https://google.com", UserID: "user1", IsDeleted: true},
+		{ShortURL: "short3", OriginalURL: "https://github.com", UserID: "user2", ContentType: ContentTypeJSON},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("Expected %d records, got %+v", len(expected), records)
+	}
+	for i := range expected {
+		if records[i].CreatedAt == nil || records[i].IsDeleted != (records[i].DeletedAt != nil) {
+			t.Errorf("Expected record %q to carry its times, got %+v", records[i].ShortURL, records[i])
+		}
+		if record := withoutTimes(records[i]); record != expected[i] {
+			t.Errorf("Expected record %+v, got %+v", expected[i], record)
+		}
+	}
+
+	// Records move between backends
+	target := newTestSQLiteStorage(t, SQLiteOptions{})
+	if err := target.Import(records); err != nil {
+		t.Fatalf("Import() returned error: %v", err)
+	}
+	imported, err := target.Export()
+	if err != nil {
+		t.Fatalf("Export() returned error: %v", err)
+	}
+	if !reflect.DeepEqual(imported, records) {
+		t.Errorf("Expected imported records %+v, got %+v", records, imported)
+	}
+	if info, _, _ := target.GetURLInfo("short2"); !info.DeletedAt.Equal(*records[1].DeletedAt) {
+		t.Errorf("Expected the deletion time %v to be kept, got %v", *records[1].DeletedAt, info.DeletedAt)
+	}
+
+	memory := NewURLStorage()
+	if err := memory.Import(records); err != nil {
+		t.Fatalf("Import() into memory returned error: %v", err)
+	}
+	if _, _, isDeleted := memory.GetURL("short2"); !isDeleted {
+		t.Error("Expected the deletion flag to be imported into memory")
+	}
+	if info, _, _ := memory.GetURLInfo("short1"); !info.CreatedAt.Equal(*records[0].CreatedAt) {
+		t.Errorf("Expected the creation time %v to be kept, got %v", *records[0].CreatedAt, info.CreatedAt)
+	}
+
+	if err := target.Import(records[:1]); !errors.Is(err, ErrURLExists) && !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected a conflict importing again, got %v", err)
+	}
+}
+
+// withoutTimes returns the record without its creation and deletion times.
+func withoutTimes(record URLMapping) URLMapping {
+	record.CreatedAt, record.DeletedAt = nil, nil
+	return record
+}
+
+func TestSQLiteStorage_PurgeUsers(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+
+	if userIDs, err := storage.GetUserIDs(); err != nil || len(userIDs) != 2 {
+		t.Errorf("Expected 2 user IDs, got %v (err: %v)", userIDs, err)
+	}
+
+	removed, err := storage.PurgeUsers([]string{"user1"})
+	if err != nil {
+		t.Fatalf("PurgeUsers() returned error: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 removed URLs, got %d", removed)
+	}
+	if _, exists, _ := storage.GetURL("short1"); exists {
+		t.Error("Expected short1 to be removed")
+	}
+	if _, exists, _ := storage.GetURL("short3"); !exists {
+		t.Error("Expected short3 of another user to be kept")
+	}
+}
+
+func TestSQLiteStorage_HardDeleteExpired(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("old", "https://old.com", "user1")
+	storage.AddURL("recent", "https://recent.com", "user1")
+	storage.AddURL("live", "https://live.com", "user1")
+	storage.DeleteURLs([]string{"old", "recent"}, "user1")
+
+	now := time.Now()
+	if _, err := storage.db.Exec(`UPDATE urls SET deleted_at = ? WHERE short_url = 'old'`, now.Add(-48*time.Hour).UnixNano()); err != nil {
+		t.Fatalf("Failed to backdate deletion: %v", err)
+	}
+
+	removed, err := storage.HardDeleteExpired(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("HardDeleteExpired() returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 removed URL, got %d", removed)
+	}
+	if _, exists, _ := storage.GetURL("old"); exists {
+		t.Error("Expected the old tombstone to be removed")
+	}
+	if _, exists, isDeleted := storage.GetURL("recent"); !exists || !isDeleted {
+		t.Error("Expected the recent tombstone to be kept")
+	}
+	if _, exists, _ := storage.GetURL("live"); !exists {
+		t.Error("Expected the live URL to be kept")
+	}
+}
+
+func TestSQLiteStorage_RestoreURLs(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.DeleteURLs([]string{"short1"}, "user1")
+
+	storage.RestoreURLs([]string{"short1"}, "user2")
+	if _, _, isDeleted := storage.GetURL("short1"); !isDeleted {
+		t.Fatal("Expected a non-owner's restore to be a no-op")
+	}
+
+	if err := storage.RestoreURLs([]string{"short1"}, "user1"); err != nil {
+		t.Fatalf("RestoreURLs() returned error: %v", err)
+	}
+	if _, _, isDeleted := storage.GetURL("short1"); isDeleted {
+		t.Error("Expected short1 to be restored")
+	}
+}
+
+func TestSQLiteStorage_GetURLInfo(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	before := time.Now()
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.DeleteURLs([]string{"short1"}, "user1")
+
+	info, exists, err := storage.GetURLInfo("short1")
+	if err != nil || !exists {
+		t.Fatalf("Expected short1 to exist, got exists: %v, err: %v", exists, err)
+	}
+	if info.OriginalURL != "https://example.com" || info.UserID != "user1" || !info.IsDeleted {
+		t.Errorf("Unexpected URL info: %+v", info)
+	}
+	if info.CreatedAt.Before(before) || info.DeletedAt.Before(info.CreatedAt) {
+		t.Errorf("Expected creation and deletion times after %v, got %+v", before, info)
+	}
+
+	if _, exists, err := storage.GetURLInfo("missing"); exists || err != nil {
+		t.Errorf("Expected missing URL to not exist, got exists: %v, err: %v", exists, err)
+	}
+}
+
+func TestSQLiteStorage_CreatorIPHash(t *testing.T) {
+	// Databases created before the column was added get it on open
+	path := filepath.Join(t.TempDir(), "urls.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	_, err = db.Exec(strings.Replace(sqliteSchema, ",\n\tcreator_ip_hash TEXT NOT NULL DEFAULT ''", "", 1))
+	db.Close()
+	if err != nil {
+		t.Fatalf("Failed to create the old schema: %v", err)
+	}
+
+	storage, err := NewSQLiteStorage(path, SQLiteOptions{})
+	if err != nil {
+		t.Fatalf("NewSQLiteStorage() returned error: %v", err)
+	}
+	defer storage.Close()
+
+	storage.AddURL("short1", "https://example.com", "user1")
+	if err := storage.SetCreatorIPHash([]string{"short1", "missing"}, "hash1"); err != nil {
+		t.Fatalf("SetCreatorIPHash() returned error: %v", err)
+	}
+	if info, _, err := storage.GetURLInfo("short1"); err != nil || info.CreatorIPHash != "hash1" {
+		t.Errorf("Expected creator IP hash hash1, got %q (err: %v)", info.CreatorIPHash, err)
+	}
+}
+
+func TestSQLiteStorage_SetProtected(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://example.org", "user1")
+
+	if err := storage.SetProtected([]string{"short1", "missing"}); err != nil {
+		t.Fatalf("SetProtected() returned error: %v", err)
+	}
+	if info, _, err := storage.GetURLInfo("short1"); err != nil || !info.Protected {
+		t.Errorf("Expected short1 to be protected, got %+v (err: %v)", info, err)
+	}
+	if info, _, err := storage.GetURLInfo("short2"); err != nil || info.Protected {
+		t.Errorf("Expected short2 not to be protected, got %+v (err: %v)", info, err)
+	}
+
+	// Protection survives a migration to another storage
+	records, err := storage.Export()
+	if err != nil {
+		t.Fatalf("Export() returned error: %v", err)
+	}
+	target := newTestSQLiteStorage(t, SQLiteOptions{})
+	if err := target.Import(records); err != nil {
+		t.Fatalf("Import() returned error: %v", err)
+	}
+	if info, _, err := target.GetURLInfo("short1"); err != nil || !info.Protected {
+		t.Errorf("Expected the imported short1 to be protected, got %+v (err: %v)", info, err)
+	}
+}
+
+func TestSQLiteStorage_SetMetadata(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+
+	if err := storage.SetMetadata("short1", "Example", "https://example.com/favicon.ico"); err != nil {
+		t.Fatalf("SetMetadata() returned error: %v", err)
+	}
+	urls, err := storage.GetUserURLs("user1", Page{})
+	if err != nil || len(urls) != 1 {
+		t.Fatalf("Expected 1 URL, got %d (err: %v)", len(urls), err)
+	}
+	if urls[0].Title != "Example" || urls[0].FaviconURL != "https://example.com/favicon.ico" {
+		t.Errorf("Expected the recorded metadata, got %q and %q", urls[0].Title, urls[0].FaviconURL)
+	}
+}
+
+func TestSQLiteStorage_CountUserURLs(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+	storage.DeleteURLs([]string{"short2"}, "user1")
+
+	for userID, want := range map[string]int{"user1": 1, "user2": 1, "user3": 0} {
+		count, err := storage.CountUserURLs(userID)
+		if err != nil {
+			t.Fatalf("CountUserURLs() returned error: %v", err)
+		}
+		if count != want {
+			t.Errorf("Expected %d URLs of %s, got %d", want, userID, count)
+		}
+	}
+}
+
+func TestSQLiteStorage_IdempotentResults(t *testing.T) {
+	storage := newTestSQLiteStorage(t, SQLiteOptions{})
+	expiresAt := time.Now().Add(time.Hour)
+	result := IdempotentResult{OriginalURL: "https://example.com", ShortURL: "short1", Status: 201, ExpiresAt: expiresAt}
+	if err := storage.SaveIdempotentResult("user1", "key", result); err != nil {
+		t.Fatalf("SaveIdempotentResult() returned error: %v", err)
+	}
+
+	saved, exists, err := storage.GetIdempotentResult("user1", "key")
+	if err != nil || !exists {
+		t.Fatalf("Expected saved result, got exists: %v, err: %v", exists, err)
+	}
+	if saved.ShortURL != "short1" || saved.Status != 201 || !saved.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected %+v, got %+v", result, saved)
+	}
+
+	storage.SaveIdempotentResult("user1", "expired", IdempotentResult{ShortURL: "short2", ExpiresAt: time.Now().Add(-time.Second)})
+	if _, exists, _ := storage.GetIdempotentResult("user1", "expired"); exists {
+		t.Error("Expected expired result to not be replayed")
+	}
+
+	// Reserving succeeds only for keys without an unexpired result
+	pending := IdempotentResult{OriginalURL: "https://example.com", ExpiresAt: time.Now().Add(time.Minute)}
+	if _, reserved, err := storage.ReserveIdempotentResult("user1", "new", pending); err != nil || !reserved {
+		t.Errorf("Expected a new key to be reserved, got %v (err: %v)", reserved, err)
+	}
+	if saved, reserved, _ := storage.ReserveIdempotentResult("user1", "new", pending); reserved || saved.OriginalURL != pending.OriginalURL {
+		t.Errorf("Expected the reservation to be returned, got %+v (reserved: %v)", saved, reserved)
+	}
+	if saved, reserved, _ := storage.ReserveIdempotentResult("user1", "key", pending); reserved || saved.ShortURL != "short1" {
+		t.Errorf("Expected the saved result to be returned, got %+v (reserved: %v)", saved, reserved)
+	}
+	if _, reserved, _ := storage.ReserveIdempotentResult("user1", "expired", pending); !reserved {
+		t.Error("Expected a key with an expired result to be reserved")
+	}
+}
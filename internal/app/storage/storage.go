@@ -1,6 +1,117 @@
 // Package storage provides interfaces and implementations for storing URL mappings.
 package storage
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrURLExists is returned when the original URL is already stored.
+	ErrURLExists = errors.New("URL already exists")
+
+	// ErrShortURLExists is returned when the short URL is already taken by another mapping.
+	ErrShortURLExists = errors.New("short URL already exists")
+
+	// ErrStorageFull is returned when the storage holds the maximum number of URLs.
+	ErrStorageFull = errors.New("storage is full")
+
+	// ErrQuotaExceeded is returned when a user holds the maximum number of URLs
+	// allowed per user.
+	ErrQuotaExceeded = errors.New("URL quota exceeded")
+)
+
+// BatchError is returned by AddURLs of storages storing batches partially
+// (see DBOptions.PartialBatches) when some mappings failed to be stored.
+// All other mappings of the batch are stored.
+type BatchError struct {
+	// Failed maps the short URLs that weren't stored to their errors, which are
+	// the same typed errors as returned by AddURL or storage errors
+	Failed map[string]error
+}
+
+// Error describes the failed mappings.
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("failed to store %d URLs of the batch", len(e.Failed))
+}
+
+// partiallyStored reports whether err is a *BatchError, meaning the storage
+// worked and stored the valid part of a batch.
+func partiallyStored(err error) bool {
+	var batchErr *BatchError
+	return errors.As(err, &batchErr)
+}
+
+// Content types URLs can be submitted with, recorded by SetContentType.
+const (
+	// ContentTypeText is a plain text request body
+	ContentTypeText = "text"
+	// ContentTypeJSON is a JSON request body
+	ContentTypeJSON = "json"
+	// ContentTypeForm is an HTML form submission
+	ContentTypeForm = "form"
+	// ContentTypeCSV is a row of a CSV import
+	ContentTypeCSV = "csv"
+)
+
+// Stats contains lifetime storage statistics.
+type Stats struct {
+	// URLs is the total number of stored URLs, including deleted ones
+	URLs int
+	// DeletedURLs is the number of soft-deleted URLs among URLs
+	DeletedURLs int
+	// Users is the number of distinct users owning URLs
+	Users int
+}
+
+// WindowStats contains storage statistics for a time window.
+type WindowStats struct {
+	// CreatedURLs is the number of URLs created within the window
+	CreatedURLs int
+	// DeletedURLs is the number of URLs deleted within the window
+	DeletedURLs int
+}
+
+// UserURL describes a URL owned by a user.
+type UserURL struct {
+	ShortURL    string
+	OriginalURL string
+	IsDeleted   bool
+	// ContentType is the content type the URL was submitted with, if recorded
+	ContentType string
+	CreatedAt   time.Time
+	// DeletedAt is when the URL was deleted, zero if it isn't
+	DeletedAt time.Time
+	// Title and FaviconURL describe the target page, if fetched (see SetMetadata)
+	Title      string
+	FaviconURL string
+}
+
+// Page selects a window of a listing.
+type Page struct {
+	// Limit is the maximum number of items to return (0 means no limit)
+	Limit int
+	// Offset is the number of items to skip
+	Offset int
+	// NewestFirst orders the listing from the most recently created item
+	NewestFirst bool
+}
+
+// IdempotentResult is the outcome of a shortening request sent with an
+// idempotency key, replayed when the request is retried with the same key.
+type IdempotentResult struct {
+	// OriginalURL is the URL the request shortened
+	OriginalURL string
+	// ShortURL is the short URL identifier returned
+	ShortURL string
+	// Status is the HTTP status code returned
+	Status int
+	// ExpiresAt is when the result is no longer replayed
+	ExpiresAt time.Time
+}
+
 // Storage defines the interface for storing shortened URLs.
 // All implementations should support both in-memory and persistent storage.
 //
@@ -18,10 +129,12 @@ package storage
 //	}
 type Storage interface {
 	// AddURL adds a new URL mapping.
-	// Returns an error if the URL already exists or if there's a storage error.
+	// Returns ErrURLExists if the original URL is already stored,
+	// ErrShortURLExists if the short URL is taken, or a storage error.
 	AddURL(shortURL, originalURL, userID string) error
 
 	// AddURLs adds multiple URL mappings at once (batch operation).
+	// Returns the same typed errors as AddURL if any mapping conflicts.
 	AddURLs(urls map[string]string, userID string) error
 
 	// GetURL returns the original URL by short URL.
@@ -32,18 +145,99 @@ type Storage interface {
 	// GetURLsByUser returns all URL mappings for the specified user.
 	GetURLsByUser(userID string) (map[string]string, error)
 
+	// GetUserURLs returns a page of the specified user's URLs, including deleted ones,
+	// in the order they were created (or the reverse order with page.NewestFirst).
+	GetUserURLs(userID string, page Page) ([]UserURL, error)
+
+	// CountUserURLs returns the number of the specified user's URLs that are not deleted.
+	CountUserURLs(userID string) (int, error)
+
 	// GetAllURLs returns all URL mappings.
 	GetAllURLs() map[string]string
 
+	// Count returns the total number of stored URLs, including deleted ones,
+	// without fetching them. Returns 0 if the storage fails to count.
+	Count() int
+
 	// GetShortURLByOriginalURL finds a short URL by original URL.
 	GetShortURLByOriginalURL(originalURL string) (string, bool)
 
 	// DeleteURLs marks the specified URLs as deleted for the specified user.
 	DeleteURLs(shortURLs []string, userID string) error
 
+	// ReplaceDeletedURL replaces the deleted mapping of originalURL stored under
+	// deletedShortURL with a live mapping under shortURL owned by userID. The deleted
+	// mapping is removed, so its short URL no longer resolves.
+	// Returns ErrURLExists if deletedShortURL is not a deleted mapping of originalURL
+	// (e.g. it was restored meanwhile), ErrShortURLExists if shortURL is taken, or a storage error.
+	ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error
+
+	// RestoreURLs clears the deleted flag of the specified URLs.
+	// Only URLs owned by the user are restored.
+	RestoreURLs(shortURLs []string, userID string) error
+
+	// SetContentType records the content type the specified URLs were submitted with.
+	SetContentType(shortURLs []string, contentType string) error
+
+	// SetCreatorIPHash records the hash of the IP address the specified URLs were created from.
+	SetCreatorIPHash(shortURLs []string, hash string) error
+
+	// SetProtected marks the specified URLs as protected, so that they only
+	// resolve with a signed token.
+	SetProtected(shortURLs []string) error
+
+	// SetMetadata records the title and favicon URL of the page a short URL points to.
+	SetMetadata(shortURL, title, faviconURL string) error
+
+	// GetIdempotentResult returns the unexpired result saved for the user's idempotency key.
+	// Returns false if there is none.
+	GetIdempotentResult(userID, key string) (IdempotentResult, bool, error)
+
+	// SaveIdempotentResult saves the result of the user's request with an idempotency key,
+	// replacing an earlier one. Expired results may be dropped.
+	SaveIdempotentResult(userID, key string, result IdempotentResult) error
+
+	// ReserveIdempotentResult saves result for the user's idempotency key unless
+	// an unexpired result is saved for it already, in one atomic step. Returns
+	// true if result was saved, otherwise the result saved earlier, which is
+	// empty if it expired in the meantime.
+	ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error)
+
+	// GetURLInfo returns everything stored about a short URL, including its owner.
+	// Returns false if the short URL doesn't exist.
+	GetURLInfo(shortURL string) (URLInfo, bool, error)
+
+	// GetUserIDs returns the IDs of all users owning URLs.
+	GetUserIDs() ([]string, error)
+
+	// PurgeUsers permanently removes all URLs of the specified users
+	// and returns the number of removed URLs.
+	PurgeUsers(userIDs []string) (int, error)
+
+	// HardDeleteExpired permanently removes URLs that were deleted before the
+	// specified time and returns the number of removed URLs.
+	HardDeleteExpired(before time.Time) (int, error)
+
+	// Export returns complete records of all stored URLs, including their owners
+	// and deletion flags, for backups and migration between storages.
+	Export() ([]URLMapping, error)
+
+	// Import stores records returned by Export as they are. Nothing is stored
+	// if any record conflicts with a stored URL, with the same typed errors as AddURLs.
+	Import(records []URLMapping) error
+
+	// GetStats returns lifetime storage statistics.
+	GetStats() (Stats, error)
+
+	// GetWindowStats returns the number of URLs created and deleted since the given time.
+	GetWindowStats(since time.Time) (WindowStats, error)
+
 	// Ping checks storage availability.
 	Ping() error
 
+	// PingContext checks storage availability, giving up when ctx is done.
+	PingContext(ctx context.Context) error
+
 	// Close closes the storage connection.
 	Close() error
 }
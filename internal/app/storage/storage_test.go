@@ -0,0 +1,54 @@
+package storage
+
+import "testing"
+
+// Compile-time checks that the storages implement the Storage interface.
+var (
+	_ Storage = (*URLStorage)(nil)
+	_ Storage = (*DBStorage)(nil)
+	_ Storage = (*SQLiteStorage)(nil)
+	_ Storage = (*CircuitBreaker)(nil)
+	_ Storage = (*WriteFallback)(nil)
+)
+
+func TestStorage_Count(t *testing.T) {
+	for name, newStorage := range map[string]func(t *testing.T) Storage{
+		"memory": func(t *testing.T) Storage { return NewURLStorage() },
+		"sqlite": func(t *testing.T) Storage { return newTestSQLiteStorage(t, SQLiteOptions{}) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			var s Storage = newStorage(t)
+			if count := s.Count(); count != 0 {
+				t.Errorf("Expected an empty storage, got %d URLs", count)
+			}
+
+			s.AddURL("short1", "https://example.com", "user1")
+			s.AddURLs(map[string]string{"short2": "https://example.org", "short3": "https://example.net"}, "user2")
+			s.DeleteURLs([]string{"short1"}, "user1")
+			// Deleted URLs are counted, like in GetStats
+			if count := s.Count(); count != 3 {
+				t.Errorf("Expected 3 URLs, got %d", count)
+			}
+			if stats, err := s.GetStats(); err != nil || stats.URLs != s.Count() {
+				t.Errorf("Expected GetStats to agree with Count, got %+v (err: %v)", stats, err)
+			}
+		})
+	}
+
+	t.Run("postgres", func(t *testing.T) {
+		s, err := openDBStorage("counting", "primary", DBOptions{})
+		if err != nil {
+			t.Fatalf("openDBStorage() returned error: %v", err)
+		}
+		defer s.Close()
+		testDriver.reset()
+
+		var storage Storage = s
+		if count := storage.Count(); count != 3 {
+			t.Errorf("Expected 3 URLs, got %d", count)
+		}
+		if got := testDriver.count("primary"); got != 1 {
+			t.Errorf("Expected a single count query, got %d queries", got)
+		}
+	})
+}
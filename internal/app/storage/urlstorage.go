@@ -1,14 +1,31 @@
 package storage
 
 import (
+	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
 // URLInfo contains information about a stored URL, including the deletion flag.
 type URLInfo struct {
+	// OriginalURL is the URL exactly as submitted; it is used for redirects
 	OriginalURL string
-	UserID      string
-	IsDeleted   bool
+	// NormalizedURL is the form of OriginalURL used to detect duplicates
+	NormalizedURL string
+	UserID        string
+	IsDeleted     bool
+	CreatedAt     time.Time
+	DeletedAt     time.Time
+	// ContentType is the content type the URL was submitted with, if recorded
+	ContentType string
+	// CreatorIPHash is the salted hash of the IP address the URL was created from, if recorded
+	CreatorIPHash string
+	// Title and FaviconURL describe the target page, if fetched
+	Title      string
+	FaviconURL string
+	// Protected URLs only resolve with a signed token, see SetProtected
+	Protected bool
 }
 
 // URLStorage represents an in-memory storage for URL mappings.
@@ -25,13 +42,45 @@ type URLStorage struct {
 	mu      sync.RWMutex
 	URLs    map[string]URLInfo
 	mapPool sync.Pool
+
+	// byOriginal maps normalized URLs to their short URLs for duplicate detection
+	byOriginal map[string]string
+
+	normalize func(string) string
+	maxURLs   int
+
+	// idempotency holds results of requests sent with idempotency keys
+	idempotency map[idempotencyKey]IdempotentResult
+}
+
+// idempotencyKey identifies an idempotency key of a user.
+type idempotencyKey struct {
+	userID string
+	key    string
+}
+
+// URLStorageOptions contains optional settings for URLStorage.
+type URLStorageOptions struct {
+	// NormalizeURLs detects duplicates by the normalized form of URLs (see NormalizeURL)
+	NormalizeURLs bool
+	// MaxURLs is the maximum number of stored URLs, including deleted ones (0 means unlimited)
+	MaxURLs int
 }
 
 // NewURLStorage creates a new URLStorage instance with an initialized URL map.
 // Returns a ready-to-use storage object.
 func NewURLStorage() *URLStorage {
+	return NewURLStorageWithOptions(URLStorageOptions{})
+}
+
+// NewURLStorageWithOptions creates a new URLStorage instance using the provided options.
+func NewURLStorageWithOptions(opts URLStorageOptions) *URLStorage {
 	storage := &URLStorage{
-		URLs: make(map[string]URLInfo, 1000),
+		URLs:        make(map[string]URLInfo, 1000),
+		byOriginal:  make(map[string]string, 1000),
+		normalize:   normalizer(opts.NormalizeURLs),
+		maxURLs:     opts.MaxURLs,
+		idempotency: make(map[idempotencyKey]IdempotentResult),
 	}
 
 	storage.mapPool = sync.Pool{
@@ -45,24 +94,99 @@ func NewURLStorage() *URLStorage {
 
 // AddURL adds a new URL mapping to the storage.
 // Thread-safe operation that stores the mapping with user association.
+// Returns ErrURLExists if the original URL is already stored
+// and ErrShortURLExists if the short URL is already taken.
+// Returns ErrStorageFull if the storage already holds MaxURLs URLs.
 func (s *URLStorage) AddURL(shortURL, originalURL, userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.URLs[shortURL] = URLInfo{OriginalURL: originalURL, UserID: userID}
+	normalizedURL := s.normalize(originalURL)
+	if _, exists := s.byOriginal[normalizedURL]; exists {
+		return ErrURLExists
+	}
+	if _, exists := s.URLs[shortURL]; exists {
+		return ErrShortURLExists
+	}
+	if !s.hasRoom(1) {
+		return ErrStorageFull
+	}
+	s.URLs[shortURL] = URLInfo{
+		OriginalURL:   originalURL,
+		NormalizedURL: normalizedURL,
+		UserID:        userID,
+		CreatedAt:     time.Now(),
+	}
+	s.byOriginal[normalizedURL] = shortURL
+	return nil
+}
+
+// ReplaceDeletedURL replaces the deleted mapping of originalURL stored under
+// deletedShortURL with a live mapping under shortURL.
+func (s *URLStorage) ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	normalizedURL := s.normalize(originalURL)
+	deleted, exists := s.URLs[deletedShortURL]
+	if !exists || !deleted.IsDeleted || deleted.NormalizedURL != normalizedURL {
+		return ErrURLExists
+	}
+	if _, exists := s.URLs[shortURL]; exists {
+		return ErrShortURLExists
+	}
+	delete(s.URLs, deletedShortURL)
+	s.URLs[shortURL] = URLInfo{
+		OriginalURL:   originalURL,
+		NormalizedURL: normalizedURL,
+		UserID:        userID,
+		CreatedAt:     time.Now(),
+	}
+	s.byOriginal[normalizedURL] = shortURL
 	return nil
 }
 
 // AddURLs adds multiple URL mappings in a single operation.
 // More efficient than multiple AddURL calls for batch operations.
+// Nothing is stored if any original URL is already stored or repeated in the batch,
+// or if any short URL is already taken, or if the batch doesn't fit within MaxURLs.
 func (s *URLStorage) AddURLs(urls map[string]string, userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	originals := make(map[string]struct{}, len(urls))
+	for shortURL, originalURL := range urls {
+		normalizedURL := s.normalize(originalURL)
+		if _, exists := s.byOriginal[normalizedURL]; exists {
+			return ErrURLExists
+		}
+		if _, repeated := originals[normalizedURL]; repeated {
+			return ErrURLExists
+		}
+		originals[normalizedURL] = struct{}{}
+		if _, exists := s.URLs[shortURL]; exists {
+			return ErrShortURLExists
+		}
+	}
+	if !s.hasRoom(len(urls)) {
+		return ErrStorageFull
+	}
+	now := time.Now()
 	for shortURL, originalURL := range urls {
-		s.URLs[shortURL] = URLInfo{OriginalURL: originalURL, UserID: userID}
+		normalizedURL := s.normalize(originalURL)
+		s.URLs[shortURL] = URLInfo{
+			OriginalURL:   originalURL,
+			NormalizedURL: normalizedURL,
+			UserID:        userID,
+			CreatedAt:     now,
+		}
+		s.byOriginal[normalizedURL] = shortURL
 	}
 	return nil
 }
 
+// hasRoom reports whether n more URLs fit within MaxURLs. Requires s.mu.
+func (s *URLStorage) hasRoom(n int) bool {
+	return s.maxURLs <= 0 || len(s.URLs)+n <= s.maxURLs
+}
+
 // GetURL retrieves URL information by short URL.
 // Returns original URL, existence flag, and deletion status.
 func (s *URLStorage) GetURL(shortURL string) (string, bool, bool) {
@@ -99,6 +223,65 @@ func (s *URLStorage) GetURLsByUser(userID string) (map[string]string, error) {
 	return result, nil
 }
 
+// GetUserURLs returns a page of URLs created by a specific user with their deletion status.
+// URLs are ordered by creation time, with the short URL breaking ties.
+// The order is reversed with page.NewestFirst.
+func (s *URLStorage) GetUserURLs(userID string, page Page) ([]UserURL, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []UserURL
+	for short, info := range s.URLs {
+		if info.UserID == userID {
+			result = append(result, UserURL{
+				ShortURL:    short,
+				OriginalURL: info.OriginalURL,
+				IsDeleted:   info.IsDeleted,
+				ContentType: info.ContentType,
+				CreatedAt:   info.CreatedAt,
+				DeletedAt:   info.DeletedAt,
+				Title:       info.Title,
+				FaviconURL:  info.FaviconURL,
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if page.NewestFirst {
+			i, j = j, i
+		}
+		ci, cj := result[i].CreatedAt, result[j].CreatedAt
+		if !ci.Equal(cj) {
+			return ci.Before(cj)
+		}
+		return result[i].ShortURL < result[j].ShortURL
+	})
+
+	if page.Offset >= len(result) {
+		return nil, nil
+	}
+	result = result[page.Offset:]
+	if page.Limit > 0 && page.Limit < len(result) {
+		result = result[:page.Limit]
+	}
+	return result, nil
+}
+
+// CountUserURLs returns the number of the specified user's URLs that are not deleted.
+func (s *URLStorage) CountUserURLs(userID string) (int, error) {
+	urls, err := s.GetUserURLs(userID, Page{})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, u := range urls {
+		if !u.IsDeleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
 // GetAllURLs returns a copy of all stored URL mappings.
 // Creates a new map to avoid exposing internal storage.
 func (s *URLStorage) GetAllURLs() map[string]string {
@@ -132,16 +315,13 @@ func (s *URLStorage) Count() int {
 }
 
 // GetShortURLByOriginalURL finds the short URL for a given original URL.
-// Returns short URL and found flag by iterating through all mappings.
+// Returns short URL and found flag using the reverse index.
+// With normalization enabled, any URL with the same normalized form matches.
 func (s *URLStorage) GetShortURLByOriginalURL(originalURL string) (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	for short, info := range s.URLs {
-		if info.OriginalURL == originalURL {
-			return short, true
-		}
-	}
-	return "", false
+	shortURL, exists := s.byOriginal[s.normalize(originalURL)]
+	return shortURL, exists
 }
 
 // DeleteURLs marks specified URLs as deleted for the given user.
@@ -149,20 +329,317 @@ func (s *URLStorage) GetShortURLByOriginalURL(originalURL string) (string, bool)
 func (s *URLStorage) DeleteURLs(shortURLs []string, userID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	now := time.Now()
 	for _, shortURL := range shortURLs {
-		if info, exists := s.URLs[shortURL]; exists && info.UserID == userID {
+		if info, exists := s.URLs[shortURL]; exists && info.UserID == userID && !info.IsDeleted {
 			info.IsDeleted = true
+			info.DeletedAt = now
+			s.URLs[shortURL] = info
+		}
+	}
+	return nil
+}
+
+// RestoreURLs clears the deleted flag of specified URLs for the given user.
+// Only URLs owned by the user are restored.
+func (s *URLStorage) RestoreURLs(shortURLs []string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		if info, exists := s.URLs[shortURL]; exists && info.UserID == userID && info.IsDeleted {
+			info.IsDeleted = false
+			info.DeletedAt = time.Time{}
+			s.URLs[shortURL] = info
+		}
+	}
+	return nil
+}
+
+// SetContentType records the content type the specified URLs were submitted with.
+// Unknown short URLs are ignored.
+func (s *URLStorage) SetContentType(shortURLs []string, contentType string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		if info, exists := s.URLs[shortURL]; exists {
+			info.ContentType = contentType
+			s.URLs[shortURL] = info
+		}
+	}
+	return nil
+}
+
+// SetCreatorIPHash records the hash of the IP address the specified URLs were created from.
+// Unknown short URLs are ignored.
+func (s *URLStorage) SetCreatorIPHash(shortURLs []string, hash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		if info, exists := s.URLs[shortURL]; exists {
+			info.CreatorIPHash = hash
 			s.URLs[shortURL] = info
 		}
 	}
 	return nil
 }
 
+// SetProtected marks the specified URLs as protected.
+// Unknown short URLs are ignored.
+func (s *URLStorage) SetProtected(shortURLs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, shortURL := range shortURLs {
+		if info, exists := s.URLs[shortURL]; exists {
+			info.Protected = true
+			s.URLs[shortURL] = info
+		}
+	}
+	return nil
+}
+
+// SetMetadata records the title and favicon URL of the page a short URL points to.
+// Unknown short URLs are ignored.
+func (s *URLStorage) SetMetadata(shortURL, title, faviconURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info, exists := s.URLs[shortURL]; exists {
+		info.Title, info.FaviconURL = title, faviconURL
+		s.URLs[shortURL] = info
+	}
+	return nil
+}
+
+// GetIdempotentResult returns the unexpired result saved for the user's idempotency key.
+func (s *URLStorage) GetIdempotentResult(userID, key string) (IdempotentResult, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	result, exists := s.idempotency[idempotencyKey{userID, key}]
+	if !exists || !result.ExpiresAt.After(time.Now()) {
+		return IdempotentResult{}, false, nil
+	}
+	return result, true, nil
+}
+
+// SaveIdempotentResult saves the result of the user's request with an idempotency key.
+// Expired results are dropped on the way.
+func (s *URLStorage) SaveIdempotentResult(userID, key string, result IdempotentResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for k, saved := range s.idempotency {
+		if !saved.ExpiresAt.After(now) {
+			delete(s.idempotency, k)
+		}
+	}
+	s.idempotency[idempotencyKey{userID, key}] = result
+	return nil
+}
+
+// ReserveIdempotentResult saves result for the user's idempotency key unless
+// an unexpired result is saved for it already, which is returned instead.
+func (s *URLStorage) ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := idempotencyKey{userID, key}
+	if saved, exists := s.idempotency[k]; exists && saved.ExpiresAt.After(time.Now()) {
+		return saved, false, nil
+	}
+	s.idempotency[k] = result
+	return IdempotentResult{}, true, nil
+}
+
+// GetURLInfo returns everything stored about a short URL.
+func (s *URLStorage) GetURLInfo(shortURL string) (URLInfo, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info, exists := s.URLs[shortURL]
+	return info, exists, nil
+}
+
+// GetUserIDs returns the IDs of all users owning URLs.
+func (s *URLStorage) GetUserIDs() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]struct{})
+	var userIDs []string
+	for _, info := range s.URLs {
+		if _, ok := seen[info.UserID]; !ok {
+			seen[info.UserID] = struct{}{}
+			userIDs = append(userIDs, info.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// PurgeUsers permanently removes all URLs of the specified users.
+func (s *URLStorage) PurgeUsers(userIDs []string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purge := make(map[string]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		purge[userID] = struct{}{}
+	}
+	removed := 0
+	for shortURL, info := range s.URLs {
+		if _, ok := purge[info.UserID]; ok {
+			delete(s.URLs, shortURL)
+			delete(s.byOriginal, info.NormalizedURL)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// HardDeleteExpired permanently removes URLs deleted before the specified time.
+func (s *URLStorage) HardDeleteExpired(before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := 0
+	for shortURL, info := range s.URLs {
+		if !info.IsDeleted || info.DeletedAt.IsZero() || !info.DeletedAt.Before(before) {
+			continue
+		}
+		delete(s.URLs, shortURL)
+		if s.byOriginal[info.NormalizedURL] == shortURL {
+			delete(s.byOriginal, info.NormalizedURL)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// Export returns complete records of all stored URLs in the order they were created.
+func (s *URLStorage) Export() ([]URLMapping, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]URLMapping, 0, len(s.URLs))
+	for shortURL, info := range s.URLs {
+		record := URLMapping{
+			ShortURL:      shortURL,
+			OriginalURL:   info.OriginalURL,
+			UserID:        info.UserID,
+			IsDeleted:     info.IsDeleted,
+			ContentType:   info.ContentType,
+			CreatorIPHash: info.CreatorIPHash,
+			Protected:     info.Protected,
+			CreatedAt:     &info.CreatedAt,
+			Title:         info.Title,
+			FaviconURL:    info.FaviconURL,
+		}
+		if info.IsDeleted {
+			record.DeletedAt = &info.DeletedAt
+		}
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := s.URLs[records[i].ShortURL], s.URLs[records[j].ShortURL]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return records[i].ShortURL < records[j].ShortURL
+	})
+	return records, nil
+}
+
+// Import stores exported records with their owners, deletion flags and times.
+// Nothing is stored if any record conflicts or the records don't fit within MaxURLs.
+func (s *URLStorage) Import(records []URLMapping) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	originals := make(map[string]struct{}, len(records))
+	shortURLs := make(map[string]struct{}, len(records))
+	for _, record := range records {
+		normalizedURL := s.normalize(record.OriginalURL)
+		if _, exists := s.byOriginal[normalizedURL]; exists {
+			return ErrURLExists
+		}
+		if _, repeated := originals[normalizedURL]; repeated {
+			return ErrURLExists
+		}
+		originals[normalizedURL] = struct{}{}
+		if _, exists := s.URLs[record.ShortURL]; exists {
+			return ErrShortURLExists
+		}
+		if _, repeated := shortURLs[record.ShortURL]; repeated {
+			return ErrShortURLExists
+		}
+		shortURLs[record.ShortURL] = struct{}{}
+	}
+	if !s.hasRoom(len(records)) {
+		return ErrStorageFull
+	}
+
+	now := time.Now()
+	for _, record := range records {
+		createdAt, deletedAt := record.times(now)
+		info := URLInfo{
+			OriginalURL:   record.OriginalURL,
+			NormalizedURL: s.normalize(record.OriginalURL),
+			UserID:        record.UserID,
+			IsDeleted:     record.IsDeleted,
+			CreatedAt:     createdAt,
+			DeletedAt:     deletedAt,
+			ContentType:   record.ContentType,
+			CreatorIPHash: record.CreatorIPHash,
+			Title:         record.Title,
+			FaviconURL:    record.FaviconURL,
+			Protected:     record.Protected,
+		}
+		s.URLs[record.ShortURL] = info
+		s.byOriginal[info.NormalizedURL] = record.ShortURL
+	}
+	return nil
+}
+
+// GetStats returns the total number of URLs, deleted URLs and distinct users.
+func (s *URLStorage) GetStats() (Stats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats := Stats{URLs: len(s.URLs)}
+	users := make(map[string]struct{})
+	for _, info := range s.URLs {
+		users[info.UserID] = struct{}{}
+		if info.IsDeleted {
+			stats.DeletedURLs++
+		}
+	}
+	stats.Users = len(users)
+	return stats, nil
+}
+
+// GetWindowStats counts URLs created and deleted since the given time.
+func (s *URLStorage) GetWindowStats(since time.Time) (WindowStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var stats WindowStats
+	for _, info := range s.URLs {
+		if !info.CreatedAt.Before(since) {
+			stats.CreatedURLs++
+		}
+		if info.IsDeleted && !info.DeletedAt.Before(since) {
+			stats.DeletedURLs++
+		}
+	}
+	return stats, nil
+}
+
 // Ping checks storage availability (always returns nil for in-memory storage).
 func (s *URLStorage) Ping() error {
 	return nil
 }
 
+// PingContext checks storage availability (always returns nil for in-memory storage).
+func (s *URLStorage) PingContext(ctx context.Context) error {
+	return nil
+}
+
 // Close performs cleanup operations (no-op for in-memory storage).
 func (s *URLStorage) Close() error {
 	return nil
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewURLStorage(t *testing.T) {
@@ -265,6 +269,24 @@ func TestURLStorage_Count(t *testing.T) {
 	}
 }
 
+func TestURLStorage_CountUserURLs(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+	storage.DeleteURLs([]string{"short2"}, "user1")
+
+	for userID, want := range map[string]int{"user1": 1, "user2": 1, "user3": 0} {
+		count, err := storage.CountUserURLs(userID)
+		if err != nil {
+			t.Fatalf("CountUserURLs() returned error: %v", err)
+		}
+		if count != want {
+			t.Errorf("Expected %d URLs of %s, got %d", want, userID, count)
+		}
+	}
+}
+
 func TestURLStorage_IterateURLs(t *testing.T) {
 	storage := NewURLStorage()
 
@@ -299,6 +321,221 @@ func TestURLStorage_IterateURLs(t *testing.T) {
 	}
 }
 
+func TestURLStorage_GetStats(t *testing.T) {
+	storage := NewURLStorage()
+
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+	storage.DeleteURLs([]string{"short1", "short2"}, "user1")
+
+	stats, err := storage.GetStats()
+	if err != nil {
+		t.Fatalf("GetStats() returned error: %v", err)
+	}
+	if stats.URLs != 3 {
+		t.Errorf("Expected 3 URLs, got %d", stats.URLs)
+	}
+	if stats.DeletedURLs != 2 {
+		t.Errorf("Expected 2 deleted URLs, got %d", stats.DeletedURLs)
+	}
+	if stats.Users != 2 {
+		t.Errorf("Expected 2 users, got %d", stats.Users)
+	}
+}
+
+func TestURLStorage_GetWindowStats(t *testing.T) {
+	storage := NewURLStorage()
+	now := time.Now()
+
+	// Seed URLs across timestamps
+	storage.URLs["old"] = URLInfo{OriginalURL: "https://old.com", UserID: "user1", CreatedAt: now.Add(-48 * time.Hour)}
+	storage.URLs["old-deleted-recently"] = URLInfo{
+		OriginalURL: "https://old-deleted.com",
+		UserID:      "user1",
+		CreatedAt:   now.Add(-72 * time.Hour),
+		IsDeleted:   true,
+		DeletedAt:   now.Add(-time.Hour),
+	}
+	storage.URLs["old-deleted-long-ago"] = URLInfo{
+		OriginalURL: "https://long-ago.com",
+		UserID:      "user2",
+		CreatedAt:   now.Add(-72 * time.Hour),
+		IsDeleted:   true,
+		DeletedAt:   now.Add(-36 * time.Hour),
+	}
+	storage.URLs["recent"] = URLInfo{OriginalURL: "https://recent.com", UserID: "user2", CreatedAt: now.Add(-2 * time.Hour)}
+	storage.AddURL("new", "https://new.com", "user3")
+
+	stats, err := storage.GetWindowStats(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("GetWindowStats() returned error: %v", err)
+	}
+	if stats.CreatedURLs != 2 {
+		t.Errorf("Expected 2 URLs created within 24h, got %d", stats.CreatedURLs)
+	}
+	if stats.DeletedURLs != 1 {
+		t.Errorf("Expected 1 URL deleted within 24h, got %d", stats.DeletedURLs)
+	}
+
+	stats, err = storage.GetWindowStats(now.Add(-96 * time.Hour))
+	if err != nil {
+		t.Fatalf("GetWindowStats() returned error: %v", err)
+	}
+	if stats.CreatedURLs != 5 || stats.DeletedURLs != 2 {
+		t.Errorf("Expected 5 created and 2 deleted within 96h, got %+v", stats)
+	}
+}
+
+func TestURLStorage_DeleteURLs_SetsDeletedAt(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "https://example.com", "user1")
+
+	before := time.Now()
+	storage.DeleteURLs([]string{"short1"}, "user1")
+
+	info := storage.URLs["short1"]
+	if info.DeletedAt.Before(before) {
+		t.Errorf("Expected DeletedAt to be set on delete, got %v", info.DeletedAt)
+	}
+	if info.CreatedAt.IsZero() {
+		t.Error("Expected CreatedAt to be set on add")
+	}
+	if urls, _ := storage.GetUserURLs("user1", Page{}); len(urls) != 1 || !urls[0].DeletedAt.Equal(info.DeletedAt) {
+		t.Errorf("Expected GetUserURLs to report DeletedAt %v, got %+v", info.DeletedAt, urls)
+	}
+
+	storage.RestoreURLs([]string{"short1"}, "user1")
+	if info := storage.URLs["short1"]; !info.DeletedAt.IsZero() {
+		t.Errorf("Expected DeletedAt to be cleared on restore, got %v", info.DeletedAt)
+	}
+}
+
+func TestURLStorage_AddURL_OriginalURLExists(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "http://example.com", "user1")
+
+	err := storage.AddURL("short2", "http://example.com", "user2")
+	if !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists, got %v", err)
+	}
+	if shortURL, _ := storage.GetShortURLByOriginalURL("http://example.com"); shortURL != "short1" {
+		t.Errorf("Expected existing short URL short1, got %s", shortURL)
+	}
+
+	err = storage.AddURLs(map[string]string{"short3": "http://example.com"}, "user1")
+	if !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists from AddURLs, got %v", err)
+	}
+	err = storage.AddURLs(map[string]string{"short4": "http://other.com", "short5": "http://other.com"}, "user1")
+	if !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists for a URL repeated within the batch, got %v", err)
+	}
+	if storage.Count() != 1 {
+		t.Errorf("Expected only the first mapping to be stored, got %d", storage.Count())
+	}
+}
+
+func TestURLStorage_AddURL_ShortURLTaken(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "http://example1.com", "user1")
+
+	err := storage.AddURL("short1", "http://example2.com", "user2")
+	if !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists, got %v", err)
+	}
+	if got := storage.URLs["short1"].OriginalURL; got != "http://example1.com" {
+		t.Errorf("Expected existing mapping to be kept, got %s", got)
+	}
+
+	err = storage.AddURLs(map[string]string{"short1": "http://example3.com", "short2": "http://example4.com"}, "user1")
+	if !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists from AddURLs, got %v", err)
+	}
+	if _, exists := storage.URLs["short2"]; exists {
+		t.Error("Expected AddURLs to store nothing on conflict")
+	}
+}
+
+func TestURLStorage_GetUserURLs_Page(t *testing.T) {
+	storage := NewURLStorage()
+	now := time.Now()
+	storage.URLs["b"] = URLInfo{OriginalURL: "https://second.com", UserID: "user1", CreatedAt: now}
+	storage.URLs["a"] = URLInfo{OriginalURL: "https://third.com", UserID: "user1", CreatedAt: now.Add(time.Second)}
+	storage.URLs["c"] = URLInfo{OriginalURL: "https://first.com", UserID: "user1", CreatedAt: now.Add(-time.Second)}
+	storage.URLs["d"] = URLInfo{OriginalURL: "https://other.com", UserID: "user2", CreatedAt: now}
+
+	all, err := storage.GetUserURLs("user1", Page{})
+	if err != nil {
+		t.Fatalf("GetUserURLs() returned error: %v", err)
+	}
+	var order []string
+	for _, u := range all {
+		order = append(order, u.ShortURL)
+	}
+	if strings.Join(order, ",") != "c,b,a" {
+		t.Errorf("Expected creation order c,b,a, got %v", order)
+	}
+
+	page, _ := storage.GetUserURLs("user1", Page{Limit: 1, Offset: 1})
+	if len(page) != 1 || page[0].ShortURL != "b" {
+		t.Errorf("Expected page with b, got %v", page)
+	}
+
+	if page, _ := storage.GetUserURLs("user1", Page{Offset: 3}); len(page) != 0 {
+		t.Errorf("Expected empty page past the end, got %v", page)
+	}
+
+	newest, _ := storage.GetUserURLs("user1", Page{NewestFirst: true, Limit: 2})
+	order = nil
+	for _, u := range newest {
+		order = append(order, u.ShortURL)
+	}
+	if strings.Join(order, ",") != "a,b" {
+		t.Errorf("Expected newest-first page a,b, got %v", order)
+	}
+}
+
+func TestURLStorage_GetUserURLs_CreatedAt(t *testing.T) {
+	storage := NewURLStorage()
+	before := time.Now()
+	if err := storage.AddURL("short1", "https://example.com", "user1"); err != nil {
+		t.Fatalf("AddURL() returned error: %v", err)
+	}
+
+	urls, _ := storage.GetUserURLs("user1", Page{})
+	if len(urls) != 1 {
+		t.Fatalf("Expected 1 URL, got %v", urls)
+	}
+	if urls[0].CreatedAt.Before(before) || urls[0].CreatedAt.After(time.Now()) {
+		t.Errorf("Expected creation time around %v, got %v", before, urls[0].CreatedAt)
+	}
+}
+
+func TestURLStorage_MaxURLs(t *testing.T) {
+	storage := NewURLStorageWithOptions(URLStorageOptions{MaxURLs: 2})
+
+	if err := storage.AddURL("a", "https://a.com", "user1"); err != nil {
+		t.Fatalf("AddURL() returned error: %v", err)
+	}
+	if err := storage.AddURLs(map[string]string{"b": "https://b.com", "c": "https://c.com"}, "user1"); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull for a batch over the cap, got %v", err)
+	}
+	if err := storage.AddURL("b", "https://b.com", "user1"); err != nil {
+		t.Fatalf("AddURL() returned error: %v", err)
+	}
+	if err := storage.AddURL("c", "https://c.com", "user1"); !errors.Is(err, ErrStorageFull) {
+		t.Errorf("Expected ErrStorageFull, got %v", err)
+	}
+	// Duplicates are still reported as such
+	if err := storage.AddURL("d", "https://a.com", "user1"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists, got %v", err)
+	}
+	if storage.Count() != 2 {
+		t.Errorf("Expected 2 stored URLs, got %d", storage.Count())
+	}
+}
+
 func TestURLStorage_Ping(t *testing.T) {
 	storage := NewURLStorage()
 
@@ -316,3 +553,221 @@ func TestURLStorage_Close(t *testing.T) {
 		t.Errorf("Close() should not return error for in-memory storage, got: %v", err)
 	}
 }
+
+func TestURLStorage_PurgeUsers(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("short2", "https://google.com", "user1")
+	storage.AddURL("short3", "https://github.com", "user2")
+
+	userIDs, err := storage.GetUserIDs()
+	if err != nil {
+		t.Fatalf("GetUserIDs() returned error: %v", err)
+	}
+	if len(userIDs) != 2 {
+		t.Errorf("Expected 2 user IDs, got %v", userIDs)
+	}
+
+	removed, err := storage.PurgeUsers([]string{"user1"})
+	if err != nil {
+		t.Fatalf("PurgeUsers() returned error: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("Expected 2 removed URLs, got %d", removed)
+	}
+	if _, exists, _ := storage.GetURL("short1"); exists {
+		t.Error("Expected short1 to be removed")
+	}
+	if _, exists, _ := storage.GetURL("short3"); !exists {
+		t.Error("Expected short3 of another user to be kept")
+	}
+	// The original URL can be shortened again
+	if err := storage.AddURL("short4", "https://example.com", "user2"); err != nil {
+		t.Errorf("Expected purged original URL to be accepted again, got %v", err)
+	}
+}
+
+func TestURLStorage_HardDeleteExpired(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("old", "https://old.com", "user1")
+	storage.AddURL("recent", "https://recent.com", "user1")
+	storage.AddURL("live", "https://live.com", "user1")
+	storage.DeleteURLs([]string{"old", "recent"}, "user1")
+
+	now := time.Now()
+	info := storage.URLs["old"]
+	info.DeletedAt = now.Add(-48 * time.Hour)
+	storage.URLs["old"] = info
+
+	removed, err := storage.HardDeleteExpired(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("HardDeleteExpired() returned error: %v", err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 removed URL, got %d", removed)
+	}
+	if _, exists, _ := storage.GetURL("old"); exists {
+		t.Error("Expected the old tombstone to be removed")
+	}
+	if _, found := storage.GetShortURLByOriginalURL("https://old.com"); found {
+		t.Error("Expected the removed URL to be forgotten by original URL lookups")
+	}
+	if _, exists, isDeleted := storage.GetURL("recent"); !exists || !isDeleted {
+		t.Error("Expected the recent tombstone to be kept")
+	}
+	if _, exists, _ := storage.GetURL("live"); !exists {
+		t.Error("Expected the live URL to be kept")
+	}
+}
+
+func TestURLStorage_ExportImport(t *testing.T) {
+	source := NewURLStorage()
+	source.AddURL("short1", "https://example.com", "user1")
+	source.AddURL("short2", "https://google.com", "user1")
+	source.AddURL("short3", "https://github.com", "user2")
+	source.DeleteURLs([]string{"short2"}, "user1")
+	source.SetContentType([]string{"short3"}, ContentTypeJSON)
+	source.SetMetadata("short3", "GitHub", "https://github.com/favicon.ico")
+	// A URL created and deleted before a restart keeps its times
+	createdAt, deletedAt := time.Now().Add(-48*time.Hour), time.Now().Add(-24*time.Hour)
+	source.Import([]URLMapping{{ShortURL: "old", OriginalURL: "https://old.com", UserID: "user2",
+		IsDeleted: true, CreatedAt: &createdAt, DeletedAt: &deletedAt}})
+
+	records, err := source.Export()
+	if err != nil {
+		t.Fatalf("Export() returned error: %v", err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("Expected 4 records, got %d", len(records))
+	}
+
+	target := NewURLStorage()
+	if err := target.Import(records); err != nil {
+		t.Fatalf("Import() returned error: %v", err)
+	}
+	imported, err := target.Export()
+	if err != nil {
+		t.Fatalf("Export() returned error: %v", err)
+	}
+	byShortURL := make(map[string]URLMapping)
+	for _, record := range imported {
+		byShortURL[record.ShortURL] = record
+	}
+	for _, record := range records {
+		if !reflect.DeepEqual(byShortURL[record.ShortURL], record) {
+			t.Errorf("Expected %+v to round-trip, got %+v", record, byShortURL[record.ShortURL])
+		}
+	}
+	if _, _, isDeleted := target.GetURL("short2"); !isDeleted {
+		t.Error("Expected the deletion flag to be imported")
+	}
+	if info, _, _ := target.GetURLInfo("old"); !info.CreatedAt.Equal(createdAt) || !info.DeletedAt.Equal(deletedAt) {
+		t.Errorf("Expected the times to be imported, got created %v, deleted %v", info.CreatedAt, info.DeletedAt)
+	}
+	if info, _, _ := target.GetURLInfo("short3"); info.Title != "GitHub" || info.FaviconURL != "https://github.com/favicon.ico" {
+		t.Errorf("Expected the metadata to be imported, got %+v", info)
+	}
+	if shortURL, exists := target.GetShortURLByOriginalURL("https://github.com"); !exists || shortURL != "short3" {
+		t.Errorf("Expected imported URLs to be found by original URL, got %q", shortURL)
+	}
+
+	// Importing again conflicts and stores nothing
+	if err := target.Import(records); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists, got %v", err)
+	}
+	if err := target.Import([]URLMapping{{ShortURL: "short1", OriginalURL: "https://new.example.com", UserID: "user3"}}); !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists, got %v", err)
+	}
+	if count := target.Count(); count != 4 {
+		t.Errorf("Expected 4 URLs after failed imports, got %d", count)
+	}
+}
+
+func TestURLStorage_RestoreURLs(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.DeleteURLs([]string{"short1"}, "user1")
+
+	// Another user's restore is a no-op
+	storage.RestoreURLs([]string{"short1"}, "user2")
+	if _, _, isDeleted := storage.GetURL("short1"); !isDeleted {
+		t.Fatal("Expected a non-owner's restore to be a no-op")
+	}
+
+	if err := storage.RestoreURLs([]string{"short1"}, "user1"); err != nil {
+		t.Fatalf("RestoreURLs() returned error: %v", err)
+	}
+	if _, _, isDeleted := storage.GetURL("short1"); isDeleted {
+		t.Error("Expected short1 to be restored")
+	}
+	if stats, _ := storage.GetStats(); stats.DeletedURLs != 0 {
+		t.Errorf("Expected no deleted URLs after restore, got %d", stats.DeletedURLs)
+	}
+}
+
+func TestURLStorage_ReplaceDeletedURL(t *testing.T) {
+	storage := NewURLStorage()
+	storage.AddURL("short1", "https://example.com", "user1")
+	storage.AddURL("taken", "https://other.com", "user1")
+
+	// Live mappings are not replaced
+	if err := storage.ReplaceDeletedURL("short1", "short2", "https://example.com", "user2"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists for a live mapping, got %v", err)
+	}
+
+	storage.DeleteURLs([]string{"short1"}, "user1")
+	if err := storage.ReplaceDeletedURL("short1", "taken", "https://example.com", "user2"); !errors.Is(err, ErrShortURLExists) {
+		t.Errorf("Expected ErrShortURLExists for a taken short URL, got %v", err)
+	}
+	if err := storage.ReplaceDeletedURL("short1", "short2", "https://another.com", "user2"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists for a mapping of another URL, got %v", err)
+	}
+
+	if err := storage.ReplaceDeletedURL("short1", "short2", "https://example.com", "user2"); err != nil {
+		t.Fatalf("ReplaceDeletedURL() returned error: %v", err)
+	}
+	if _, exists, _ := storage.GetURL("short1"); exists {
+		t.Error("Expected the deleted mapping to be removed")
+	}
+	if shortURL, _ := storage.GetShortURLByOriginalURL("https://example.com"); shortURL != "short2" {
+		t.Errorf("Expected the URL to map to short2, got %s", shortURL)
+	}
+	if info, _, _ := storage.GetURLInfo("short2"); info.IsDeleted || info.UserID != "user2" {
+		t.Errorf("Expected a live mapping of user2, got %+v", info)
+	}
+}
+
+func TestURLStorage_IdempotentResults(t *testing.T) {
+	storage := NewURLStorage()
+	result := IdempotentResult{OriginalURL: "https://example.com", ShortURL: "short1", Status: 201, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := storage.SaveIdempotentResult("user1", "key", result); err != nil {
+		t.Fatalf("SaveIdempotentResult() returned error: %v", err)
+	}
+
+	if saved, exists, err := storage.GetIdempotentResult("user1", "key"); err != nil || !exists || saved != result {
+		t.Errorf("Expected %+v, got %+v (exists: %v, err: %v)", result, saved, exists, err)
+	}
+	if _, exists, _ := storage.GetIdempotentResult("user2", "key"); exists {
+		t.Error("Expected keys to be scoped to the user")
+	}
+
+	storage.SaveIdempotentResult("user1", "expired", IdempotentResult{ShortURL: "short2", ExpiresAt: time.Now().Add(-time.Second)})
+	if _, exists, _ := storage.GetIdempotentResult("user1", "expired"); exists {
+		t.Error("Expected expired result to not be replayed")
+	}
+
+	// Reserving succeeds only for keys without an unexpired result
+	pending := IdempotentResult{OriginalURL: "https://example.com", ExpiresAt: time.Now().Add(time.Minute)}
+	if _, reserved, err := storage.ReserveIdempotentResult("user1", "new", pending); err != nil || !reserved {
+		t.Errorf("Expected a new key to be reserved, got %v (err: %v)", reserved, err)
+	}
+	if saved, reserved, _ := storage.ReserveIdempotentResult("user1", "new", pending); reserved || saved.OriginalURL != pending.OriginalURL {
+		t.Errorf("Expected the reservation to be returned, got %+v (reserved: %v)", saved, reserved)
+	}
+	if saved, reserved, _ := storage.ReserveIdempotentResult("user1", "key", pending); reserved || saved.ShortURL != "short1" {
+		t.Errorf("Expected the saved result to be returned, got %+v (reserved: %v)", saved, reserved)
+	}
+	if _, reserved, _ := storage.ReserveIdempotentResult("user1", "expired", pending); !reserved {
+		t.Error("Expected a key with an expired result to be reserved")
+	}
+}
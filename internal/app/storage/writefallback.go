@@ -0,0 +1,533 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultReplayInterval is used when WriteFallbackOptions.ReplayInterval is not set.
+const DefaultReplayInterval = 5 * time.Second
+
+// WriteFallbackOptions contains settings for WriteFallback.
+type WriteFallbackOptions struct {
+	// Path is the write-ahead log file keeping queued writes across restarts
+	Path string
+	// ReplayInterval is how often queued writes are retried against the underlying storage
+	ReplayInterval time.Duration
+}
+
+// WriteFallback is a Storage decorator that queues URL writes failing in the
+// underlying storage to a local write-ahead log instead of returning the error,
+// and replays them in the background once the storage recovers.
+//
+// Queued URLs are served by GetURL and GetShortURLByOriginalURL until they are
+// replayed. Duplicate URL errors are returned as usual and are never queued.
+// A queued write that turns out to conflict with a stored URL on replay is
+// dropped and logged, as the client has already been given its short URL.
+type WriteFallback struct {
+	next Storage
+	opts WriteFallbackOptions
+
+	mu      sync.Mutex
+	pending []URLMapping
+	wal     *os.File
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewWriteFallback wraps next with a write fallback logging to opts.Path.
+// Writes left in the log by a previous run are queued for replay.
+func NewWriteFallback(next Storage, opts WriteFallbackOptions) (*WriteFallback, error) {
+	if opts.ReplayInterval <= 0 {
+		opts.ReplayInterval = DefaultReplayInterval
+	}
+
+	pending, err := LoadURLRecords(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(opts.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	wf := &WriteFallback{
+		next:    next,
+		opts:    opts,
+		pending: pending,
+		wal:     wal,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go wf.replayLoop()
+	return wf, nil
+}
+
+// Unwrap returns the underlying storage.
+func (wf *WriteFallback) Unwrap() Storage {
+	return wf.next
+}
+
+// Pending returns the number of queued writes.
+func (wf *WriteFallback) Pending() int {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return len(wf.pending)
+}
+
+// shouldQueue reports whether a write failed because of the storage rather than the data.
+// Partially stored batches aren't queued, as the storage stored everything it could.
+func shouldQueue(err error) bool {
+	return err != nil && !errors.Is(err, ErrURLExists) && !errors.Is(err, ErrShortURLExists) && !partiallyStored(err)
+}
+
+// enqueue appends records to the log and the in-memory queue.
+// Records clashing with queued ones are rejected with the usual duplicate URL errors.
+func (wf *WriteFallback) enqueue(records []URLMapping) error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+
+	for _, record := range records {
+		for _, queued := range wf.pending {
+			if queued.OriginalURL == record.OriginalURL {
+				return ErrURLExists
+			}
+			if queued.ShortURL == record.ShortURL {
+				return ErrShortURLExists
+			}
+		}
+	}
+
+	writer := bufio.NewWriter(wf.wal)
+	for _, record := range records {
+		if err := writeURLRecord(writer, record); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := wf.wal.Sync(); err != nil {
+		return err
+	}
+
+	wf.pending = append(wf.pending, records...)
+	return nil
+}
+
+// fallback queues records after a failed write. It returns writeErr
+// unchanged if the failure isn't transient or the records can't be logged.
+func (wf *WriteFallback) fallback(writeErr error, records []URLMapping) error {
+	if !shouldQueue(writeErr) {
+		return writeErr
+	}
+	if err := wf.enqueue(records); err != nil {
+		if shouldQueue(err) {
+			log.Printf("Error queuing failed write: %v", err)
+			return writeErr
+		}
+		return err
+	}
+	return nil
+}
+
+// AddURL adds a URL mapping, queuing it if the underlying storage fails.
+func (wf *WriteFallback) AddURL(shortURL, originalURL, userID string) error {
+	err := wf.next.AddURL(shortURL, originalURL, userID)
+	return wf.fallback(err, []URLMapping{{
+		UUID:        generateUUID(),
+		ShortURL:    shortURL,
+		OriginalURL: originalURL,
+		UserID:      userID,
+	}})
+}
+
+// AddURLs adds URL mappings, queuing them if the underlying storage fails.
+func (wf *WriteFallback) AddURLs(urls map[string]string, userID string) error {
+	err := wf.next.AddURLs(urls, userID)
+	records := make([]URLMapping, 0, len(urls))
+	for shortURL, originalURL := range urls {
+		records = append(records, URLMapping{
+			UUID:        generateUUID(),
+			ShortURL:    shortURL,
+			OriginalURL: originalURL,
+			UserID:      userID,
+		})
+	}
+	return wf.fallback(err, records)
+}
+
+// GetURL returns the original URL from the underlying storage or the queue.
+func (wf *WriteFallback) GetURL(shortURL string) (string, bool, bool) {
+	if originalURL, exists, isDeleted := wf.next.GetURL(shortURL); exists {
+		return originalURL, exists, isDeleted
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		if record.ShortURL == shortURL {
+			return record.OriginalURL, true, false
+		}
+	}
+	return "", false, false
+}
+
+// GetURLsByUser returns the user's URL mappings from the underlying storage.
+func (wf *WriteFallback) GetURLsByUser(userID string) (map[string]string, error) {
+	return wf.next.GetURLsByUser(userID)
+}
+
+// GetUserURLs returns a page of the user's URLs from the underlying storage.
+func (wf *WriteFallback) GetUserURLs(userID string, page Page) ([]UserURL, error) {
+	return wf.next.GetUserURLs(userID, page)
+}
+
+// CountUserURLs counts the user's URLs in the underlying storage and the queue.
+// While the underlying storage fails, only queued URLs are counted, so that
+// shortening keeps working.
+func (wf *WriteFallback) CountUserURLs(userID string) (int, error) {
+	count, err := wf.next.CountUserURLs(userID)
+	if err != nil {
+		count = 0
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		if record.UserID == userID && !record.IsDeleted {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// GetAllURLs returns all URL mappings, including queued ones.
+func (wf *WriteFallback) GetAllURLs() map[string]string {
+	urls := wf.next.GetAllURLs()
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		urls[record.ShortURL] = record.OriginalURL
+	}
+	return urls
+}
+
+// Count returns the number of URLs in the underlying storage and the queue.
+func (wf *WriteFallback) Count() int {
+	count := wf.next.Count()
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	return count + len(wf.pending)
+}
+
+// GetShortURLByOriginalURL finds a short URL in the underlying storage or the queue.
+func (wf *WriteFallback) GetShortURLByOriginalURL(originalURL string) (string, bool) {
+	if shortURL, exists := wf.next.GetShortURLByOriginalURL(originalURL); exists {
+		return shortURL, true
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		if record.OriginalURL == originalURL {
+			return record.ShortURL, true
+		}
+	}
+	return "", false
+}
+
+// DeleteURLs marks URLs as deleted in the underlying storage.
+func (wf *WriteFallback) DeleteURLs(shortURLs []string, userID string) error {
+	return wf.next.DeleteURLs(shortURLs, userID)
+}
+
+// ReplaceDeletedURL replaces a deleted mapping in the underlying storage.
+// Replacements are not queued, as they depend on the stored mapping.
+func (wf *WriteFallback) ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID string) error {
+	return wf.next.ReplaceDeletedURL(deletedShortURL, shortURL, originalURL, userID)
+}
+
+// RestoreURLs restores deleted URLs in the underlying storage.
+func (wf *WriteFallback) RestoreURLs(shortURLs []string, userID string) error {
+	return wf.next.RestoreURLs(shortURLs, userID)
+}
+
+// SetContentType records URL content types in the underlying storage.
+// Content types of queued URLs are not recorded.
+func (wf *WriteFallback) SetContentType(shortURLs []string, contentType string) error {
+	return wf.next.SetContentType(shortURLs, contentType)
+}
+
+// SetCreatorIPHash records creator IP hashes in the underlying storage.
+// Hashes of queued URLs are not recorded.
+func (wf *WriteFallback) SetCreatorIPHash(shortURLs []string, hash string) error {
+	return wf.next.SetCreatorIPHash(shortURLs, hash)
+}
+
+// SetProtected protects URLs in the underlying storage. Queued URLs are
+// protected in the queue instead, so that they are replayed protected.
+func (wf *WriteFallback) SetProtected(shortURLs []string) error {
+	protect := make(map[string]struct{}, len(shortURLs))
+	for _, shortURL := range shortURLs {
+		protect[shortURL] = struct{}{}
+	}
+
+	wf.mu.Lock()
+	queued := 0
+	for i, record := range wf.pending {
+		if _, ok := protect[record.ShortURL]; ok {
+			wf.pending[i].Protected = true
+			delete(protect, record.ShortURL)
+			queued++
+		}
+	}
+	var err error
+	if queued > 0 {
+		err = wf.rewriteLog()
+	}
+	wf.mu.Unlock()
+	if err != nil || len(protect) == 0 {
+		return err
+	}
+
+	rest := make([]string, 0, len(protect))
+	for shortURL := range protect {
+		rest = append(rest, shortURL)
+	}
+	return wf.next.SetProtected(rest)
+}
+
+// SetMetadata records page metadata in the underlying storage.
+// Metadata of queued URLs is not recorded.
+func (wf *WriteFallback) SetMetadata(shortURL, title, faviconURL string) error {
+	return wf.next.SetMetadata(shortURL, title, faviconURL)
+}
+
+// GetIdempotentResult returns a saved idempotency key result from the underlying storage.
+func (wf *WriteFallback) GetIdempotentResult(userID, key string) (IdempotentResult, bool, error) {
+	return wf.next.GetIdempotentResult(userID, key)
+}
+
+// SaveIdempotentResult saves an idempotency key result in the underlying storage.
+// Results are not queued: failing to save one only disables the replay.
+func (wf *WriteFallback) SaveIdempotentResult(userID, key string, result IdempotentResult) error {
+	return wf.next.SaveIdempotentResult(userID, key, result)
+}
+
+// ReserveIdempotentResult reserves an idempotency key in the underlying storage.
+func (wf *WriteFallback) ReserveIdempotentResult(userID, key string, result IdempotentResult) (IdempotentResult, bool, error) {
+	return wf.next.ReserveIdempotentResult(userID, key, result)
+}
+
+// GetURLInfo returns everything stored about a short URL in the underlying
+// storage or the queue. Queued URLs only carry their URLs and owner.
+func (wf *WriteFallback) GetURLInfo(shortURL string) (URLInfo, bool, error) {
+	info, exists, err := wf.next.GetURLInfo(shortURL)
+	if exists {
+		return info, exists, err
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		if record.ShortURL == shortURL {
+			return URLInfo{OriginalURL: record.OriginalURL, UserID: record.UserID, Protected: record.Protected}, true, nil
+		}
+	}
+	return URLInfo{}, false, err
+}
+
+// GetUserIDs returns the IDs of users owning URLs in the underlying storage.
+func (wf *WriteFallback) GetUserIDs() ([]string, error) {
+	return wf.next.GetUserIDs()
+}
+
+// PurgeUsers removes all URLs of the specified users from the underlying storage.
+// Queued URLs of the users are dropped as well.
+func (wf *WriteFallback) PurgeUsers(userIDs []string) (int, error) {
+	removed, err := wf.next.PurgeUsers(userIDs)
+	if err != nil {
+		return removed, err
+	}
+
+	purge := make(map[string]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		purge[userID] = struct{}{}
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	kept := wf.pending[:0]
+	for _, record := range wf.pending {
+		if _, ok := purge[record.UserID]; !ok {
+			kept = append(kept, record)
+		}
+	}
+	if dropped := len(wf.pending) - len(kept); dropped > 0 {
+		wf.pending = kept
+		removed += dropped
+		return removed, wf.rewriteLog()
+	}
+	return removed, nil
+}
+
+// HardDeleteExpired removes expired deleted URLs from the underlying storage.
+// Queued URLs have not been deleted yet, so they are kept.
+func (wf *WriteFallback) HardDeleteExpired(before time.Time) (int, error) {
+	return wf.next.HardDeleteExpired(before)
+}
+
+// Export returns the records of the underlying storage followed by the queued ones.
+func (wf *WriteFallback) Export() ([]URLMapping, error) {
+	records, err := wf.next.Export()
+	if err != nil {
+		return nil, err
+	}
+
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+	for _, record := range wf.pending {
+		record.UUID = ""
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Import stores exported records in the underlying storage. Failed imports are
+// not queued, as they are retried by the caller as a whole.
+func (wf *WriteFallback) Import(records []URLMapping) error {
+	return wf.next.Import(records)
+}
+
+// GetStats returns statistics of the underlying storage.
+func (wf *WriteFallback) GetStats() (Stats, error) {
+	return wf.next.GetStats()
+}
+
+// GetWindowStats returns windowed statistics of the underlying storage.
+func (wf *WriteFallback) GetWindowStats(since time.Time) (WindowStats, error) {
+	return wf.next.GetWindowStats(since)
+}
+
+// Ping checks the underlying storage.
+func (wf *WriteFallback) Ping() error {
+	return wf.next.Ping()
+}
+
+// PingContext checks the underlying storage, giving up when ctx is done.
+func (wf *WriteFallback) PingContext(ctx context.Context) error {
+	return wf.next.PingContext(ctx)
+}
+
+// Replay writes queued URLs to the underlying storage in order,
+// stopping at the first transient failure, and compacts the log.
+func (wf *WriteFallback) Replay() error {
+	wf.mu.Lock()
+	defer wf.mu.Unlock()
+
+	replayed := 0
+	for _, record := range wf.pending {
+		var err error
+		if record.Protected {
+			// Importing stores the URL together with its protection
+			err = wf.next.Import([]URLMapping{record})
+		} else {
+			err = wf.next.AddURL(record.ShortURL, record.OriginalURL, record.UserID)
+		}
+		if shouldQueue(err) {
+			break
+		}
+		if err != nil {
+			log.Printf("Dropping queued URL (short: %s, original: %s): %v", record.ShortURL, record.OriginalURL, err)
+		}
+		replayed++
+	}
+	if replayed == 0 {
+		return nil
+	}
+
+	wf.pending = wf.pending[replayed:]
+	return wf.rewriteLog()
+}
+
+// rewriteLog atomically replaces the log with the queued records. Requires wf.mu.
+func (wf *WriteFallback) rewriteLog() error {
+	tmpFile := wf.opts.Path + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, record := range wf.pending {
+		if err := writeURLRecord(writer, record); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, wf.opts.Path); err != nil {
+		return err
+	}
+
+	wal, err := os.OpenFile(wf.opts.Path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	wf.wal.Close()
+	wf.wal = wal
+	return nil
+}
+
+// replayLoop replays queued writes at regular intervals until Close is called.
+func (wf *WriteFallback) replayLoop() {
+	defer close(wf.done)
+
+	ticker := time.NewTicker(wf.opts.ReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := wf.Replay(); err != nil {
+				log.Printf("Error compacting write fallback log: %v", err)
+			}
+		case <-wf.stop:
+			return
+		}
+	}
+}
+
+// Close stops the replay goroutine, makes a last replay attempt and closes
+// the underlying storage. Writes still queued stay in the log for the next run.
+func (wf *WriteFallback) Close() error {
+	wf.closeOnce.Do(func() {
+		close(wf.stop)
+		<-wf.done
+		if err := wf.Replay(); err != nil {
+			log.Printf("Error compacting write fallback log: %v", err)
+		}
+		wf.mu.Lock()
+		wf.wal.Close()
+		wf.mu.Unlock()
+	})
+	return wf.next.Close()
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestWriteFallback(t *testing.T, next Storage, path string) *WriteFallback {
+	t.Helper()
+	wf, err := NewWriteFallback(next, WriteFallbackOptions{Path: path, ReplayInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create write fallback: %v", err)
+	}
+	return wf
+}
+
+func TestWriteFallback_QueuesAndReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.wal")
+	backend := &flakyStorage{URLStorage: NewURLStorage(), err: errors.New("connection refused")}
+	wf := newTestWriteFallback(t, backend, path)
+	defer wf.Close()
+
+	if err := wf.AddURL("short1", "https://example.com", "user1"); err != nil {
+		t.Fatalf("Expected failed write to be queued, got %v", err)
+	}
+	if wf.Pending() != 1 {
+		t.Fatalf("Expected 1 queued write, got %d", wf.Pending())
+	}
+	if originalURL, exists, _ := wf.GetURL("short1"); !exists || originalURL != "https://example.com" {
+		t.Errorf("Expected queued URL to be readable, got %q (exists: %v)", originalURL, exists)
+	}
+	if err := wf.AddURL("short2", "https://example.com", "user1"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists for a queued URL, got %v", err)
+	}
+	if shortURL, _ := wf.GetShortURLByOriginalURL("https://example.com"); shortURL != "short1" {
+		t.Errorf("Expected short1 for the queued URL, got %q", shortURL)
+	}
+
+	// Replay keeps the write queued while the storage is down
+	if err := wf.Replay(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if wf.Pending() != 1 {
+		t.Fatalf("Expected write to stay queued, got %d", wf.Pending())
+	}
+
+	backend.err = nil
+	if err := wf.Replay(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if wf.Pending() != 0 {
+		t.Errorf("Expected queue to be empty, got %d", wf.Pending())
+	}
+	if originalURL, exists, _ := backend.URLStorage.GetURL("short1"); !exists || originalURL != "https://example.com" {
+		t.Errorf("Expected URL to be persisted, got %q (exists: %v)", originalURL, exists)
+	}
+	if records, _ := LoadURLRecords(path); len(records) != 0 {
+		t.Errorf("Expected log to be compacted, got %d records", len(records))
+	}
+}
+
+func TestWriteFallback_ReloadsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.wal")
+	backend := &flakyStorage{URLStorage: NewURLStorage(), err: errors.New("connection refused")}
+
+	wf := newTestWriteFallback(t, backend, path)
+	if err := wf.AddURLs(map[string]string{"short1": "https://a.com", "short2": "https://b.com"}, "user1"); err != nil {
+		t.Fatalf("Expected failed batch to be queued, got %v", err)
+	}
+	wf.Close()
+
+	// The queued writes survive a restart
+	wf = newTestWriteFallback(t, backend, path)
+	defer wf.Close()
+	if wf.Pending() != 2 {
+		t.Fatalf("Expected 2 queued writes after reload, got %d", wf.Pending())
+	}
+	backend.err = nil
+	if err := wf.Replay(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	urls, _ := backend.URLStorage.GetURLsByUser("user1")
+	if len(urls) != 2 {
+		t.Errorf("Expected 2 persisted URLs, got %v", urls)
+	}
+}
+
+func TestWriteFallback_SetProtected(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "urls.wal")
+	backend := &flakyStorage{URLStorage: NewURLStorage(), err: errors.New("connection refused")}
+	wf := newTestWriteFallback(t, backend, path)
+	defer wf.Close()
+
+	if err := wf.AddURL("short1", "https://example.com", "user1"); err != nil {
+		t.Fatalf("Expected failed write to be queued, got %v", err)
+	}
+	if err := wf.SetProtected([]string{"short1"}); err != nil {
+		t.Fatalf("SetProtected() returned error: %v", err)
+	}
+	if info, _, _ := wf.GetURLInfo("short1"); !info.Protected {
+		t.Error("Expected the queued URL to be protected")
+	}
+	if records, _ := LoadURLRecords(path); len(records) != 1 || !records[0].Protected {
+		t.Errorf("Expected the protection to be logged, got %+v", records)
+	}
+
+	backend.err = nil
+	if err := wf.Replay(); err != nil {
+		t.Fatalf("Replay failed: %v", err)
+	}
+	if info, exists, _ := backend.URLStorage.GetURLInfo("short1"); !exists || !info.Protected {
+		t.Errorf("Expected the URL to be replayed protected, got %+v (exists: %v)", info, exists)
+	}
+}
+
+func TestWriteFallback_DuplicatesAreNotQueued(t *testing.T) {
+	backend := &flakyStorage{URLStorage: NewURLStorage()}
+	wf := newTestWriteFallback(t, backend, filepath.Join(t.TempDir(), "urls.wal"))
+	defer wf.Close()
+
+	wf.AddURL("short1", "https://example.com", "user1")
+	if err := wf.AddURL("short2", "https://example.com", "user1"); !errors.Is(err, ErrURLExists) {
+		t.Errorf("Expected ErrURLExists, got %v", err)
+	}
+	if wf.Pending() != 0 {
+		t.Errorf("Expected nothing queued, got %d", wf.Pending())
+	}
+}
@@ -169,3 +169,31 @@ func BenchmarkURLStorageMemoryAllocation(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkBatchShortenPerItem stores a batch with one AddURL call per item.
+func BenchmarkBatchShortenPerItem(b *testing.B) {
+	storageInstance := storage.NewURLStorage()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 100; j++ {
+			id := strconv.Itoa(i) + "_" + strconv.Itoa(j)
+			storageInstance.AddURL("short"+id, "https://example.com/"+id, "user")
+		}
+	}
+}
+
+// BenchmarkBatchShortenTransactional stores the same batch with a single AddURLs call.
+func BenchmarkBatchShortenTransactional(b *testing.B) {
+	storageInstance := storage.NewURLStorage()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		urls := make(map[string]string, 100)
+		for j := 0; j < 100; j++ {
+			id := strconv.Itoa(i) + "_" + strconv.Itoa(j)
+			urls["short"+id] = "https://example.com/" + id
+		}
+		storageInstance.AddURLs(urls, "user")
+	}
+}
@@ -2,17 +2,24 @@ package main
 
 import (
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/achufistov/shortygopher.git/internal/app/config"
 	"github.com/achufistov/shortygopher.git/internal/app/handlers"
+	"github.com/achufistov/shortygopher.git/internal/app/metrics"
 	"github.com/achufistov/shortygopher.git/internal/app/middleware"
 	"github.com/achufistov/shortygopher.git/internal/app/storage"
+	"github.com/andybalholm/brotli"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -207,6 +214,128 @@ func Test_handleShortenPost(t *testing.T) {
 	}
 }
 
+func Test_handleShortenPostBuffered(t *testing.T) {
+	initConfig()
+	cfg.BufferResponses = true
+	defer func() { cfg.BufferResponses = false }()
+
+	handlers.InitStorage(storage.NewURLStorage())
+
+	r := chi.NewRouter()
+	r.Use(middleware.ResponseBufferingMiddleware(cfg))
+	r.Use(mockAuthMiddleware)
+	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenPost(cfg, w, r)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com/buffered"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	r.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	contentLength := rr.Header().Get("Content-Length")
+	if contentLength == "" {
+		t.Fatal("expected Content-Length header to be set")
+	}
+	if contentLength != strconv.Itoa(rr.Body.Len()) {
+		t.Errorf("Content-Length %s doesn't match body length %d", contentLength, rr.Body.Len())
+	}
+}
+
+func compressBody(t *testing.T, encoding string, data []byte) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		fw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w = fw
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unsupported encoding %q", encoding)
+	}
+
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func Test_handleShortenPostCompressed(t *testing.T) {
+
+	initConfig()
+	storageInstance := storage.NewURLStorage()
+	handlers.InitStorage(storageInstance)
+
+	r := chi.NewRouter()
+	r.Use(mockAuthMiddleware)
+	r.Use(middleware.GzipMiddleware(cfg))
+	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenPost(cfg, w, r)
+	})
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			originalURL := "https://example.com/" + encoding
+			body := compressBody(t, encoding, []byte(`{"url": "`+originalURL+`"}`))
+
+			req, err := http.NewRequest("POST", "/api/shorten", body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusCreated {
+				t.Fatalf("handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+			}
+
+			var resp handlers.ShortenResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			shortID := strings.TrimPrefix(resp.ShortURL, cfg.BaseURL+"/")
+			storedURL, exists, _ := storageInstance.GetURL(shortID)
+			if !exists || storedURL != originalURL {
+				t.Errorf("stored URL mismatch: got %q want %q", storedURL, originalURL)
+			}
+		})
+	}
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run("malformed "+encoding, func(t *testing.T) {
+			req, err := http.NewRequest("POST", "/api/shorten", strings.NewReader("definitely not compressed"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Encoding", encoding)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if status := rr.Code; status != http.StatusBadRequest {
+				t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
+
 func Test_handlePing(t *testing.T) {
 
 	initConfig()
@@ -287,3 +416,72 @@ func Test_handleBatchShortenPost(t *testing.T) {
 		})
 	}
 }
+
+func Test_metrics(t *testing.T) {
+	initConfig()
+	storageInstance := storage.NewURLStorage()
+	handlers.InitStorage(storageInstance)
+	metrics.SetStorageSizeFunc(storageInstance.Count)
+
+	r := chi.NewRouter()
+	r.Use(middleware.MetricsMiddleware)
+	r.Use(mockAuthMiddleware)
+	r.Post("/api/shorten", func(w http.ResponseWriter, r *http.Request) {
+		handlers.HandleShortenPost(cfg, w, r)
+	})
+	r.Get("/{id}", handlers.HandleGet)
+	r.Handle("/metrics", metrics.Handler())
+
+	scrape := func() string {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("metrics returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		return rr.Body.String()
+	}
+
+	// Counters are global, so compare against a scrape taken before the requests
+	before := scrape()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url": "https://example.com/metrics"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	after := scrape()
+
+	series := []struct {
+		name  string
+		delta float64
+	}{
+		{`shortener_http_requests_total{method="POST",route="/api/shorten",status="201"}`, 1},
+		{`shortener_http_requests_total{method="GET",route="/{id}",status="404"}`, 2},
+	}
+	for _, s := range series {
+		if got := metricValue(after, s.name) - metricValue(before, s.name); got != s.delta {
+			t.Errorf("%s increased by %v, want %v", s.name, got, s.delta)
+		}
+	}
+	if !strings.Contains(after, `shortener_http_request_duration_seconds_count{method="POST",route="/api/shorten"}`) {
+		t.Error("expected a duration histogram for POST /api/shorten")
+	}
+	if got := metricValue(after, "shortener_storage_urls"); got != 1 {
+		t.Errorf("shortener_storage_urls = %v, want 1", got)
+	}
+}
+
+// metricValue returns the value of a series in Prometheus text output, or 0 if absent.
+func metricValue(output, series string) float64 {
+	for _, line := range strings.Split(output, "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return 0
+			}
+			return v
+		}
+	}
+	return 0
+}